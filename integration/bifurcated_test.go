@@ -2,12 +2,14 @@ package integration_test
 
 import (
 	"encoding/json"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/sclevine/spec"
 	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
 	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
 	"github.com/dwillist/summerschool/v2/neuraltools"
 	"gonum.org/v1/gonum/mat"
@@ -20,6 +22,35 @@ func testBifurcated(t *testing.T, context spec.G, it spec.S) {
 		Expect = NewWithT(t).Expect
 	)
 
+	loadData := func() []neuraltools.DataPair {
+		var generatedData struct {
+			Inputs    [][]float64 `json:"inputs"`
+			Solutions [][]float64 `json:"solutions"`
+		}
+
+		rawDataPath := filepath.Join("testdata", "bifurcated-test.json")
+		rawDataReader, err := os.Open(rawDataPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(json.NewDecoder(rawDataReader).Decode(&generatedData)).To(Succeed())
+
+		var inputVecs []*mat.VecDense
+		var solutionVecs []*mat.VecDense
+
+		for _, input := range generatedData.Inputs {
+			inputVecs = append(inputVecs, mat.NewVecDense(2, input))
+		}
+
+		for _, solution := range generatedData.Solutions {
+			solutionVecs = append(solutionVecs, mat.NewVecDense(2, solution))
+		}
+
+		dataPairs, err := neuraltools.NewDataPair(inputVecs, solutionVecs)
+		Expect(err).NotTo(HaveOccurred())
+
+		return dataPairs
+	}
+
 	context("Bifurcated Data", func() {
 		var (
 			network neuralnet.Network
@@ -42,44 +73,51 @@ func testBifurcated(t *testing.T, context spec.G, it spec.S) {
 						},
 					},
 					WeightInit: neuralnet.InitRandom,
+					Source:     rand.NewSource(92),
 				})
 
 				Expect(err).NotTo(HaveOccurred())
 			})
 			it("succeeds", func() {
-				var generatedData struct {
-					Inputs    [][]float64 `json:"inputs"`
-					Solutions [][]float64 `json:"solutions"`
-				}
-
-				rawDataPath := filepath.Join("testdata", "bifurcated-test.json")
-				rawDataReader, err := os.Open(rawDataPath)
+				correctList, err := neuraltools.TestAndTrain(&network, 100, 1, neuraltools.MaxJudge, loadData()...)
 				Expect(err).NotTo(HaveOccurred())
 
-				Expect(json.NewDecoder(rawDataReader).Decode(&generatedData)).To(Succeed())
+				Expect(correctList).To(HaveLen(100))
+
+				finalCorrectCount := correctList[len(correctList)-1]
+				Expect(finalCorrectCount).To(BeNumerically(">", 90))
+
+			})
+		})
 
-				var inputVecs []*mat.VecDense
-				var solutionVecs []*mat.VecDense
+		context("when composing the same network from layers", func() {
+			var (
+				sequential neuralnet.Sequential
+			)
 
-				for _, input := range generatedData.Inputs {
-					inputVecs = append(inputVecs, mat.NewVecDense(2, input))
-				}
+			it.Before(func() {
+				rng := rand.New(rand.NewSource(92))
 
-				for _, solution := range generatedData.Solutions {
-					solutionVecs = append(solutionVecs, mat.NewVecDense(2, solution))
-				}
+				var err error
+				sequential, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+					InputShape: neuralnet.Shape{2},
+					Layers: []neuralnet.Layer{
+						layers.NewDense(2, 2, neuralnet.InitRandom, nil, rng),
+						layers.NewActivation(nodefuncs.Sigmoid{}),
+						layers.NewDense(2, 2, neuralnet.InitRandom, nil, rng),
+						layers.NewActivation(nodefuncs.Sigmoid{}),
+					},
+				})
 
-				dataPairs, err := neuraltools.NewDataPair(inputVecs, solutionVecs)
 				Expect(err).NotTo(HaveOccurred())
+			})
 
-				correctList, err := neuraltools.TestAndTrain(&network, 100, 1, neuraltools.MaxJudge, dataPairs...)
+			it("succeeds", func() {
+				correctList, err := neuraltools.TestAndTrain(&sequential, 100, 1, neuraltools.MaxJudge, loadData()...)
 				Expect(err).NotTo(HaveOccurred())
 
 				Expect(correctList).To(HaveLen(100))
-
-				finalCorrectCount := correctList[len(correctList)-1]
-				Expect(finalCorrectCount).To(BeNumerically(">", 90))
-
+				Expect(correctList[len(correctList)-1]).To(BeNumerically(">", 90))
 			})
 		})
 	})
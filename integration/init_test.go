@@ -1,7 +1,6 @@
 package integration_test
 
 import (
-	"math/rand"
 	"testing"
 
 	"github.com/sclevine/spec"
@@ -12,8 +11,6 @@ import (
 )
 
 func TestUnitSummerSchool(t *testing.T) {
-	rand.Seed(92)
-
 	// Use optimized libs for matrix mult
 	blas64.Use(blas_netlib.Implementation{}) // This improves Mul time from ~3s to ~0.6s
 
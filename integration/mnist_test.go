@@ -1,13 +1,18 @@
 package integration_test
 
 import (
+	"math/rand"
 	"path/filepath"
 	"testing"
 
 	"github.com/sclevine/spec"
 	"github.com/dwillist/summerschool/v2/integration"
 	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
 	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
 	"github.com/dwillist/summerschool/v2/neuraltools"
 
 	. "github.com/onsi/gomega"
@@ -52,7 +57,8 @@ func testMNIST(t *testing.T, context spec.G, it spec.S) {
 							Func: nodefuncs.Sigmoid{},
 						},
 					},
-					WeightInit: neuralnet.InitRandom,
+					WeightInit: initializers.XavierUniform{},
+					Source:     rand.NewSource(92),
 				})
 
 				Expect(err).NotTo(HaveOccurred())
@@ -93,5 +99,88 @@ func testMNIST(t *testing.T, context spec.G, it spec.S) {
 				Expect(correctCount).To(BeNumerically(">=", 8000))
 			})
 		})
+
+		context("when using the MNIST dataset to train a convolutional network", func() {
+			var (
+				trainData []neuraltools.DataPair
+				testData  []neuraltools.DataPair
+
+				network neuralnet.Sequential
+			)
+			it.Before(func() {
+				// testdata has no training images, so the t10k set is split instead
+				labels, err := integration.NewLabelSet(filepath.Join("testdata", "t10k-labels-idx1-ubyte.gz"))
+				Expect(err).NotTo(HaveOccurred())
+				images, err := integration.NewImageSet(filepath.Join("testdata", "t10k-images-idx3-ubyte.gz"))
+				Expect(err).NotTo(HaveOccurred())
+
+				data, err := neuraltools.NewDataPair(images.Vectorize(), labels.Vectorize())
+				Expect(err).NotTo(HaveOccurred())
+				trainData, testData = data[:8000], data[8000:]
+
+				rng := rand.New(rand.NewSource(92))
+
+				// 1x28x28 -> 16x28x28 -> 16x14x14 -> 32x10x10 -> 32x5x5 -> 10
+				first, err := layers.NewConv2D(layers.Conv2DConfig{
+					InChannels:   1,
+					OutChannels:  16,
+					KernelHeight: 5,
+					KernelWidth:  5,
+					Padding:      2,
+					WeightInit:   initializers.HeNormal{},
+				}, rng)
+				Expect(err).NotTo(HaveOccurred())
+
+				second, err := layers.NewConv2D(layers.Conv2DConfig{
+					InChannels:   16,
+					OutChannels:  32,
+					KernelHeight: 5,
+					KernelWidth:  5,
+					WeightInit:   initializers.HeNormal{},
+				}, rng)
+				Expect(err).NotTo(HaveOccurred())
+
+				network, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+					InputShape: neuralnet.Shape{28 * 28},
+					Layers: []neuralnet.Layer{
+						layers.NewReshape(1, 28, 28),
+						first,
+						layers.NewActivation(nodefuncs.Relu{}),
+						layers.NewMaxPool2D(2, 0),
+						second,
+						layers.NewActivation(nodefuncs.Relu{}),
+						layers.NewMaxPool2D(2, 0),
+						layers.NewFlatten(),
+						layers.NewDense(32*5*5, 10, nil, nil, rng),
+						layers.NewActivation(nodefuncs.Softmax{}),
+					},
+					Loss:      losses.CrossEntropy{},
+					Optimizer: optimizers.NewAdam(0.002),
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("succeeds", func() {
+				// reshuffled before every epoch from a fixed seed, with a decaying learning rate
+				rng := rand.New(rand.NewSource(7))
+
+				epochCount := 5
+				for i := 0; i < epochCount; i++ {
+					rng.Shuffle(len(trainData), func(a, b int) {
+						trainData[a], trainData[b] = trainData[b], trainData[a]
+					})
+
+					err := neuraltools.Train(&network, 32, trainData...)
+					Expect(err).NotTo(HaveOccurred())
+					network.Optimizer.(*optimizers.Adam).LearningRate *= 0.7
+				}
+
+				correctCount, err := neuraltools.Test(&network, neuraltools.MaxJudge, testData...)
+				Expect(err).NotTo(HaveOccurred())
+
+				// more than 98%, training is seeded and 1970 of 2000 are correct without netlib
+				Expect(correctCount).To(BeNumerically(">", 1960))
+			})
+		})
 	})
 }
@@ -2,6 +2,7 @@ package integration_test
 
 import (
 	"encoding/json"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
@@ -42,6 +43,7 @@ func testTarget(t *testing.T, context spec.G, it spec.S) {
 						},
 					},
 					WeightInit: neuralnet.InitRandom,
+					Source:     rand.NewSource(92),
 				})
 
 				Expect(err).NotTo(HaveOccurred())
@@ -0,0 +1,134 @@
+package autodiff
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Reverse-mode automatic differentiation. Operations on a Tape compute their value right
+// away and record how to backpropagate through it, Backward then walks the tape in reverse
+// and accumulates the gradient of one output with respect to every Variable. Values are
+// matrices, functions of a batch use one column per sample like the rest of neuralnet.
+// Operations panic on mismatched dimensions, as gonum does.
+
+///
+/// Var Def
+///
+type Var struct {
+	Value *mat.Dense
+	// gradient of the most recent Backward output with respect to Value, nil for
+	// Constants and values the output does not depend on
+	Grad *mat.Dense
+
+	tape         *Tape
+	requiresGrad bool
+	// propagates Grad to the inputs of the operation that produced the Var
+	backward func(grad *mat.Dense)
+}
+
+// adds grad to Grad, allocating it on first use
+func (v *Var) accumulate(grad mat.Matrix) {
+	if !v.requiresGrad {
+		return
+	}
+
+	if v.Grad == nil {
+		v.Grad = mat.DenseCopyOf(grad)
+		return
+	}
+
+	v.Grad.Add(v.Grad, grad)
+}
+
+///
+/// Tape Def
+///
+// records operations in the order they are computed, a Tape is meant for a single
+// function evaluation and is not safe for concurrent use
+type Tape struct {
+	vars []*Var
+}
+
+func NewTape() *Tape {
+	return &Tape{}
+}
+
+// a value Backward computes the gradient for, value is used as is and must not be
+// modified while the tape is in use
+func (t *Tape) Variable(value *mat.Dense) *Var {
+	return t.record(value, true, nil)
+}
+
+// a value treated as fixed, Backward does not compute its gradient
+func (t *Tape) Constant(value *mat.Dense) *Var {
+	return t.record(value, false, nil)
+}
+
+// result of an operation on inputs, backward is only called when some input needs a gradient
+func (t *Tape) operation(value *mat.Dense, inputs []*Var, backward func(grad *mat.Dense)) *Var {
+	requiresGrad := false
+	for _, input := range inputs {
+		if input.tape != t {
+			panic("autodiff: variable recorded on a different tape")
+		}
+
+		requiresGrad = requiresGrad || input.requiresGrad
+	}
+
+	return t.record(value, requiresGrad, backward)
+}
+
+func (t *Tape) record(value *mat.Dense, requiresGrad bool, backward func(grad *mat.Dense)) *Var {
+	result := &Var{
+		Value:        value,
+		tape:         t,
+		requiresGrad: requiresGrad,
+	}
+
+	if requiresGrad {
+		result.backward = backward
+	}
+
+	t.vars = append(t.vars, result)
+
+	return result
+}
+
+// computes the gradient of output with respect to every Var recorded before it, seed is the
+// gradient with respect to output itself and may be nil for a 1x1 output, e.g. a loss.
+// Gradients of a previous Backward call on the same tape are discarded.
+func (t *Tape) Backward(output *Var, seed *mat.Dense) error {
+	if output.tape != t {
+		return fmt.Errorf("output is not recorded on this tape")
+	}
+
+	r, c := output.Value.Dims()
+	if seed == nil {
+		if r != 1 || c != 1 {
+			return fmt.Errorf("output of %dx%d requires a seed gradient", r, c)
+		}
+
+		seed = mat.NewDense(1, 1, []float64{1})
+	}
+
+	if sr, sc := seed.Dims(); sr != r || sc != c {
+		return fmt.Errorf("invalid seed dimension: %dx%d, expected %dx%d", sr, sc, r, c)
+	}
+
+	for _, v := range t.vars {
+		v.Grad = nil
+	}
+
+	output.accumulate(seed)
+
+	// every Var is recorded after its inputs, so reverse order visits a Var after
+	// everything that depends on it
+	for idx := len(t.vars) - 1; idx >= 0; idx-- {
+		if v := t.vars[idx]; v.Grad != nil && v.backward != nil {
+			v.backward(v.Grad)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package autodiff_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/autodiff"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testTape(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		tape *autodiff.Tape
+	)
+
+	it.Before(func() {
+		tape = autodiff.NewTape()
+	})
+
+	it("accumulates gradients of values used more than once", func() {
+		x := tape.Variable(mat.NewDense(1, 1, []float64{3}))
+
+		// x*x + 2x
+		output := tape.Add(tape.Mul(x, x), tape.Scale(x, 2))
+		Expect(output.Value.At(0, 0)).To(Equal(float64(15)))
+
+		Expect(tape.Backward(output, nil)).To(Succeed())
+		Expect(x.Grad.At(0, 0)).To(Equal(float64(8)))
+	})
+
+	it("skips gradients of constants and unrelated values", func() {
+		x := tape.Variable(mat.NewDense(1, 2, []float64{1, 2}))
+		c := tape.Constant(mat.NewDense(1, 2, []float64{3, 4}))
+		unused := tape.Variable(mat.NewDense(1, 1, []float64{5}))
+
+		output := tape.Sum(tape.Mul(x, c))
+		tape.Exp(unused)
+
+		Expect(tape.Backward(output, nil)).To(Succeed())
+		Expect(x.Grad.RawMatrix().Data).To(Equal([]float64{3, 4}))
+		Expect(c.Grad).To(BeNil())
+		Expect(unused.Grad).To(BeNil())
+	})
+
+	it("starts from the seed gradient and discards earlier results", func() {
+		x := tape.Variable(mat.NewDense(2, 1, []float64{1, 2}))
+		output := tape.Scale(x, 3)
+
+		Expect(tape.Backward(output, mat.NewDense(2, 1, []float64{1, -1}))).To(Succeed())
+		Expect(x.Grad.RawMatrix().Data).To(Equal([]float64{3, -3}))
+
+		Expect(tape.Backward(output, mat.NewDense(2, 1, []float64{0, 2}))).To(Succeed())
+		Expect(x.Grad.RawMatrix().Data).To(Equal([]float64{0, 6}))
+	})
+
+	it("fails on invalid outputs and seeds", func() {
+		x := tape.Variable(mat.NewDense(2, 1, nil))
+
+		Expect(tape.Backward(x, nil)).To(MatchError("output of 2x1 requires a seed gradient"))
+		Expect(tape.Backward(x, mat.NewDense(1, 2, nil))).To(MatchError("invalid seed dimension: 1x2, expected 2x1"))
+		Expect(autodiff.NewTape().Backward(x, nil)).To(MatchError("output is not recorded on this tape"))
+
+		Expect(func() {
+			autodiff.NewTape().Exp(x)
+		}).To(Panic())
+	})
+}
+
+// compares the gradients of Σ build(vars)·weights for every value with central differences,
+// weights are random so every output value contributes
+func checkGradients(t *testing.T, build func(tape *autodiff.Tape, vars []*autodiff.Var) *autodiff.Var, values ...*mat.Dense) {
+	const epsilon = 1e-6
+
+	Expect := NewWithT(t).Expect
+	rng := rand.New(rand.NewSource(3))
+
+	var weights *mat.Dense
+	evaluate := func() (*autodiff.Tape, []*autodiff.Var, *autodiff.Var) {
+		tape := autodiff.NewTape()
+
+		var vars []*autodiff.Var
+		for _, value := range values {
+			vars = append(vars, tape.Variable(value))
+		}
+
+		output := build(tape, vars)
+		if weights == nil {
+			r, c := output.Value.Dims()
+			weights = mat.NewDense(r, c, nil)
+			weights.Apply(func(_, _ int, _ float64) float64 {
+				return rng.Float64()*2 - 1
+			}, weights)
+		}
+
+		return tape, vars, tape.Sum(tape.Mul(output, tape.Constant(weights)))
+	}
+
+	tape, vars, loss := evaluate()
+	Expect(tape.Backward(loss, nil)).To(Succeed())
+
+	for idx, value := range values {
+		r, c := value.Dims()
+		for i := 0; i < r; i++ {
+			for j := 0; j < c; j++ {
+				original := value.At(i, j)
+
+				value.Set(i, j, original+epsilon)
+				_, _, plus := evaluate()
+				value.Set(i, j, original-epsilon)
+				_, _, minus := evaluate()
+				value.Set(i, j, original)
+
+				expected := (plus.Value.At(0, 0) - minus.Value.At(0, 0)) / (2 * epsilon)
+				Expect(vars[idx].Grad.At(i, j)).To(BeNumerically("~", expected, 1e-6))
+			}
+		}
+	}
+}
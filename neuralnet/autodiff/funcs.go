@@ -0,0 +1,174 @@
+package autodiff
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Adapters from functions written with Tape operations to the neuralnet interfaces,
+// their derivatives come from Backward so none has to be written by hand. When Backward
+// fails the derivatives are NaN instead of a panic.
+
+///
+/// Func Def
+///
+// a neuralnet.NodeFunc applying F to every value on its own, F gets and returns a 1x1 matrix
+type Func struct {
+	F func(t *Tape, x *Var) *Var
+}
+
+func (f Func) CalcVal(x float64, _ mat.Vector) float64 {
+	tape := NewTape()
+	return f.F(tape, tape.Constant(mat.NewDense(1, 1, []float64{x}))).Value.At(0, 0)
+}
+
+func (f Func) CalcDiff(x float64, _ mat.Vector) float64 {
+	tape := NewTape()
+	input := tape.Variable(mat.NewDense(1, 1, []float64{x}))
+
+	if err := tape.Backward(f.F(tape, input), nil); err != nil {
+		return math.NaN()
+	}
+
+	return gradAt(input, 0, 0)
+}
+
+///
+/// VectorFunc Def
+///
+// a neuralnet.VectorFunc computing a whole layer activation with F, F gets the layer's
+// Zval as a column vector and returns a column vector of the same length
+type VectorFunc struct {
+	F func(t *Tape, z *Var) *Var
+}
+
+// NaN, a value of f(z) depends on its index and not only on x, layers use Apply and
+// BackpropVec and ValAt takes the index
+func (f VectorFunc) CalcVal(float64, mat.Vector) float64 {
+	return math.NaN()
+}
+
+// NaN like CalcVal, DiffAt takes the index
+func (f VectorFunc) CalcDiff(float64, mat.Vector) float64 {
+	return math.NaN()
+}
+
+// value at index i of f(z)
+func (f VectorFunc) ValAt(i int, z mat.Vector) float64 {
+	dst := mat.NewVecDense(z.Len(), nil)
+	f.Apply(dst, mat.VecDenseCopyOf(z))
+
+	return dst.AtVec(i)
+}
+
+// d f(z)_i / d z_i
+func (f VectorFunc) DiffAt(i int, z mat.Vector) float64 {
+	return f.Jacobian(mat.VecDenseCopyOf(z)).At(i, i)
+}
+
+func (f VectorFunc) Apply(dst, z *mat.VecDense) {
+	tape := NewTape()
+	output := f.F(tape, tape.Constant(column(z)))
+
+	dst.CopyVec(output.Value.ColView(0))
+}
+
+// one Backward call per output
+func (f VectorFunc) Jacobian(z *mat.VecDense) *mat.Dense {
+	tape := NewTape()
+	input := tape.Variable(column(z))
+	output := f.F(tape, input)
+
+	rows, _ := output.Value.Dims()
+	result := mat.NewDense(rows, z.Len(), nil)
+	for i := 0; i < rows; i++ {
+		seed := mat.NewDense(rows, 1, nil)
+		seed.Set(i, 0, 1)
+
+		if err := tape.Backward(output, seed); err != nil {
+			return nanDense(rows, z.Len())
+		}
+
+		for j := 0; j < z.Len(); j++ {
+			result.Set(i, j, gradAt(input, j, 0))
+		}
+	}
+
+	return result
+}
+
+func (f VectorFunc) BackpropVec(dst, z, _, grad *mat.VecDense) {
+	tape := NewTape()
+	input := tape.Variable(column(z))
+
+	if err := tape.Backward(f.F(tape, input), column(grad)); err != nil {
+		for i := 0; i < z.Len(); i++ {
+			dst.SetVec(i, math.NaN())
+		}
+		return
+	}
+
+	for i := 0; i < z.Len(); i++ {
+		dst.SetVec(i, gradAt(input, i, 0))
+	}
+}
+
+///
+/// Loss Def
+///
+// a neuralnet.Loss computed by F, which gets the actual and expected outputs as column
+// vectors and returns the loss as a 1x1 matrix
+type Loss struct {
+	F func(t *Tape, actual, expected *Var) *Var
+}
+
+func (l Loss) CalcLoss(actual, expected mat.Vector) float64 {
+	tape := NewTape()
+	return l.F(tape, tape.Constant(column(actual)), tape.Constant(column(expected))).Value.At(0, 0)
+}
+
+func (l Loss) CalcDiff(actual, expected mat.Vector) *mat.VecDense {
+	tape := NewTape()
+	input := tape.Variable(column(actual))
+
+	err := tape.Backward(l.F(tape, input, tape.Constant(column(expected))), nil)
+
+	result := mat.NewVecDense(actual.Len(), nil)
+	if err != nil {
+		result.CopyVec(nanDense(actual.Len(), 1).ColView(0))
+		return result
+	}
+
+	for i := 0; i < actual.Len(); i++ {
+		result.SetVec(i, gradAt(input, i, 0))
+	}
+
+	return result
+}
+
+// v as a single column matrix
+func column(v mat.Vector) *mat.Dense {
+	return mat.NewDense(v.Len(), 1, mat.VecDenseCopyOf(v).RawVector().Data)
+}
+
+// value of v's gradient, zero when the output does not depend on v
+func gradAt(v *Var, i, j int) float64 {
+	if v.Grad == nil {
+		return 0
+	}
+
+	return v.Grad.At(i, j)
+}
+
+// rows x cols matrix of NaN, the result of a failed Backward
+func nanDense(rows, cols int) *mat.Dense {
+	result := mat.NewDense(rows, cols, nil)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			result.Set(i, j, math.NaN())
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,146 @@
+package autodiff_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/autodiff"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testFuncs(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		sigmoid = autodiff.Func{F: (*autodiff.Tape).Sigmoid}
+		softmax = autodiff.VectorFunc{F: (*autodiff.Tape).Softmax}
+		mse     = autodiff.Loss{F: func(tape *autodiff.Tape, actual, expected *autodiff.Var) *autodiff.Var {
+			return tape.Scale(tape.Sum(tape.Square(tape.Sub(actual, expected))), 0.5)
+		}}
+
+		z *mat.VecDense
+	)
+
+	it.Before(func() {
+		z = mat.NewVecDense(3, []float64{0.5, -1.25, 2})
+	})
+
+	context("Func", func() {
+		it("matches the hand-written NodeFunc", func() {
+			for idx := 0; idx < z.Len(); idx++ {
+				x := z.AtVec(idx)
+				Expect(sigmoid.CalcVal(x, z)).To(BeNumerically("~", nodefuncs.Sigmoid{}.CalcVal(x, z), 1e-12))
+				Expect(sigmoid.CalcDiff(x, z)).To(BeNumerically("~", nodefuncs.Sigmoid{}.CalcDiff(x, z), 1e-12))
+			}
+		})
+	})
+
+	context("VectorFunc", func() {
+		it("matches the hand-written VectorFunc", func() {
+			expected := mat.NewVecDense(3, nil)
+			nodefuncs.Softmax{}.Apply(expected, z)
+			actual := mat.NewVecDense(3, nil)
+			softmax.Apply(actual, z)
+			Expect(mat.EqualApprox(actual, expected, 1e-12)).To(BeTrue())
+
+			Expect(mat.EqualApprox(softmax.Jacobian(z), nodefuncs.Softmax{}.Jacobian(z), 1e-12)).To(BeTrue())
+
+			grad := mat.NewVecDense(3, []float64{1, -2, 0.5})
+			nodefuncs.Softmax{}.BackpropVec(expected, z, expected, grad)
+			softmax.BackpropVec(actual, z, nil, grad)
+			Expect(mat.EqualApprox(actual, expected, 1e-12)).To(BeTrue())
+
+			Expect(softmax.ValAt(2, z)).To(BeNumerically("~", nodefuncs.Softmax{}.CalcVal(z.AtVec(2), z), 1e-12))
+			Expect(softmax.DiffAt(2, z)).To(BeNumerically("~", nodefuncs.Softmax{}.CalcDiff(z.AtVec(2), z), 1e-12))
+		})
+
+		it("evaluates the index it is given", func() {
+			weighted := autodiff.VectorFunc{F: func(tape *autodiff.Tape, z *autodiff.Var) *autodiff.Var {
+				return tape.Mul(tape.Constant(mat.NewDense(3, 1, []float64{1, 2, 3})), tape.Square(z))
+			}}
+			repeated := mat.NewVecDense(3, []float64{1, 1, 2})
+
+			Expect(weighted.ValAt(0, repeated)).To(Equal(1.0))
+			Expect(weighted.ValAt(1, repeated)).To(Equal(2.0))
+			Expect(weighted.DiffAt(1, repeated)).To(Equal(4.0))
+
+			Expect(math.IsNaN(weighted.CalcVal(1, repeated))).To(BeTrue())
+			Expect(math.IsNaN(weighted.CalcDiff(1, repeated))).To(BeTrue())
+		})
+
+		it("returns NaN derivatives when Backward fails", func() {
+			detached := autodiff.VectorFunc{F: func(_ *autodiff.Tape, z *autodiff.Var) *autodiff.Var {
+				return autodiff.NewTape().Constant(z.Value)
+			}}
+
+			jacobian := detached.Jacobian(z)
+			Expect(math.IsNaN(jacobian.At(0, 0))).To(BeTrue())
+			Expect(math.IsNaN(jacobian.At(2, 1))).To(BeTrue())
+
+			dst := mat.NewVecDense(3, nil)
+			detached.BackpropVec(dst, z, nil, mat.NewVecDense(3, []float64{1, 1, 1}))
+			Expect(math.IsNaN(dst.AtVec(0))).To(BeTrue())
+			Expect(math.IsNaN(dst.AtVec(2))).To(BeTrue())
+
+			detachedFunc := autodiff.Func{F: func(_ *autodiff.Tape, x *autodiff.Var) *autodiff.Var {
+				return autodiff.NewTape().Constant(x.Value)
+			}}
+			Expect(math.IsNaN(detachedFunc.CalcDiff(1, nil))).To(BeTrue())
+		})
+	})
+
+	context("Loss", func() {
+		it("matches the hand-written Loss", func() {
+			solution := mat.NewVecDense(3, []float64{1, 0, 0})
+
+			Expect(mse.CalcLoss(z, solution)).To(BeNumerically("~", losses.MSE{}.CalcLoss(z, solution), 1e-12))
+			Expect(mat.EqualApprox(mse.CalcDiff(z, solution), losses.MSE{}.CalcDiff(z, solution), 1e-12)).To(BeTrue())
+		})
+	})
+
+	context("in a Network", func() {
+		it("produces the gradients of the hand-written functions", func() {
+			build := func(hidden, output neuralnet.NodeFunc, loss neuralnet.Loss) neuralnet.Network {
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{Size: 3},
+						{Size: 4, Func: hidden},
+						{Size: 3, Func: output},
+					},
+					Loss:   loss,
+					Source: rand.NewSource(4),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				return network
+			}
+
+			gradient := func(network neuralnet.Network) neuralnet.Gradient {
+				_, err := network.Calculate(z)
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := network.GenerateDelta(mat.NewVecDense(3, []float64{0, 1, 0}))
+				Expect(err).NotTo(HaveOccurred())
+				result, err := network.GenerateGradient(delta)
+				Expect(err).NotTo(HaveOccurred())
+
+				return result
+			}
+
+			expected := gradient(build(nodefuncs.Sigmoid{}, nodefuncs.Softmax{}, losses.MSE{}))
+			actual := gradient(build(sigmoid, softmax, mse))
+
+			for idx, grads := range expected.Layers {
+				for paramIndex, grad := range grads {
+					Expect(mat.EqualApprox(actual.Layers[idx][paramIndex], grad, 1e-12)).To(BeTrue())
+				}
+			}
+		})
+	})
+}
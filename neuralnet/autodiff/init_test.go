@@ -0,0 +1,16 @@
+package autodiff_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitAutodiff(t *testing.T) {
+	suite := spec.New("Autodiff", spec.Report(report.Terminal{}))
+	suite("Tape", testTape)
+	suite("Ops", testOps)
+	suite("Funcs", testFuncs)
+	suite.Run(t)
+}
@@ -0,0 +1,309 @@
+package autodiff
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+///
+/// Linear Ops
+///
+// matrix product a·b
+func (t *Tape) MatMul(a, b *Var) *Var {
+	value := &mat.Dense{}
+	value.Mul(a.Value, b.Value)
+
+	return t.operation(value, []*Var{a, b}, func(grad *mat.Dense) {
+		if a.requiresGrad {
+			aGrad := &mat.Dense{}
+			aGrad.Mul(grad, b.Value.T())
+			a.accumulate(aGrad)
+		}
+
+		if b.requiresGrad {
+			bGrad := &mat.Dense{}
+			bGrad.Mul(a.Value.T(), grad)
+			b.accumulate(bGrad)
+		}
+	})
+}
+
+// a + b, b may also be a column vector which is then added to every column of a, e.g. a bias
+func (t *Tape) Add(a, b *Var) *Var {
+	ar, ac := a.Value.Dims()
+	br, bc := b.Value.Dims()
+
+	if ar == br && ac == bc {
+		value := &mat.Dense{}
+		value.Add(a.Value, b.Value)
+
+		return t.operation(value, []*Var{a, b}, func(grad *mat.Dense) {
+			a.accumulate(grad)
+			b.accumulate(grad)
+		})
+	}
+
+	if ar != br || bc != 1 {
+		panic(fmt.Sprintf("autodiff: cannot add %dx%d to %dx%d", br, bc, ar, ac))
+	}
+
+	value := &mat.Dense{}
+	value.Apply(func(i, _ int, v float64) float64 {
+		return v + b.Value.At(i, 0)
+	}, a.Value)
+
+	return t.operation(value, []*Var{a, b}, func(grad *mat.Dense) {
+		a.accumulate(grad)
+		b.accumulate(rowSums(grad))
+	})
+}
+
+// a - b, both of the same dimensions
+func (t *Tape) Sub(a, b *Var) *Var {
+	value := &mat.Dense{}
+	value.Sub(a.Value, b.Value)
+
+	return t.operation(value, []*Var{a, b}, func(grad *mat.Dense) {
+		a.accumulate(grad)
+
+		if b.requiresGrad {
+			bGrad := &mat.Dense{}
+			bGrad.Scale(-1, grad)
+			b.accumulate(bGrad)
+		}
+	})
+}
+
+// elementwise product of a and b
+func (t *Tape) Mul(a, b *Var) *Var {
+	value := &mat.Dense{}
+	value.MulElem(a.Value, b.Value)
+
+	return t.operation(value, []*Var{a, b}, func(grad *mat.Dense) {
+		if a.requiresGrad {
+			aGrad := &mat.Dense{}
+			aGrad.MulElem(grad, b.Value)
+			a.accumulate(aGrad)
+		}
+
+		if b.requiresGrad {
+			bGrad := &mat.Dense{}
+			bGrad.MulElem(grad, a.Value)
+			b.accumulate(bGrad)
+		}
+	})
+}
+
+func (t *Tape) Scale(a *Var, factor float64) *Var {
+	value := &mat.Dense{}
+	value.Scale(factor, a.Value)
+
+	return t.operation(value, []*Var{a}, func(grad *mat.Dense) {
+		aGrad := &mat.Dense{}
+		aGrad.Scale(factor, grad)
+		a.accumulate(aGrad)
+	})
+}
+
+///
+/// Elementwise Ops
+///
+// f applied to every value of a, df is its derivative
+func (t *Tape) Apply(a *Var, f, df func(float64) float64) *Var {
+	value := &mat.Dense{}
+	value.Apply(func(_, _ int, v float64) float64 {
+		return f(v)
+	}, a.Value)
+
+	return t.operation(value, []*Var{a}, func(grad *mat.Dense) {
+		aGrad := &mat.Dense{}
+		aGrad.Apply(func(i, j int, g float64) float64 {
+			return g * df(a.Value.At(i, j))
+		}, grad)
+		a.accumulate(aGrad)
+	})
+}
+
+func (t *Tape) Sigmoid(a *Var) *Var {
+	return t.Apply(a, sigmoid, func(v float64) float64 {
+		s := sigmoid(v)
+		return s * (1 - s)
+	})
+}
+
+func (t *Tape) Tanh(a *Var) *Var {
+	return t.Apply(a, math.Tanh, func(v float64) float64 {
+		tanh := math.Tanh(v)
+		return 1 - tanh*tanh
+	})
+}
+
+// the derivative at 0 is taken to be 0
+func (t *Tape) Relu(a *Var) *Var {
+	return t.Apply(a, func(v float64) float64 {
+		return math.Max(v, 0)
+	}, func(v float64) float64 {
+		if v > 0 {
+			return 1
+		}
+
+		return 0
+	})
+}
+
+func (t *Tape) Exp(a *Var) *Var {
+	return t.Apply(a, math.Exp, math.Exp)
+}
+
+func (t *Tape) Log(a *Var) *Var {
+	return t.Apply(a, math.Log, func(v float64) float64 {
+		return 1 / v
+	})
+}
+
+func (t *Tape) Square(a *Var) *Var {
+	return t.Apply(a, func(v float64) float64 {
+		return v * v
+	}, func(v float64) float64 {
+		return 2 * v
+	})
+}
+
+///
+/// Reduction Ops
+///
+// sum of every value of a as a 1x1 matrix
+func (t *Tape) Sum(a *Var) *Var {
+	value := mat.NewDense(1, 1, []float64{mat.Sum(a.Value)})
+
+	return t.operation(value, []*Var{a}, func(grad *mat.Dense) {
+		r, c := a.Value.Dims()
+		a.accumulate(filled(r, c, grad.At(0, 0)))
+	})
+}
+
+// mean of every value of a as a 1x1 matrix
+func (t *Tape) Mean(a *Var) *Var {
+	r, c := a.Value.Dims()
+	return t.Scale(t.Sum(a), 1/float64(r*c))
+}
+
+// 1 x columns matrix holding the sum of every column of a, e.g. a per sample loss
+func (t *Tape) SumCols(a *Var) *Var {
+	r, c := a.Value.Dims()
+
+	value := mat.NewDense(1, c, nil)
+	for j := 0; j < c; j++ {
+		value.Set(0, j, mat.Sum(a.Value.ColView(j)))
+	}
+
+	return t.operation(value, []*Var{a}, func(grad *mat.Dense) {
+		aGrad := mat.NewDense(r, c, nil)
+		aGrad.Apply(func(_, j int, _ float64) float64 {
+			return grad.At(0, j)
+		}, aGrad)
+		a.accumulate(aGrad)
+	})
+}
+
+///
+/// Softmax Ops
+///
+// softmax of every column of a
+func (t *Tape) Softmax(a *Var) *Var {
+	value := softmax(a.Value)
+
+	return t.operation(value, []*Var{a}, func(grad *mat.Dense) {
+		// grad_in = s * (grad - Σ grad*s) in every column
+		weighted := &mat.Dense{}
+		weighted.MulElem(grad, value)
+		sums := colSums(weighted)
+
+		aGrad := &mat.Dense{}
+		aGrad.Apply(func(i, j int, g float64) float64 {
+			return value.At(i, j) * (g - sums[j])
+		}, grad)
+		a.accumulate(aGrad)
+	})
+}
+
+// log of the softmax of every column of a, computed without overflow
+func (t *Tape) LogSoftmax(a *Var) *Var {
+	probabilities := softmax(a.Value)
+
+	value := &mat.Dense{}
+	value.Apply(func(_, _ int, v float64) float64 {
+		return math.Log(v)
+	}, probabilities)
+
+	return t.operation(value, []*Var{a}, func(grad *mat.Dense) {
+		// grad_in = grad - s * Σ grad in every column
+		sums := colSums(grad)
+
+		aGrad := &mat.Dense{}
+		aGrad.Apply(func(i, j int, g float64) float64 {
+			return g - probabilities.At(i, j)*sums[j]
+		}, grad)
+		a.accumulate(aGrad)
+	})
+}
+
+func softmax(m *mat.Dense) *mat.Dense {
+	r, c := m.Dims()
+	result := mat.NewDense(r, c, nil)
+
+	for j := 0; j < c; j++ {
+		max := mat.Max(m.ColView(j))
+
+		sum := float64(0)
+		for i := 0; i < r; i++ {
+			v := math.Exp(m.At(i, j) - max)
+			result.Set(i, j, v)
+			sum += v
+		}
+
+		for i := 0; i < r; i++ {
+			result.Set(i, j, result.At(i, j)/sum)
+		}
+	}
+
+	return result
+}
+
+func sigmoid(v float64) float64 {
+	return 1 / (1 + math.Exp(-v))
+}
+
+// column vector of the sum of every row of m
+func rowSums(m *mat.Dense) *mat.Dense {
+	r, _ := m.Dims()
+
+	result := mat.NewDense(r, 1, nil)
+	for i := 0; i < r; i++ {
+		result.Set(i, 0, mat.Sum(m.RowView(i)))
+	}
+
+	return result
+}
+
+func colSums(m *mat.Dense) []float64 {
+	_, c := m.Dims()
+
+	result := make([]float64, c)
+	for j := range result {
+		result[j] = mat.Sum(m.ColView(j))
+	}
+
+	return result
+}
+
+func filled(r, c int, value float64) *mat.Dense {
+	result := mat.NewDense(r, c, nil)
+	result.Apply(func(_, _ int, _ float64) float64 {
+		return value
+	}, result)
+
+	return result
+}
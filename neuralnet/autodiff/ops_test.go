@@ -0,0 +1,119 @@
+package autodiff_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/autodiff"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testOps(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		a, b, bias *mat.Dense
+	)
+
+	it.Before(func() {
+		a = mat.NewDense(3, 2, []float64{
+			0.5, -1,
+			0.25, 2,
+			-0.75, 0.1,
+		})
+		b = mat.NewDense(3, 2, []float64{
+			1.5, 0.3,
+			-0.2, 0.7,
+			0.4, -2,
+		})
+		bias = mat.NewDense(3, 1, []float64{0.1, -0.2, 0.3})
+	})
+
+	binary := func(op func(*autodiff.Tape, *autodiff.Var, *autodiff.Var) *autodiff.Var) func(*autodiff.Tape, []*autodiff.Var) *autodiff.Var {
+		return func(tape *autodiff.Tape, vars []*autodiff.Var) *autodiff.Var {
+			return op(tape, vars[0], vars[1])
+		}
+	}
+
+	unary := func(op func(*autodiff.Tape, *autodiff.Var) *autodiff.Var) func(*autodiff.Tape, []*autodiff.Var) *autodiff.Var {
+		return func(tape *autodiff.Tape, vars []*autodiff.Var) *autodiff.Var {
+			return op(tape, vars[0])
+		}
+	}
+
+	context("gradients", func() {
+		it("matches finite differences for linear ops", func() {
+			checkGradients(t, binary((*autodiff.Tape).Add), a, b)
+			checkGradients(t, binary((*autodiff.Tape).Add), a, bias)
+			checkGradients(t, binary((*autodiff.Tape).Sub), a, b)
+			checkGradients(t, binary((*autodiff.Tape).Mul), a, b)
+			checkGradients(t, binary((*autodiff.Tape).MatMul), mat.DenseCopyOf(b.T()), a)
+			checkGradients(t, func(tape *autodiff.Tape, vars []*autodiff.Var) *autodiff.Var {
+				return tape.Scale(vars[0], -2.5)
+			}, a)
+		})
+
+		it("matches finite differences for elementwise ops", func() {
+			for _, op := range []func(*autodiff.Tape, *autodiff.Var) *autodiff.Var{
+				(*autodiff.Tape).Sigmoid,
+				(*autodiff.Tape).Tanh,
+				(*autodiff.Tape).Relu,
+				(*autodiff.Tape).Exp,
+				(*autodiff.Tape).Square,
+			} {
+				checkGradients(t, unary(op), a)
+			}
+
+			positive := mat.NewDense(2, 2, []float64{0.5, 1, 2, 3})
+			checkGradients(t, unary((*autodiff.Tape).Log), positive)
+		})
+
+		it("matches finite differences for reductions and softmax", func() {
+			for _, op := range []func(*autodiff.Tape, *autodiff.Var) *autodiff.Var{
+				(*autodiff.Tape).Sum,
+				(*autodiff.Tape).Mean,
+				(*autodiff.Tape).SumCols,
+				(*autodiff.Tape).Softmax,
+				(*autodiff.Tape).LogSoftmax,
+			} {
+				checkGradients(t, unary(op), a)
+			}
+		})
+
+		it("matches finite differences for a two layer network", func() {
+			weights := mat.NewDense(2, 3, []float64{0.1, -0.4, 0.3, 0.8, 0.2, -0.5})
+			output := mat.NewDense(2, 1, []float64{0.05, -0.1})
+
+			checkGradients(t, func(tape *autodiff.Tape, vars []*autodiff.Var) *autodiff.Var {
+				hidden := tape.Tanh(tape.Add(vars[0], vars[1]))
+				return tape.LogSoftmax(tape.Add(tape.MatMul(vars[2], hidden), vars[3]))
+			}, a, bias, weights, output)
+		})
+	})
+
+	context("values", func() {
+		it("broadcasts column vectors over every column", func() {
+			tape := autodiff.NewTape()
+			result := tape.Add(tape.Constant(a), tape.Constant(bias))
+
+			Expect(result.Value.At(2, 1)).To(BeNumerically("~", 0.4, 1e-12))
+
+			Expect(func() {
+				tape.Add(tape.Constant(a), tape.Constant(mat.NewDense(2, 1, nil)))
+			}).To(Panic())
+		})
+
+		it("normalizes every column with softmax", func() {
+			tape := autodiff.NewTape()
+			probabilities := tape.Softmax(tape.Constant(mat.NewDense(2, 2, []float64{1000, 0, 1000, 0})))
+			logProbabilities := tape.LogSoftmax(tape.Constant(mat.NewDense(2, 2, []float64{1000, 0, 1000, 0})))
+
+			Expect(probabilities.Value.RawMatrix().Data).To(Equal([]float64{0.5, 0.5, 0.5, 0.5}))
+			Expect(logProbabilities.Value.At(0, 0)).To(BeNumerically("~", math.Log(0.5), 1e-12))
+			Expect(tape.SumCols(probabilities).Value.RawMatrix().Data).To(Equal([]float64{1, 1}))
+		})
+	})
+}
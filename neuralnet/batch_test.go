@@ -0,0 +1,192 @@
+package neuralnet_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testBatch(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		network   neuralnet.Network
+		inputs    *mat.Dense
+		solutions *mat.Dense
+	)
+
+	it.Before(func() {
+		network = newNetwork(t, neuralnet.Config{Source: rand.NewSource(5)}, neuralnet.LayerConfig{Func: nodefuncs.Sigmoid{}}, neuralnet.LayerConfig{Func: nodefuncs.Sigmoid{}})
+
+		inputs = mat.NewDense(3, 4, []float64{
+			0.1, 0.5, -0.3, 1,
+			0.2, -0.4, 0.8, 0,
+			-0.6, 0.7, 0.9, 0.5,
+		})
+		solutions = mat.NewDense(2, 4, []float64{
+			1, 0, 0, 1,
+			0, 1, 1, 0,
+		})
+	})
+
+	column := func(m *mat.Dense, j int) *mat.VecDense {
+		return mat.VecDenseCopyOf(m.ColView(j))
+	}
+
+	context("CalculateBatch", func() {
+		it("matches Calculate for every column", func() {
+			output, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			r, c := output.Dims()
+			Expect(r).To(Equal(2))
+			Expect(c).To(Equal(4))
+
+			for j := 0; j < c; j++ {
+				expected, err := network.Calculate(column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mat.EqualApprox(column(output, j), expected, 1e-12)).To(BeTrue())
+			}
+
+			Expect(network.ActivationBatch).To(HaveLen(3))
+			Expect(network.ZvalBatch).To(HaveLen(3))
+		})
+
+		context("failure cases", func() {
+			it("when input has the wrong size", func() {
+				_, err := network.CalculateBatch(mat.NewDense(2, 4, nil))
+				Expect(err).To(MatchError("invalid input size: 2"))
+			})
+		})
+	})
+
+	context("GenerateGradientBatch", func() {
+		for _, tc := range []struct {
+			name   string
+			output neuralnet.NodeFunc
+			loss   neuralnet.Loss
+		}{
+			{"Sigmoid with MSE", nodefuncs.Sigmoid{}, losses.MSE{}},
+			{"Softmax with CrossEntropy", nodefuncs.Softmax{}, losses.CrossEntropy{}},
+			{"Softmax with MSE", nodefuncs.Softmax{}, losses.MSE{}},
+		} {
+			tc := tc
+
+			it("sums per sample gradients for "+tc.name, func() {
+				network = newNetwork(t, neuralnet.Config{Loss: tc.loss, Source: rand.NewSource(5)}, neuralnet.LayerConfig{Func: nodefuncs.Sigmoid{}}, neuralnet.LayerConfig{Func: tc.output})
+
+				var expected neuralnet.Gradient
+				expectedLoss := float64(0)
+				for j := 0; j < 4; j++ {
+					_, err := network.Calculate(column(inputs, j))
+					Expect(err).NotTo(HaveOccurred())
+
+					loss, err := network.CalcLoss(column(solutions, j))
+					Expect(err).NotTo(HaveOccurred())
+					expectedLoss += loss
+
+					delta, err := network.GenerateDelta(column(solutions, j))
+					Expect(err).NotTo(HaveOccurred())
+					gradient, err := network.GenerateGradient(delta)
+					Expect(err).NotTo(HaveOccurred())
+					expected.Add(gradient)
+				}
+
+				_, err := network.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+
+				loss, err := network.CalcLossBatch(solutions)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(loss).To(BeNumerically("~", expectedLoss, 1e-12))
+
+				delta, err := network.GenerateDeltaBatch(solutions)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(delta).To(HaveLen(2))
+
+				gradient, err := network.GenerateGradientBatch(delta)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(equalGradients(gradient, expected, 1e-12)).To(BeTrue())
+			})
+		}
+
+		context("failure cases", func() {
+			it("when no batch has been calculated", func() {
+				_, err := network.GenerateDeltaBatch(solutions)
+				Expect(err).To(MatchError("no batch has been calculated"))
+			})
+
+			it("when solutions have the wrong dimension", func() {
+				_, err := network.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = network.GenerateDeltaBatch(mat.NewDense(3, 4, nil))
+				Expect(err).To(MatchError("invalid solution dimension: 3, expected 2"))
+
+				_, err = network.CalcLossBatch(mat.NewDense(2, 3, nil))
+				Expect(err).To(MatchError("invalid solution batch size: 3, expected 4"))
+			})
+
+			it("when delta count does not match the network", func() {
+				_, err := network.GenerateGradientBatch(nil)
+				Expect(err).To(MatchError("invalid delta count: 0, expected 2"))
+			})
+		})
+	})
+}
+
+// a network of 3 inputs, a hidden layer of 4 and 2 outputs, hidden and output hold the
+// options of their layers and may change their sizes. Some biases are set so that tests
+// do not pass only because the biases are zero.
+func newNetwork(t *testing.T, config neuralnet.Config, hidden, output neuralnet.LayerConfig) neuralnet.Network {
+	if hidden.Size == 0 {
+		hidden.Size = 4
+	}
+	if output.Size == 0 {
+		output.Size = 2
+	}
+	config.LayerConfigs = []neuralnet.LayerConfig{{Size: 3}, hidden, output}
+
+	network, err := neuralnet.NewNetwork(config)
+	NewWithT(t).Expect(err).NotTo(HaveOccurred())
+
+	network.Bias[1].SetVec(0, 0.3)
+	network.Bias[2].SetVec(1, -0.2)
+
+	return network
+}
+
+// whether every gradient of actual matches the one of expected within tolerance
+func equalGradients(actual, expected neuralnet.Gradient, tolerance float64) bool {
+	if len(actual.Layers) != len(expected.Layers) {
+		return false
+	}
+
+	for idx, grads := range expected.Layers {
+		if len(actual.Layers[idx]) != len(grads) {
+			return false
+		}
+
+		for paramIndex, grad := range grads {
+			if !mat.EqualApprox(actual.Layers[idx][paramIndex], grad, tolerance) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// weights and bias gradients of the Dense layer feeding LayerConfigs[layer] of network,
+// sparse weights have a single row holding the gradients of their stored values
+func denseGradient(network neuralnet.Network, gradient neuralnet.Gradient, layer int) (*mat.Dense, *mat.VecDense) {
+	grads := gradient.Layers[network.LayerIndex(layer)]
+	return grads[0], mat.VecDenseCopyOf(grads[1].ColView(0))
+}
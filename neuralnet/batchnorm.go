@@ -0,0 +1,290 @@
+package neuralnet
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// BatchNorm layers normalize every value of a sample over the batch,
+// y = Gamma*(z-mean)/sqrt(var+Epsilon) + Beta for every node. Layers with
+// LayerConfig.BatchNorm normalize their Zval with one before Func is applied. In training
+// mode CalculateBatch normalizes with the mean and variance of the batch and folds them
+// into the running statistics. Everywhere else, including every single sample method,
+// the running statistics are used so the layer is a fixed affine transform.
+// Zval and ZvalBatch hold the normalized values and deltas are taken with respect to them.
+
+const (
+	DefaultNormMomentum = .9
+	DefaultNormEpsilon  = 1e-5
+)
+
+///
+/// BatchNorm Def
+///
+// BatchNorm holds the learned scale and shift and the running statistics of a layer.
+// Gamma and Beta are its Params and the running statistics its State.
+type BatchNorm struct {
+	Gamma       *mat.VecDense
+	Beta        *mat.VecDense
+	RunningMean *mat.VecDense
+	RunningVar  *mat.VecDense
+	// weight of the previous running statistics in every update
+	Momentum float64
+	// added to the variance to avoid dividing by zero
+	Epsilon float64
+
+	cache     *NormCache
+	batchSize int
+	gammaGrad *mat.Dense
+	betaGrad  *mat.Dense
+}
+
+// identity transform with unit running variance
+func NewBatchNorm(size int) *BatchNorm {
+	result := &BatchNorm{
+		Gamma:       mat.NewVecDense(size, nil),
+		Beta:        mat.NewVecDense(size, nil),
+		RunningMean: mat.NewVecDense(size, nil),
+		RunningVar:  mat.NewVecDense(size, nil),
+		Momentum:    DefaultNormMomentum,
+		Epsilon:     DefaultNormEpsilon,
+	}
+
+	for i := 0; i < size; i++ {
+		result.Gamma.SetVec(i, 1)
+		result.RunningVar.SetVec(i, 1)
+	}
+
+	return result
+}
+
+// values of a batch normalized layer kept for the backward pass, see NormalizeBatch
+type NormCache struct {
+	// (z-mean)/sqrt(var+Epsilon) before Gamma and Beta are applied
+	normalized *mat.Dense
+	invStd     *mat.VecDense
+	// set when mean and variance came from the batch rather than the running statistics
+	batchStats bool
+}
+
+func (b *BatchNorm) invStd() *mat.VecDense {
+	result := mat.NewVecDense(b.RunningVar.Len(), nil)
+	for i := 0; i < result.Len(); i++ {
+		result.SetVec(i, 1/math.Sqrt(b.RunningVar.AtVec(i)+b.Epsilon))
+	}
+
+	return result
+}
+
+// normalizes every column of z in place. Batch statistics are used in training mode
+// when there are at least 2 samples, they then update the running statistics.
+// The result is needed by BackwardBatch and Gradient.
+func (b *BatchNorm) NormalizeBatch(z *mat.Dense, training bool) *NormCache {
+	r, c := z.Dims()
+	result := &NormCache{
+		normalized: mat.NewDense(r, c, nil),
+		batchStats: training && c > 1,
+	}
+
+	if result.batchStats {
+		result.invStd = mat.NewVecDense(r, nil)
+
+		for i := 0; i < r; i++ {
+			row := z.RawRowView(i)
+
+			mean := float64(0)
+			for _, v := range row {
+				mean += v
+			}
+			mean /= float64(c)
+
+			variance := float64(0)
+			for _, v := range row {
+				variance += (v - mean) * (v - mean)
+			}
+			variance /= float64(c)
+
+			// running variance is unbiased so inference matches the population
+			b.RunningMean.SetVec(i, b.Momentum*b.RunningMean.AtVec(i)+(1-b.Momentum)*mean)
+			b.RunningVar.SetVec(i, b.Momentum*b.RunningVar.AtVec(i)+(1-b.Momentum)*variance*float64(c)/float64(c-1))
+
+			invStd := 1 / math.Sqrt(variance+b.Epsilon)
+			result.invStd.SetVec(i, invStd)
+
+			for j, v := range row {
+				result.normalized.Set(i, j, (v-mean)*invStd)
+			}
+		}
+	} else {
+		result.invStd = b.invStd()
+
+		for i := 0; i < r; i++ {
+			for j, v := range z.RawRowView(i) {
+				result.normalized.Set(i, j, (v-b.RunningMean.AtVec(i))*result.invStd.AtVec(i))
+			}
+		}
+	}
+
+	z.Apply(func(i, _ int, v float64) float64 {
+		return b.Gamma.AtVec(i)*v + b.Beta.AtVec(i)
+	}, result.normalized)
+
+	return result
+}
+
+// batched backward for the z normalized into cache, with batch statistics every sample's
+// delta depends on the whole batch:
+// dz = invStd/c * (c*dx - sum(dx) - x*sum(dx*x)) where dx = Gamma*delta and x is normalized
+func (b *BatchNorm) BackwardBatch(cache *NormCache, delta *mat.Dense) *mat.Dense {
+	r, c := delta.Dims()
+	result := mat.NewDense(r, c, nil)
+
+	for i := 0; i < r; i++ {
+		gamma := b.Gamma.AtVec(i)
+		invStd := cache.invStd.AtVec(i)
+
+		if !cache.batchStats {
+			for j := 0; j < c; j++ {
+				result.Set(i, j, delta.At(i, j)*gamma*invStd)
+			}
+
+			continue
+		}
+
+		sum, dotNormalized := float64(0), float64(0)
+		for j := 0; j < c; j++ {
+			dx := delta.At(i, j) * gamma
+			sum += dx
+			dotNormalized += dx * cache.normalized.At(i, j)
+		}
+
+		for j := 0; j < c; j++ {
+			dx := delta.At(i, j) * gamma
+			value := float64(c)*dx - sum - cache.normalized.At(i, j)*dotNormalized
+			result.Set(i, j, invStd*value/float64(c))
+		}
+	}
+
+	return result
+}
+
+// Gamma and Beta gradients summed over every sample, given the delta with respect to the
+// values normalized by NormalizeBatch
+func (c *NormCache) Gradient(delta *mat.Dense) (gamma, beta *mat.VecDense) {
+	return normGradient(delta, c.normalized)
+}
+
+// Gamma and Beta gradients summed over the columns of delta and normalized
+func normGradient(delta, normalized mat.Matrix) (gamma, beta *mat.VecDense) {
+	r, c := delta.Dims()
+	gamma = mat.NewVecDense(r, nil)
+	beta = mat.NewVecDense(r, nil)
+
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			gamma.SetVec(i, gamma.AtVec(i)+delta.At(i, j)*normalized.At(i, j))
+			beta.SetVec(i, beta.AtVec(i)+delta.At(i, j))
+		}
+	}
+
+	return gamma, beta
+}
+
+func (b *BatchNorm) Forward(input *mat.Dense, training bool) (*mat.Dense, error) {
+	result := mat.DenseCopyOf(input)
+	b.cache = b.NormalizeBatch(result, training)
+	_, b.batchSize = input.Dims()
+
+	return result, nil
+}
+
+func (b *BatchNorm) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	if err := checkGrad("batch norm", grad, b.Gamma.Len(), b.batchSize); err != nil {
+		return nil, err
+	}
+
+	gamma, beta := b.cache.Gradient(grad)
+	b.gammaGrad = sharedColumn(gamma)
+	b.betaGrad = sharedColumn(beta)
+
+	return b.BackwardBatch(b.cache, grad), nil
+}
+
+func (b *BatchNorm) Params() []*mat.Dense {
+	return []*mat.Dense{sharedColumn(b.Gamma), sharedColumn(b.Beta)}
+}
+
+func (b *BatchNorm) Decayed() []bool {
+	return []bool{false, false}
+}
+
+func (b *BatchNorm) Grads() []*mat.Dense {
+	return []*mat.Dense{b.gammaGrad, b.betaGrad}
+}
+
+func (b *BatchNorm) State() []*mat.Dense {
+	return []*mat.Dense{sharedColumn(b.RunningMean), sharedColumn(b.RunningVar)}
+}
+
+func (b *BatchNorm) OutputShape(input Shape) (Shape, error) {
+	if size := b.Gamma.Len(); input.Size() != size {
+		return nil, fmt.Errorf("invalid input size: %d, expected %d", input.Size(), size)
+	}
+
+	return input, nil
+}
+
+// shares every value, replicas only keep their own cache
+func (b *BatchNorm) Replica(_ *rand.Rand) Layer {
+	return &BatchNorm{
+		Gamma:       b.Gamma,
+		Beta:        b.Beta,
+		RunningMean: b.RunningMean,
+		RunningVar:  b.RunningVar,
+		Momentum:    b.Momentum,
+		Epsilon:     b.Epsilon,
+	}
+}
+
+type batchNormConfig struct {
+	Size     int     `json:"size"`
+	Momentum float64 `json:"momentum"`
+	Epsilon  float64 `json:"epsilon"`
+}
+
+func (b *BatchNorm) LayerConfig() interface{} {
+	return batchNormConfig{
+		Size:     b.Gamma.Len(),
+		Momentum: b.Momentum,
+		Epsilon:  b.Epsilon,
+	}
+}
+
+func loadBatchNorm(data []byte, _ *rand.Rand) (Layer, error) {
+	var config batchNormConfig
+	if err := decodeConfig("batch norm", data, &config); err != nil {
+		return nil, err
+	}
+
+	if config.Size <= 0 {
+		return nil, fmt.Errorf("invalid batch norm size: %d", config.Size)
+	}
+
+	if err := CheckLoadSize(config.Size, 1); err != nil {
+		return nil, err
+	}
+
+	result := NewBatchNorm(config.Size)
+	result.Momentum = config.Momentum
+	result.Epsilon = config.Epsilon
+
+	return result, nil
+}
+
+// column matrix sharing the values of v, which must not be strided
+func sharedColumn(v *mat.VecDense) *mat.Dense {
+	return mat.NewDense(v.Len(), 1, v.RawVector().Data)
+}
@@ -0,0 +1,321 @@
+package neuralnet_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testBatchNorm(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		network   neuralnet.Network
+		inputs    *mat.Dense
+		solutions *mat.Dense
+	)
+
+	// gamma and beta start away from 1 and 0 so that the tests cover them
+	normalized := func(hidden neuralnet.NodeFunc) neuralnet.Network {
+		result := newNetwork(t, neuralnet.Config{Source: rand.NewSource(8)},
+			neuralnet.LayerConfig{Func: hidden, BatchNorm: true},
+			neuralnet.LayerConfig{Func: nodefuncs.Sigmoid{}})
+
+		norm := result.Norms[1]
+		norm.Gamma.SetVec(0, 1.5)
+		norm.Gamma.SetVec(2, 0.5)
+		norm.Beta.SetVec(1, 0.25)
+		norm.Beta.SetVec(3, -0.5)
+
+		return result
+	}
+
+	it.Before(func() {
+		network = normalized(nodefuncs.Sigmoid{})
+
+		inputs = mat.NewDense(3, 4, []float64{
+			0.1, 0.5, -0.3, 1,
+			0.2, -0.4, 0.8, 0,
+			-0.6, 0.7, 0.9, 0.5,
+		})
+		solutions = mat.NewDense(2, 4, []float64{
+			1, 0, 0, 1,
+			0, 1, 1, 0,
+		})
+	})
+
+	batchGradient := func(network *neuralnet.Network) neuralnet.Gradient {
+		_, err := network.CalculateBatch(inputs)
+		Expect(err).NotTo(HaveOccurred())
+		delta, err := network.GenerateDeltaBatch(solutions)
+		Expect(err).NotTo(HaveOccurred())
+		gradient, err := network.GenerateGradientBatch(delta)
+		Expect(err).NotTo(HaveOccurred())
+
+		return gradient
+	}
+
+	context("NewNetwork", func() {
+		it("only creates BatchNorms for normalized layers", func() {
+			Expect(network.Norms).To(HaveLen(3))
+			Expect(network.Norms[0]).To(BeNil())
+			Expect(network.Norms[2]).To(BeNil())
+
+			norm := neuralnet.NewBatchNorm(2)
+			Expect(norm.Gamma.RawVector().Data).To(Equal([]float64{1, 1}))
+			Expect(norm.Beta.RawVector().Data).To(Equal([]float64{0, 0}))
+			Expect(norm.RunningMean.RawVector().Data).To(Equal([]float64{0, 0}))
+			Expect(norm.RunningVar.RawVector().Data).To(Equal([]float64{1, 1}))
+			Expect(norm.Momentum).To(Equal(neuralnet.DefaultNormMomentum))
+			Expect(norm.Epsilon).To(Equal(neuralnet.DefaultNormEpsilon))
+		})
+
+		it("leaves Norms nil without normalized layers", func() {
+			plain, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{{Size: 1}, {Size: 1, Func: nodefuncs.Relu{}}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plain.Norms).To(BeNil())
+		})
+
+		it("rejects batch normalization on the input layer", func() {
+			_, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{{Size: 1, BatchNorm: true}, {Size: 1, Func: nodefuncs.Relu{}}},
+			})
+			Expect(err).To(MatchError("batch normalization is not supported on the input layer"))
+		})
+	})
+
+	context("in training mode", func() {
+		it.Before(func() {
+			network = normalized(nodefuncs.Identity{})
+			network.SetTraining(true)
+		})
+
+		it("normalizes every node over the batch", func() {
+			_, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			norm := network.Norms[1]
+			for i := 0; i < 4; i++ {
+				row := network.ZvalBatch[1].RawRowView(i)
+
+				mean, variance := float64(0), float64(0)
+				for _, v := range row {
+					mean += v / 4
+				}
+				for _, v := range row {
+					variance += (v - mean) * (v - mean) / 4
+				}
+
+				Expect(mean).To(BeNumerically("~", norm.Beta.AtVec(i), 1e-12))
+				Expect(math.Sqrt(variance)).To(BeNumerically("~", norm.Gamma.AtVec(i), 1e-3))
+			}
+		})
+
+		it("folds the batch statistics into the running statistics", func() {
+			_, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			z := &mat.Dense{}
+			z.Mul(network.Weights[0], inputs)
+			z.Apply(func(i, _ int, v float64) float64 {
+				return v + network.Bias[1].AtVec(i)
+			}, z)
+
+			norm := network.Norms[1]
+			for i := 0; i < 4; i++ {
+				row := z.RawRowView(i)
+
+				mean, variance := float64(0), float64(0)
+				for _, v := range row {
+					mean += v / 4
+				}
+				for _, v := range row {
+					variance += (v - mean) * (v - mean) / 3
+				}
+
+				Expect(norm.RunningMean.AtVec(i)).To(BeNumerically("~", 0.1*mean, 1e-12))
+				Expect(norm.RunningVar.AtVec(i)).To(BeNumerically("~", 0.9+0.1*variance, 1e-12))
+			}
+		})
+
+		it("matches finite differences of the batch loss", func() {
+			network = normalized(nodefuncs.Sigmoid{})
+			network.SetTraining(true)
+
+			report, err := gradcheck.CheckBatch(&network, inputs, solutions, 0)
+			Expect(err).NotTo(HaveOccurred())
+			for _, layer := range report.Layers {
+				Expect(layer.Weights).To(BeNumerically("<", 1e-6))
+				Expect(layer.Gamma).To(BeNumerically("<", 1e-6))
+				Expect(layer.Beta).To(BeNumerically("<", 1e-6))
+			}
+			Expect(report.Layers[1].Bias).To(BeNumerically("<", 1e-6))
+
+			// the batch mean cancels the bias of a normalized layer
+			gradient := batchGradient(&network)
+			_, bias := denseGradient(network, gradient, 1)
+			Expect(mat.Norm(bias, math.Inf(1))).To(BeNumerically("<", 1e-12))
+
+			// the output layer is not normalized, its Dense layer feeds its Activation layer
+			Expect(gradient.Layers[network.LayerIndex(2)+1]).To(BeEmpty())
+		})
+
+		it("refuses to compute single sample gradients", func() {
+			_, err := network.ComputeGradient(network.NewWorkspace(), column(inputs, 0), column(solutions, 0))
+			Expect(err).To(MatchError("layer at index 1 updates its state, which requires CalculateBatch in training mode"))
+			Expect(network.CheckParallel()).To(MatchError("layer at index 1 updates its state, which requires CalculateBatch in training mode"))
+		})
+	})
+
+	context("in inference mode", func() {
+		it.Before(func() {
+			norm := network.Norms[1]
+			norm.RunningMean.SetVec(0, 0.2)
+			norm.RunningMean.SetVec(3, -0.1)
+			norm.RunningVar.SetVec(1, 0.5)
+			norm.RunningVar.SetVec(2, 2)
+		})
+
+		it("normalizes with the running statistics", func() {
+			output, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			for j := 0; j < 4; j++ {
+				expected, err := network.Calculate(column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mat.EqualApprox(column(output, j), expected, 1e-12)).To(BeTrue())
+
+				predicted, err := network.Predict(column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(predicted).To(Equal(expected))
+			}
+
+			norm := network.Norms[1]
+			Expect(norm.RunningMean.AtVec(0)).To(Equal(0.2))
+			Expect(norm.RunningVar.AtVec(1)).To(Equal(0.5))
+		})
+
+		it("passes the gradient check", func() {
+			report, err := gradcheck.Check(&network, column(inputs, 1), column(solutions, 1), 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Max()).To(BeNumerically("<", 1e-6))
+		})
+
+		it("matches the batch gradient summed over samples", func() {
+			expected := batchGradient(&network)
+
+			var actual neuralnet.Gradient
+			for j := 0; j < 4; j++ {
+				gradient, err := network.ComputeGradient(network.NewWorkspace(), column(inputs, j), column(solutions, j))
+				Expect(err).NotTo(HaveOccurred())
+				actual.Add(gradient)
+			}
+
+			Expect(equalGradients(actual, expected, 1e-12)).To(BeTrue())
+		})
+	})
+
+	context("Update", func() {
+		it("applies the Gamma and Beta gradients", func() {
+			network.Optimizer = &optimizers.SGD{LearningRate: 0.5}
+			network.SetTraining(true)
+
+			gradient := batchGradient(&network)
+			gradient.Scale(0.25)
+
+			// the BatchNorm layer follows the Dense layer
+			normGrads := gradient.Layers[network.LayerIndex(1)+1]
+
+			norm := network.Norms[1]
+			expectedGamma := mat.VecDenseCopyOf(norm.Gamma)
+			expectedGamma.AddScaledVec(expectedGamma, -0.5, column(normGrads[0], 0))
+			expectedBeta := mat.VecDenseCopyOf(norm.Beta)
+			expectedBeta.AddScaledVec(expectedBeta, -0.5, column(normGrads[1], 0))
+
+			Expect(network.Update(gradient)).To(Succeed())
+			Expect(mat.EqualApprox(norm.Gamma, expectedGamma, 1e-12)).To(BeTrue())
+			Expect(mat.EqualApprox(norm.Beta, expectedBeta, 1e-12)).To(BeTrue())
+		})
+
+		it("fails without batch norm gradients", func() {
+			gradient := batchGradient(&network)
+			gradient.Layers[network.LayerIndex(1)+1] = nil
+
+			Expect(network.Update(gradient)).To(MatchError("invalid gradient count at layer 1: 0, expected 2"))
+		})
+	})
+
+	context("with a deep sigmoid stack", func() {
+		// 2 inputs through 6 sigmoid layers, the class is whether x > y
+		trainLoss := func(batchNorm bool) float64 {
+			layers := []neuralnet.LayerConfig{{Size: 2}}
+			for idx := 0; idx < 6; idx++ {
+				layers = append(layers, neuralnet.LayerConfig{Size: 8, Func: nodefuncs.Sigmoid{}, BatchNorm: batchNorm})
+			}
+			layers = append(layers, neuralnet.LayerConfig{Size: 1, Func: nodefuncs.Sigmoid{}})
+
+			deep, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: layers,
+				WeightInit:   neuralnet.InitRandom,
+				Optimizer:    &optimizers.SGD{LearningRate: 0.5},
+				Source:       rand.NewSource(3),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			rng := rand.New(rand.NewSource(4))
+			data := mat.NewDense(2, 32, nil)
+			labels := mat.NewDense(1, 32, nil)
+			for j := 0; j < 32; j++ {
+				x, y := rng.Float64()*2-1, rng.Float64()*2-1
+				data.Set(0, j, x)
+				data.Set(1, j, y)
+				if x > y {
+					labels.Set(0, j, 1)
+				}
+			}
+
+			deep.SetTraining(true)
+			for step := 0; step < 300; step++ {
+				_, err := deep.CalculateBatch(data)
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := deep.GenerateDeltaBatch(labels)
+				Expect(err).NotTo(HaveOccurred())
+				gradient, err := deep.GenerateGradientBatch(delta)
+				Expect(err).NotTo(HaveOccurred())
+				gradient.Scale(1.0 / 32)
+				Expect(deep.Update(gradient)).To(Succeed())
+			}
+			deep.SetTraining(false)
+
+			_, err = deep.CalculateBatch(data)
+			Expect(err).NotTo(HaveOccurred())
+			loss, err := deep.CalcLossBatch(labels)
+			Expect(err).NotTo(HaveOccurred())
+
+			return loss
+		}
+
+		it("trains faster with batch normalization", func() {
+			Expect(trainLoss(true)).To(BeNumerically("<", trainLoss(false)/4))
+		})
+	})
+}
+
+func column(m *mat.Dense, j int) *mat.VecDense {
+	return mat.VecDenseCopyOf(m.ColView(j))
+}
+
+func denseData(m *mat.Dense) []float64 {
+	return mat.DenseCopyOf(m).RawMatrix().Data
+}
@@ -0,0 +1,345 @@
+package neuralnet
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"github.com/dwillist/summerschool/v2/neuralnet/sparse"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Dense, Activation, Dropout and BatchNorm are the layers NewNetwork builds from
+// LayerConfigs, the layers package provides them alongside its other layers.
+
+///
+/// Dense Def
+///
+// fully connected layer: Weights·x + Bias. Weights stored sparsely, see Sparsify, are held
+// by SparseWeights instead and the stored values are then the layer's weights param.
+type Dense struct {
+	// nil when SparseWeights is set
+	Weights       *mat.Dense
+	SparseWeights *sparse.CSR
+	// column vector with one row per output
+	Bias *mat.Dense
+
+	input       *mat.Dense
+	weightsGrad *mat.Dense
+	biasGrad    *mat.Dense
+}
+
+// nil initializers default to initializers.XavierUniform for weights and zeros for bias,
+// see Initializer
+func NewDense(inSize, outSize int, weightInit, biasInit Initializer, rng *rand.Rand) *Dense {
+	if weightInit == nil {
+		weightInit = initializers.XavierUniform{}
+	}
+
+	if biasInit == nil {
+		biasInit = initializers.Constant{}
+	}
+
+	weights := make([]float64, outSize*inSize)
+	weightInit.Initialize(weights, inSize, outSize, 0, rng)
+
+	bias := make([]float64, outSize)
+	biasInit.Initialize(bias, inSize, outSize, 0, rng)
+
+	return &Dense{
+		Weights: mat.NewDense(outSize, inSize, weights),
+		Bias:    mat.NewDense(outSize, 1, bias),
+	}
+}
+
+// converts the weights to sparse storage, dropping every weight whose magnitude is at most
+// threshold. Dropped weights stay zero during training, at least one weight must be kept.
+func (d *Dense) Sparsify(threshold float64) error {
+	var weights mat.Matrix = d.Weights
+	if d.SparseWeights != nil {
+		weights = d.SparseWeights
+	}
+
+	result := sparse.CSRFromDense(weights, threshold)
+	if result.NNZ() == 0 {
+		return fmt.Errorf("no weights above threshold: %v", threshold)
+	}
+
+	d.SparseWeights = result
+	d.Weights = nil
+
+	return nil
+}
+
+// dimensions of the weights regardless of their storage
+func (d *Dense) dims() (int, int) {
+	if d.SparseWeights != nil {
+		return d.SparseWeights.Dims()
+	}
+
+	return d.Weights.Dims()
+}
+
+func (d *Dense) Forward(input *mat.Dense, _ bool) (*mat.Dense, error) {
+	d.input = input
+
+	r, _ := d.dims()
+	_, c := input.Dims()
+
+	result := mat.NewDense(r, c, nil)
+	if d.SparseWeights != nil {
+		d.SparseWeights.MulTo(result, false, input)
+	} else {
+		result.Mul(d.Weights, input)
+	}
+
+	result.Apply(func(i, _ int, v float64) float64 {
+		return v + d.Bias.At(i, 0)
+	}, result)
+
+	return result, nil
+}
+
+func (d *Dense) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	r, c := d.dims()
+	if err := checkGrad("dense", grad, r, columns(d.input)); err != nil {
+		return nil, err
+	}
+
+	d.biasGrad = mat.NewDense(r, 1, nil)
+	for i := 0; i < r; i++ {
+		d.biasGrad.Set(i, 0, mat.Sum(grad.RowView(i)))
+	}
+
+	_, batchSize := grad.Dims()
+	result := mat.NewDense(c, batchSize, nil)
+
+	if d.SparseWeights != nil {
+		// only the stored weights have a gradient, in the order of their values
+		weightsGrad := d.SparseWeights.Pattern()
+		weightsGrad.AddMulT(grad, d.input)
+		d.weightsGrad = storedValues(weightsGrad)
+
+		d.SparseWeights.MulTo(result, true, grad)
+
+		return result, nil
+	}
+
+	d.weightsGrad = &mat.Dense{}
+	d.weightsGrad.Mul(grad, d.input.T())
+
+	result.Mul(d.Weights.T(), grad)
+
+	return result, nil
+}
+
+// with sparse weights the weights param is a single row holding their stored values
+func (d *Dense) Params() []*mat.Dense {
+	if d.SparseWeights != nil {
+		return []*mat.Dense{storedValues(d.SparseWeights), d.Bias}
+	}
+
+	return []*mat.Dense{d.Weights, d.Bias}
+}
+
+func (d *Dense) Decayed() []bool {
+	return []bool{true, false}
+}
+
+func (d *Dense) Grads() []*mat.Dense {
+	return []*mat.Dense{d.weightsGrad, d.biasGrad}
+}
+
+func (d *Dense) OutputShape(input Shape) (Shape, error) {
+	r, c := d.dims()
+	if input.Size() != c {
+		return nil, fmt.Errorf("invalid input size: %d, expected %d", input.Size(), c)
+	}
+
+	return Shape{r}, nil
+}
+
+func (d *Dense) Replica(_ *rand.Rand) Layer {
+	return &Dense{Weights: d.Weights, SparseWeights: d.SparseWeights, Bias: d.Bias}
+}
+
+// sparse weights constrain the stored weights of every unit, their rows of the weights
+// param do not match the units
+func (d *Dense) applyMaxNorm(reg Regularization) {
+	weights := d.SparseWeights
+	r, _ := weights.Dims()
+
+	for i := 0; i < r; i++ {
+		row := weights.Data[weights.Indptr[i]:weights.Indptr[i+1]]
+
+		sumSquares := float64(0)
+		for _, v := range row {
+			sumSquares += v * v
+		}
+
+		if reg.IncludeBias {
+			sumSquares += d.Bias.At(i, 0) * d.Bias.At(i, 0)
+		}
+
+		norm := math.Sqrt(sumSquares)
+		if norm <= reg.MaxNorm {
+			continue
+		}
+
+		factor := reg.MaxNorm / norm
+		for k := range row {
+			row[k] *= factor
+		}
+
+		if reg.IncludeBias {
+			d.Bias.Set(i, 0, d.Bias.At(i, 0)*factor)
+		}
+	}
+}
+
+type denseConfig struct {
+	Inputs  int `json:"inputs"`
+	Outputs int `json:"outputs"`
+}
+
+func (d *Dense) LayerConfig() interface{} {
+	r, c := d.dims()
+	return denseConfig{Inputs: c, Outputs: r}
+}
+
+func loadDense(data []byte, _ *rand.Rand) (Layer, error) {
+	var config denseConfig
+	if err := decodeConfig("dense", data, &config); err != nil {
+		return nil, err
+	}
+
+	if config.Inputs <= 0 || config.Outputs <= 0 {
+		return nil, fmt.Errorf("invalid dense size: %d inputs, %d outputs", config.Inputs, config.Outputs)
+	}
+
+	if err := CheckLoadSize(config.Outputs, config.Inputs); err != nil {
+		return nil, err
+	}
+
+	return &Dense{
+		Weights: mat.NewDense(config.Outputs, config.Inputs, nil),
+		Bias:    mat.NewDense(config.Outputs, 1, nil),
+	}, nil
+}
+
+// single row matrix sharing the stored values of m
+func storedValues(m *sparse.CSR) *mat.Dense {
+	return mat.NewDense(1, len(m.Data), m.Data)
+}
+
+///
+/// Activation Def
+///
+// applies Func to every sample, VectorFuncs see the whole sample at once
+type Activation struct {
+	Func NodeFunc
+
+	input  *mat.Dense
+	output *mat.Dense
+}
+
+func NewActivation(f NodeFunc) *Activation {
+	return &Activation{Func: f}
+}
+
+func (a *Activation) NodeFunc() NodeFunc {
+	return a.Func
+}
+
+func (a *Activation) Forward(input *mat.Dense, _ bool) (*mat.Dense, error) {
+	r, c := input.Dims()
+	a.input = input
+	a.output = mat.NewDense(r, c, nil)
+
+	for j := 0; j < c; j++ {
+		z := mat.VecDenseCopyOf(input.ColView(j))
+
+		if vecFunc, ok := a.Func.(VectorFunc); ok {
+			col := mat.NewVecDense(r, nil)
+			vecFunc.Apply(col, z)
+			a.output.SetCol(j, col.RawVector().Data)
+
+			continue
+		}
+
+		for i := 0; i < r; i++ {
+			a.output.Set(i, j, a.Func.CalcVal(z.AtVec(i), z))
+		}
+	}
+
+	return a.output, nil
+}
+
+func (a *Activation) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	if a.output == nil {
+		return nil, fmt.Errorf("activation: no batch has been calculated")
+	}
+
+	r, c := a.output.Dims()
+	if err := checkGrad("activation", grad, r, c); err != nil {
+		return nil, err
+	}
+
+	result := mat.NewDense(r, c, nil)
+
+	for j := 0; j < c; j++ {
+		z := mat.VecDenseCopyOf(a.input.ColView(j))
+
+		if vecFunc, ok := a.Func.(VectorFunc); ok {
+			col := mat.NewVecDense(r, nil)
+			vecFunc.BackpropVec(col, z, mat.VecDenseCopyOf(a.output.ColView(j)), mat.VecDenseCopyOf(grad.ColView(j)))
+			result.SetCol(j, col.RawVector().Data)
+
+			continue
+		}
+
+		for i := 0; i < r; i++ {
+			result.Set(i, j, grad.At(i, j)*a.Func.CalcDiff(z.AtVec(i), z))
+		}
+	}
+
+	return result, nil
+}
+
+func (a *Activation) Params() []*mat.Dense {
+	return nil
+}
+
+func (a *Activation) Grads() []*mat.Dense {
+	return nil
+}
+
+func (a *Activation) OutputShape(input Shape) (Shape, error) {
+	if a.Func == nil {
+		return nil, fmt.Errorf("missing activation function")
+	}
+
+	return input, nil
+}
+
+func (a *Activation) Replica(_ *rand.Rand) Layer {
+	return NewActivation(a.Func)
+}
+
+type activationConfig struct {
+	Func RegisteredFunc `json:"func"`
+}
+
+func (a *Activation) LayerConfig() interface{} {
+	return activationConfig{Func: RegisteredFunc{NodeFunc: a.Func}}
+}
+
+func loadActivation(data []byte, _ *rand.Rand) (Layer, error) {
+	var config activationConfig
+	if err := decodeConfig("activation", data, &config); err != nil {
+		return nil, err
+	}
+
+	return NewActivation(config.Func.NodeFunc), nil
+}
@@ -0,0 +1,106 @@
+package neuralnet
+
+import (
+	"fmt"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+///
+/// Dropout Def
+///
+// inverted dropout: in training mode every value is zeroed with probability Rate and
+// kept values are scaled by 1/(1-Rate), so inference needs no rescaling. Otherwise
+// input passes through unchanged.
+type Dropout struct {
+	Rate float64
+	Rand *rand.Rand
+
+	mask *mat.Dense
+}
+
+func NewDropout(rate float64, rng *rand.Rand) *Dropout {
+	return &Dropout{
+		Rate: rate,
+		Rand: rng,
+	}
+}
+
+func (d *Dropout) Forward(input *mat.Dense, training bool) (*mat.Dense, error) {
+	d.mask = nil
+	if !training || d.Rate == 0 {
+		return mat.DenseCopyOf(input), nil
+	}
+
+	r, c := input.Dims()
+	d.mask = mat.NewDense(r, c, nil)
+	d.mask.Apply(func(_, _ int, _ float64) float64 {
+		if d.Rand.Float64() < d.Rate {
+			return 0
+		}
+
+		return 1 / (1 - d.Rate)
+	}, d.mask)
+
+	result := &mat.Dense{}
+	result.MulElem(input, d.mask)
+
+	return result, nil
+}
+
+func (d *Dropout) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	if d.mask == nil {
+		return mat.DenseCopyOf(grad), nil
+	}
+
+	r, c := d.mask.Dims()
+	if err := checkGrad("dropout", grad, r, c); err != nil {
+		return nil, err
+	}
+
+	result := &mat.Dense{}
+	result.MulElem(grad, d.mask)
+
+	return result, nil
+}
+
+func (d *Dropout) Params() []*mat.Dense {
+	return nil
+}
+
+func (d *Dropout) Grads() []*mat.Dense {
+	return nil
+}
+
+func (d *Dropout) OutputShape(input Shape) (Shape, error) {
+	switch {
+	case d.Rate < 0 || d.Rate >= 1:
+		return nil, fmt.Errorf("invalid dropout rate: %v", d.Rate)
+	case d.Rate != 0 && d.Rand == nil:
+		return nil, fmt.Errorf("dropout requires a random source")
+	}
+
+	return input, nil
+}
+
+func (d *Dropout) Replica(rng *rand.Rand) Layer {
+	return NewDropout(d.Rate, rng)
+}
+
+type dropoutConfig struct {
+	Rate float64 `json:"rate"`
+}
+
+func (d *Dropout) LayerConfig() interface{} {
+	return dropoutConfig{Rate: d.Rate}
+}
+
+func loadDropout(data []byte, rng *rand.Rand) (Layer, error) {
+	var config dropoutConfig
+	if err := decodeConfig("dropout", data, &config); err != nil {
+		return nil, err
+	}
+
+	return NewDropout(config.Rate, rng), nil
+}
@@ -0,0 +1,205 @@
+package neuralnet_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testDropout(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		network neuralnet.Network
+		plain   neuralnet.Network
+		input   *mat.VecDense
+	)
+
+	it.Before(func() {
+		network = newNetwork(t, neuralnet.Config{Source: rand.NewSource(7)},
+			neuralnet.LayerConfig{Size: 50, Func: nodefuncs.Sigmoid{}, Dropout: 0.5},
+			neuralnet.LayerConfig{Size: 3, Func: nodefuncs.Softmax{}})
+		plain = newNetwork(t, neuralnet.Config{Source: rand.NewSource(7)},
+			neuralnet.LayerConfig{Size: 50, Func: nodefuncs.Sigmoid{}},
+			neuralnet.LayerConfig{Size: 3, Func: nodefuncs.Softmax{}})
+		input = mat.NewVecDense(3, []float64{0.2, -0.4, 0.6})
+	})
+
+	context("in inference mode", func() {
+		it("does not drop activations", func() {
+			Expect(network.IsTraining()).To(BeFalse())
+
+			expected, err := plain.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := network.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal(expected))
+		})
+	})
+
+	context("in training mode", func() {
+		it.Before(func() {
+			network.SetTraining(true)
+			Expect(network.IsTraining()).To(BeTrue())
+		})
+
+		it("zeroes activations and scales the kept ones", func() {
+			_, err := plain.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = network.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+
+			dropped := 0
+			for i := 0; i < 50; i++ {
+				actual := network.Activation[1].AtVec(i)
+				if actual == 0 {
+					dropped++
+					continue
+				}
+
+				Expect(actual).To(BeNumerically("~", 2*plain.Activation[1].AtVec(i), 1e-12))
+			}
+
+			Expect(dropped).To(BeNumerically(">", 10))
+			Expect(dropped).To(BeNumerically("<", 40))
+		})
+
+		it("leaves Predict in inference mode", func() {
+			expected, err := plain.Predict(input)
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := network.Predict(input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal(expected))
+		})
+
+		it("respects the mask when generating gradients", func() {
+			_, err := network.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+
+			delta, err := network.GenerateDelta(mat.NewVecDense(3, []float64{0, 1, 0}))
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err := network.GenerateGradient(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			hidden, _ := denseGradient(network, gradient, 1)
+			output, _ := denseGradient(network, gradient, 2)
+
+			for i := 0; i < 50; i++ {
+				if network.Activation[1].AtVec(i) != 0 {
+					Expect(delta[0].AtVec(i)).NotTo(BeZero())
+					continue
+				}
+
+				Expect(delta[0].AtVec(i)).To(BeZero())
+				Expect(mat.Norm(hidden.RowView(i), 2)).To(BeZero())
+				Expect(mat.Norm(output.ColView(i), 2)).To(BeZero())
+			}
+		})
+
+		it("backpropagates VectorFunc layers through the activation before dropout", func() {
+			softmax := newNetwork(t, neuralnet.Config{Source: rand.NewSource(8)},
+				neuralnet.LayerConfig{Size: 6, Func: nodefuncs.Softmax{}, Dropout: 0.5},
+				neuralnet.LayerConfig{Func: nodefuncs.Identity{}})
+			softmax.SetTraining(true)
+
+			_, err := softmax.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+
+			delta, err := softmax.GenerateDelta(mat.NewVecDense(2, []float64{1, 0}))
+			Expect(err).NotTo(HaveOccurred())
+
+			mask := mat.NewVecDense(6, nil)
+			unmasked := mat.NewVecDense(6, nil)
+			nodefuncs.Softmax{}.Apply(unmasked, softmax.Zval[1])
+			for i := 0; i < 6; i++ {
+				mask.SetVec(i, softmax.Activation[1].AtVec(i)/unmasked.AtVec(i))
+			}
+
+			grad := mat.NewVecDense(6, nil)
+			grad.MulVec(softmax.Weights[1].T(), delta[1])
+			grad.MulElemVec(grad, mask)
+
+			expected := mat.NewVecDense(6, nil)
+			nodefuncs.Softmax{}.BackpropVec(expected, softmax.Zval[1], unmasked, grad)
+
+			Expect(mat.EqualApprox(delta[0], expected, 1e-12)).To(BeTrue())
+			Expect(mat.Min(mask)).To(BeZero())
+		})
+
+		it("respects the mask when batched", func() {
+			inputs := mat.NewDense(3, 2, []float64{0.2, 1, -0.4, 0, 0.6, -1})
+			_, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			delta, err := network.GenerateDeltaBatch(mat.NewDense(3, 2, []float64{0, 1, 1, 0, 0, 0}))
+			Expect(err).NotTo(HaveOccurred())
+
+			dropped := 0
+			for i := 0; i < 50; i++ {
+				for j := 0; j < 2; j++ {
+					if network.ActivationBatch[1].At(i, j) == 0 {
+						dropped++
+						Expect(delta[0].At(i, j)).To(BeZero())
+					} else {
+						Expect(delta[0].At(i, j)).NotTo(BeZero())
+					}
+				}
+			}
+			Expect(dropped).To(BeNumerically(">", 20))
+		})
+
+		it("draws masks from the workspace in ComputeGradient", func() {
+			solution := mat.NewVecDense(3, []float64{0, 1, 0})
+
+			first, err := network.ComputeGradient(network.NewWorkspace(), input, solution)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := network.ComputeGradient(network.NewWorkspace(), input, solution)
+			Expect(err).NotTo(HaveOccurred())
+
+			firstOutput, _ := denseGradient(network, first, 2)
+			secondOutput, _ := denseGradient(network, second, 2)
+			Expect(firstOutput).NotTo(Equal(secondOutput))
+		})
+	})
+
+	context("failure cases", func() {
+		newConfig := func(hidden, output float64) neuralnet.Config {
+			return neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{
+						Size: 1,
+					},
+					{
+						Size:    1,
+						Func:    nodefuncs.Sigmoid{},
+						Dropout: hidden,
+					},
+					{
+						Size:    1,
+						Func:    nodefuncs.Sigmoid{},
+						Dropout: output,
+					},
+				},
+			}
+		}
+
+		it("when the dropout rate is invalid", func() {
+			_, err := neuralnet.NewNetwork(newConfig(1, 0))
+			Expect(err).To(MatchError("invalid dropout rate: 1"))
+		})
+
+		it("when the output layer has dropout", func() {
+			_, err := neuralnet.NewNetwork(newConfig(0, 0.5))
+			Expect(err).To(MatchError("dropout is not supported on the output layer"))
+		})
+	})
+}
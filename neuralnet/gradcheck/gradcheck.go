@@ -0,0 +1,306 @@
+package gradcheck
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	DefaultEpsilon = 1e-5
+	// relative errors are not meaningful when both gradients are essentially zero
+	minDenominator = 1e-8
+)
+
+// max relative error between analytic and numerical gradients for a single layer,
+// Layer matches the index into Network.Weights. Gamma and Beta are 0 for layers
+// without batch normalization.
+type LayerError struct {
+	Layer   int
+	Weights float64
+	Bias    float64
+	Gamma   float64
+	Beta    float64
+}
+
+// Input holds the max relative error of every Param of the network's InputLayer, it is
+// nil for networks without one
+type Report struct {
+	Layers []LayerError
+	Input  []float64
+}
+
+func (r Report) Max() float64 {
+	result := float64(0)
+
+	for _, paramError := range r.Input {
+		result = math.Max(result, paramError)
+	}
+
+	for _, layer := range r.Layers {
+		result = math.Max(result, math.Max(layer.Weights, layer.Bias))
+		result = math.Max(result, math.Max(layer.Gamma, layer.Beta))
+	}
+
+	return result
+}
+
+// compares the gradients produced by GenerateDelta with central finite differences of the
+// network loss for every weight, bias, batch norm Gamma and Beta and InputLayer param,
+// epsilon <= 0 uses DefaultEpsilon. The network is checked in inference mode so dropout
+// masks stay fixed, its mode and parameters are restored before returning.
+func Check(network *neuralnet.Network, input, solution *mat.VecDense, epsilon float64) (Report, error) {
+	defer network.SetTraining(network.IsTraining())
+	network.SetTraining(false)
+
+	return check(network, epsilon,
+		func() error {
+			_, err := network.Calculate(input)
+			return err
+		},
+		func() (float64, error) {
+			return network.CalcLoss(solution)
+		},
+		func() (neuralnet.Gradient, error) {
+			delta, err := network.GenerateDelta(solution)
+			if err != nil {
+				return neuralnet.Gradient{}, fmt.Errorf("network delta generation failed: %s", err)
+			}
+
+			gradient, err := network.GenerateGradient(delta)
+			if err != nil {
+				return neuralnet.Gradient{}, fmt.Errorf("network gradient generation failed: %s", err)
+			}
+
+			return gradient, nil
+		},
+	)
+}
+
+// Check for the summed loss of a batch with one sample per column, in the network's
+// current mode so batch normalization uses the statistics of inputs while training.
+// Dropout draws new masks in training mode, so networks with dropout must be checked in
+// inference mode. Parameters and batch norm running statistics are restored.
+func CheckBatch(network *neuralnet.Network, inputs, solutions *mat.Dense, epsilon float64) (Report, error) {
+	if network.IsTraining() {
+		for idx, lconfig := range network.LayerConfigs {
+			if lconfig.Dropout != 0 {
+				return Report{}, fmt.Errorf("dropout at layer %d cannot be checked in training mode", idx)
+			}
+		}
+	}
+
+	for _, norm := range network.Norms {
+		if norm != nil {
+			mean, variance := mat.VecDenseCopyOf(norm.RunningMean), mat.VecDenseCopyOf(norm.RunningVar)
+			defer norm.RunningMean.CopyVec(mean)
+			defer norm.RunningVar.CopyVec(variance)
+		}
+	}
+
+	return check(network, epsilon,
+		func() error {
+			_, err := network.CalculateBatch(inputs)
+			return err
+		},
+		func() (float64, error) {
+			return network.CalcLossBatch(solutions)
+		},
+		func() (neuralnet.Gradient, error) {
+			delta, err := network.GenerateDeltaBatch(solutions)
+			if err != nil {
+				return neuralnet.Gradient{}, fmt.Errorf("network delta generation failed: %s", err)
+			}
+
+			gradient, err := network.GenerateGradientBatch(delta)
+			if err != nil {
+				return neuralnet.Gradient{}, fmt.Errorf("network gradient generation failed: %s", err)
+			}
+
+			return gradient, nil
+		},
+	)
+}
+
+// forward runs the network on the checked input, loss and gradient use the latest forward
+// pass
+func check(network *neuralnet.Network, epsilon float64, forward func() error, loss func() (float64, error), gradient func() (neuralnet.Gradient, error)) (Report, error) {
+	if epsilon <= 0 {
+		epsilon = DefaultEpsilon
+	}
+
+	if err := forward(); err != nil {
+		return Report{}, fmt.Errorf("network calculation failed: %s", err)
+	}
+
+	analytic, err := gradient()
+	if err != nil {
+		return Report{}, err
+	}
+
+	lossAt := func() (float64, error) {
+		if err := forward(); err != nil {
+			return 0, err
+		}
+
+		return loss()
+	}
+
+	numerical := func(get func() float64, set func(float64)) (float64, error) {
+		original := get()
+		defer set(original)
+
+		set(original + epsilon)
+		plus, err := lossAt()
+		if err != nil {
+			return 0, err
+		}
+
+		set(original - epsilon)
+		minus, err := lossAt()
+		if err != nil {
+			return 0, err
+		}
+
+		return (plus - minus) / (2 * epsilon), nil
+	}
+
+	var result Report
+
+	for layer, weights := range network.Weights {
+		layerError := LayerError{Layer: layer}
+
+		// gradients of the Dense layer feeding the layer, then of its BatchNorm layer
+		layerIndex := network.LayerIndex(layer + 1)
+		grads := analytic.Layers[layerIndex]
+
+		// sparse layers are only checked at their stored entries
+		if weights == nil {
+			sparseWeights := network.SparseWeights[layer]
+			for k := range sparseWeights.Data {
+				approx, err := numerical(
+					func() float64 { return sparseWeights.Data[k] },
+					func(x float64) { sparseWeights.Data[k] = x },
+				)
+				if err != nil {
+					return Report{}, fmt.Errorf("loss calculation failed: %s", err)
+				}
+
+				layerError.Weights = math.Max(layerError.Weights, relativeError(grads[0].At(0, k), approx))
+			}
+		}
+
+		r, c := 0, 0
+		if weights != nil {
+			r, c = weights.Dims()
+		}
+
+		for i := 0; i < r; i++ {
+			for j := 0; j < c; j++ {
+				approx, err := numerical(
+					func() float64 { return weights.At(i, j) },
+					func(x float64) { weights.Set(i, j, x) },
+				)
+				if err != nil {
+					return Report{}, fmt.Errorf("loss calculation failed: %s", err)
+				}
+
+				layerError.Weights = math.Max(layerError.Weights, relativeError(grads[0].At(i, j), approx))
+			}
+		}
+
+		var err error
+		if layerError.Bias, err = vecError(network.Bias[layer+1], column(grads[1]), numerical); err != nil {
+			return Report{}, err
+		}
+
+		if layer+1 < len(network.Norms) && network.Norms[layer+1] != nil {
+			norm := network.Norms[layer+1]
+			normGrads := analytic.Layers[layerIndex+1]
+
+			if layerError.Gamma, err = vecError(norm.Gamma, column(normGrads[0]), numerical); err != nil {
+				return Report{}, err
+			}
+
+			if layerError.Beta, err = vecError(norm.Beta, column(normGrads[1]), numerical); err != nil {
+				return Report{}, err
+			}
+		}
+
+		result.Layers = append(result.Layers, layerError)
+	}
+
+	if network.InputLayer != nil {
+		if result.Input, err = inputError(network.InputLayer, analytic, epsilon, lossAt); err != nil {
+			return Report{}, err
+		}
+	}
+
+	// leave the network state matching the unperturbed parameters
+	if err := forward(); err != nil {
+		return Report{}, fmt.Errorf("network calculation failed: %s", err)
+	}
+
+	return result, nil
+}
+
+// max relative error of every param of the input layer, whose gradient is analytic.Layers[0]
+func inputError(layer neuralnet.Layer, analytic neuralnet.Gradient, epsilon float64, lossAt func() (float64, error)) ([]float64, error) {
+	params := layer.Params()
+	if len(analytic.Layers) == 0 || len(analytic.Layers[0]) != len(params) {
+		return nil, fmt.Errorf("invalid input layer gradient: expected %d params", len(params))
+	}
+
+	result := make([]float64, len(params))
+	for idx, param := range params {
+		grad := analytic.Layers[0][idx]
+		if len(analytic.LayerRows) != 0 && idx < len(analytic.LayerRows[0]) && analytic.LayerRows[0][idx] != nil {
+			var err error
+			if grad, err = expandRows(grad, analytic.LayerRows[0][idx], param); err != nil {
+				return nil, fmt.Errorf("invalid input layer gradient: %s", err)
+			}
+		}
+
+		numerical, err := numericalGrad(param, epsilon, lossAt)
+		if err != nil {
+			return nil, fmt.Errorf("loss calculation failed: %s", err)
+		}
+
+		if result[idx], err = matrixError(grad, numerical); err != nil {
+			return nil, fmt.Errorf("invalid input layer gradient: %s", err)
+		}
+	}
+
+	return result, nil
+}
+
+// max relative error over every value of params against analytic
+func vecError(params, analytic *mat.VecDense, numerical func(get func() float64, set func(float64)) (float64, error)) (float64, error) {
+	result := float64(0)
+
+	for i := 0; i < params.Len(); i++ {
+		approx, err := numerical(
+			func() float64 { return params.AtVec(i) },
+			func(x float64) { params.SetVec(i, x) },
+		)
+		if err != nil {
+			return 0, fmt.Errorf("loss calculation failed: %s", err)
+		}
+
+		result = math.Max(result, relativeError(analytic.AtVec(i), approx))
+	}
+
+	return result, nil
+}
+
+// first column of m
+func column(m *mat.Dense) *mat.VecDense {
+	return mat.VecDenseCopyOf(m.ColView(0))
+}
+
+func relativeError(analytic, numerical float64) float64 {
+	denom := math.Max(math.Max(math.Abs(analytic), math.Abs(numerical)), minDenominator)
+	return math.Abs(analytic-numerical) / denom
+}
@@ -0,0 +1,396 @@
+package gradcheck_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Sigmoid with a derivative that is off by a constant factor
+type brokenSigmoid struct {
+	nodefuncs.Sigmoid
+}
+
+func (b brokenSigmoid) CalcDiff(x float64, v mat.Vector) float64 {
+	return 2 * b.Sigmoid.CalcDiff(x, v)
+}
+
+func initCentered(rng *rand.Rand) float64 {
+	return rng.Float64() - 0.5
+}
+
+func testGradCheck(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		network  *neuralnet.Network
+		input    *mat.VecDense
+		solution *mat.VecDense
+	)
+
+	// the network of every test, configure edits its LayerConfigs before it is built
+	newNetwork := func(configure func(configs []neuralnet.LayerConfig)) *neuralnet.Network {
+		configs := []neuralnet.LayerConfig{
+			{
+				Size: 3,
+			},
+			{
+				Size: 4,
+				Func: nodefuncs.Sigmoid{},
+			},
+			{
+				Size: 4,
+				Func: nodefuncs.Sigmoid{},
+			},
+			{
+				Size: 2,
+				Func: nodefuncs.Sigmoid{},
+			},
+		}
+		if configure != nil {
+			configure(configs)
+		}
+
+		result, err := neuralnet.NewNetwork(neuralnet.Config{
+			LayerConfigs: configs,
+			WeightInit:   neuralnet.InitFunc(initCentered),
+			BiasInit:     neuralnet.InitFunc(initCentered),
+			Source:       rand.NewSource(92),
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		return &result
+	}
+
+	// replaces the Func of the Activation layer that follows the Dense layer of layer
+	setFunc := func(network *neuralnet.Network, layer int, f neuralnet.NodeFunc) {
+		network.Layers[network.LayerIndex(layer)+1].(*neuralnet.Activation).Func = f
+	}
+
+	it.Before(func() {
+		network = newNetwork(nil)
+
+		input = mat.NewVecDense(3, []float64{0.2, -0.5, 0.9})
+		solution = mat.NewVecDense(2, []float64{1, 0})
+	})
+
+	context("Check", func() {
+		for _, tc := range []struct {
+			name   string
+			hidden neuralnet.NodeFunc
+			output neuralnet.NodeFunc
+			loss   neuralnet.Loss
+		}{
+			{"Sigmoid with MSE", nodefuncs.Sigmoid{}, nodefuncs.Sigmoid{}, losses.MSE{}},
+			{"Relu with Identity output", nodefuncs.Relu{}, nodefuncs.Identity{}, losses.MSE{}},
+			{"Sigmoid with BinaryCrossEntropy", nodefuncs.Sigmoid{}, nodefuncs.Sigmoid{}, losses.BinaryCrossEntropy{}},
+			{"Identity with Huber", nodefuncs.Identity{}, nodefuncs.Identity{}, losses.Huber{Delta: 0.1}},
+			{"Sigmoid with Hinge", nodefuncs.Sigmoid{}, nodefuncs.Identity{}, losses.Hinge{}},
+			{"Softmax with CrossEntropy", nodefuncs.Sigmoid{}, nodefuncs.Softmax{}, losses.CrossEntropy{}},
+			{"Softmax with MSE", nodefuncs.Sigmoid{}, nodefuncs.Softmax{}, losses.MSE{}},
+			{"Softmax hidden layers", nodefuncs.Softmax{}, nodefuncs.Identity{}, losses.MSE{}},
+			{"LogSoftmax with NLL", nodefuncs.Relu{}, nodefuncs.LogSoftmax{}, losses.NLL{}},
+		} {
+			tc := tc
+
+			it("agrees with finite differences for "+tc.name, func() {
+				setFunc(network, 1, tc.hidden)
+				setFunc(network, 2, tc.hidden)
+				setFunc(network, 3, tc.output)
+				network.Loss = tc.loss
+
+				report, err := gradcheck.Check(network, input, solution, 0)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(report.Layers).To(HaveLen(3))
+				for idx, layer := range report.Layers {
+					Expect(layer.Layer).To(Equal(idx))
+				}
+				Expect(report.Max()).To(BeNumerically("<", 1e-5))
+			})
+		}
+
+		it("checks the stored entries of sparse weights", func() {
+			Expect(network.Sparsify(0, 0.2)).To(Succeed())
+			Expect(network.Sparsify(1, 0.2)).To(Succeed())
+
+			report, err := gradcheck.Check(network, input, solution, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Layers).To(HaveLen(3))
+			Expect(report.Layers[0].Weights).To(BeNumerically(">", 0))
+			Expect(report.Max()).To(BeNumerically("<", 1e-5))
+		})
+
+		it("restores network parameters", func() {
+			weights := mat.DenseCopyOf(network.Weights[1])
+			bias := mat.VecDenseCopyOf(network.Bias[2])
+
+			_, err := gradcheck.Check(network, input, solution, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(network.Weights[1]).To(Equal(weights))
+			Expect(network.Bias[2]).To(Equal(bias))
+		})
+
+		it("checks a network with dropout in inference mode", func() {
+			network = newNetwork(func(configs []neuralnet.LayerConfig) {
+				configs[1].Dropout = 0.5
+				configs[2].Dropout = 0.5
+			})
+			network.SetTraining(true)
+
+			report, err := gradcheck.Check(network, input, solution, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Max()).To(BeNumerically("<", 1e-5))
+			Expect(network.IsTraining()).To(BeTrue())
+		})
+
+		it("checks the Gamma and Beta of batch normalized layers", func() {
+			network = newNetwork(func(configs []neuralnet.LayerConfig) {
+				configs[2].BatchNorm = true
+			})
+			for _, vec := range []*mat.VecDense{network.Norms[2].Gamma, network.Norms[2].Beta, network.Norms[2].RunningMean} {
+				for i := 0; i < vec.Len(); i++ {
+					vec.SetVec(i, initCentered(network.Rand)+1)
+				}
+			}
+
+			report, err := gradcheck.Check(network, input, solution, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Layers[0].Gamma).To(BeZero())
+			Expect(report.Layers[1].Gamma).To(BeNumerically(">", 0))
+			Expect(report.Layers[1].Beta).To(BeNumerically(">", 0))
+			Expect(report.Max()).To(BeNumerically("<", 1e-5))
+		})
+
+		it("reports the layers with incorrect derivatives", func() {
+			setFunc(network, 3, brokenSigmoid{})
+
+			report, err := gradcheck.Check(network, input, solution, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Layers[2].Weights).To(BeNumerically(">", 0.1))
+			Expect(report.Layers[2].Bias).To(BeNumerically(">", 0.1))
+			Expect(report.Max()).To(BeNumerically(">", 0.1))
+		})
+
+		it("checks the params of an input layer", func() {
+			embedded, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{Size: 2 * 3},
+					{Size: 4, Func: nodefuncs.Sigmoid{}},
+					{Size: 2, Func: nodefuncs.Sigmoid{}},
+				},
+				InputLayer: layers.NewEmbedding(5, 3, nil, rand.New(rand.NewSource(7))),
+				InputShape: neuralnet.Shape{2},
+				BiasInit:   neuralnet.InitFunc(initCentered),
+				Source:     rand.NewSource(8),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			ids := layers.IDs(3, 1)
+
+			report, err := gradcheck.Check(&embedded, ids, solution, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Input).To(HaveLen(1))
+			Expect(report.Input[0]).To(BeNumerically(">", 0))
+			Expect(report.Max()).To(BeNumerically("<", 1e-5))
+
+			batchReport, err := gradcheck.CheckBatch(&embedded, mat.NewDense(2, 2, []float64{3, 0, 1, 4}), mat.NewDense(2, 2, []float64{0, 1, 1, 0}), 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(batchReport.Max()).To(BeNumerically("<", 1e-5))
+
+			// a wrong derivative in the first hidden layer reaches the vectors
+			setFunc(&embedded, 1, brokenSigmoid{})
+
+			report, err = gradcheck.Check(&embedded, ids, solution, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Input[0]).To(BeNumerically(">", 0.1))
+		})
+
+		context("failure cases", func() {
+			it("when the input has the wrong dimension", func() {
+				_, err := gradcheck.Check(network, mat.NewVecDense(2, nil), solution, 0)
+				Expect(err).To(MatchError("network calculation failed: invalid input size: 2"))
+			})
+
+			it("when the solution has the wrong dimension", func() {
+				_, err := gradcheck.Check(network, input, mat.NewVecDense(3, nil), 0)
+				Expect(err).To(MatchError("network delta generation failed: invalid solution dimension: 3, expected 2"))
+			})
+		})
+	})
+
+	context("CheckBatch", func() {
+		var inputs, solutions *mat.Dense
+
+		it.Before(func() {
+			inputs = mat.NewDense(3, 4, []float64{
+				0.2, -0.5, 0.9, 0.1,
+				-0.3, 0.4, 0, 0.7,
+				0.6, -0.8, 0.2, -0.1,
+			})
+			solutions = mat.NewDense(2, 4, []float64{
+				1, 0, 1, 0,
+				0, 1, 0, 1,
+			})
+
+			network = newNetwork(func(configs []neuralnet.LayerConfig) {
+				configs[1].BatchNorm = true
+			})
+		})
+
+		it("uses the batch statistics in training mode", func() {
+			network.SetTraining(true)
+			mean := mat.VecDenseCopyOf(network.Norms[1].RunningMean)
+
+			report, err := gradcheck.CheckBatch(network, inputs, solutions, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			// the batch mean cancels the bias of the normalized layer, so its error compares
+			// rounding noise and is left out
+			Expect(report.Layers[0].Gamma).To(BeNumerically(">", 0))
+			Expect(report.Layers[0].Weights).To(BeNumerically("<", 1e-5))
+			Expect(report.Layers[0].Gamma).To(BeNumerically("<", 1e-5))
+			Expect(report.Layers[0].Beta).To(BeNumerically("<", 1e-5))
+			for _, layer := range report.Layers[1:] {
+				Expect(layer.Weights).To(BeNumerically("<", 1e-5))
+				Expect(layer.Bias).To(BeNumerically("<", 1e-5))
+			}
+
+			Expect(network.Norms[1].RunningMean).To(Equal(mean))
+		})
+
+		it("uses the running statistics in inference mode", func() {
+			report, err := gradcheck.CheckBatch(network, inputs, solutions, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Max()).To(BeNumerically("<", 1e-5))
+		})
+
+		it("fails on dropout in training mode", func() {
+			network.LayerConfigs[2].Dropout = 0.5
+			network.SetTraining(true)
+
+			_, err := gradcheck.CheckBatch(network, inputs, solutions, 0)
+			Expect(err).To(MatchError("dropout at layer 2 cannot be checked in training mode"))
+		})
+	})
+
+	context("CheckLayer", func() {
+		var (
+			dense      *layers.Dense
+			batch      *mat.Dense
+			outputGrad *mat.Dense
+		)
+
+		it.Before(func() {
+			dense = layers.NewDense(3, 2, neuralnet.InitFunc(initCentered), neuralnet.InitFunc(initCentered), network.Rand)
+			batch = mat.NewDense(3, 2, []float64{0.2, -0.5, 0.9, 0.1, -0.3, 0.4})
+			outputGrad = mat.NewDense(2, 2, []float64{1, -2, 0.5, 3})
+		})
+
+		it("agrees with finite differences", func() {
+			report, err := gradcheck.CheckLayer(dense, batch, outputGrad, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Params).To(HaveLen(2))
+			Expect(report.NumericalParams).To(HaveLen(2))
+			Expect(report.Max()).To(BeNumerically("<", 1e-6))
+		})
+
+		it("restores the parameters and the gradients of the unperturbed layer", func() {
+			weights := mat.DenseCopyOf(dense.Weights)
+
+			report, err := gradcheck.CheckLayer(dense, batch, outputGrad, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(dense.Weights).To(Equal(weights))
+			Expect(mat.EqualApprox(dense.Grads()[0], report.NumericalParams[0], 1e-6)).To(BeTrue())
+		})
+
+		it("reports incorrect derivatives", func() {
+			report, err := gradcheck.CheckLayer(layers.NewActivation(brokenSigmoid{}), batch, mat.NewDense(3, 2, []float64{1, -2, 0.5, 3, -1, 0.25}), 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Input).To(BeNumerically(">", 0.1))
+		})
+
+		it("leaves the input out with CheckLayerParams", func() {
+			report, err := gradcheck.CheckLayerParams(layers.NewActivation(brokenSigmoid{}), batch, mat.NewDense(3, 2, nil), 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Input).To(BeZero())
+			Expect(report.NumericalInput).To(BeNil())
+		})
+
+		it("fails on an output gradient of the wrong dimension", func() {
+			_, err := gradcheck.CheckLayer(dense, batch, mat.NewDense(1, 2, nil), 0)
+			Expect(err).To(MatchError("layer calculation failed: invalid output gradient dimension: 1x2, expected 2x2"))
+		})
+	})
+
+	context("CheckSequential", func() {
+		var (
+			sequential *neuralnet.Sequential
+			inputs     *mat.Dense
+			solutions  *mat.Dense
+		)
+
+		it.Before(func() {
+			init := neuralnet.InitFunc(initCentered)
+
+			result, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers: []neuralnet.Layer{
+					layers.NewDense(3, 4, init, init, network.Rand),
+					layers.NewActivation(nodefuncs.Sigmoid{}),
+					layers.NewDense(4, 2, init, init, network.Rand),
+					layers.NewActivation(nodefuncs.Softmax{}),
+				},
+				Loss: losses.CrossEntropy{},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			sequential = &result
+
+			inputs = mat.NewDense(3, 2, []float64{0.2, -0.5, 0.9, 0.1, -0.3, 0.4})
+			solutions = mat.NewDense(2, 2, []float64{1, 0, 0, 1})
+		})
+
+		it("agrees with finite differences", func() {
+			report, err := gradcheck.CheckSequential(sequential, inputs, solutions, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Layers).To(HaveLen(4))
+			Expect(report.Layers[0]).To(HaveLen(2))
+			Expect(report.Layers[1]).To(BeEmpty())
+			Expect(report.Max()).To(BeNumerically("<", 1e-5))
+		})
+
+		it("reports the layers before an incorrect derivative", func() {
+			sequential.Layers[1] = layers.NewActivation(brokenSigmoid{})
+
+			report, err := gradcheck.CheckSequential(sequential, inputs, solutions, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Layers[0][0]).To(BeNumerically(">", 0.1))
+			Expect(report.Layers[2][0]).To(BeNumerically("<", 1e-5))
+		})
+
+		it("fails when the solution has the wrong dimension", func() {
+			_, err := gradcheck.CheckSequential(sequential, inputs, mat.NewDense(3, 2, nil), 0)
+			Expect(err).To(MatchError("network delta generation failed: invalid solution dimension: 3, expected 2"))
+		})
+	})
+}
@@ -0,0 +1,14 @@
+package gradcheck_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitGradCheck(t *testing.T) {
+	suite := spec.New("GradCheck", spec.Report(report.Terminal{}))
+	suite("GradCheck", testGradCheck)
+	suite.Run(t)
+}
@@ -0,0 +1,305 @@
+package gradcheck
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"gonum.org/v1/gonum/mat"
+)
+
+// max relative errors of a single Layer, Params follows the order of its Params
+type LayerReport struct {
+	Input  float64
+	Params []float64
+	// central differences the errors are computed from
+	NumericalInput  *mat.Dense
+	NumericalParams []*mat.Dense
+}
+
+func (r LayerReport) Max() float64 {
+	result := r.Input
+
+	for _, paramError := range r.Params {
+		result = math.Max(result, paramError)
+	}
+
+	return result
+}
+
+// compares Backward and Grads with central finite differences of sum(output·outputGrad),
+// whose gradient with respect to the output is outputGrad, for every input value and every
+// value of every param, epsilon <= 0 uses DefaultEpsilon. The layer runs in inference mode,
+// rows a SparseLayer leaves out of its gradient are expected to be 0. Parameters are
+// restored and the cached Forward and Backward state matches them before returning.
+func CheckLayer(layer neuralnet.Layer, input, outputGrad *mat.Dense, epsilon float64) (LayerReport, error) {
+	return checkLayer(layer, input, outputGrad, epsilon, true)
+}
+
+// CheckLayer without the input gradient, for layers whose input is not differentiable such
+// as the ids of an Embedding
+func CheckLayerParams(layer neuralnet.Layer, input, outputGrad *mat.Dense, epsilon float64) (LayerReport, error) {
+	return checkLayer(layer, input, outputGrad, epsilon, false)
+}
+
+func checkLayer(layer neuralnet.Layer, input, outputGrad *mat.Dense, epsilon float64, checkInput bool) (LayerReport, error) {
+	if epsilon <= 0 {
+		epsilon = DefaultEpsilon
+	}
+
+	objective := func() (float64, error) {
+		output, err := layer.Forward(input, false)
+		if err != nil {
+			return 0, err
+		}
+
+		r, c := output.Dims()
+		gr, gc := outputGrad.Dims()
+		if r != gr || c != gc {
+			return 0, fmt.Errorf("invalid output gradient dimension: %dx%d, expected %dx%d", gr, gc, r, c)
+		}
+
+		weighted := &mat.Dense{}
+		weighted.MulElem(output, outputGrad)
+
+		return mat.Sum(weighted), nil
+	}
+
+	backward := func() (*mat.Dense, error) {
+		if _, err := objective(); err != nil {
+			return nil, fmt.Errorf("layer calculation failed: %s", err)
+		}
+
+		inputGrad, err := layer.Backward(outputGrad)
+		if err != nil {
+			return nil, fmt.Errorf("layer backpropagation failed: %s", err)
+		}
+
+		return inputGrad, nil
+	}
+
+	inputGrad, err := backward()
+	if err != nil {
+		return LayerReport{}, err
+	}
+
+	params := layer.Params()
+	grads, err := denseGrads(layer, params)
+	if err != nil {
+		return LayerReport{}, err
+	}
+
+	result := LayerReport{Params: make([]float64, len(params))}
+
+	if checkInput {
+		if result.NumericalInput, err = numericalGrad(input, epsilon, objective); err != nil {
+			return LayerReport{}, fmt.Errorf("layer calculation failed: %s", err)
+		}
+
+		if result.Input, err = matrixError(inputGrad, result.NumericalInput); err != nil {
+			return LayerReport{}, fmt.Errorf("invalid input gradient: %s", err)
+		}
+	}
+
+	for idx, param := range params {
+		numerical, err := numericalGrad(param, epsilon, objective)
+		if err != nil {
+			return LayerReport{}, fmt.Errorf("layer calculation failed: %s", err)
+		}
+
+		result.NumericalParams = append(result.NumericalParams, numerical)
+		if result.Params[idx], err = matrixError(grads[idx], numerical); err != nil {
+			return LayerReport{}, fmt.Errorf("invalid gradient of param %d: %s", idx, err)
+		}
+	}
+
+	// leave the cached state matching the unperturbed parameters
+	if _, err := backward(); err != nil {
+		return LayerReport{}, err
+	}
+
+	return result, nil
+}
+
+// max relative error of every param of every layer, in the order of Layers and Params
+type SequentialReport struct {
+	Layers [][]float64
+}
+
+func (r SequentialReport) Max() float64 {
+	result := float64(0)
+
+	for _, layer := range r.Layers {
+		for _, paramError := range layer {
+			result = math.Max(result, paramError)
+		}
+	}
+
+	return result
+}
+
+// compares the gradients produced by GenerateGradientBatch with central finite differences
+// of the summed batch loss for every value of every layer param, epsilon <= 0 uses
+// DefaultEpsilon. The network runs in its current mode, so every layer must be
+// deterministic in it, e.g. Dropout is only checked in inference mode. Parameters and the
+// State of StatefulLayers are restored and the cached state matches them before returning.
+func CheckSequential(sequential *neuralnet.Sequential, inputs, solutions *mat.Dense, epsilon float64) (SequentialReport, error) {
+	if epsilon <= 0 {
+		epsilon = DefaultEpsilon
+	}
+
+	snapshot := sequential.Snapshot()
+	defer sequential.Restore(snapshot)
+
+	if _, err := sequential.CalculateBatch(inputs); err != nil {
+		return SequentialReport{}, fmt.Errorf("network calculation failed: %s", err)
+	}
+
+	delta, err := sequential.GenerateDeltaBatch(solutions)
+	if err != nil {
+		return SequentialReport{}, fmt.Errorf("network delta generation failed: %s", err)
+	}
+
+	analytic, err := sequential.GenerateGradientBatch(delta)
+	if err != nil {
+		return SequentialReport{}, fmt.Errorf("network gradient generation failed: %s", err)
+	}
+
+	lossAt := func() (float64, error) {
+		if _, err := sequential.CalculateBatch(inputs); err != nil {
+			return 0, err
+		}
+
+		return sequential.CalcLossBatch(solutions)
+	}
+
+	var result SequentialReport
+
+	for layerIndex, layer := range sequential.Layers {
+		params := layer.Params()
+		layerErrors := make([]float64, len(params))
+
+		for idx, param := range params {
+			grad := analytic.Layers[layerIndex][idx]
+			if layerIndex < len(analytic.LayerRows) && idx < len(analytic.LayerRows[layerIndex]) && analytic.LayerRows[layerIndex][idx] != nil {
+				if grad, err = expandRows(grad, analytic.LayerRows[layerIndex][idx], param); err != nil {
+					return SequentialReport{}, fmt.Errorf("invalid gradient at layer %d: %s", layerIndex, err)
+				}
+			}
+
+			numerical, err := numericalGrad(param, epsilon, lossAt)
+			if err != nil {
+				return SequentialReport{}, fmt.Errorf("loss calculation failed: %s", err)
+			}
+
+			if layerErrors[idx], err = matrixError(grad, numerical); err != nil {
+				return SequentialReport{}, fmt.Errorf("invalid gradient at layer %d: %s", layerIndex, err)
+			}
+		}
+
+		result.Layers = append(result.Layers, layerErrors)
+	}
+
+	// leave the network state matching the unperturbed parameters
+	if _, err := sequential.CalculateBatch(inputs); err != nil {
+		return SequentialReport{}, fmt.Errorf("network calculation failed: %s", err)
+	}
+
+	return result, nil
+}
+
+// Grads of layer with the rows a SparseLayer leaves out filled in as zeros
+func denseGrads(layer neuralnet.Layer, params []*mat.Dense) ([]*mat.Dense, error) {
+	grads := layer.Grads()
+	if len(grads) != len(params) {
+		return nil, fmt.Errorf("layer has %d gradients for %d params", len(grads), len(params))
+	}
+
+	sparseLayer, ok := layer.(neuralnet.SparseLayer)
+	if !ok {
+		return grads, nil
+	}
+
+	result := append([]*mat.Dense(nil), grads...)
+	for idx, rows := range sparseLayer.GradRows() {
+		if rows == nil || idx >= len(result) {
+			continue
+		}
+
+		var err error
+		if result[idx], err = expandRows(grads[idx], rows, params[idx]); err != nil {
+			return nil, fmt.Errorf("invalid gradient of param %d: %s", idx, err)
+		}
+	}
+
+	return result, nil
+}
+
+// gradient shaped like param from grad, whose row k is the gradient of row rows[k]
+func expandRows(grad *mat.Dense, rows []int, param *mat.Dense) (*mat.Dense, error) {
+	r, c := param.Dims()
+	if gr, gc := grad.Dims(); gr != len(rows) || gc != c {
+		return nil, fmt.Errorf("dimension %dx%d, expected %dx%d", gr, gc, len(rows), c)
+	}
+
+	result := mat.NewDense(r, c, nil)
+	for k, row := range rows {
+		if row < 0 || row >= r {
+			return nil, fmt.Errorf("row %d, expected less than %d", row, r)
+		}
+
+		result.SetRow(row, grad.RawRowView(k))
+	}
+
+	return result, nil
+}
+
+// central difference of f for every value of m, which is restored afterwards
+func numericalGrad(m *mat.Dense, epsilon float64, f func() (float64, error)) (*mat.Dense, error) {
+	r, c := m.Dims()
+	result := mat.NewDense(r, c, nil)
+
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			original := m.At(i, j)
+
+			m.Set(i, j, original+epsilon)
+			plus, err := f()
+			if err != nil {
+				m.Set(i, j, original)
+				return nil, err
+			}
+
+			m.Set(i, j, original-epsilon)
+			minus, err := f()
+			m.Set(i, j, original)
+			if err != nil {
+				return nil, err
+			}
+
+			result.Set(i, j, (plus-minus)/(2*epsilon))
+		}
+	}
+
+	return result, nil
+}
+
+func matrixError(analytic, numerical *mat.Dense) (float64, error) {
+	r, c := numerical.Dims()
+	if analytic == nil {
+		return 0, fmt.Errorf("missing gradient")
+	}
+
+	if ar, ac := analytic.Dims(); ar != r || ac != c {
+		return 0, fmt.Errorf("dimension %dx%d, expected %dx%d", ar, ac, r, c)
+	}
+
+	result := float64(0)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			result = math.Max(result, relativeError(analytic.At(i, j), numerical.At(i, j)))
+		}
+	}
+
+	return result, nil
+}
@@ -13,5 +13,14 @@ func TestUnitSummerSchool(t *testing.T) {
 
 	suite := spec.New("neuralnet", spec.Report(report.Terminal{}))
 	suite("Network", testNetwork)
+	suite("Batch", testBatch)
+	suite("BatchNorm", testBatchNorm)
+	suite("Dropout", testDropout)
+	suite("Persist", testPersist)
+	suite("PersistSequential", testPersistSequential)
+	suite("Predict", testPredict)
+	suite("Regularization", testRegularization)
+	suite("Sequential", testSequential)
+	suite("Snapshot", testSnapshot)
 	suite.Run(t)
 }
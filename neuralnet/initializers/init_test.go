@@ -0,0 +1,14 @@
+package initializers_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitInitializers(t *testing.T) {
+	suite := spec.New("Initializers", spec.Report(report.Terminal{}))
+	suite("Initializers", testInitializers)
+	suite.Run(t)
+}
@@ -0,0 +1,153 @@
+package initializers
+
+import (
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Initializers fill values with the row-major data of a fanOut x fanIn weight
+// matrix, or a fanOut length bias vector, for the layer at index layer. All
+// sampling uses rng so a seeded source reproduces the same values.
+
+///
+/// Constant Def
+///
+type Constant struct {
+	Value float64
+}
+
+func (c Constant) Initialize(values []float64, _, _, _ int, _ *rand.Rand) {
+	for idx := range values {
+		values[idx] = c.Value
+	}
+}
+
+///
+/// Xavier/Glorot Def
+///
+// uniform over [-limit, limit] where limit = sqrt(6 / (fanIn + fanOut))
+type XavierUniform struct{}
+
+func (x XavierUniform) Initialize(values []float64, fanIn, fanOut, _ int, rng *rand.Rand) {
+	fillUniform(rng, values, math.Sqrt(6/float64(fanIn+fanOut)))
+}
+
+// normal with standard deviation sqrt(2 / (fanIn + fanOut))
+type XavierNormal struct{}
+
+func (x XavierNormal) Initialize(values []float64, fanIn, fanOut, _ int, rng *rand.Rand) {
+	fillNormal(rng, values, math.Sqrt(2/float64(fanIn+fanOut)))
+}
+
+///
+/// He Def
+///
+// uniform over [-limit, limit] where limit = sqrt(6 / fanIn), suited to Relu layers
+type HeUniform struct{}
+
+func (h HeUniform) Initialize(values []float64, fanIn, _, _ int, rng *rand.Rand) {
+	fillUniform(rng, values, math.Sqrt(6/float64(fanIn)))
+}
+
+// normal with standard deviation sqrt(2 / fanIn)
+type HeNormal struct{}
+
+func (h HeNormal) Initialize(values []float64, fanIn, _, _ int, rng *rand.Rand) {
+	fillNormal(rng, values, math.Sqrt(2/float64(fanIn)))
+}
+
+///
+/// LeCun Def
+///
+// uniform over [-limit, limit] where limit = sqrt(3 / fanIn)
+type LeCunUniform struct{}
+
+func (l LeCunUniform) Initialize(values []float64, fanIn, _, _ int, rng *rand.Rand) {
+	fillUniform(rng, values, math.Sqrt(3/float64(fanIn)))
+}
+
+// normal with standard deviation sqrt(1 / fanIn)
+type LeCunNormal struct{}
+
+func (l LeCunNormal) Initialize(values []float64, fanIn, _, _ int, rng *rand.Rand) {
+	fillNormal(rng, values, math.Sqrt(1/float64(fanIn)))
+}
+
+///
+/// Orthogonal Def
+///
+// fills the weight matrix with orthonormal rows (or columns when it has more rows than
+// fanIn columns), scaled by Gain. Values that do not form rows of fanIn, e.g. a bias
+// vector, are filled as a single row, a random unit vector scaled by Gain.
+type Orthogonal struct {
+	Gain float64
+}
+
+// Gain of 1
+func NewOrthogonal() Orthogonal {
+	return Orthogonal{Gain: 1}
+}
+
+func (o Orthogonal) Initialize(values []float64, fanIn, _, _ int, rng *rand.Rand) {
+	gain := o.Gain
+
+	rows, cols := len(values)/fanIn, fanIn
+	if rows == 0 || len(values)%fanIn != 0 {
+		rows, cols = 1, len(values)
+	}
+
+	// factor the taller orientation so Q has orthonormal columns
+	transpose := rows < cols
+	if transpose {
+		rows, cols = cols, rows
+	}
+
+	random := make([]float64, rows*cols)
+	fillNormal(rng, random, 1)
+
+	var qr mat.QR
+	qr.Factorize(mat.NewDense(rows, cols, random))
+
+	var q, r mat.Dense
+	qr.QTo(&q)
+	qr.RTo(&r)
+
+	result := mat.NewDense(rows, cols, nil)
+	for j := 0; j < cols; j++ {
+		// sign correction gives a uniform distribution over orthogonal matrices
+		sign := float64(1)
+		if r.At(j, j) < 0 {
+			sign = -1
+		}
+
+		for i := 0; i < rows; i++ {
+			result.Set(i, j, gain*sign*q.At(i, j))
+		}
+	}
+
+	var output mat.Matrix = result
+	if transpose {
+		output = result.T()
+	}
+
+	outRows, outCols := output.Dims()
+	for i := 0; i < outRows; i++ {
+		for j := 0; j < outCols; j++ {
+			values[i*outCols+j] = output.At(i, j)
+		}
+	}
+}
+
+func fillUniform(rng *rand.Rand, values []float64, limit float64) {
+	for idx := range values {
+		values[idx] = (2*rng.Float64() - 1) * limit
+	}
+}
+
+func fillNormal(rng *rand.Rand, values []float64, stdDev float64) {
+	for idx := range values {
+		values[idx] = rng.NormFloat64() * stdDev
+	}
+}
@@ -0,0 +1,159 @@
+package initializers_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+type initializer interface {
+	Initialize(values []float64, fanIn, fanOut, layer int, rng *rand.Rand)
+}
+
+func stats(values []float64) (mean, stdDev, maxAbs float64) {
+	for _, val := range values {
+		mean += val
+		maxAbs = math.Max(maxAbs, math.Abs(val))
+	}
+	mean /= float64(len(values))
+
+	for _, val := range values {
+		stdDev += (val - mean) * (val - mean)
+	}
+
+	return mean, math.Sqrt(stdDev / float64(len(values))), maxAbs
+}
+
+func testInitializers(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		rng *rand.Rand
+	)
+
+	it.Before(func() {
+		rng = rand.New(rand.NewSource(92))
+	})
+
+	context("Constant", func() {
+		it("fills every value", func() {
+			values := make([]float64, 3)
+			initializers.Constant{Value: 0.1}.Initialize(values, 2, 3, 1, rng)
+
+			Expect(values).To(Equal([]float64{0.1, 0.1, 0.1}))
+		})
+	})
+
+	context("uniform initializers", func() {
+		for _, tc := range []struct {
+			name        string
+			initializer initializer
+			limit       float64
+		}{
+			{"XavierUniform", initializers.XavierUniform{}, math.Sqrt(6.0 / (400 + 200))},
+			{"HeUniform", initializers.HeUniform{}, math.Sqrt(6.0 / 400)},
+			{"LeCunUniform", initializers.LeCunUniform{}, math.Sqrt(3.0 / 400)},
+		} {
+			tc := tc
+
+			it(tc.name+" samples within the fan based limit", func() {
+				values := make([]float64, 400*200)
+				tc.initializer.Initialize(values, 400, 200, 1, rng)
+
+				mean, stdDev, maxAbs := stats(values)
+				Expect(maxAbs).To(BeNumerically("<=", tc.limit))
+				Expect(mean).To(BeNumerically("~", 0, tc.limit/100))
+				Expect(stdDev).To(BeNumerically("~", tc.limit/math.Sqrt(3), tc.limit/100))
+			})
+		}
+	})
+
+	context("normal initializers", func() {
+		for _, tc := range []struct {
+			name        string
+			initializer initializer
+			stdDev      float64
+		}{
+			{"XavierNormal", initializers.XavierNormal{}, math.Sqrt(2.0 / (400 + 200))},
+			{"HeNormal", initializers.HeNormal{}, math.Sqrt(2.0 / 400)},
+			{"LeCunNormal", initializers.LeCunNormal{}, math.Sqrt(1.0 / 400)},
+		} {
+			tc := tc
+
+			it(tc.name+" samples with the fan based standard deviation", func() {
+				values := make([]float64, 400*200)
+				tc.initializer.Initialize(values, 400, 200, 1, rng)
+
+				mean, stdDev, _ := stats(values)
+				Expect(mean).To(BeNumerically("~", 0, tc.stdDev/50))
+				Expect(stdDev).To(BeNumerically("~", tc.stdDev, tc.stdDev/50))
+			})
+		}
+	})
+
+	context("when using the same seed", func() {
+		it("produces identical values", func() {
+			first := make([]float64, 10)
+			initializers.HeNormal{}.Initialize(first, 5, 2, 1, rand.New(rand.NewSource(7)))
+
+			second := make([]float64, 10)
+			initializers.HeNormal{}.Initialize(second, 5, 2, 1, rand.New(rand.NewSource(7)))
+
+			Expect(first).To(Equal(second))
+		})
+	})
+
+	context("Orthogonal", func() {
+		it("produces orthonormal rows for wide matrices", func() {
+			values := make([]float64, 3*5)
+			initializers.NewOrthogonal().Initialize(values, 5, 3, 1, rng)
+
+			w := mat.NewDense(3, 5, values)
+			var product mat.Dense
+			product.Mul(w, w.T())
+
+			Expect(mat.EqualApprox(&product, eye(3), 1e-10)).To(BeTrue())
+		})
+
+		it("produces orthonormal columns for tall matrices scaled by gain", func() {
+			values := make([]float64, 5*3)
+			initializers.Orthogonal{Gain: 2}.Initialize(values, 3, 5, 1, rng)
+
+			w := mat.NewDense(5, 3, values)
+			var product mat.Dense
+			product.Mul(w.T(), w)
+			product.Scale(0.25, &product)
+
+			Expect(mat.EqualApprox(&product, eye(3), 1e-10)).To(BeTrue())
+		})
+
+		it("fills a bias vector with a unit vector", func() {
+			values := make([]float64, 2)
+			initializers.NewOrthogonal().Initialize(values, 3, 2, 1, rng)
+
+			Expect(mat.Norm(mat.NewVecDense(2, values), 2)).To(BeNumerically("~", 1, 1e-10))
+		})
+
+		it("uses a Gain of 0 as given", func() {
+			values := make([]float64, 3*5)
+			initializers.Orthogonal{}.Initialize(values, 5, 3, 1, rng)
+
+			Expect(values).To(Equal(make([]float64, 3*5)))
+		})
+	})
+}
+
+func eye(n int) *mat.Dense {
+	result := mat.NewDense(n, n, nil)
+	for i := 0; i < n; i++ {
+		result.Set(i, i, 1)
+	}
+
+	return result
+}
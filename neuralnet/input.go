@@ -0,0 +1,38 @@
+package neuralnet
+
+import (
+	"fmt"
+)
+
+// A Network's InputLayer runs before its dense layers, e.g. so a layers.Embedding can look
+// up the vectors of ids. It is the first of the network's Layers, so it behaves as it would
+// as the first layer of any other Sequential network.
+
+func (n *Network) setInputLayer(layer Layer, shape Shape) error {
+	if layer == nil {
+		if shape != nil {
+			return fmt.Errorf("an input shape requires an input layer")
+		}
+
+		return nil
+	}
+
+	if err := shape.validate(); err != nil {
+		return err
+	}
+
+	output, err := layer.OutputShape(shape)
+	if err != nil {
+		return fmt.Errorf("invalid input layer: %s", err)
+	}
+
+	if output.Size() != n.InputSize {
+		return fmt.Errorf("invalid input layer output size: %d, expected %d", output.Size(), n.InputSize)
+	}
+
+	n.InputLayer = layer
+	n.InputShape = shape
+	n.InputSize = shape.Size()
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package neuralnet
+
+import (
+	"fmt"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Shape lists the dimensions of a single sample, e.g. {channels, height, width}.
+// Samples always travel as flattened matrix columns in row-major order.
+type Shape []int
+
+// number of values in a sample
+func (s Shape) Size() int {
+	if len(s) == 0 {
+		return 0
+	}
+
+	result := 1
+	for _, dim := range s {
+		result *= dim
+	}
+
+	return result
+}
+
+func (s Shape) validate() error {
+	if len(s) == 0 {
+		return fmt.Errorf("invalid shape: %v", []int(s))
+	}
+
+	for _, dim := range s {
+		if dim <= 0 {
+			return fmt.Errorf("invalid shape: %v", []int(s))
+		}
+	}
+
+	return nil
+}
+
+// A Layer is one step of a Sequential network. Every column of a matrix is one sample.
+type Layer interface {
+	// output for input, training selects training behaviour such as dropout. Layers keep
+	// whatever Backward needs from the most recent call and must not modify input.
+	// Input a layer cannot take, e.g. invalid embedding ids, is an error.
+	Forward(input *mat.Dense, training bool) (*mat.Dense, error)
+	// gradient with respect to the input of the most recent Forward call, given the gradient
+	// with respect to its output. Parameter gradients summed over the batch are kept for Grads.
+	Backward(grad *mat.Dense) (*mat.Dense, error)
+	// trainable parameters, nil for layers without any. Params are updated in place
+	// and must not be strided views.
+	Params() []*mat.Dense
+	// gradients matching Params from the most recent Backward call
+	Grads() []*mat.Dense
+	// sample shape produced for input, an error when the layer cannot take input.
+	// NewSequential calls it once per layer before any Forward call, layers that depend
+	// on the layout of their input, like convolutions and recurrent layers, keep input from it.
+	OutputShape(input Shape) (Shape, error)
+}
+
+// A FuncLayer applies a NodeFunc to its input, when it is the last layer and the
+// network Loss pairs with its Func the layer's derivative is skipped
+type FuncLayer interface {
+	Layer
+	NodeFunc() NodeFunc
+}
+
+// A DecayedLayer reports which of its Params are weight matrices, only those are decayed
+// by a DecayingOptimizer. Every parameter of layers that are not DecayedLayers is decayed.
+type DecayedLayer interface {
+	Layer
+	// whether each Params entry is decayed
+	Decayed() []bool
+}
+
+// A SparseLayer only computes gradients for the parameter rows used by the most recent
+// Forward call, e.g. the embedding vectors of the ids in a batch. Its Grads hold just those
+// rows and Update leaves every other row, and its optimizer state, untouched.
+type SparseLayer interface {
+	Layer
+	// sorted parameter rows held by each Grads entry, nil for entries with a full gradient
+	GradRows() [][]int
+}
+
+// A StatefulLayer learns values besides its Params without gradients, e.g. the running
+// statistics of batch normalization. Snapshots hold them after the layer's Params.
+type StatefulLayer interface {
+	Layer
+	// values updated in place by Forward, they must not be strided views
+	State() []*mat.Dense
+}
+
+// A ReplicaLayer can run on several goroutines at once through replicas, which share its
+// Params and State but keep caches of their own. Only Sequentials of ReplicaLayers have
+// working Workspaces, see Sequential.NewWorkspace.
+type ReplicaLayer interface {
+	Layer
+	// layer sharing the values of this one, random values such as dropout masks are drawn
+	// from rng, which is nil for replicas that only run in inference mode
+	Replica(rng *rand.Rand) Layer
+}
+
+// parameter rows held by Layers[layer][param], nil for a full gradient
+func (g Gradient) rows(layer, param int) []int {
+	if layer >= len(g.LayerRows) || param >= len(g.LayerRows[layer]) {
+		return nil
+	}
+
+	return g.LayerRows[layer][param]
+}
+
+// sum of two sparse gradients holding the sorted parameter rows of rows and otherRows
+func addRows(grad *mat.Dense, rows []int, other *mat.Dense, otherRows []int) (*mat.Dense, []int) {
+	var merged []int
+	for i, j := 0, 0; i < len(rows) || j < len(otherRows); {
+		switch {
+		case j == len(otherRows) || (i < len(rows) && rows[i] < otherRows[j]):
+			merged = append(merged, rows[i])
+			i++
+		case i == len(rows) || otherRows[j] < rows[i]:
+			merged = append(merged, otherRows[j])
+			j++
+		default:
+			merged = append(merged, rows[i])
+			i++
+			j++
+		}
+	}
+
+	_, c := grad.Dims()
+	result := mat.NewDense(len(merged), c, nil)
+
+	for _, source := range []struct {
+		grad *mat.Dense
+		rows []int
+	}{{grad, rows}, {other, otherRows}} {
+		k := 0
+		for i, row := range source.rows {
+			for merged[k] != row {
+				k++
+			}
+
+			dst := result.RawRowView(k)
+			for col, v := range source.grad.RawRowView(i) {
+				dst[col] += v
+			}
+		}
+	}
+
+	return result, merged
+}
+
+// grad must be rows x batchSize, the output size and sample count of the last Forward
+// call, a batchSize of 0 means Forward has not been called
+func checkGrad(name string, grad *mat.Dense, rows, batchSize int) error {
+	if batchSize == 0 {
+		return fmt.Errorf("%s: no batch has been calculated", name)
+	}
+
+	r, c := grad.Dims()
+	if r != rows || c != batchSize {
+		return fmt.Errorf("%s: invalid gradient dimension: %dx%d, expected %dx%d", name, r, c, rows, batchSize)
+	}
+
+	return nil
+}
+
+// sample count of a cached batch, 0 when there is none
+func columns(m *mat.Dense) int {
+	if m == nil {
+		return 0
+	}
+
+	_, c := m.Dims()
+	return c
+}
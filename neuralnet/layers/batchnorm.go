@@ -0,0 +1,17 @@
+package layers
+
+import (
+	"github.com/dwillist/summerschool/v2/neuralnet"
+)
+
+///
+/// BatchNorm Def
+///
+// normalizes every value of a sample over the batch like a Network layer with
+// LayerConfig.BatchNorm, see neuralnet.BatchNorm. Gamma and Beta are the layer's Params
+// and the running statistics its State.
+type BatchNorm = neuralnet.BatchNorm
+
+func NewBatchNorm(size int) *BatchNorm {
+	return neuralnet.NewBatchNorm(size)
+}
@@ -0,0 +1,129 @@
+package layers_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testBatchNorm(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		norm  *layers.BatchNorm
+		input *mat.Dense
+	)
+
+	it.Before(func() {
+		norm = layers.NewBatchNorm(2)
+		norm.Gamma.SetVec(0, 2)
+		norm.Beta.SetVec(1, 0.5)
+
+		input = mat.NewDense(2, 3, []float64{
+			1, 2, 3,
+			-1, 0, 4,
+		})
+	})
+
+	it("normalizes over the batch in training mode", func() {
+		output, err := norm.Forward(input, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		for i := 0; i < 2; i++ {
+			row := output.RawRowView(i)
+			Expect(row[0] + row[1] + row[2]).To(BeNumerically("~", 3*norm.Beta.AtVec(i), 1e-9))
+		}
+
+		// the running statistics move towards the batch mean of 2 and 1
+		Expect(norm.RunningMean.AtVec(0)).To(BeNumerically("~", 0.2, 1e-12))
+		Expect(norm.RunningMean.AtVec(1)).To(BeNumerically("~", 0.1, 1e-12))
+	})
+
+	it("uses the running statistics in inference mode", func() {
+		norm.RunningMean.SetVec(0, 1)
+		norm.RunningVar.SetVec(1, 4)
+
+		output, err := norm.Forward(input, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(output.At(0, 2)).To(BeNumerically("~", 4/math.Sqrt(1+norm.Epsilon), 1e-12))
+		Expect(output.At(1, 2)).To(BeNumerically("~", 4/math.Sqrt(4+norm.Epsilon)+0.5, 1e-12))
+		Expect(norm.RunningMean.AtVec(0)).To(Equal(1.0))
+	})
+
+	it("matches finite differences in inference mode", func() {
+		norm.RunningMean.SetVec(1, -0.5)
+
+		report, err := gradcheck.CheckLayer(norm, input, mat.NewDense(2, 3, []float64{1, -2, 0.5, 3, 0, -1}), 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(report.Params).To(HaveLen(2))
+		Expect(report.Max()).To(BeNumerically("<", 1e-6))
+	})
+
+	it("matches finite differences in training mode without moving the running statistics", func() {
+		rng := rand.New(rand.NewSource(5))
+		sequential, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+			InputShape: neuralnet.Shape{2},
+			Layers: []neuralnet.Layer{
+				layers.NewDense(2, 3, neuralnet.InitRandom, neuralnet.InitRandom, rng),
+				layers.NewBatchNorm(3),
+				layers.NewActivation(nodefuncs.Sigmoid{}),
+				layers.NewDense(3, 2, neuralnet.InitRandom, neuralnet.InitRandom, rng),
+			},
+			Loss: losses.MSE{},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sequential.SetTraining(true)
+
+		before := sequential.Snapshot()
+
+		solutions := mat.NewDense(2, 3, []float64{1, 0, 0.5, 0, 1, -1})
+		report, err := gradcheck.CheckSequential(&sequential, input, solutions, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(report.Layers[1]).To(HaveLen(2))
+		Expect(report.Max()).To(BeNumerically("<", 1e-6))
+		Expect(sequential.Snapshot()).To(Equal(before))
+	})
+
+	it("keeps the running statistics as its State", func() {
+		_, err := norm.Forward(input, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		state := norm.State()
+		Expect(state).To(HaveLen(2))
+		Expect(state[0].RawMatrix().Data).To(Equal(norm.RunningMean.RawVector().Data))
+		Expect(state[1].RawMatrix().Data).To(Equal(norm.RunningVar.RawVector().Data))
+	})
+
+	it("fails to backpropagate without a matching batch", func() {
+		_, err := norm.Backward(mat.NewDense(2, 3, nil))
+		Expect(err).To(MatchError("batch norm: no batch has been calculated"))
+
+		_, err = norm.Forward(input, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = norm.Backward(mat.NewDense(3, 3, nil))
+		Expect(err).To(MatchError("batch norm: invalid gradient dimension: 3x3, expected 2x3"))
+	})
+
+	it("checks the input size", func() {
+		shape, err := norm.OutputShape(neuralnet.Shape{2})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(shape).To(Equal(neuralnet.Shape{2}))
+
+		_, err = norm.OutputShape(neuralnet.Shape{3})
+		Expect(err).To(MatchError("invalid input size: 3, expected 2"))
+	})
+}
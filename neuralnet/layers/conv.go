@@ -0,0 +1,661 @@
+package layers
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Spatial layers take samples of shape {channels, height, width} flattened in row-major
+// order. They learn their input height and width from OutputShape, which must be called,
+// e.g. by neuralnet.NewSequential, before Forward.
+
+func init() {
+	neuralnet.RegisterLayer("conv2d", &Conv2D{}, loadConv2D)
+	neuralnet.RegisterLayer("maxpool2d", &MaxPool2D{}, loadMaxPool2D)
+	neuralnet.RegisterLayer("avgpool2d", &AvgPool2D{}, loadAvgPool2D)
+	neuralnet.RegisterLayer("flatten", &Flatten{}, loadFlatten)
+}
+
+// spatial input layout fixed by OutputShape
+type spatial struct {
+	channels, height, width int
+}
+
+func spatialInput(name string, input neuralnet.Shape) (spatial, error) {
+	if len(input) != 3 {
+		return spatial{}, fmt.Errorf("%s requires a {channels, height, width} input, got %v", name, []int(input))
+	}
+
+	return spatial{input[0], input[1], input[2]}, nil
+}
+
+func (s spatial) size() int {
+	return s.channels * s.height * s.width
+}
+
+func (s spatial) check(name string, input *mat.Dense) error {
+	if r, _ := input.Dims(); s.size() == 0 || r != s.size() {
+		return fmt.Errorf("%s: input of %d rows does not match the shape set by OutputShape", name, r)
+	}
+
+	return nil
+}
+
+// number of window positions along a dimension of length size
+func windows(size, kernel, stride, padding int) int {
+	if size+2*padding < kernel {
+		return 0
+	}
+
+	return (size+2*padding-kernel)/stride + 1
+}
+
+///
+/// Conv2D Def
+///
+type Conv2DConfig struct {
+	InChannels  int
+	OutChannels int
+	// kernels are KernelHeight x KernelWidth in every input channel
+	KernelHeight int
+	KernelWidth  int
+	// defaults to 1
+	Stride int
+	// zeros added on every side of the input
+	Padding int
+	// default to initializers.XavierUniform for kernels and zeros for bias
+	WeightInit neuralnet.Initializer
+	BiasInit   neuralnet.Initializer
+}
+
+// 2D convolution, every output channel sums one kernel per input channel over every window.
+// Windows are unrolled into columns (im2col) so each sample takes a single matrix multiply.
+type Conv2D struct {
+	InChannels   int
+	OutChannels  int
+	KernelHeight int
+	KernelWidth  int
+	Stride       int
+	Padding      int
+	// one row per output channel holding its kernels for every input channel, row-major
+	Kernels *mat.Dense
+	// column vector with one row per output channel
+	Bias *mat.Dense
+
+	input spatial
+	// unrolled windows of every sample of the most recent Forward call
+	cols        []*mat.Dense
+	kernelsGrad *mat.Dense
+	biasGrad    *mat.Dense
+}
+
+func NewConv2D(config Conv2DConfig, rng *rand.Rand) (*Conv2D, error) {
+	if config.Stride == 0 {
+		config.Stride = 1
+	}
+
+	switch {
+	case config.InChannels <= 0 || config.OutChannels <= 0:
+		return nil, fmt.Errorf("invalid channel count: %d in, %d out", config.InChannels, config.OutChannels)
+	case config.KernelHeight <= 0 || config.KernelWidth <= 0:
+		return nil, fmt.Errorf("invalid kernel size: %dx%d", config.KernelHeight, config.KernelWidth)
+	case config.Stride < 0 || config.Padding < 0:
+		return nil, fmt.Errorf("invalid stride %d or padding %d", config.Stride, config.Padding)
+	}
+
+	weightInit := config.WeightInit
+	if weightInit == nil {
+		weightInit = initializers.XavierUniform{}
+	}
+
+	biasInit := config.BiasInit
+	if biasInit == nil {
+		biasInit = initializers.Constant{}
+	}
+
+	// every output reads InChannels kernels and every input reaches OutChannels kernels,
+	// the matrix itself is OutChannels x InChannels*area
+	area := config.KernelHeight * config.KernelWidth
+	kernels := make([]float64, config.OutChannels*config.InChannels*area)
+	weightInit.Initialize(kernels, config.InChannels*area, config.OutChannels*area, 0, rng)
+
+	// one bias per output channel, as for a Dense layer with OutChannels outputs
+	bias := make([]float64, config.OutChannels)
+	biasInit.Initialize(bias, config.InChannels*area, config.OutChannels, 0, rng)
+
+	return &Conv2D{
+		InChannels:   config.InChannels,
+		OutChannels:  config.OutChannels,
+		KernelHeight: config.KernelHeight,
+		KernelWidth:  config.KernelWidth,
+		Stride:       config.Stride,
+		Padding:      config.Padding,
+		Kernels:      mat.NewDense(config.OutChannels, config.InChannels*area, kernels),
+		Bias:         mat.NewDense(config.OutChannels, 1, bias),
+	}, nil
+}
+
+func (c *Conv2D) outputDims() (int, int) {
+	return windows(c.input.height, c.KernelHeight, c.Stride, c.Padding),
+		windows(c.input.width, c.KernelWidth, c.Stride, c.Padding)
+}
+
+func (c *Conv2D) Forward(input *mat.Dense, _ bool) (*mat.Dense, error) {
+	if err := c.input.check("conv2d", input); err != nil {
+		return nil, err
+	}
+
+	outHeight, outWidth := c.outputDims()
+	outArea := outHeight * outWidth
+
+	_, batchSize := input.Dims()
+	result := mat.NewDense(c.OutChannels*outArea, batchSize, nil)
+	c.cols = make([]*mat.Dense, batchSize)
+
+	out := mat.NewDense(c.OutChannels, outArea, nil)
+	for j := 0; j < batchSize; j++ {
+		c.cols[j] = c.im2col(mat.Col(nil, j, input))
+
+		out.Mul(c.Kernels, c.cols[j])
+		for channel := 0; channel < c.OutChannels; channel++ {
+			bias := c.Bias.At(channel, 0)
+			for k, v := range out.RawRowView(channel) {
+				result.Set(channel*outArea+k, j, v+bias)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (c *Conv2D) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	outHeight, outWidth := c.outputDims()
+	outArea := outHeight * outWidth
+
+	batchSize := len(c.cols)
+	if err := checkGrad("conv2d", grad, c.OutChannels*outArea, batchSize); err != nil {
+		return nil, err
+	}
+
+	result := mat.NewDense(c.input.size(), batchSize, nil)
+
+	c.kernelsGrad = mat.NewDense(c.OutChannels, c.InChannels*c.KernelHeight*c.KernelWidth, nil)
+	c.biasGrad = mat.NewDense(c.OutChannels, 1, nil)
+
+	kernelsGrad := &mat.Dense{}
+	colsGrad := &mat.Dense{}
+	for j := 0; j < batchSize; j++ {
+		// rows of the sample's gradient are output channels, as in Forward
+		outGrad := mat.NewDense(c.OutChannels, outArea, mat.Col(nil, j, grad))
+
+		kernelsGrad.Mul(outGrad, c.cols[j].T())
+		c.kernelsGrad.Add(c.kernelsGrad, kernelsGrad)
+
+		for channel := 0; channel < c.OutChannels; channel++ {
+			c.biasGrad.Set(channel, 0, c.biasGrad.At(channel, 0)+mat.Sum(outGrad.RowView(channel)))
+		}
+
+		colsGrad.Mul(c.Kernels.T(), outGrad)
+		result.SetCol(j, c.col2im(colsGrad))
+	}
+
+	return result, nil
+}
+
+func (c *Conv2D) Params() []*mat.Dense {
+	return []*mat.Dense{c.Kernels, c.Bias}
+}
+
+func (c *Conv2D) Decayed() []bool {
+	return []bool{true, false}
+}
+
+func (c *Conv2D) Grads() []*mat.Dense {
+	return []*mat.Dense{c.kernelsGrad, c.biasGrad}
+}
+
+func (c *Conv2D) OutputShape(input neuralnet.Shape) (neuralnet.Shape, error) {
+	layout, err := spatialInput("conv2d", input)
+	if err != nil {
+		return nil, err
+	}
+
+	if layout.channels != c.InChannels {
+		return nil, fmt.Errorf("invalid input channel count: %d, expected %d", layout.channels, c.InChannels)
+	}
+
+	c.input = layout
+
+	outHeight, outWidth := c.outputDims()
+	if outHeight <= 0 || outWidth <= 0 {
+		return nil, fmt.Errorf("kernel %dx%d does not fit input %v", c.KernelHeight, c.KernelWidth, []int(input))
+	}
+
+	return neuralnet.Shape{c.OutChannels, outHeight, outWidth}, nil
+}
+
+func (c *Conv2D) Replica(_ *rand.Rand) neuralnet.Layer {
+	return &Conv2D{
+		InChannels:   c.InChannels,
+		OutChannels:  c.OutChannels,
+		KernelHeight: c.KernelHeight,
+		KernelWidth:  c.KernelWidth,
+		Stride:       c.Stride,
+		Padding:      c.Padding,
+		Kernels:      c.Kernels,
+		Bias:         c.Bias,
+		input:        c.input,
+	}
+}
+
+// initializers are not saved, the loaded kernels and bias are copied over zeros
+type conv2DConfig struct {
+	InChannels   int `json:"inChannels"`
+	OutChannels  int `json:"outChannels"`
+	KernelHeight int `json:"kernelHeight"`
+	KernelWidth  int `json:"kernelWidth"`
+	Stride       int `json:"stride"`
+	Padding      int `json:"padding"`
+}
+
+func (c *Conv2D) LayerConfig() interface{} {
+	return conv2DConfig{
+		InChannels:   c.InChannels,
+		OutChannels:  c.OutChannels,
+		KernelHeight: c.KernelHeight,
+		KernelWidth:  c.KernelWidth,
+		Stride:       c.Stride,
+		Padding:      c.Padding,
+	}
+}
+
+func loadConv2D(data []byte, rng *rand.Rand) (neuralnet.Layer, error) {
+	var config conv2DConfig
+	if err := decodeConfig("conv2d", data, &config); err != nil {
+		return nil, err
+	}
+
+	// invalid sizes are left to NewConv2D, the area is checked first so no product overflows
+	if config.InChannels > 0 && config.OutChannels > 0 && config.KernelHeight > 0 && config.KernelWidth > 0 {
+		if err := neuralnet.CheckLoadSize(config.KernelHeight, config.KernelWidth); err != nil {
+			return nil, err
+		}
+
+		area := config.KernelHeight * config.KernelWidth
+		if err := neuralnet.CheckLoadSize(config.InChannels, area); err != nil {
+			return nil, err
+		}
+
+		if err := neuralnet.CheckLoadSize(config.OutChannels, config.InChannels*area); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewConv2D(Conv2DConfig{
+		InChannels:   config.InChannels,
+		OutChannels:  config.OutChannels,
+		KernelHeight: config.KernelHeight,
+		KernelWidth:  config.KernelWidth,
+		Stride:       config.Stride,
+		Padding:      config.Padding,
+		WeightInit:   initializers.Constant{},
+	}, rng)
+}
+
+// calls f with the input index of every (channel, kernel row, kernel column) row and
+// output position column of the unrolled matrix, skipping positions in the padding
+func (c *Conv2D) eachWindow(f func(row, col, inputIndex int)) {
+	outHeight, outWidth := c.outputDims()
+
+	row := 0
+	for channel := 0; channel < c.input.channels; channel++ {
+		for ki := 0; ki < c.KernelHeight; ki++ {
+			for kj := 0; kj < c.KernelWidth; kj++ {
+				for oi := 0; oi < outHeight; oi++ {
+					i := oi*c.Stride + ki - c.Padding
+					if i < 0 || i >= c.input.height {
+						continue
+					}
+
+					for oj := 0; oj < outWidth; oj++ {
+						j := oj*c.Stride + kj - c.Padding
+						if j < 0 || j >= c.input.width {
+							continue
+						}
+
+						f(row, oi*outWidth+oj, (channel*c.input.height+i)*c.input.width+j)
+					}
+				}
+
+				row++
+			}
+		}
+	}
+}
+
+func (c *Conv2D) im2col(sample []float64) *mat.Dense {
+	outHeight, outWidth := c.outputDims()
+	result := mat.NewDense(c.input.channels*c.KernelHeight*c.KernelWidth, outHeight*outWidth, nil)
+
+	c.eachWindow(func(row, col, inputIndex int) {
+		result.Set(row, col, sample[inputIndex])
+	})
+
+	return result
+}
+
+// sums the unrolled gradient back into the input positions it was read from
+func (c *Conv2D) col2im(cols *mat.Dense) []float64 {
+	result := make([]float64, c.input.size())
+
+	c.eachWindow(func(row, col, inputIndex int) {
+		result[inputIndex] += cols.At(row, col)
+	})
+
+	return result
+}
+
+///
+/// Pooling Def
+///
+// MaxPool2D keeps the largest value of every Size x Size window in each channel
+type MaxPool2D struct {
+	pooling
+
+	// input index of the kept value for every output value of the most recent Forward call
+	argmax [][]int
+}
+
+// AvgPool2D averages every Size x Size window in each channel
+type AvgPool2D struct {
+	pooling
+}
+
+// stride 0 defaults to size, so windows do not overlap
+func NewMaxPool2D(size, stride int) *MaxPool2D {
+	return &MaxPool2D{pooling: newPooling(size, stride)}
+}
+
+func NewAvgPool2D(size, stride int) *AvgPool2D {
+	return &AvgPool2D{pooling: newPooling(size, stride)}
+}
+
+type pooling struct {
+	Size   int
+	Stride int
+
+	input spatial
+	// sample count of the most recent Forward call
+	batchSize int
+}
+
+func newPooling(size, stride int) pooling {
+	if stride == 0 {
+		stride = size
+	}
+
+	return pooling{
+		Size:   size,
+		Stride: stride,
+	}
+}
+
+func (p *pooling) outputDims() (int, int) {
+	return windows(p.input.height, p.Size, p.Stride, 0), windows(p.input.width, p.Size, p.Stride, 0)
+}
+
+// calls f with the output index and the input indexes of every window of a sample
+func (p *pooling) eachWindow(f func(outputIndex int, window []int)) {
+	outHeight, outWidth := p.outputDims()
+	window := make([]int, 0, p.Size*p.Size)
+
+	outputIndex := 0
+	for channel := 0; channel < p.input.channels; channel++ {
+		for oi := 0; oi < outHeight; oi++ {
+			for oj := 0; oj < outWidth; oj++ {
+				window = window[:0]
+				for ki := 0; ki < p.Size; ki++ {
+					for kj := 0; kj < p.Size; kj++ {
+						i, j := oi*p.Stride+ki, oj*p.Stride+kj
+						window = append(window, (channel*p.input.height+i)*p.input.width+j)
+					}
+				}
+
+				f(outputIndex, window)
+				outputIndex++
+			}
+		}
+	}
+}
+
+func (p *pooling) outputSize() int {
+	outHeight, outWidth := p.outputDims()
+	return p.input.channels * outHeight * outWidth
+}
+
+func (p *pooling) Params() []*mat.Dense {
+	return nil
+}
+
+func (p *pooling) Grads() []*mat.Dense {
+	return nil
+}
+
+func (p *pooling) OutputShape(input neuralnet.Shape) (neuralnet.Shape, error) {
+	layout, err := spatialInput("pooling", input)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Size <= 0 || p.Stride <= 0 {
+		return nil, fmt.Errorf("invalid pooling size %d or stride %d", p.Size, p.Stride)
+	}
+
+	p.input = layout
+
+	outHeight, outWidth := p.outputDims()
+	if outHeight <= 0 || outWidth <= 0 {
+		return nil, fmt.Errorf("pooling window %d does not fit input %v", p.Size, []int(input))
+	}
+
+	return neuralnet.Shape{layout.channels, outHeight, outWidth}, nil
+}
+
+// pooling with the same window and input layout but no cached batch
+func (p *pooling) replica() pooling {
+	return pooling{
+		Size:   p.Size,
+		Stride: p.Stride,
+		input:  p.input,
+	}
+}
+
+func (m *MaxPool2D) Forward(input *mat.Dense, _ bool) (*mat.Dense, error) {
+	if err := m.input.check("maxpool2d", input); err != nil {
+		return nil, err
+	}
+
+	_, batchSize := input.Dims()
+	result := mat.NewDense(m.outputSize(), batchSize, nil)
+	m.argmax = make([][]int, batchSize)
+	m.batchSize = batchSize
+
+	for j := 0; j < batchSize; j++ {
+		sample := mat.Col(nil, j, input)
+		m.argmax[j] = make([]int, m.outputSize())
+
+		m.eachWindow(func(outputIndex int, window []int) {
+			best := window[0]
+			for _, idx := range window[1:] {
+				if sample[idx] > sample[best] {
+					best = idx
+				}
+			}
+
+			m.argmax[j][outputIndex] = best
+			result.Set(outputIndex, j, sample[best])
+		})
+	}
+
+	return result, nil
+}
+
+// only the kept value of every window receives gradient
+func (m *MaxPool2D) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	batchSize := m.batchSize
+	if err := checkGrad("maxpool2d", grad, m.outputSize(), batchSize); err != nil {
+		return nil, err
+	}
+
+	result := mat.NewDense(m.input.size(), batchSize, nil)
+
+	for j := 0; j < batchSize; j++ {
+		for outputIndex, idx := range m.argmax[j] {
+			result.Set(idx, j, result.At(idx, j)+grad.At(outputIndex, j))
+		}
+	}
+
+	return result, nil
+}
+
+func (m *MaxPool2D) Replica(_ *rand.Rand) neuralnet.Layer {
+	return &MaxPool2D{pooling: m.replica()}
+}
+
+type poolingConfig struct {
+	Size   int `json:"size"`
+	Stride int `json:"stride"`
+}
+
+func (p *pooling) LayerConfig() interface{} {
+	return poolingConfig{Size: p.Size, Stride: p.Stride}
+}
+
+func loadPooling(name string, data []byte) (pooling, error) {
+	var config poolingConfig
+	if err := decodeConfig(name, data, &config); err != nil {
+		return pooling{}, err
+	}
+
+	if config.Size <= 0 || config.Stride < 0 {
+		return pooling{}, fmt.Errorf("invalid pool size %d or stride %d", config.Size, config.Stride)
+	}
+
+	return newPooling(config.Size, config.Stride), nil
+}
+
+func loadMaxPool2D(data []byte, _ *rand.Rand) (neuralnet.Layer, error) {
+	pool, err := loadPooling("maxpool2d", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MaxPool2D{pooling: pool}, nil
+}
+
+func (a *AvgPool2D) Forward(input *mat.Dense, _ bool) (*mat.Dense, error) {
+	if err := a.input.check("avgpool2d", input); err != nil {
+		return nil, err
+	}
+
+	_, batchSize := input.Dims()
+	result := mat.NewDense(a.outputSize(), batchSize, nil)
+	area := float64(a.Size * a.Size)
+	a.batchSize = batchSize
+
+	for j := 0; j < batchSize; j++ {
+		sample := mat.Col(nil, j, input)
+
+		a.eachWindow(func(outputIndex int, window []int) {
+			sum := float64(0)
+			for _, idx := range window {
+				sum += sample[idx]
+			}
+
+			result.Set(outputIndex, j, sum/area)
+		})
+	}
+
+	return result, nil
+}
+
+// every value of a window receives an equal share of its gradient
+func (a *AvgPool2D) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	batchSize := a.batchSize
+	if err := checkGrad("avgpool2d", grad, a.outputSize(), batchSize); err != nil {
+		return nil, err
+	}
+
+	result := mat.NewDense(a.input.size(), batchSize, nil)
+	area := float64(a.Size * a.Size)
+
+	for j := 0; j < batchSize; j++ {
+		a.eachWindow(func(outputIndex int, window []int) {
+			share := grad.At(outputIndex, j) / area
+			for _, idx := range window {
+				result.Set(idx, j, result.At(idx, j)+share)
+			}
+		})
+	}
+
+	return result, nil
+}
+
+func (a *AvgPool2D) Replica(_ *rand.Rand) neuralnet.Layer {
+	return &AvgPool2D{pooling: a.replica()}
+}
+
+func loadAvgPool2D(data []byte, _ *rand.Rand) (neuralnet.Layer, error) {
+	pool, err := loadPooling("avgpool2d", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AvgPool2D{pooling: pool}, nil
+}
+
+///
+/// Flatten Def
+///
+// reshapes every sample to a single dimension, e.g. between pooling and Dense layers
+type Flatten struct{}
+
+func NewFlatten() *Flatten {
+	return &Flatten{}
+}
+
+func (f *Flatten) Forward(input *mat.Dense, _ bool) (*mat.Dense, error) {
+	return mat.DenseCopyOf(input), nil
+}
+
+func (f *Flatten) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	return mat.DenseCopyOf(grad), nil
+}
+
+func (f *Flatten) Params() []*mat.Dense {
+	return nil
+}
+
+func (f *Flatten) Grads() []*mat.Dense {
+	return nil
+}
+
+func (f *Flatten) OutputShape(input neuralnet.Shape) (neuralnet.Shape, error) {
+	return neuralnet.Shape{input.Size()}, nil
+}
+
+func (f *Flatten) Replica(_ *rand.Rand) neuralnet.Layer {
+	return NewFlatten()
+}
+
+func (f *Flatten) LayerConfig() interface{} {
+	return nil
+}
+
+func loadFlatten(_ []byte, _ *rand.Rand) (neuralnet.Layer, error) {
+	return NewFlatten(), nil
+}
@@ -0,0 +1,483 @@
+package layers_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testConv(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		rng *rand.Rand
+	)
+
+	it.Before(func() {
+		rng = rand.New(rand.NewSource(11))
+	})
+
+	// batch of count random samples with size values each
+	randomBatch := func(size, count int) *mat.Dense {
+		result := mat.NewDense(size, count, nil)
+		result.Apply(func(_, _ int, _ float64) float64 {
+			return rng.Float64()*2 - 1
+		}, result)
+
+		return result
+	}
+
+	context("Conv2D", func() {
+		it("convolves every channel with its kernels", func() {
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{
+				InChannels:   1,
+				OutChannels:  1,
+				KernelHeight: 2,
+				KernelWidth:  2,
+			}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			conv.Kernels = mat.NewDense(1, 4, []float64{1, 0, 0, -1})
+			conv.Bias.Set(0, 0, 0.5)
+
+			shape, err := conv.OutputShape(neuralnet.Shape{1, 3, 3})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape).To(Equal(neuralnet.Shape{1, 2, 2}))
+
+			output, err := conv.Forward(mat.NewDense(9, 1, []float64{
+				1, 2, 3,
+				4, 5, 6,
+				7, 8, 10,
+			}), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			// every window is top left - bottom right + bias
+			Expect(output.RawMatrix().Data).To(Equal([]float64{-3.5, -3.5, -3.5, -4.5}))
+		})
+
+		it("zero pads the input", func() {
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{
+				InChannels:   1,
+				OutChannels:  1,
+				KernelHeight: 3,
+				KernelWidth:  3,
+				Padding:      1,
+			}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			conv.Kernels = mat.NewDense(1, 9, []float64{1, 1, 1, 1, 1, 1, 1, 1, 1})
+
+			shape, err := conv.OutputShape(neuralnet.Shape{1, 2, 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape).To(Equal(neuralnet.Shape{1, 2, 2}))
+
+			output, err := conv.Forward(mat.NewDense(4, 1, []float64{1, 2, 3, 4}), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.RawMatrix().Data).To(Equal([]float64{10, 10, 10, 10}))
+		})
+
+		it("matches finite differences with strides, padding and several channels", func() {
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{
+				InChannels:   2,
+				OutChannels:  3,
+				KernelHeight: 3,
+				KernelWidth:  2,
+				Stride:       2,
+				Padding:      1,
+				BiasInit:     neuralnet.InitRandom,
+			}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			shape, err := conv.OutputShape(neuralnet.Shape{2, 5, 4})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape).To(Equal(neuralnet.Shape{3, 3, 3}))
+
+			report, err := gradcheck.CheckLayer(conv, randomBatch(40, 2), randomBatch(27, 2), 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Params).To(HaveLen(2))
+			Expect(report.Max()).To(BeNumerically("<", 1e-6))
+		})
+
+		it("initializes the kernels as an OutChannels x InChannels·area matrix", func() {
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{
+				InChannels:   2,
+				OutChannels:  3,
+				KernelHeight: 2,
+				KernelWidth:  2,
+				WeightInit:   initializers.NewOrthogonal(),
+			}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			var product mat.Dense
+			product.Mul(conv.Kernels, conv.Kernels.T())
+
+			identity := mat.NewDense(3, 3, nil)
+			for i := 0; i < 3; i++ {
+				identity.Set(i, i, 1)
+			}
+			Expect(mat.EqualApprox(&product, identity, 1e-10)).To(BeTrue())
+		})
+
+		it("passes kernel area fans to the weights and OutChannels to the bias", func() {
+			weightInit, biasInit := &fanRecorder{}, &fanRecorder{}
+			_, err := layers.NewConv2D(layers.Conv2DConfig{
+				InChannels:   2,
+				OutChannels:  3,
+				KernelHeight: 2,
+				KernelWidth:  3,
+				WeightInit:   weightInit,
+				BiasInit:     biasInit,
+			}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(weightInit.fans).To(Equal([][2]int{{12, 18}}))
+			Expect(biasInit.fans).To(Equal([][2]int{{12, 3}}))
+		})
+
+		it("fails on invalid configurations", func() {
+			_, err := layers.NewConv2D(layers.Conv2DConfig{InChannels: 1, KernelHeight: 1, KernelWidth: 1}, rng)
+			Expect(err).To(MatchError("invalid channel count: 1 in, 0 out"))
+
+			_, err = layers.NewConv2D(layers.Conv2DConfig{InChannels: 1, OutChannels: 1, KernelHeight: 1}, rng)
+			Expect(err).To(MatchError("invalid kernel size: 1x0"))
+
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{InChannels: 2, OutChannels: 1, KernelHeight: 3, KernelWidth: 3}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = conv.OutputShape(neuralnet.Shape{18})
+			Expect(err).To(MatchError("conv2d requires a {channels, height, width} input, got [18]"))
+
+			_, err = conv.OutputShape(neuralnet.Shape{1, 3, 3})
+			Expect(err).To(MatchError("invalid input channel count: 1, expected 2"))
+
+			_, err = conv.OutputShape(neuralnet.Shape{2, 2, 3})
+			Expect(err).To(MatchError("kernel 3x3 does not fit input [2 2 3]"))
+		})
+
+		it("fails on input that does not match its OutputShape", func() {
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{InChannels: 1, OutChannels: 1, KernelHeight: 2, KernelWidth: 2}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = conv.Forward(mat.NewDense(9, 1, nil), false)
+			Expect(err).To(MatchError("conv2d: input of 9 rows does not match the shape set by OutputShape"))
+
+			_, err = conv.OutputShape(neuralnet.Shape{1, 3, 3})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = conv.Forward(mat.NewDense(8, 1, nil), false)
+			Expect(err).To(MatchError("conv2d: input of 8 rows does not match the shape set by OutputShape"))
+		})
+
+		it("fails to backpropagate without a matching batch", func() {
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{InChannels: 1, OutChannels: 2, KernelHeight: 2, KernelWidth: 2}, rng)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = conv.OutputShape(neuralnet.Shape{1, 3, 3})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = conv.Backward(mat.NewDense(8, 1, nil))
+			Expect(err).To(MatchError("conv2d: no batch has been calculated"))
+
+			_, err = conv.Forward(randomBatch(9, 2), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = conv.Backward(mat.NewDense(8, 3, nil))
+			Expect(err).To(MatchError("conv2d: invalid gradient dimension: 8x3, expected 8x2"))
+		})
+	})
+
+	context("MaxPool2D", func() {
+		it("keeps the largest value of every window", func() {
+			pool := layers.NewMaxPool2D(2, 0)
+
+			shape, err := pool.OutputShape(neuralnet.Shape{1, 4, 4})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape).To(Equal(neuralnet.Shape{1, 2, 2}))
+
+			output, err := pool.Forward(mat.NewDense(16, 1, []float64{
+				1, 5, 2, 0,
+				3, 4, 8, 1,
+				0, 0, -1, -2,
+				9, 0, -3, -4,
+			}), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.RawMatrix().Data).To(Equal([]float64{5, 8, 9, -1}))
+
+			grad, err := pool.Backward(mat.NewDense(4, 1, []float64{1, 2, 3, 4}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(grad.RawMatrix().Data).To(Equal([]float64{
+				0, 1, 0, 0,
+				0, 0, 2, 0,
+				0, 0, 4, 0,
+				3, 0, 0, 0,
+			}))
+		})
+
+		it("matches finite differences with overlapping windows", func() {
+			pool := layers.NewMaxPool2D(3, 1)
+
+			shape, err := pool.OutputShape(neuralnet.Shape{2, 4, 5})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape).To(Equal(neuralnet.Shape{2, 2, 3}))
+
+			report, err := gradcheck.CheckLayer(pool, randomBatch(40, 2), randomBatch(12, 2), 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Max()).To(BeNumerically("<", 1e-6))
+		})
+
+		it("fails to backpropagate without a matching batch", func() {
+			pool := layers.NewMaxPool2D(2, 0)
+			_, err := pool.OutputShape(neuralnet.Shape{1, 4, 4})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = pool.Backward(mat.NewDense(4, 1, nil))
+			Expect(err).To(MatchError("maxpool2d: no batch has been calculated"))
+
+			_, err = pool.Forward(randomBatch(16, 2), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = pool.Backward(mat.NewDense(4, 1, nil))
+			Expect(err).To(MatchError("maxpool2d: invalid gradient dimension: 4x1, expected 4x2"))
+		})
+	})
+
+	context("AvgPool2D", func() {
+		it("averages every window", func() {
+			pool := layers.NewAvgPool2D(2, 0)
+
+			_, err := pool.OutputShape(neuralnet.Shape{1, 2, 4})
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := pool.Forward(mat.NewDense(8, 1, []float64{
+				1, 2, 3, 4,
+				5, 6, 7, 9,
+			}), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.RawMatrix().Data).To(Equal([]float64{3.5, 5.75}))
+
+			report, err := gradcheck.CheckLayer(pool, randomBatch(8, 3), randomBatch(2, 3), 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Max()).To(BeNumerically("<", 1e-6))
+		})
+
+		it("fails to backpropagate without a matching batch", func() {
+			pool := layers.NewAvgPool2D(2, 0)
+			_, err := pool.OutputShape(neuralnet.Shape{1, 2, 4})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = pool.Backward(mat.NewDense(2, 1, nil))
+			Expect(err).To(MatchError("avgpool2d: no batch has been calculated"))
+
+			_, err = pool.Forward(randomBatch(8, 3), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = pool.Backward(mat.NewDense(2, 2, nil))
+			Expect(err).To(MatchError("avgpool2d: invalid gradient dimension: 2x2, expected 2x3"))
+		})
+
+		it("fails when the window does not fit", func() {
+			_, err := layers.NewAvgPool2D(3, 0).OutputShape(neuralnet.Shape{1, 2, 4})
+			Expect(err).To(MatchError("pooling window 3 does not fit input [1 2 4]"))
+
+			_, err = layers.NewAvgPool2D(0, 0).OutputShape(neuralnet.Shape{1, 2, 4})
+			Expect(err).To(MatchError("invalid pooling size 0 or stride 0"))
+		})
+	})
+
+	context("Flatten", func() {
+		it("keeps values in a single dimension", func() {
+			flatten := layers.NewFlatten()
+
+			shape, err := flatten.OutputShape(neuralnet.Shape{2, 3, 4})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape).To(Equal(neuralnet.Shape{24}))
+
+			input := randomBatch(24, 2)
+			Expect(flatten.Forward(input, false)).To(Equal(input))
+			Expect(flatten.Backward(input)).To(Equal(input))
+		})
+	})
+
+	context("in a Sequential network", func() {
+		it("computes the batch gradient from replicas of every layer", func() {
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{
+				InChannels:   2,
+				OutChannels:  3,
+				KernelHeight: 3,
+				KernelWidth:  2,
+				Stride:       2,
+				Padding:      1,
+			}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			network, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{2, 6, 5},
+				Layers: []neuralnet.Layer{
+					conv,
+					layers.NewMaxPool2D(2, 1),
+					layers.NewAvgPool2D(2, 0),
+					layers.NewFlatten(),
+					layers.NewDense(3, 2, nil, nil, rng),
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			inputs := randomBatch(60, 3)
+			solutions := randomBatch(2, 3)
+
+			_, err = network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := network.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := network.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			workspace := network.NewWorkspace()
+			var summed neuralnet.Gradient
+			for j := 0; j < 3; j++ {
+				gradient, err := network.ComputeGradient(workspace, mat.VecDenseCopyOf(inputs.ColView(j)), mat.VecDenseCopyOf(solutions.ColView(j)))
+				Expect(err).NotTo(HaveOccurred())
+				summed.Add(gradient)
+			}
+
+			for idx, grads := range expected.Layers {
+				for paramIndex, grad := range grads {
+					Expect(mat.EqualApprox(summed.Layers[idx][paramIndex], grad, 1e-12)).To(BeTrue())
+				}
+			}
+		})
+
+		it("saves and loads every layer", func() {
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{
+				InChannels:   2,
+				OutChannels:  3,
+				KernelHeight: 3,
+				KernelWidth:  2,
+				Stride:       2,
+				Padding:      1,
+				BiasInit:     initializers.Constant{Value: 0.5},
+			}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			network, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{2, 6, 5},
+				Layers: []neuralnet.Layer{
+					conv,
+					layers.NewMaxPool2D(2, 1),
+					layers.NewAvgPool2D(2, 0),
+					layers.NewFlatten(),
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, format := range []neuralnet.Format{neuralnet.JSONFormat, neuralnet.BinaryFormat} {
+				buffer := bytes.NewBuffer(nil)
+				Expect(network.Save(buffer, format)).To(Succeed())
+
+				loaded, err := neuralnet.LoadSequential(buffer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(loaded.Layers[0].(*layers.Conv2D).Stride).To(Equal(2))
+				Expect(loaded.Layers[0].(*layers.Conv2D).Padding).To(Equal(1))
+				Expect(loaded.Layers[1].(*layers.MaxPool2D).Stride).To(Equal(1))
+				Expect(loaded.Layers[2].(*layers.AvgPool2D).Stride).To(Equal(2))
+				Expect(loaded.Snapshot()).To(Equal(network.Snapshot()))
+
+				inputs := randomBatch(60, 2)
+				expected, err := network.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				actual, err := loaded.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(actual).To(Equal(expected))
+			}
+		})
+
+		it("fails to load kernels too large to allocate", func() {
+			_, err := neuralnet.LoadSequential(strings.NewReader(`{
+				"version": 7,
+				"kind": "sequential",
+				"inputShape": [1, 4, 4],
+				"layers": [{"name": "conv2d", "config": {"inChannels": 65536, "outChannels": 65536, "kernelHeight": 3, "kernelWidth": 3}}]
+			}`))
+			Expect(err).To(MatchError("invalid layer at index 0: invalid matrix dimension: 65536x589824, at most 67108864 values"))
+		})
+
+		it("learns to tell vertical from horizontal lines", func() {
+			conv, err := layers.NewConv2D(layers.Conv2DConfig{
+				InChannels:   1,
+				OutChannels:  4,
+				KernelHeight: 3,
+				KernelWidth:  3,
+				Padding:      1,
+			}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			network, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{1, 6, 6},
+				Layers: []neuralnet.Layer{
+					conv,
+					layers.NewActivation(nodefuncs.Relu{}),
+					layers.NewMaxPool2D(2, 0),
+					layers.NewFlatten(),
+					layers.NewDense(36, 2, nil, nil, rng),
+					layers.NewActivation(nodefuncs.Softmax{}),
+				},
+				Loss:      losses.CrossEntropy{},
+				Optimizer: optimizers.NewAdam(0.01),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// one line per sample at every position, the label is its orientation
+			inputs := mat.NewDense(36, 12, nil)
+			labels := mat.NewDense(2, 12, nil)
+			for line := 0; line < 6; line++ {
+				for k := 0; k < 6; k++ {
+					inputs.Set(k*6+line, line, 1)
+					inputs.Set(line*6+k, 6+line, 1)
+				}
+
+				labels.Set(0, line, 1)
+				labels.Set(1, 6+line, 1)
+			}
+
+			for step := 0; step < 100; step++ {
+				_, err := network.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := network.GenerateDeltaBatch(labels)
+				Expect(err).NotTo(HaveOccurred())
+				gradient, err := network.GenerateGradientBatch(delta)
+				Expect(err).NotTo(HaveOccurred())
+				gradient.Scale(1.0 / 12)
+				Expect(network.Update(gradient)).To(Succeed())
+			}
+
+			output, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			for j := 0; j < 12; j++ {
+				Expect(output.At(0, j) > output.At(1, j)).To(Equal(j < 6))
+			}
+		})
+	})
+}
+
+type fanRecorder struct {
+	fans [][2]int
+}
+
+func (f *fanRecorder) Initialize(_ []float64, fanIn, fanOut, _ int, _ *rand.Rand) {
+	f.fans = append(f.fans, [2]int{fanIn, fanOut})
+}
@@ -0,0 +1,193 @@
+package layers
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"gonum.org/v1/gonum/mat"
+)
+
+func init() {
+	neuralnet.RegisterLayer("embedding", &Embedding{}, loadEmbedding)
+}
+
+///
+/// Embedding Def
+///
+// maps integer ids to learnable vectors, every input value is an id in [0, count) and
+// every output sample holds the vector of each of its ids in order, so an input of shape
+// {n} becomes {n, size}. It computes the same function as a Dense layer over one-hot
+// inputs without ever building them, and is a neuralnet.SparseLayer: only the vectors
+// of ids seen by the most recent Forward call are updated.
+type Embedding struct {
+	// one row per id
+	Vectors *mat.Dense
+
+	ids      [][]int
+	rows     []int
+	gradRows *mat.Dense
+}
+
+// a nil initializer defaults to initializers.XavierUniform. Vectors are initialized as a
+// fanOut x fanIn matrix with a fanIn of size and a fanOut of count, so the layout matches
+// and fan-in scaled initializers depend on the vector size rather than the vocabulary.
+func NewEmbedding(count, size int, init neuralnet.Initializer, rng *rand.Rand) *Embedding {
+	if init == nil {
+		init = initializers.XavierUniform{}
+	}
+
+	vectors := make([]float64, count*size)
+	init.Initialize(vectors, size, count, 0, rng)
+
+	return &Embedding{
+		Vectors: mat.NewDense(count, size, vectors),
+	}
+}
+
+// fails on values that are not ids, Backward then fails until the next valid batch
+func (e *Embedding) Forward(input *mat.Dense, _ bool) (*mat.Dense, error) {
+	count, size := e.Vectors.Dims()
+	r, c := input.Dims()
+
+	// the ids of a previous batch must not meet the gradient of this one
+	e.ids = nil
+	ids := make([][]int, c)
+	result := mat.NewDense(r*size, c, nil)
+
+	for j := 0; j < c; j++ {
+		ids[j] = make([]int, r)
+
+		for i := 0; i < r; i++ {
+			value := input.At(i, j)
+			id := int(value)
+			if float64(id) != value || id < 0 || id >= count {
+				return nil, fmt.Errorf("embedding: invalid id %v, expected an integer in [0, %d)", value, count)
+			}
+
+			ids[j][i] = id
+			for k, v := range e.Vectors.RawRowView(id) {
+				result.Set(i*size+k, j, v)
+			}
+		}
+	}
+
+	e.ids = ids
+
+	return result, nil
+}
+
+// ids are not differentiable, so the input gradient is always zero
+func (e *Embedding) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	_, size := e.Vectors.Dims()
+	rows := 0
+	if len(e.ids) != 0 {
+		rows = len(e.ids[0]) * size
+	}
+
+	if err := checkGrad("embedding", grad, rows, len(e.ids)); err != nil {
+		return nil, err
+	}
+
+	_, c := grad.Dims()
+
+	seen := map[int]bool{}
+	e.rows = nil
+	for _, ids := range e.ids {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				e.rows = append(e.rows, id)
+			}
+		}
+	}
+
+	// gradient matrix row of every id, in order of id
+	sort.Ints(e.rows)
+	index := make(map[int]int, len(e.rows))
+	for k, id := range e.rows {
+		index[id] = k
+	}
+
+	e.gradRows = mat.NewDense(len(e.rows), size, nil)
+	for j, ids := range e.ids {
+		for i, id := range ids {
+			dst := e.gradRows.RawRowView(index[id])
+			for k := range dst {
+				dst[k] += grad.At(i*size+k, j)
+			}
+		}
+	}
+
+	return mat.NewDense(len(e.ids[0]), c, nil), nil
+}
+
+func (e *Embedding) Params() []*mat.Dense {
+	return []*mat.Dense{e.Vectors}
+}
+
+// vectors are looked up rather than weighting inputs, so like biases they are not decayed
+func (e *Embedding) Decayed() []bool {
+	return []bool{false}
+}
+
+// gradients of the Vectors rows listed by GradRows
+func (e *Embedding) Grads() []*mat.Dense {
+	return []*mat.Dense{e.gradRows}
+}
+
+func (e *Embedding) GradRows() [][]int {
+	return [][]int{e.rows}
+}
+
+func (e *Embedding) OutputShape(input neuralnet.Shape) (neuralnet.Shape, error) {
+	_, size := e.Vectors.Dims()
+	if len(input) != 1 {
+		return nil, fmt.Errorf("embedding requires an {ids} input, got %v", []int(input))
+	}
+
+	return neuralnet.Shape{input[0], size}, nil
+}
+
+func (e *Embedding) Replica(_ *rand.Rand) neuralnet.Layer {
+	return &Embedding{Vectors: e.Vectors}
+}
+
+type embeddingConfig struct {
+	Count int `json:"count"`
+	Size  int `json:"size"`
+}
+
+func (e *Embedding) LayerConfig() interface{} {
+	count, size := e.Vectors.Dims()
+	return embeddingConfig{Count: count, Size: size}
+}
+
+func loadEmbedding(data []byte, _ *rand.Rand) (neuralnet.Layer, error) {
+	var config embeddingConfig
+	if err := decodeConfig("embedding", data, &config); err != nil {
+		return nil, err
+	}
+
+	if config.Count <= 0 || config.Size <= 0 {
+		return nil, fmt.Errorf("invalid embedding size: %d ids of %d values", config.Count, config.Size)
+	}
+
+	if err := neuralnet.CheckLoadSize(config.Count, config.Size); err != nil {
+		return nil, err
+	}
+
+	return &Embedding{Vectors: mat.NewDense(config.Count, config.Size, nil)}, nil
+}
+
+// id for every value of ids, e.g. to build embedding inputs from categorical features
+func IDs(ids ...int) *mat.VecDense {
+	values := make([]float64, len(ids))
+	for idx, id := range ids {
+		values[idx] = float64(id)
+	}
+
+	return mat.NewVecDense(len(values), values)
+}
@@ -0,0 +1,393 @@
+package layers_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testEmbedding(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		embedding *layers.Embedding
+		input     *mat.Dense
+	)
+
+	it.Before(func() {
+		embedding = layers.NewEmbedding(5, 2, nil, rand.New(rand.NewSource(17)))
+
+		// two samples of three ids each
+		input = mat.NewDense(3, 2, []float64{
+			4, 1,
+			0, 4,
+			4, 3,
+		})
+	})
+
+	it("looks up the vector of every id", func() {
+		shape, err := embedding.OutputShape(neuralnet.Shape{3})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(shape).To(Equal(neuralnet.Shape{3, 2}))
+
+		output, err := embedding.Forward(input, false)
+		Expect(err).NotTo(HaveOccurred())
+		r, _ := output.Dims()
+		Expect(r).To(Equal(6))
+
+		for j := 0; j < 2; j++ {
+			for i := 0; i < 3; i++ {
+				id := int(input.At(i, j))
+				Expect(output.At(2*i, j)).To(Equal(embedding.Vectors.At(id, 0)))
+				Expect(output.At(2*i+1, j)).To(Equal(embedding.Vectors.At(id, 1)))
+			}
+		}
+	})
+
+	it("only computes gradients for the ids it has seen", func() {
+		weights := mat.NewDense(6, 2, []float64{
+			1, -1,
+			2, 0.5,
+			-3, 0.25,
+			0.5, 2,
+			4, -2,
+			1, 3,
+		})
+
+		// ids are not differentiable, only the vectors are checked
+		report, err := gradcheck.CheckLayerParams(embedding, input, weights, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Max()).To(BeNumerically("<", 1e-6))
+
+		// id 2 is unused
+		Expect(mat.Norm(report.NumericalParams[0].RowView(2), 1)).To(BeZero())
+
+		inputGrad, err := embedding.Backward(weights)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(embedding.GradRows()).To(Equal([][]int{{0, 1, 3, 4}}))
+		rows, _ := embedding.Grads()[0].Dims()
+		Expect(rows).To(Equal(4))
+		Expect(mat.Norm(inputGrad, 1)).To(BeZero())
+	})
+
+	it("does not decay the vectors", func() {
+		Expect(embedding.Decayed()).To(Equal([]bool{false}))
+	})
+
+	it("matches a Dense layer over one-hot inputs", func() {
+		dense := layers.NewDense(5, 2, nil, nil, rand.New(rand.NewSource(1)))
+		embedding.Vectors = mat.DenseCopyOf(dense.Weights.T())
+
+		oneHot := mat.NewDense(5, 2, nil)
+		oneHot.Set(3, 0, 1)
+		oneHot.Set(1, 1, 1)
+
+		expected, err := dense.Forward(oneHot, false)
+		Expect(err).NotTo(HaveOccurred())
+		actual, err := embedding.Forward(mat.NewDense(1, 2, []float64{3, 1}), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mat.EqualApprox(actual, expected, 1e-12)).To(BeTrue())
+	})
+
+	it("initializes Vectors as a count x size weight matrix", func() {
+		orthogonal := layers.NewEmbedding(5, 2, initializers.NewOrthogonal(), rand.New(rand.NewSource(3)))
+
+		gram := &mat.Dense{}
+		gram.Mul(orthogonal.Vectors.T(), orthogonal.Vectors)
+		Expect(mat.EqualApprox(gram, mat.NewDiagDense(2, []float64{1, 1}), 1e-12)).To(BeTrue())
+	})
+
+	it("fails on invalid ids", func() {
+		_, err := embedding.Forward(mat.NewDense(1, 1, []float64{5}), false)
+		Expect(err).To(MatchError("embedding: invalid id 5, expected an integer in [0, 5)"))
+
+		_, err = embedding.Forward(mat.NewDense(1, 1, []float64{1.5}), false)
+		Expect(err).To(MatchError("embedding: invalid id 1.5, expected an integer in [0, 5)"))
+	})
+
+	it("fails to backpropagate without a matching batch", func() {
+		_, err := embedding.Backward(mat.NewDense(6, 2, nil))
+		Expect(err).To(MatchError("embedding: no batch has been calculated"))
+
+		_, err = embedding.Forward(input, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = embedding.Backward(mat.NewDense(6, 1, nil))
+		Expect(err).To(MatchError("embedding: invalid gradient dimension: 6x1, expected 6x2"))
+
+		// the ids of the failed batch are neither kept nor mixed with the previous ones
+		_, err = embedding.Forward(mat.NewDense(3, 2, []float64{0, 1, 2, 3, 4, 5}), false)
+		Expect(err).To(MatchError("embedding: invalid id 5, expected an integer in [0, 5)"))
+
+		_, err = embedding.Backward(mat.NewDense(6, 2, nil))
+		Expect(err).To(MatchError("embedding: no batch has been calculated"))
+	})
+
+	it("requires a single dimension of ids", func() {
+		_, err := embedding.OutputShape(neuralnet.Shape{2, 3})
+		Expect(err).To(MatchError("embedding requires an {ids} input, got [2 3]"))
+	})
+
+	context("in a Sequential network", func() {
+		var rng *rand.Rand
+
+		it.Before(func() {
+			rng = rand.New(rand.NewSource(19))
+		})
+
+		it("leaves unused vectors and their optimizer state untouched", func() {
+			network, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{1},
+				Layers:     []neuralnet.Layer{embedding},
+				Optimizer:  optimizers.NewAdam(0.1),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			train := func(ids ...int) {
+				inputs := mat.NewDense(1, len(ids), nil)
+				inputs.SetRow(0, layers.IDs(ids...).RawVector().Data)
+
+				_, err := network.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := network.GenerateDeltaBatch(mat.NewDense(2, len(ids), nil))
+				Expect(err).NotTo(HaveOccurred())
+				gradient, err := network.GenerateGradientBatch(delta)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(gradient.LayerRows).To(HaveLen(1))
+				Expect(network.Update(gradient)).To(Succeed())
+			}
+
+			original := mat.DenseCopyOf(embedding.Vectors)
+
+			train(0, 1, 0)
+			afterFirst := mat.DenseCopyOf(embedding.Vectors)
+			Expect(mat.Equal(afterFirst.RowView(0), original.RowView(0))).To(BeFalse())
+			Expect(mat.Equal(afterFirst.RowView(2), original.RowView(2))).To(BeTrue())
+
+			// Adam momentum would keep moving ids 0 and 1 if they were updated
+			train(2)
+			Expect(mat.Equal(embedding.Vectors.RowView(0), afterFirst.RowView(0))).To(BeTrue())
+			Expect(mat.Equal(embedding.Vectors.RowView(1), afterFirst.RowView(1))).To(BeTrue())
+			Expect(mat.Equal(embedding.Vectors.RowView(2), afterFirst.RowView(2))).To(BeFalse())
+		})
+
+		it("sums the sparse gradients of replicas to the batch gradient", func() {
+			network, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers:     []neuralnet.Layer{embedding, layers.NewFlatten(), layers.NewDense(6, 2, nil, nil, rng)},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			solutions := mat.NewDense(2, 2, []float64{1, 0, -1, 0.5})
+
+			_, err = network.CalculateBatch(input)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := network.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := network.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			workspace := network.NewWorkspace()
+			var summed neuralnet.Gradient
+			for j := 0; j < 2; j++ {
+				gradient, err := network.ComputeGradient(workspace, mat.VecDenseCopyOf(input.ColView(j)), mat.VecDenseCopyOf(solutions.ColView(j)))
+				Expect(err).NotTo(HaveOccurred())
+				summed.Add(gradient)
+			}
+
+			Expect(summed.LayerRows[0]).To(Equal(expected.LayerRows[0]))
+			for idx, grads := range expected.Layers {
+				for paramIndex, grad := range grads {
+					Expect(mat.EqualApprox(summed.Layers[idx][paramIndex], grad, 1e-12)).To(BeTrue())
+				}
+			}
+		})
+
+		it("saves and loads the vectors", func() {
+			network, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers:     []neuralnet.Layer{embedding},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			buffer := bytes.NewBuffer(nil)
+			Expect(network.Save(buffer, neuralnet.JSONFormat)).To(Succeed())
+
+			loaded, err := neuralnet.LoadSequential(buffer)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(loaded.Layers[0].(*layers.Embedding).Vectors).To(Equal(embedding.Vectors))
+			Expect(loaded.CalculateBatch(input)).To(Equal(mat.NewDense(6, 2, []float64{
+				embedding.Vectors.At(4, 0), embedding.Vectors.At(1, 0),
+				embedding.Vectors.At(4, 1), embedding.Vectors.At(1, 1),
+				embedding.Vectors.At(0, 0), embedding.Vectors.At(4, 0),
+				embedding.Vectors.At(0, 1), embedding.Vectors.At(4, 1),
+				embedding.Vectors.At(4, 0), embedding.Vectors.At(3, 0),
+				embedding.Vectors.At(4, 1), embedding.Vectors.At(3, 1),
+			})))
+		})
+
+		it("feeds the dense layers of a Network", func() {
+			dense, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{Size: 2 * 4},
+					{Size: 6, Func: nodefuncs.Relu{}},
+					{Size: 3, Func: nodefuncs.Softmax{}},
+				},
+				Source: rand.NewSource(20),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			converted, err := layers.FromNetwork(&dense)
+			Expect(err).NotTo(HaveOccurred())
+
+			network, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{2},
+				Layers:     append([]neuralnet.Layer{layers.NewEmbedding(10, 4, nil, rng)}, converted...),
+				Loss:       losses.CrossEntropy{},
+				Optimizer:  optimizers.NewAdam(0.05),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// pairs of ids labelled by the sum of the ids modulo 3
+			inputs := mat.NewDense(2, 100, nil)
+			labels := mat.NewDense(3, 100, nil)
+			for j := 0; j < 100; j++ {
+				inputs.Set(0, j, float64(j/10))
+				inputs.Set(1, j, float64(j%10))
+				labels.Set((j/10+j%10)%3, j, 1)
+			}
+
+			for step := 0; step < 300; step++ {
+				_, err := network.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := network.GenerateDeltaBatch(labels)
+				Expect(err).NotTo(HaveOccurred())
+				gradient, err := network.GenerateGradientBatch(delta)
+				Expect(err).NotTo(HaveOccurred())
+				gradient.Scale(1.0 / 100)
+				Expect(network.Update(gradient)).To(Succeed())
+			}
+
+			output, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			correct := 0
+			for j := 0; j < 100; j++ {
+				best := 0
+				for i := 1; i < 3; i++ {
+					if output.At(i, j) > output.At(best, j) {
+						best = i
+					}
+				}
+
+				if labels.At(best, j) == 1 {
+					correct++
+				}
+			}
+			Expect(correct).To(BeNumerically(">=", 95))
+		})
+	})
+
+	context("as the InputLayer of a Network", func() {
+		var network neuralnet.Network
+
+		it.Before(func() {
+			var err error
+			network, err = neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{Size: 3 * 2},
+					{Size: 2, Func: nodefuncs.Sigmoid{}},
+				},
+				InputLayer: embedding,
+				InputShape: neuralnet.Shape{3},
+				Source:     rand.NewSource(21),
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("takes ids and backpropagates into the vectors", func() {
+			Expect(network.InputSize).To(Equal(3))
+
+			solutions := mat.NewDense(2, 2, []float64{1, 0, 0, 1})
+
+			_, err := network.CalculateBatch(input)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := network.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := network.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			// the Embedding, Dense and Activation layers
+			Expect(expected.Layers).To(HaveLen(3))
+			Expect(expected.LayerRows).To(Equal([][][]int{{{0, 1, 3, 4}}, nil, nil}))
+
+			// the batch gradient is the sum of the single sample gradients of workspaces
+			workspace := network.NewWorkspace()
+			var summed neuralnet.Gradient
+			for j := 0; j < 2; j++ {
+				gradient, err := network.ComputeGradient(workspace, mat.VecDenseCopyOf(input.ColView(j)), mat.VecDenseCopyOf(solutions.ColView(j)))
+				Expect(err).NotTo(HaveOccurred())
+				summed.Add(gradient)
+			}
+
+			Expect(summed.LayerRows).To(Equal(expected.LayerRows))
+			Expect(mat.EqualApprox(summed.Layers[0][0], expected.Layers[0][0], 1e-12)).To(BeTrue())
+			Expect(mat.EqualApprox(summed.Layers[1][0], expected.Layers[1][0], 1e-12)).To(BeTrue())
+
+			original := mat.DenseCopyOf(embedding.Vectors)
+			Expect(network.Update(expected)).To(Succeed())
+			Expect(mat.Equal(embedding.Vectors.RowView(0), original.RowView(0))).To(BeFalse())
+			Expect(mat.Equal(embedding.Vectors.RowView(2), original.RowView(2))).To(BeTrue())
+		})
+
+		it("saves and loads the vectors", func() {
+			expected, err := network.CalculateBatch(input)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, format := range []neuralnet.Format{neuralnet.JSONFormat, neuralnet.BinaryFormat} {
+				buffer := bytes.NewBuffer(nil)
+				Expect(network.Save(buffer, format)).To(Succeed())
+
+				loaded, err := neuralnet.Load(buffer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(loaded.InputShape).To(Equal(neuralnet.Shape{3}))
+				Expect(loaded.InputLayer.(*layers.Embedding).Vectors).To(Equal(embedding.Vectors))
+				Expect(loaded.CalculateBatch(input)).To(Equal(expected))
+			}
+		})
+
+		it("fails to load vectors too large to allocate", func() {
+			_, err := neuralnet.Load(strings.NewReader(`{
+				"version": 7,
+				"layers": [{"size": 4}],
+				"inputShape": [2],
+				"inputLayer": {"name": "embedding", "config": {"count": 4000000000, "size": 2}}
+			}`))
+			Expect(err).To(MatchError("invalid input layer: invalid matrix dimension: 4000000000x2, at most 67108864 values"))
+		})
+
+		it("predicts like Calculate", func() {
+			sample := layers.IDs(4, 0, 4)
+
+			expected, err := network.Calculate(sample)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(network.Predict(sample)).To(Equal(expected))
+		})
+	})
+}
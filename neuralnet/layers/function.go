@@ -0,0 +1,130 @@
+package layers
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/autodiff"
+	"gonum.org/v1/gonum/mat"
+)
+
+///
+/// Function Def
+///
+// layer computing any differentiable function written with autodiff.Tape operations,
+// Backward and Grads come from the tape instead of hand-written derivatives
+type Function struct {
+	// F gets the input batch and a Var for every Parameters entry, in order. Replicas call
+	// it concurrently, so it must not modify anything but the tape.
+	F          func(t *autodiff.Tape, input *autodiff.Var, params []*autodiff.Var) *autodiff.Var
+	Parameters []*mat.Dense
+	// output sample shape for an input shape, when nil the output has a single
+	// dimension found by evaluating F on a zero sample
+	Shape func(input neuralnet.Shape) (neuralnet.Shape, error)
+
+	tape   *autodiff.Tape
+	input  *autodiff.Var
+	params []*autodiff.Var
+	output *autodiff.Var
+}
+
+func NewFunction(f func(t *autodiff.Tape, input *autodiff.Var, params []*autodiff.Var) *autodiff.Var, params ...*mat.Dense) *Function {
+	return &Function{
+		F:          f,
+		Parameters: params,
+	}
+}
+
+// tape operations panic on mismatched dimensions, those panics are returned as errors
+func (f *Function) Forward(input *mat.Dense, _ bool) (result *mat.Dense, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.output = nil
+			result, err = nil, fmt.Errorf("function: %v", r)
+		}
+	}()
+
+	f.tape = autodiff.NewTape()
+	f.input = f.tape.Variable(input)
+
+	f.params = make([]*autodiff.Var, len(f.Parameters))
+	for idx, param := range f.Parameters {
+		f.params[idx] = f.tape.Variable(param)
+	}
+
+	f.output = f.F(f.tape, f.input, f.params)
+
+	return f.output.Value, nil
+}
+
+func (f *Function) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	if f.output == nil {
+		return nil, fmt.Errorf("function: no batch has been calculated")
+	}
+
+	rows, batchSize := f.output.Value.Dims()
+	if err := checkGrad("function", grad, rows, batchSize); err != nil {
+		return nil, err
+	}
+
+	if err := f.tape.Backward(f.output, grad); err != nil {
+		return nil, fmt.Errorf("function: %s", err)
+	}
+
+	return gradOf(f.input), nil
+}
+
+func (f *Function) Params() []*mat.Dense {
+	return f.Parameters
+}
+
+func (f *Function) Grads() []*mat.Dense {
+	var result []*mat.Dense
+	for _, param := range f.params {
+		result = append(result, gradOf(param))
+	}
+
+	return result
+}
+
+func (f *Function) OutputShape(input neuralnet.Shape) (neuralnet.Shape, error) {
+	if f.F == nil {
+		return nil, fmt.Errorf("missing function")
+	}
+
+	if f.Shape != nil {
+		return f.Shape(input)
+	}
+
+	output, err := f.Forward(mat.NewDense(input.Size(), 1, nil), false)
+	if err != nil {
+		return nil, fmt.Errorf("function cannot take input %v: %s", []int(input), err)
+	}
+
+	if _, c := output.Dims(); c != 1 {
+		return nil, fmt.Errorf("function must keep one column per sample, got %d", c)
+	}
+
+	r, _ := output.Dims()
+
+	return neuralnet.Shape{r}, nil
+}
+
+func (f *Function) Replica(_ *rand.Rand) neuralnet.Layer {
+	return &Function{
+		F:          f.F,
+		Parameters: f.Parameters,
+		Shape:      f.Shape,
+	}
+}
+
+// gradient of v, zeros when the output does not depend on it
+func gradOf(v *autodiff.Var) *mat.Dense {
+	if v.Grad != nil {
+		return v.Grad
+	}
+
+	r, c := v.Value.Dims()
+	return mat.NewDense(r, c, nil)
+}
@@ -0,0 +1,152 @@
+package layers_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/autodiff"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testFunction(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		dense    *layers.Dense
+		function *layers.Function
+		input    *mat.Dense
+	)
+
+	// sigmoid(W·x + b)
+	affine := func(tape *autodiff.Tape, input *autodiff.Var, params []*autodiff.Var) *autodiff.Var {
+		return tape.Sigmoid(tape.Add(tape.MatMul(params[0], input), params[1]))
+	}
+
+	it.Before(func() {
+		dense = layers.NewDense(3, 2, neuralnet.InitRandom, neuralnet.InitRandom, rand.New(rand.NewSource(21)))
+		function = layers.NewFunction(affine, mat.DenseCopyOf(dense.Weights), mat.DenseCopyOf(dense.Bias))
+
+		input = mat.NewDense(3, 2, []float64{
+			0.5, -1,
+			0.25, 2,
+			-0.75, 0,
+		})
+	})
+
+	it("matches Dense and Activation layers", func() {
+		shape, err := function.OutputShape(neuralnet.Shape{3})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(shape).To(Equal(neuralnet.Shape{2}))
+
+		activation := layers.NewActivation(nodefuncs.Sigmoid{})
+		hidden, err := dense.Forward(input, false)
+		Expect(err).NotTo(HaveOccurred())
+		expected, err := activation.Forward(hidden, false)
+		Expect(err).NotTo(HaveOccurred())
+		actual, err := function.Forward(input, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mat.EqualApprox(actual, expected, 1e-12)).To(BeTrue())
+
+		grad := mat.NewDense(2, 2, []float64{1, -2, 0.5, 3})
+		hiddenGrad, err := activation.Backward(grad)
+		Expect(err).NotTo(HaveOccurred())
+		expectedInputGrad, err := dense.Backward(hiddenGrad)
+		Expect(err).NotTo(HaveOccurred())
+		inputGrad, err := function.Backward(grad)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(mat.EqualApprox(inputGrad, expectedInputGrad, 1e-12)).To(BeTrue())
+		for idx, expectedGrad := range dense.Grads() {
+			Expect(mat.EqualApprox(function.Grads()[idx], expectedGrad, 1e-12)).To(BeTrue())
+		}
+	})
+
+	it("matches finite differences", func() {
+		report, err := gradcheck.CheckLayer(function, input, mat.NewDense(2, 2, []float64{1, -2, 0.5, 3}), 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(report.Params).To(HaveLen(2))
+		Expect(report.Max()).To(BeNumerically("<", 1e-6))
+	})
+
+	it("replicates with shared parameters and a tape of its own", func() {
+		replica := function.Replica(nil)
+		Expect(replica.Params()).To(Equal(function.Params()))
+
+		grad := mat.NewDense(2, 2, []float64{1, -2, 0.5, 3})
+		_, err := function.Forward(input, false)
+		Expect(err).NotTo(HaveOccurred())
+		expected, err := function.Backward(grad)
+		Expect(err).NotTo(HaveOccurred())
+		expectedGrads := function.Grads()
+
+		_, err = function.Forward(input, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		// a replica running another batch leaves the tape of the layer alone
+		_, err = replica.Forward(mat.NewDense(3, 1, nil), false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = replica.Backward(mat.NewDense(2, 1, []float64{1, 1}))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(function.Backward(grad)).To(Equal(expected))
+		Expect(function.Grads()).To(Equal(expectedGrads))
+	})
+
+	it("returns zero gradients for values the output does not use", func() {
+		unused := layers.NewFunction(func(tape *autodiff.Tape, input *autodiff.Var, params []*autodiff.Var) *autodiff.Var {
+			return tape.Tanh(params[0])
+		}, mat.NewDense(2, 1, []float64{1, 2}), mat.NewDense(1, 1, []float64{3}))
+
+		_, err := unused.Forward(input, false)
+		Expect(err).NotTo(HaveOccurred())
+		inputGrad, err := unused.Backward(mat.NewDense(2, 1, []float64{1, 1}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mat.Norm(inputGrad, 1)).To(BeZero())
+		Expect(mat.Norm(unused.Grads()[1], 1)).To(BeZero())
+	})
+
+	it("fails to backpropagate without a matching batch", func() {
+		_, err := function.Backward(mat.NewDense(2, 2, nil))
+		Expect(err).To(MatchError("function: no batch has been calculated"))
+
+		_, err = function.Forward(input, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = function.Backward(mat.NewDense(2, 3, nil))
+		Expect(err).To(MatchError("function: invalid gradient dimension: 2x3, expected 2x2"))
+	})
+
+	it("returns dimension mismatches as errors", func() {
+		_, err := function.Forward(mat.NewDense(4, 2, nil), false)
+		Expect(err).To(MatchError(HavePrefix("function: ")))
+
+		_, err = function.Backward(mat.NewDense(2, 2, nil))
+		Expect(err).To(MatchError("function: no batch has been calculated"))
+	})
+
+	it("uses Shape when set", func() {
+		function.Shape = func(neuralnet.Shape) (neuralnet.Shape, error) {
+			return neuralnet.Shape{1, 2}, nil
+		}
+
+		shape, err := function.OutputShape(neuralnet.Shape{3})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(shape).To(Equal(neuralnet.Shape{1, 2}))
+	})
+
+	it("fails when the function cannot take the input", func() {
+		_, err := function.OutputShape(neuralnet.Shape{4})
+		Expect(err).To(MatchError(HavePrefix("function cannot take input [4]: ")))
+
+		_, err = layers.NewFunction(nil).OutputShape(neuralnet.Shape{4})
+		Expect(err).To(MatchError("missing function"))
+	})
+}
@@ -0,0 +1,19 @@
+package layers_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitLayers(t *testing.T) {
+	suite := spec.New("Layers", spec.Report(report.Terminal{}))
+	suite("Layers", testLayers)
+	suite("BatchNorm", testBatchNorm)
+	suite("Conv", testConv)
+	suite("Recurrent", testRecurrent)
+	suite("Embedding", testEmbedding)
+	suite("Function", testFunction)
+	suite.Run(t)
+}
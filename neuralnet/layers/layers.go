@@ -0,0 +1,152 @@
+package layers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Layers for neuralnet.Sequential, every column of a matrix is one sample. Every layer
+// but Function is registered with neuralnet.RegisterLayer, so networks of them can be saved.
+// Dense, Activation, Dropout and BatchNorm are the layers neuralnet.NewNetwork builds, they
+// are registered by the neuralnet package.
+
+func init() {
+	neuralnet.RegisterLayer("reshape", &Reshape{}, loadReshape)
+}
+
+///
+/// Dense Def
+///
+// fully connected layer, see neuralnet.Dense
+type Dense = neuralnet.Dense
+
+// nil initializers default to initializers.XavierUniform for weights and zeros for bias,
+// see neuralnet.Initializer
+func NewDense(inSize, outSize int, weightInit, biasInit neuralnet.Initializer, rng *rand.Rand) *Dense {
+	return neuralnet.NewDense(inSize, outSize, weightInit, biasInit, rng)
+}
+
+///
+/// Activation Def
+///
+// applies Func to every sample, see neuralnet.Activation
+type Activation = neuralnet.Activation
+
+func NewActivation(f neuralnet.NodeFunc) *Activation {
+	return neuralnet.NewActivation(f)
+}
+
+///
+/// Dropout Def
+///
+// inverted dropout, see neuralnet.Dropout
+type Dropout = neuralnet.Dropout
+
+func NewDropout(rate float64, rng *rand.Rand) *Dropout {
+	return neuralnet.NewDropout(rate, rng)
+}
+
+///
+/// Reshape Def
+///
+// reinterprets every sample as Shape, samples are stored flattened so values are unchanged
+type Reshape struct {
+	Shape neuralnet.Shape
+}
+
+func NewReshape(shape ...int) *Reshape {
+	return &Reshape{Shape: shape}
+}
+
+func (r *Reshape) Forward(input *mat.Dense, _ bool) (*mat.Dense, error) {
+	return mat.DenseCopyOf(input), nil
+}
+
+func (r *Reshape) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	return mat.DenseCopyOf(grad), nil
+}
+
+func (r *Reshape) Params() []*mat.Dense {
+	return nil
+}
+
+func (r *Reshape) Grads() []*mat.Dense {
+	return nil
+}
+
+func (r *Reshape) OutputShape(input neuralnet.Shape) (neuralnet.Shape, error) {
+	if input.Size() != r.Shape.Size() {
+		return nil, fmt.Errorf("cannot reshape %v to %v", []int(input), []int(r.Shape))
+	}
+
+	return r.Shape, nil
+}
+
+func (r *Reshape) Replica(_ *rand.Rand) neuralnet.Layer {
+	return NewReshape(r.Shape...)
+}
+
+type reshapeConfig struct {
+	Shape []int `json:"shape"`
+}
+
+func (r *Reshape) LayerConfig() interface{} {
+	return reshapeConfig{Shape: r.Shape}
+}
+
+func loadReshape(data []byte, _ *rand.Rand) (neuralnet.Layer, error) {
+	var config reshapeConfig
+	if err := decodeConfig("reshape", data, &config); err != nil {
+		return nil, err
+	}
+
+	return NewReshape(config.Shape...), nil
+}
+
+// grad must be rows x batchSize, the output size and sample count of the last Forward
+// call, a batchSize of 0 means Forward has not been called
+func checkGrad(name string, grad *mat.Dense, rows, batchSize int) error {
+	if batchSize == 0 {
+		return fmt.Errorf("%s: no batch has been calculated", name)
+	}
+
+	r, c := grad.Dims()
+	if r != rows || c != batchSize {
+		return fmt.Errorf("%s: invalid gradient dimension: %dx%d, expected %dx%d", name, r, c, rows, batchSize)
+	}
+
+	return nil
+}
+
+// decodes the LayerConfig of a saved layer into config
+func decodeConfig(name string, data []byte, config interface{}) error {
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("error decoding %s config: %s", name, err)
+	}
+
+	return nil
+}
+
+// sample count of a cached batch, 0 when there is none
+func columns(m *mat.Dense) int {
+	if m == nil {
+		return 0
+	}
+
+	_, c := m.Dims()
+	return c
+}
+
+///
+/// Conversion
+///
+// the layers of network, starting with its InputLayer if it has one, so a Sequential of
+// them computes the same function. The layers are the network's own, so updating either
+// updates both.
+func FromNetwork(network *neuralnet.Network) ([]neuralnet.Layer, error) {
+	return append([]neuralnet.Layer(nil), network.Layers...), nil
+}
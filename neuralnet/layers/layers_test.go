@@ -0,0 +1,310 @@
+package layers_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testLayers(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		input *mat.Dense
+	)
+
+	it.Before(func() {
+		input = mat.NewDense(3, 2, []float64{
+			0.5, -1,
+			0.25, 2,
+			-0.75, 0,
+		})
+	})
+
+	context("Dense", func() {
+		var dense *layers.Dense
+
+		it.Before(func() {
+			dense = layers.NewDense(3, 2, neuralnet.InitRandom, neuralnet.InitRandom, rand.New(rand.NewSource(1)))
+		})
+
+		it("computes Weights·x + Bias for every sample", func() {
+			output, err := dense.Forward(input, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			for j := 0; j < 2; j++ {
+				expected := mat.NewVecDense(2, nil)
+				expected.MulVec(dense.Weights, input.ColView(j))
+				expected.AddVec(expected, dense.Bias.ColView(0))
+
+				Expect(mat.EqualApprox(output.ColView(j), expected, 1e-12)).To(BeTrue())
+			}
+		})
+
+		it("matches finite differences", func() {
+			report, err := gradcheck.CheckLayer(dense, input, mat.NewDense(2, 2, []float64{1, -2, 0.5, 3}), 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Params).To(HaveLen(2))
+			Expect(report.Max()).To(BeNumerically("<", 1e-6))
+		})
+
+		it("fails to backpropagate without a matching batch", func() {
+			_, err := dense.Backward(mat.NewDense(2, 2, nil))
+			Expect(err).To(MatchError("dense: no batch has been calculated"))
+
+			_, err = dense.Forward(input, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = dense.Backward(mat.NewDense(2, 3, nil))
+			Expect(err).To(MatchError("dense: invalid gradient dimension: 2x3, expected 2x2"))
+		})
+
+		it("replicates with shared parameters and a cache of its own", func() {
+			replica := dense.Replica(nil)
+			Expect(replica.Params()).To(Equal(dense.Params()))
+
+			_, err := dense.Forward(input, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = replica.Forward(mat.NewDense(3, 1, nil), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = dense.Backward(mat.NewDense(2, 2, nil))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("checks the input size", func() {
+			shape, err := dense.OutputShape(neuralnet.Shape{3})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape).To(Equal(neuralnet.Shape{2}))
+
+			_, err = dense.OutputShape(neuralnet.Shape{2, 2})
+			Expect(err).To(MatchError("invalid input size: 4, expected 3"))
+		})
+	})
+
+	context("Activation", func() {
+		for _, tc := range []struct {
+			name string
+			f    neuralnet.NodeFunc
+		}{
+			{"scalar functions", nodefuncs.Sigmoid{}},
+			{"vector functions", nodefuncs.Softmax{}},
+		} {
+			tc := tc
+
+			it("matches finite differences for "+tc.name, func() {
+				activation := layers.NewActivation(tc.f)
+
+				report, err := gradcheck.CheckLayer(activation, input, mat.NewDense(3, 2, []float64{1, -2, 0.5, 3, -1, 0.25}), 0)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(report.Params).To(BeEmpty())
+				Expect(report.Max()).To(BeNumerically("<", 1e-6))
+			})
+		}
+
+		it("fails to backpropagate without a matching batch", func() {
+			activation := layers.NewActivation(nodefuncs.Sigmoid{})
+			_, err := activation.Backward(mat.NewDense(3, 2, nil))
+			Expect(err).To(MatchError("activation: no batch has been calculated"))
+
+			_, err = activation.Forward(input, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = activation.Backward(mat.NewDense(3, 1, nil))
+			Expect(err).To(MatchError("activation: invalid gradient dimension: 3x1, expected 3x2"))
+		})
+
+		it("requires a function", func() {
+			_, err := layers.NewActivation(nil).OutputShape(neuralnet.Shape{3})
+			Expect(err).To(MatchError("missing activation function"))
+		})
+	})
+
+	context("Dropout", func() {
+		var dropout *layers.Dropout
+
+		it.Before(func() {
+			dropout = layers.NewDropout(0.5, rand.New(rand.NewSource(2)))
+		})
+
+		it("passes values through in inference mode", func() {
+			Expect(dropout.Forward(input, false)).To(Equal(input))
+			Expect(dropout.Backward(input)).To(Equal(input))
+		})
+
+		it("zeroes and rescales values in training mode", func() {
+			output, err := dropout.Forward(input, true)
+			Expect(err).NotTo(HaveOccurred())
+			grad, err := dropout.Backward(mat.NewDense(3, 2, []float64{1, 1, 1, 1, 1, 1}))
+			Expect(err).NotTo(HaveOccurred())
+
+			zeroed := 0
+			for i := 0; i < 3; i++ {
+				for j := 0; j < 2; j++ {
+					switch grad.At(i, j) {
+					case 0:
+						zeroed++
+						Expect(output.At(i, j)).To(Equal(float64(0)))
+					case 2:
+						Expect(output.At(i, j)).To(Equal(2 * input.At(i, j)))
+					default:
+						t.Fatalf("unexpected mask value: %v", grad.At(i, j))
+					}
+				}
+			}
+
+			Expect(zeroed).To(BeNumerically(">", 0))
+			Expect(zeroed).To(BeNumerically("<", 6))
+		})
+
+		it("fails to backpropagate a gradient that does not match the mask", func() {
+			_, err := dropout.Forward(input, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = dropout.Backward(mat.NewDense(3, 1, nil))
+			Expect(err).To(MatchError("dropout: invalid gradient dimension: 3x1, expected 3x2"))
+		})
+
+		it("draws the masks of a replica from its own source", func() {
+			replica := dropout.Replica(rand.New(rand.NewSource(2)))
+			expected, err := dropout.Forward(input, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(replica.Forward(input, true)).To(Equal(expected))
+		})
+
+		it("validates the rate", func() {
+			_, err := layers.NewDropout(1, nil).OutputShape(neuralnet.Shape{3})
+			Expect(err).To(MatchError("invalid dropout rate: 1"))
+
+			_, err = layers.NewDropout(0.5, nil).OutputShape(neuralnet.Shape{3})
+			Expect(err).To(MatchError("dropout requires a random source"))
+		})
+	})
+
+	context("Reshape", func() {
+		it("keeps values and changes the shape", func() {
+			reshape := layers.NewReshape(1, 3)
+
+			shape, err := reshape.OutputShape(neuralnet.Shape{3})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(shape).To(Equal(neuralnet.Shape{1, 3}))
+			Expect(reshape.Forward(input, false)).To(Equal(input))
+			Expect(reshape.Backward(input)).To(Equal(input))
+
+			_, err = reshape.OutputShape(neuralnet.Shape{2, 2})
+			Expect(err).To(MatchError("cannot reshape [2 2] to [1 3]"))
+		})
+	})
+
+	context("FromNetwork", func() {
+		var network neuralnet.Network
+
+		it.Before(func() {
+			var err error
+			network, err = neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{Size: 3},
+					{Size: 4, Func: nodefuncs.Relu{}, Dropout: 0.25},
+					{Size: 2, Func: nodefuncs.Softmax{}},
+				},
+				Source: rand.NewSource(3),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			network.Bias[1].SetVec(0, 0.5)
+		})
+
+		it("computes the same function with shared parameters", func() {
+			converted, err := layers.FromNetwork(&network)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(converted).To(HaveLen(5))
+
+			sequential, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers:     converted,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			network.Bias[2].SetVec(1, -0.25)
+
+			for j := 0; j < 2; j++ {
+				sample := mat.VecDenseCopyOf(input.ColView(j))
+
+				expected, err := network.Calculate(sample)
+				Expect(err).NotTo(HaveOccurred())
+				actual, err := sequential.Calculate(sample)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mat.EqualApprox(actual, expected, 1e-12)).To(BeTrue())
+			}
+		})
+
+		it("converts batch normalization", func() {
+			normalized, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{Size: 3},
+					{Size: 4, Func: nodefuncs.Relu{}, BatchNorm: true},
+					{Size: 2, Func: nodefuncs.Sigmoid{}},
+				},
+				Source: rand.NewSource(3),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			normalized.Norms[1].Gamma.SetVec(0, 1.5)
+			normalized.SetTraining(true)
+
+			converted, err := layers.FromNetwork(&normalized)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(converted).To(HaveLen(5))
+
+			sequential, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers:     converted,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			sequential.SetTraining(true)
+
+			solutions := mat.NewDense(2, 2, []float64{1, 0, 0, 1})
+
+			expected, err := normalized.CalculateBatch(input)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := normalized.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			expectedGradient, err := normalized.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			actual, err := sequential.CalculateBatch(input)
+			Expect(err).NotTo(HaveOccurred())
+			deltaBatch, err := sequential.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			actualGradient, err := sequential.GenerateGradientBatch(deltaBatch)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(mat.EqualApprox(actual, expected, 1e-12)).To(BeTrue())
+			Expect(actualGradient.Layers).To(HaveLen(len(expectedGradient.Layers)))
+			for idx, grads := range expectedGradient.Layers {
+				for paramIndex, grad := range grads {
+					Expect(mat.EqualApprox(actualGradient.Layers[idx][paramIndex], grad, 1e-12)).To(BeTrue())
+				}
+			}
+		})
+
+		it("keeps sparse weights", func() {
+			Expect(network.Sparsify(1, 0)).To(Succeed())
+
+			converted, err := layers.FromNetwork(&network)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(converted[network.LayerIndex(2)].(*layers.Dense).SparseWeights).To(BeIdenticalTo(network.SparseWeights[1]))
+		})
+	})
+}
@@ -0,0 +1,542 @@
+package layers
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Recurrent layers take samples of shape {steps, features} flattened in row-major order,
+// so rows step*features to (step+1)*features of a column hold one step of the sequence.
+// They learn the number of steps from OutputShape, which must be called before Forward.
+
+func init() {
+	neuralnet.RegisterLayer("recurrent", &Recurrent{}, loadRecurrent)
+}
+
+///
+/// Recurrent Def
+///
+type RecurrentConfig struct {
+	InputSize  int
+	HiddenSize int
+	// output the hidden state of every step, shape {steps, HiddenSize}, instead of
+	// only the last one, shape {HiddenSize}
+	ReturnSequences bool
+	// backpropagate through at most Truncate steps: sequences are split into chunks of
+	// Truncate steps and state gradients do not flow between chunks, 0 for the whole sequence
+	Truncate int
+	// default to initializers.XavierUniform for input weights, initializers.NewOrthogonal()
+	// for recurrent weights and zeros for bias
+	WeightInit    neuralnet.Initializer
+	RecurrentInit neuralnet.Initializer
+	BiasInit      neuralnet.Initializer
+}
+
+// recurrent layer, at every step each gate's pre-activation is
+// InputWeights·x + RecurrentWeights·h + Bias, with gates stacked along the rows
+type Recurrent struct {
+	HiddenSize       int
+	ReturnSequences  bool
+	Truncate         int
+	InputWeights     *mat.Dense
+	RecurrentWeights *mat.Dense
+	// column vector with one row per gate and hidden unit
+	Bias *mat.Dense
+
+	cell  cell
+	steps int
+	// per step inputs, states before the step and cell values of the most recent Forward call
+	inputs []mat.Matrix
+	states [][]*mat.Dense
+	caches [][]*mat.Dense
+
+	inputWeightsGrad     *mat.Dense
+	recurrentWeightsGrad *mat.Dense
+	biasGrad             *mat.Dense
+}
+
+// Elman network: h = tanh(W·x + U·h + b)
+func NewSimpleRNN(config RecurrentConfig, rng *rand.Rand) (*Recurrent, error) {
+	return newRecurrent(simpleCell{}, config, rng)
+}
+
+// long short-term memory with input, forget, cell and output gates in that order,
+// the forget gate bias defaults to 1
+func NewLSTM(config RecurrentConfig, rng *rand.Rand) (*Recurrent, error) {
+	return newRecurrent(lstmCell{}, config, rng)
+}
+
+// gated recurrent unit with update, reset and candidate gates in that order, the reset
+// gate is applied after the recurrent weights: n = tanh(W·x + r*(U·h) + b)
+func NewGRU(config RecurrentConfig, rng *rand.Rand) (*Recurrent, error) {
+	return newRecurrent(gruCell{}, config, rng)
+}
+
+func newRecurrent(c cell, config RecurrentConfig, rng *rand.Rand) (*Recurrent, error) {
+	switch {
+	case config.InputSize <= 0 || config.HiddenSize <= 0:
+		return nil, fmt.Errorf("invalid %s size: %d input, %d hidden", c.name(), config.InputSize, config.HiddenSize)
+	case config.Truncate < 0:
+		return nil, fmt.Errorf("invalid truncation: %d", config.Truncate)
+	}
+
+	weightInit := config.WeightInit
+	if weightInit == nil {
+		weightInit = initializers.XavierUniform{}
+	}
+
+	recurrentInit := config.RecurrentInit
+	if recurrentInit == nil {
+		recurrentInit = initializers.NewOrthogonal()
+	}
+
+	biasInit := config.BiasInit
+	if biasInit == nil {
+		biasInit = initializers.Constant{}
+	}
+
+	hidden := config.HiddenSize
+	rows := c.gates() * hidden
+
+	// every gate is initialized on its own, as if it were a separate layer
+	inputWeights := make([]float64, rows*config.InputSize)
+	recurrentWeights := make([]float64, rows*hidden)
+	bias := make([]float64, rows)
+	for gate := 0; gate < c.gates(); gate++ {
+		weightInit.Initialize(inputWeights[gate*hidden*config.InputSize:(gate+1)*hidden*config.InputSize], config.InputSize, hidden, 0, rng)
+		recurrentInit.Initialize(recurrentWeights[gate*hidden*hidden:(gate+1)*hidden*hidden], hidden, hidden, 0, rng)
+		biasInit.Initialize(bias[gate*hidden:(gate+1)*hidden], config.InputSize, hidden, 0, rng)
+	}
+
+	if config.BiasInit == nil {
+		c.defaultBias(bias, hidden)
+	}
+
+	return &Recurrent{
+		HiddenSize:       hidden,
+		ReturnSequences:  config.ReturnSequences,
+		Truncate:         config.Truncate,
+		InputWeights:     mat.NewDense(rows, config.InputSize, inputWeights),
+		RecurrentWeights: mat.NewDense(rows, hidden, recurrentWeights),
+		Bias:             mat.NewDense(rows, 1, bias),
+		cell:             c,
+	}, nil
+}
+
+func (r *Recurrent) inputSize() int {
+	_, c := r.InputWeights.Dims()
+	return c
+}
+
+func (r *Recurrent) Forward(input *mat.Dense, _ bool) (*mat.Dense, error) {
+	features := r.inputSize()
+	if rows, _ := input.Dims(); r.steps == 0 || rows != r.steps*features {
+		return nil, fmt.Errorf("%s: input of %d rows does not match the shape set by OutputShape", r.cell.name(), rows)
+	}
+
+	_, batchSize := input.Dims()
+	r.inputs = make([]mat.Matrix, r.steps)
+	r.states = make([][]*mat.Dense, r.steps)
+	r.caches = make([][]*mat.Dense, r.steps)
+
+	result := mat.NewDense(r.HiddenSize, batchSize, nil)
+	if r.ReturnSequences {
+		result = mat.NewDense(r.steps*r.HiddenSize, batchSize, nil)
+	}
+
+	state := make([]*mat.Dense, r.cell.states())
+	for idx := range state {
+		state[idx] = mat.NewDense(r.HiddenSize, batchSize, nil)
+	}
+
+	for step := 0; step < r.steps; step++ {
+		r.inputs[step] = input.Slice(step*features, (step+1)*features, 0, batchSize)
+		r.states[step] = state
+
+		pre := &mat.Dense{}
+		pre.Mul(r.InputWeights, r.inputs[step])
+		pre.Apply(func(i, _ int, v float64) float64 {
+			return v + r.Bias.At(i, 0)
+		}, pre)
+
+		recurrent := &mat.Dense{}
+		recurrent.Mul(r.RecurrentWeights, state[0])
+
+		state, r.caches[step] = r.cell.forward(pre, recurrent, state)
+
+		if r.ReturnSequences {
+			result.Slice(step*r.HiddenSize, (step+1)*r.HiddenSize, 0, batchSize).(*mat.Dense).Copy(state[0])
+		}
+	}
+
+	if !r.ReturnSequences {
+		result.Copy(state[0])
+	}
+
+	return result, nil
+}
+
+func (r *Recurrent) Backward(grad *mat.Dense) (*mat.Dense, error) {
+	batchSize := 0
+	if len(r.inputs) != 0 {
+		_, batchSize = r.inputs[0].Dims()
+	}
+
+	rows := r.HiddenSize
+	if r.ReturnSequences {
+		rows *= r.steps
+	}
+
+	if err := checkGrad(r.cell.name(), grad, rows, batchSize); err != nil {
+		return nil, err
+	}
+
+	features := r.inputSize()
+	result := mat.NewDense(r.steps*features, batchSize, nil)
+
+	r.inputWeightsGrad = mat.NewDense(r.cell.gates()*r.HiddenSize, features, nil)
+	r.recurrentWeightsGrad = mat.NewDense(r.cell.gates()*r.HiddenSize, r.HiddenSize, nil)
+	r.biasGrad = mat.NewDense(r.cell.gates()*r.HiddenSize, 1, nil)
+
+	// gradient with respect to the state after the current step
+	stateGrad := make([]*mat.Dense, r.cell.states())
+	for idx := range stateGrad {
+		stateGrad[idx] = mat.NewDense(r.HiddenSize, batchSize, nil)
+	}
+
+	if !r.ReturnSequences {
+		stateGrad[0].Copy(grad)
+	}
+
+	for step := r.steps - 1; step >= 0; step-- {
+		if r.ReturnSequences {
+			stateGrad[0].Add(stateGrad[0], grad.Slice(step*r.HiddenSize, (step+1)*r.HiddenSize, 0, batchSize))
+		}
+
+		preGrad, recurrentGrad, prevGrad := r.cell.backward(r.caches[step], stateGrad)
+
+		inputWeightsGrad := &mat.Dense{}
+		inputWeightsGrad.Mul(preGrad, r.inputs[step].T())
+		r.inputWeightsGrad.Add(r.inputWeightsGrad, inputWeightsGrad)
+
+		recurrentWeightsGrad := &mat.Dense{}
+		recurrentWeightsGrad.Mul(recurrentGrad, r.states[step][0].T())
+		r.recurrentWeightsGrad.Add(r.recurrentWeightsGrad, recurrentWeightsGrad)
+
+		for i := 0; i < r.cell.gates()*r.HiddenSize; i++ {
+			r.biasGrad.Set(i, 0, r.biasGrad.At(i, 0)+mat.Sum(preGrad.RowView(i)))
+		}
+
+		result.Slice(step*features, (step+1)*features, 0, batchSize).(*mat.Dense).Mul(r.InputWeights.T(), preGrad)
+
+		hiddenGrad := &mat.Dense{}
+		hiddenGrad.Mul(r.RecurrentWeights.T(), recurrentGrad)
+		prevGrad[0].Add(prevGrad[0], hiddenGrad)
+		stateGrad = prevGrad
+
+		if r.Truncate > 0 && step%r.Truncate == 0 {
+			for _, g := range stateGrad {
+				g.Zero()
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (r *Recurrent) Params() []*mat.Dense {
+	return []*mat.Dense{r.InputWeights, r.RecurrentWeights, r.Bias}
+}
+
+func (r *Recurrent) Decayed() []bool {
+	return []bool{true, true, false}
+}
+
+func (r *Recurrent) Grads() []*mat.Dense {
+	return []*mat.Dense{r.inputWeightsGrad, r.recurrentWeightsGrad, r.biasGrad}
+}
+
+func (r *Recurrent) OutputShape(input neuralnet.Shape) (neuralnet.Shape, error) {
+	if len(input) != 2 {
+		return nil, fmt.Errorf("%s requires a {steps, features} input, got %v", r.cell.name(), []int(input))
+	}
+
+	if input[1] != r.inputSize() {
+		return nil, fmt.Errorf("invalid input size: %d, expected %d", input[1], r.inputSize())
+	}
+
+	r.steps = input[0]
+
+	if r.ReturnSequences {
+		return neuralnet.Shape{r.steps, r.HiddenSize}, nil
+	}
+
+	return neuralnet.Shape{r.HiddenSize}, nil
+}
+
+func (r *Recurrent) Replica(_ *rand.Rand) neuralnet.Layer {
+	return &Recurrent{
+		HiddenSize:       r.HiddenSize,
+		ReturnSequences:  r.ReturnSequences,
+		Truncate:         r.Truncate,
+		InputWeights:     r.InputWeights,
+		RecurrentWeights: r.RecurrentWeights,
+		Bias:             r.Bias,
+		cell:             r.cell,
+		steps:            r.steps,
+	}
+}
+
+// the cell is saved by name, initializers are not saved
+type recurrentConfig struct {
+	Cell            string `json:"cell"`
+	InputSize       int    `json:"inputSize"`
+	HiddenSize      int    `json:"hiddenSize"`
+	ReturnSequences bool   `json:"returnSequences,omitempty"`
+	Truncate        int    `json:"truncate,omitempty"`
+}
+
+func (r *Recurrent) LayerConfig() interface{} {
+	_, inputSize := r.InputWeights.Dims()
+	return recurrentConfig{
+		Cell:            r.cell.name(),
+		InputSize:       inputSize,
+		HiddenSize:      r.HiddenSize,
+		ReturnSequences: r.ReturnSequences,
+		Truncate:        r.Truncate,
+	}
+}
+
+func loadRecurrent(data []byte, rng *rand.Rand) (neuralnet.Layer, error) {
+	var config recurrentConfig
+	if err := decodeConfig("recurrent", data, &config); err != nil {
+		return nil, err
+	}
+
+	for _, c := range []cell{simpleCell{}, lstmCell{}, gruCell{}} {
+		if c.name() == config.Cell {
+			// invalid sizes are left to newRecurrent, the hidden size is checked first so
+			// the weight rows do not overflow
+			if config.InputSize > 0 && config.HiddenSize > 0 {
+				if err := neuralnet.CheckLoadSize(c.gates(), config.HiddenSize); err != nil {
+					return nil, err
+				}
+
+				rows := c.gates() * config.HiddenSize
+				if err := neuralnet.CheckLoadSize(rows, config.InputSize); err != nil {
+					return nil, err
+				}
+
+				if err := neuralnet.CheckLoadSize(rows, config.HiddenSize); err != nil {
+					return nil, err
+				}
+			}
+
+			return newRecurrent(c, RecurrentConfig{
+				InputSize:       config.InputSize,
+				HiddenSize:      config.HiddenSize,
+				ReturnSequences: config.ReturnSequences,
+				Truncate:        config.Truncate,
+				WeightInit:      initializers.Constant{},
+				RecurrentInit:   initializers.Constant{},
+				BiasInit:        initializers.Constant{},
+			}, rng)
+		}
+	}
+
+	return nil, fmt.Errorf("unknown recurrent cell: %s", config.Cell)
+}
+
+///
+/// Cell Defs
+///
+// a single step of a recurrent layer, states[0] is the hidden state
+type cell interface {
+	name() string
+	gates() int
+	states() int
+	// replaces the initialized bias of every gate when no BiasInit is configured
+	defaultBias(bias []float64, hidden int)
+	// next states from the input and recurrent pre-activations, with the values backward needs
+	forward(pre, recurrent *mat.Dense, prev []*mat.Dense) (next, cache []*mat.Dense)
+	// gradients with respect to the input pre-activation, the recurrent pre-activation and
+	// the previous states, not counting the path through the recurrent weights
+	backward(cache, grad []*mat.Dense) (preGrad, recurrentGrad *mat.Dense, prevGrad []*mat.Dense)
+}
+
+type simpleCell struct{}
+
+func (simpleCell) name() string                   { return "simple rnn" }
+func (simpleCell) gates() int                     { return 1 }
+func (simpleCell) states() int                    { return 1 }
+func (simpleCell) defaultBias(_ []float64, _ int) {}
+
+func (simpleCell) forward(pre, recurrent *mat.Dense, _ []*mat.Dense) ([]*mat.Dense, []*mat.Dense) {
+	h := &mat.Dense{}
+	h.Add(pre, recurrent)
+	h.Apply(func(_, _ int, v float64) float64 {
+		return math.Tanh(v)
+	}, h)
+
+	return []*mat.Dense{h}, []*mat.Dense{h}
+}
+
+func (simpleCell) backward(cache, grad []*mat.Dense) (*mat.Dense, *mat.Dense, []*mat.Dense) {
+	h := cache[0]
+
+	result := &mat.Dense{}
+	result.Apply(func(i, j int, v float64) float64 {
+		return v * (1 - h.At(i, j)*h.At(i, j))
+	}, grad[0])
+
+	r, c := h.Dims()
+	return result, result, []*mat.Dense{mat.NewDense(r, c, nil)}
+}
+
+type lstmCell struct{}
+
+func (lstmCell) name() string { return "lstm" }
+func (lstmCell) gates() int   { return 4 }
+func (lstmCell) states() int  { return 2 }
+
+func (lstmCell) defaultBias(bias []float64, hidden int) {
+	for idx := hidden; idx < 2*hidden; idx++ {
+		bias[idx] = 1
+	}
+}
+
+func (lstmCell) forward(pre, recurrent *mat.Dense, prev []*mat.Dense) ([]*mat.Dense, []*mat.Dense) {
+	hidden, batchSize := prev[0].Dims()
+
+	total := &mat.Dense{}
+	total.Add(pre, recurrent)
+
+	in := gate(total, 0, hidden, sigmoid)
+	forget := gate(total, 1, hidden, sigmoid)
+	candidate := gate(total, 2, hidden, math.Tanh)
+	out := gate(total, 3, hidden, sigmoid)
+
+	c := mat.NewDense(hidden, batchSize, nil)
+	tanhC := mat.NewDense(hidden, batchSize, nil)
+	h := mat.NewDense(hidden, batchSize, nil)
+	for i := 0; i < hidden; i++ {
+		for j := 0; j < batchSize; j++ {
+			cell := forget.At(i, j)*prev[1].At(i, j) + in.At(i, j)*candidate.At(i, j)
+			c.Set(i, j, cell)
+			tanhC.Set(i, j, math.Tanh(cell))
+			h.Set(i, j, out.At(i, j)*tanhC.At(i, j))
+		}
+	}
+
+	return []*mat.Dense{h, c}, []*mat.Dense{in, forget, candidate, out, tanhC, prev[1]}
+}
+
+func (lstmCell) backward(cache, grad []*mat.Dense) (*mat.Dense, *mat.Dense, []*mat.Dense) {
+	in, forget, candidate, out, tanhC, prevC := cache[0], cache[1], cache[2], cache[3], cache[4], cache[5]
+	hidden, batchSize := in.Dims()
+
+	result := mat.NewDense(4*hidden, batchSize, nil)
+	prevCGrad := mat.NewDense(hidden, batchSize, nil)
+	for i := 0; i < hidden; i++ {
+		for j := 0; j < batchSize; j++ {
+			hGrad := grad[0].At(i, j)
+			t := tanhC.At(i, j)
+			cGrad := grad[1].At(i, j) + hGrad*out.At(i, j)*(1-t*t)
+
+			iv, fv, gv, ov := in.At(i, j), forget.At(i, j), candidate.At(i, j), out.At(i, j)
+			result.Set(i, j, cGrad*gv*iv*(1-iv))
+			result.Set(hidden+i, j, cGrad*prevC.At(i, j)*fv*(1-fv))
+			result.Set(2*hidden+i, j, cGrad*iv*(1-gv*gv))
+			result.Set(3*hidden+i, j, hGrad*t*ov*(1-ov))
+
+			prevCGrad.Set(i, j, cGrad*fv)
+		}
+	}
+
+	return result, result, []*mat.Dense{mat.NewDense(hidden, batchSize, nil), prevCGrad}
+}
+
+type gruCell struct{}
+
+func (gruCell) name() string                   { return "gru" }
+func (gruCell) gates() int                     { return 3 }
+func (gruCell) states() int                    { return 1 }
+func (gruCell) defaultBias(_ []float64, _ int) {}
+
+func (gruCell) forward(pre, recurrent *mat.Dense, prev []*mat.Dense) ([]*mat.Dense, []*mat.Dense) {
+	hidden, batchSize := prev[0].Dims()
+
+	total := &mat.Dense{}
+	total.Add(pre, recurrent)
+
+	update := gate(total, 0, hidden, sigmoid)
+	reset := gate(total, 1, hidden, sigmoid)
+	// recurrent part of the candidate, before the reset gate
+	recurrentCandidate := mat.DenseCopyOf(recurrent.Slice(2*hidden, 3*hidden, 0, batchSize))
+
+	candidate := mat.NewDense(hidden, batchSize, nil)
+	h := mat.NewDense(hidden, batchSize, nil)
+	for i := 0; i < hidden; i++ {
+		for j := 0; j < batchSize; j++ {
+			n := math.Tanh(pre.At(2*hidden+i, j) + reset.At(i, j)*recurrentCandidate.At(i, j))
+			z := update.At(i, j)
+
+			candidate.Set(i, j, n)
+			h.Set(i, j, (1-z)*n+z*prev[0].At(i, j))
+		}
+	}
+
+	return []*mat.Dense{h}, []*mat.Dense{update, reset, candidate, recurrentCandidate, prev[0]}
+}
+
+func (gruCell) backward(cache, grad []*mat.Dense) (*mat.Dense, *mat.Dense, []*mat.Dense) {
+	update, reset, candidate, recurrentCandidate, prevH := cache[0], cache[1], cache[2], cache[3], cache[4]
+	hidden, batchSize := update.Dims()
+
+	preGrad := mat.NewDense(3*hidden, batchSize, nil)
+	recurrentGrad := mat.NewDense(3*hidden, batchSize, nil)
+	prevGrad := mat.NewDense(hidden, batchSize, nil)
+	for i := 0; i < hidden; i++ {
+		for j := 0; j < batchSize; j++ {
+			hGrad := grad[0].At(i, j)
+			z, r, n := update.At(i, j), reset.At(i, j), candidate.At(i, j)
+
+			zGrad := hGrad * (prevH.At(i, j) - n) * z * (1 - z)
+			nGrad := hGrad * (1 - z) * (1 - n*n)
+			rGrad := nGrad * recurrentCandidate.At(i, j) * r * (1 - r)
+
+			preGrad.Set(i, j, zGrad)
+			preGrad.Set(hidden+i, j, rGrad)
+			preGrad.Set(2*hidden+i, j, nGrad)
+
+			recurrentGrad.Set(i, j, zGrad)
+			recurrentGrad.Set(hidden+i, j, rGrad)
+			recurrentGrad.Set(2*hidden+i, j, nGrad*r)
+
+			prevGrad.Set(i, j, hGrad*z)
+		}
+	}
+
+	return preGrad, recurrentGrad, []*mat.Dense{prevGrad}
+}
+
+// rows of gate index of m with f applied
+func gate(m *mat.Dense, index, hidden int, f func(float64) float64) *mat.Dense {
+	_, c := m.Dims()
+
+	result := mat.DenseCopyOf(m.Slice(index*hidden, (index+1)*hidden, 0, c))
+	result.Apply(func(_, _ int, v float64) float64 {
+		return f(v)
+	}, result)
+
+	return result
+}
+
+func sigmoid(v float64) float64 {
+	return 1 / (1 + math.Exp(-v))
+}
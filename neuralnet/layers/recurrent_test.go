@@ -0,0 +1,299 @@
+package layers_test
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testRecurrent(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		rng *rand.Rand
+	)
+
+	it.Before(func() {
+		rng = rand.New(rand.NewSource(13))
+	})
+
+	randomBatch := func(size, count int) *mat.Dense {
+		result := mat.NewDense(size, count, nil)
+		result.Apply(func(_, _ int, _ float64) float64 {
+			return rng.Float64()*2 - 1
+		}, result)
+
+		return result
+	}
+
+	for _, tc := range []struct {
+		name        string
+		constructor func(layers.RecurrentConfig, *rand.Rand) (*layers.Recurrent, error)
+	}{
+		{"SimpleRNN", layers.NewSimpleRNN},
+		{"LSTM", layers.NewLSTM},
+		{"GRU", layers.NewGRU},
+	} {
+		tc := tc
+
+		context(tc.name, func() {
+			for _, sequences := range []bool{false, true} {
+				sequences := sequences
+
+				it("matches finite differences", func() {
+					layer, err := tc.constructor(layers.RecurrentConfig{
+						InputSize:       3,
+						HiddenSize:      2,
+						ReturnSequences: sequences,
+						BiasInit:        neuralnet.InitRandom,
+					}, rng)
+					Expect(err).NotTo(HaveOccurred())
+
+					shape, err := layer.OutputShape(neuralnet.Shape{4, 3})
+					Expect(err).NotTo(HaveOccurred())
+
+					if sequences {
+						Expect(shape).To(Equal(neuralnet.Shape{4, 2}))
+					} else {
+						Expect(shape).To(Equal(neuralnet.Shape{2}))
+					}
+
+					report, err := gradcheck.CheckLayer(layer, randomBatch(12, 2), randomBatch(shape.Size(), 2), 0)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(report.Params).To(HaveLen(3))
+					Expect(report.Max()).To(BeNumerically("<", 1e-6))
+				})
+			}
+
+			it("only backpropagates through the last chunk of a truncated sequence", func() {
+				layer, err := tc.constructor(layers.RecurrentConfig{
+					InputSize:  3,
+					HiddenSize: 2,
+					Truncate:   2,
+				}, rng)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = layer.OutputShape(neuralnet.Shape{4, 3})
+				Expect(err).NotTo(HaveOccurred())
+
+				weights := randomBatch(2, 2)
+
+				// the full gradient of the input reaches back to the first step
+				report, err := gradcheck.CheckLayer(layer, randomBatch(12, 2), weights, 0)
+				Expect(err).NotTo(HaveOccurred())
+				expected := report.NumericalInput
+
+				inputGrad, err := layer.Backward(weights)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(mat.Norm(inputGrad.Slice(0, 6, 0, 2), 1)).To(BeZero())
+				Expect(mat.Norm(expected.Slice(0, 6, 0, 2), 1)).NotTo(BeZero())
+				Expect(mat.EqualApprox(inputGrad.Slice(6, 12, 0, 2), expected.Slice(6, 12, 0, 2), 1e-6)).To(BeTrue())
+			})
+
+			it("replicates with shared weights and the sequence length", func() {
+				layer, err := tc.constructor(layers.RecurrentConfig{InputSize: 3, HiddenSize: 2, ReturnSequences: true}, rng)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = layer.OutputShape(neuralnet.Shape{4, 3})
+				Expect(err).NotTo(HaveOccurred())
+
+				replica := layer.Replica(nil)
+				Expect(replica.Params()).To(Equal(layer.Params()))
+
+				input, grad := randomBatch(12, 2), randomBatch(8, 2)
+				expected, err := layer.Forward(input, false)
+				Expect(err).NotTo(HaveOccurred())
+				expectedGrad, err := layer.Backward(grad)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(replica.Forward(input, false)).To(Equal(expected))
+				Expect(replica.Backward(grad)).To(Equal(expectedGrad))
+				Expect(replica.Grads()).To(Equal(layer.Grads()))
+			})
+
+			it("saves and loads with its cell and sequence settings", func() {
+				layer, err := tc.constructor(layers.RecurrentConfig{InputSize: 3, HiddenSize: 2, ReturnSequences: true, Truncate: 2}, rng)
+				Expect(err).NotTo(HaveOccurred())
+
+				network, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+					InputShape: neuralnet.Shape{4, 3},
+					Layers:     []neuralnet.Layer{layer},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				buffer := bytes.NewBuffer(nil)
+				Expect(network.Save(buffer, neuralnet.BinaryFormat)).To(Succeed())
+
+				loaded, err := neuralnet.LoadSequential(buffer)
+				Expect(err).NotTo(HaveOccurred())
+
+				recurrent := loaded.Layers[0].(*layers.Recurrent)
+				Expect(recurrent.ReturnSequences).To(BeTrue())
+				Expect(recurrent.Truncate).To(Equal(2))
+				Expect(loaded.Snapshot()).To(Equal(network.Snapshot()))
+
+				input := randomBatch(12, 2)
+				expected, err := network.CalculateBatch(input)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(loaded.CalculateBatch(input)).To(Equal(expected))
+			})
+
+			it("fails to backpropagate without a matching batch", func() {
+				layer, err := tc.constructor(layers.RecurrentConfig{InputSize: 3, HiddenSize: 2}, rng)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = layer.OutputShape(neuralnet.Shape{4, 3})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = layer.Backward(mat.NewDense(2, 2, nil))
+				Expect(err).To(MatchError(HaveSuffix(": no batch has been calculated")))
+
+				_, err = layer.Forward(randomBatch(12, 2), false)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = layer.Backward(mat.NewDense(2, 1, nil))
+				Expect(err).To(MatchError(HaveSuffix(": invalid gradient dimension: 2x1, expected 2x2")))
+			})
+		})
+	}
+
+	context("SimpleRNN", func() {
+		it("carries the hidden state between steps", func() {
+			layer, err := layers.NewSimpleRNN(layers.RecurrentConfig{
+				InputSize:       1,
+				HiddenSize:      1,
+				ReturnSequences: true,
+			}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			layer.InputWeights.Set(0, 0, 0.5)
+			layer.RecurrentWeights.Set(0, 0, -2)
+			layer.Bias.Set(0, 0, 0.1)
+
+			_, err = layer.OutputShape(neuralnet.Shape{2, 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := layer.Forward(mat.NewDense(2, 1, []float64{1, 3}), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			first := math.Tanh(0.5 + 0.1)
+			Expect(output.At(0, 0)).To(BeNumerically("~", first, 1e-12))
+			Expect(output.At(1, 0)).To(BeNumerically("~", math.Tanh(1.5-2*first+0.1), 1e-12))
+		})
+	})
+
+	context("LSTM", func() {
+		it("defaults the forget gate bias to 1", func() {
+			layer, err := layers.NewLSTM(layers.RecurrentConfig{InputSize: 1, HiddenSize: 2}, rng)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(layer.Bias.RawMatrix().Data).To(Equal([]float64{0, 0, 1, 1, 0, 0, 0, 0}))
+
+			layer, err = layers.NewLSTM(layers.RecurrentConfig{InputSize: 1, HiddenSize: 2, BiasInit: initializers.Constant{Value: 2}}, rng)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(layer.Bias.RawMatrix().Data).To(Equal([]float64{2, 2, 2, 2, 2, 2, 2, 2}))
+		})
+
+		it("learns to remember the first step of a sequence", func() {
+			lstm, err := layers.NewLSTM(layers.RecurrentConfig{InputSize: 1, HiddenSize: 4}, rng)
+			Expect(err).NotTo(HaveOccurred())
+
+			network, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{6, 1},
+				Layers: []neuralnet.Layer{
+					lstm,
+					layers.NewDense(4, 2, nil, nil, rng),
+					layers.NewActivation(nodefuncs.Softmax{}),
+				},
+				Loss:      losses.CrossEntropy{},
+				Optimizer: optimizers.NewAdam(0.05),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// the label is the sign of the first step, the other steps are noise
+			inputs := randomBatch(6, 32)
+			labels := mat.NewDense(2, 32, nil)
+			for j := 0; j < 32; j++ {
+				if inputs.At(0, j) > 0 {
+					labels.Set(0, j, 1)
+				} else {
+					labels.Set(1, j, 1)
+				}
+			}
+
+			for step := 0; step < 150; step++ {
+				_, err := network.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := network.GenerateDeltaBatch(labels)
+				Expect(err).NotTo(HaveOccurred())
+				gradient, err := network.GenerateGradientBatch(delta)
+				Expect(err).NotTo(HaveOccurred())
+				gradient.Scale(1.0 / 32)
+				Expect(network.Update(gradient)).To(Succeed())
+			}
+
+			output, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			correct := 0
+			for j := 0; j < 32; j++ {
+				if (output.At(0, j) > output.At(1, j)) == (labels.At(0, j) == 1) {
+					correct++
+				}
+			}
+			Expect(correct).To(BeNumerically(">=", 30))
+		})
+	})
+
+	it("fails on invalid configurations", func() {
+		_, err := layers.NewGRU(layers.RecurrentConfig{InputSize: 1}, rng)
+		Expect(err).To(MatchError("invalid gru size: 1 input, 0 hidden"))
+
+		_, err = layers.NewLSTM(layers.RecurrentConfig{InputSize: 1, HiddenSize: 1, Truncate: -1}, rng)
+		Expect(err).To(MatchError("invalid truncation: -1"))
+
+		layer, err := layers.NewSimpleRNN(layers.RecurrentConfig{InputSize: 2, HiddenSize: 1}, rng)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = layer.OutputShape(neuralnet.Shape{4})
+		Expect(err).To(MatchError("simple rnn requires a {steps, features} input, got [4]"))
+
+		_, err = layer.OutputShape(neuralnet.Shape{2, 3})
+		Expect(err).To(MatchError("invalid input size: 3, expected 2"))
+
+		_, err = layer.OutputShape(neuralnet.Shape{2, 2})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = layer.Forward(mat.NewDense(2, 1, nil), false)
+		Expect(err).To(MatchError("simple rnn: input of 2 rows does not match the shape set by OutputShape"))
+
+		_, err = neuralnet.LoadSequential(strings.NewReader(`{
+			"version": 6,
+			"kind": "sequential",
+			"inputShape": [2, 2],
+			"layers": [{"name": "recurrent", "config": {"cell": "elman", "inputSize": 2, "hiddenSize": 1}}]
+		}`))
+		Expect(err).To(MatchError("invalid layer at index 0: unknown recurrent cell: elman"))
+
+		_, err = neuralnet.LoadSequential(strings.NewReader(`{
+			"version": 7,
+			"kind": "sequential",
+			"inputShape": [2, 2],
+			"layers": [{"name": "recurrent", "config": {"cell": "lstm", "inputSize": 2, "hiddenSize": 8192}}]
+		}`))
+		Expect(err).To(MatchError("invalid layer at index 0: invalid matrix dimension: 32768x8192, at most 67108864 values"))
+	})
+}
@@ -0,0 +1,14 @@
+package losses_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitLosses(t *testing.T) {
+	suite := spec.New("Losses", spec.Report(report.Terminal{}))
+	suite("Losses", testLosses)
+	suite.Run(t)
+}
@@ -0,0 +1,226 @@
+package losses
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"gonum.org/v1/gonum/mat"
+)
+
+// keeps logarithms and divisions finite when activations saturate
+const epsilon = 1e-12
+
+///
+/// MSE Def
+///
+// half squared error summed over outputs: 0.5 * sum((a - y)^2)
+type MSE struct{}
+
+func (m MSE) CalcLoss(actual, expected mat.Vector) float64 {
+	result := float64(0)
+
+	for idx := 0; idx < actual.Len(); idx++ {
+		diff := actual.AtVec(idx) - expected.AtVec(idx)
+		result += diff * diff
+	}
+
+	return result / 2
+}
+
+func (m MSE) CalcDiff(actual, expected mat.Vector) *mat.VecDense {
+	result := mat.NewVecDense(actual.Len(), nil)
+	result.SubVec(actual, expected)
+
+	return result
+}
+
+///
+/// CrossEntropy Def
+///
+// categorical cross entropy: -sum(y * ln(a)), paired with a Softmax output layer
+type CrossEntropy struct{}
+
+func (c CrossEntropy) CalcLoss(actual, expected mat.Vector) float64 {
+	result := float64(0)
+
+	for idx := 0; idx < actual.Len(); idx++ {
+		result -= expected.AtVec(idx) * math.Log(math.Max(actual.AtVec(idx), epsilon))
+	}
+
+	return result
+}
+
+func (c CrossEntropy) CalcDiff(actual, expected mat.Vector) *mat.VecDense {
+	result := mat.NewVecDense(actual.Len(), nil)
+
+	for idx := 0; idx < actual.Len(); idx++ {
+		result.SetVec(idx, -expected.AtVec(idx)/math.Max(actual.AtVec(idx), epsilon))
+	}
+
+	return result
+}
+
+func (c CrossEntropy) Pairs(f interface{}) bool {
+	_, ok := f.(nodefuncs.Softmax)
+	return ok
+}
+
+// gradient with respect to the Softmax input: (a - y)
+func (c CrossEntropy) CalcDelta(actual, expected mat.Vector) *mat.VecDense {
+	result := mat.NewVecDense(actual.Len(), nil)
+	result.SubVec(actual, expected)
+
+	return result
+}
+
+///
+/// BinaryCrossEntropy Def
+///
+// -sum(y * ln(a) + (1 - y) * ln(1 - a)), paired with a Sigmoid output layer
+type BinaryCrossEntropy struct{}
+
+func (b BinaryCrossEntropy) CalcLoss(actual, expected mat.Vector) float64 {
+	result := float64(0)
+
+	for idx := 0; idx < actual.Len(); idx++ {
+		a := clip(actual.AtVec(idx))
+		y := expected.AtVec(idx)
+		result -= y*math.Log(a) + (1-y)*math.Log(1-a)
+	}
+
+	return result
+}
+
+func (b BinaryCrossEntropy) CalcDiff(actual, expected mat.Vector) *mat.VecDense {
+	result := mat.NewVecDense(actual.Len(), nil)
+
+	for idx := 0; idx < actual.Len(); idx++ {
+		a := clip(actual.AtVec(idx))
+		result.SetVec(idx, (a-expected.AtVec(idx))/(a*(1-a)))
+	}
+
+	return result
+}
+
+func (b BinaryCrossEntropy) Pairs(f interface{}) bool {
+	_, ok := f.(nodefuncs.Sigmoid)
+	return ok
+}
+
+// gradient with respect to the Sigmoid input: (a - y)
+func (b BinaryCrossEntropy) CalcDelta(actual, expected mat.Vector) *mat.VecDense {
+	result := mat.NewVecDense(actual.Len(), nil)
+	result.SubVec(actual, expected)
+
+	return result
+}
+
+///
+/// NLL Def
+///
+// negative log likelihood of log-probabilities: -sum(y * a), for use with a LogSoftmax output layer
+type NLL struct{}
+
+func (n NLL) CalcLoss(actual, expected mat.Vector) float64 {
+	return -mat.Dot(actual, expected)
+}
+
+func (n NLL) CalcDiff(actual, expected mat.Vector) *mat.VecDense {
+	result := mat.NewVecDense(actual.Len(), nil)
+	result.ScaleVec(-1, expected)
+
+	return result
+}
+
+///
+/// Huber Def
+///
+// quadratic for errors within Delta and linear beyond, a Delta that is not positive
+// fails Validate
+type Huber struct {
+	Delta float64
+}
+
+// Delta of 1
+func NewHuber() Huber {
+	return Huber{Delta: 1}
+}
+
+func (h Huber) Validate() error {
+	if h.Delta <= 0 {
+		return fmt.Errorf("invalid huber delta: %v", h.Delta)
+	}
+
+	return nil
+}
+
+func (h Huber) CalcLoss(actual, expected mat.Vector) float64 {
+	delta := h.Delta
+	result := float64(0)
+
+	for idx := 0; idx < actual.Len(); idx++ {
+		diff := math.Abs(actual.AtVec(idx) - expected.AtVec(idx))
+		if diff <= delta {
+			result += diff * diff / 2
+		} else {
+			result += delta * (diff - delta/2)
+		}
+	}
+
+	return result
+}
+
+func (h Huber) CalcDiff(actual, expected mat.Vector) *mat.VecDense {
+	delta := h.Delta
+	result := mat.NewVecDense(actual.Len(), nil)
+
+	for idx := 0; idx < actual.Len(); idx++ {
+		diff := actual.AtVec(idx) - expected.AtVec(idx)
+		result.SetVec(idx, math.Max(-delta, math.Min(delta, diff)))
+	}
+
+	return result
+}
+
+///
+/// Hinge Def
+///
+// sum(max(0, 1 - t * a)) where t is +1 for positive expected values and -1 otherwise,
+// so one-hot solutions can be used directly
+type Hinge struct{}
+
+func (h Hinge) CalcLoss(actual, expected mat.Vector) float64 {
+	result := float64(0)
+
+	for idx := 0; idx < actual.Len(); idx++ {
+		result += math.Max(0, 1-sign(expected.AtVec(idx))*actual.AtVec(idx))
+	}
+
+	return result
+}
+
+func (h Hinge) CalcDiff(actual, expected mat.Vector) *mat.VecDense {
+	result := mat.NewVecDense(actual.Len(), nil)
+
+	for idx := 0; idx < actual.Len(); idx++ {
+		t := sign(expected.AtVec(idx))
+		if t*actual.AtVec(idx) < 1 {
+			result.SetVec(idx, -t)
+		}
+	}
+
+	return result
+}
+
+func clip(x float64) float64 {
+	return math.Min(math.Max(x, epsilon), 1-epsilon)
+}
+
+func sign(x float64) float64 {
+	if x > 0 {
+		return 1
+	}
+
+	return -1
+}
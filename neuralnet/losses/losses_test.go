@@ -0,0 +1,143 @@
+package losses_test
+
+import (
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+	"gonum.org/v1/gonum/mat"
+)
+
+func testLosses(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	context("MSE", func() {
+		var mse losses.MSE
+
+		it("calculates loss and diff", func() {
+			actual := mat.NewVecDense(2, []float64{1, 3})
+			expected := mat.NewVecDense(2, []float64{0, 1})
+
+			Expect(mse.CalcLoss(actual, expected)).To(BeNumerically("~", 2.5))
+			Expect(mse.CalcDiff(actual, expected).RawVector().Data).To(Equal([]float64{1, 2}))
+		})
+	})
+
+	context("CrossEntropy", func() {
+		var crossEntropy losses.CrossEntropy
+
+		it("calculates loss and diff", func() {
+			actual := mat.NewVecDense(3, []float64{0.7, 0.2, 0.1})
+			expected := mat.NewVecDense(3, []float64{0, 1, 0})
+
+			Expect(crossEntropy.CalcLoss(actual, expected)).To(BeNumerically("~", 1.6094379124341003))
+
+			diff := crossEntropy.CalcDiff(actual, expected)
+			Expect(diff.AtVec(0)).To(BeNumerically("~", 0))
+			Expect(diff.AtVec(1)).To(BeNumerically("~", -5))
+			Expect(diff.AtVec(2)).To(BeNumerically("~", 0))
+		})
+
+		it("pairs with Softmax", func() {
+			Expect(crossEntropy.Pairs(nodefuncs.Softmax{})).To(BeTrue())
+			Expect(crossEntropy.Pairs(nodefuncs.Sigmoid{})).To(BeFalse())
+
+			delta := crossEntropy.CalcDelta(
+				mat.NewVecDense(3, []float64{0.7, 0.2, 0.1}),
+				mat.NewVecDense(3, []float64{0, 1, 0}),
+			)
+			Expect(delta.AtVec(0)).To(BeNumerically("~", 0.7))
+			Expect(delta.AtVec(1)).To(BeNumerically("~", -0.8))
+			Expect(delta.AtVec(2)).To(BeNumerically("~", 0.1))
+		})
+
+		it("stays finite for saturated activations", func() {
+			actual := mat.NewVecDense(2, []float64{1, 0})
+			expected := mat.NewVecDense(2, []float64{0, 1})
+
+			Expect(crossEntropy.CalcLoss(actual, expected)).To(BeNumerically("~", 27.631021115928547))
+		})
+	})
+
+	context("BinaryCrossEntropy", func() {
+		var binaryCrossEntropy losses.BinaryCrossEntropy
+
+		it("calculates loss and diff", func() {
+			actual := mat.NewVecDense(2, []float64{0.8, 0.4})
+			expected := mat.NewVecDense(2, []float64{1, 0})
+
+			Expect(binaryCrossEntropy.CalcLoss(actual, expected)).To(BeNumerically("~", 0.7339691750802004))
+
+			diff := binaryCrossEntropy.CalcDiff(actual, expected)
+			Expect(diff.AtVec(0)).To(BeNumerically("~", -1.25))
+			Expect(diff.AtVec(1)).To(BeNumerically("~", 1/0.6))
+		})
+
+		it("pairs with Sigmoid", func() {
+			Expect(binaryCrossEntropy.Pairs(nodefuncs.Sigmoid{})).To(BeTrue())
+			Expect(binaryCrossEntropy.Pairs(nodefuncs.Softmax{})).To(BeFalse())
+
+			delta := binaryCrossEntropy.CalcDelta(
+				mat.NewVecDense(2, []float64{0.8, 0.4}),
+				mat.NewVecDense(2, []float64{1, 0}),
+			)
+			Expect(delta.AtVec(0)).To(BeNumerically("~", -0.2))
+			Expect(delta.AtVec(1)).To(BeNumerically("~", 0.4))
+		})
+	})
+
+	context("NLL", func() {
+		var nll losses.NLL
+
+		it("calculates loss and diff from log-probabilities", func() {
+			actual := mat.NewVecDense(3, []float64{-0.5, -1.5, -2})
+			expected := mat.NewVecDense(3, []float64{0, 1, 0})
+
+			Expect(nll.CalcLoss(actual, expected)).To(BeNumerically("~", 1.5))
+			Expect(nll.CalcDiff(actual, expected).RawVector().Data).To(Equal([]float64{0, -1, 0}))
+		})
+	})
+
+	context("Huber", func() {
+		it("is quadratic within delta and linear beyond it", func() {
+			huber := losses.Huber{Delta: 1}
+			actual := mat.NewVecDense(2, []float64{0.5, 3})
+			expected := mat.NewVecDense(2, []float64{0, 0})
+
+			Expect(huber.CalcLoss(actual, expected)).To(BeNumerically("~", 0.125+2.5))
+			Expect(huber.CalcDiff(actual, expected).RawVector().Data).To(Equal([]float64{0.5, 1}))
+		})
+
+		it("rejects a delta that is not positive", func() {
+			Expect(losses.Huber{Delta: -0.5}.Validate()).To(MatchError("invalid huber delta: -0.5"))
+			Expect(losses.Huber{}.Validate()).To(MatchError("invalid huber delta: 0"))
+			Expect(losses.NewHuber().Validate()).To(Succeed())
+		})
+
+		it("has a delta of 1 when built with NewHuber", func() {
+			huber := losses.NewHuber()
+			actual := mat.NewVecDense(1, []float64{-3})
+			expected := mat.NewVecDense(1, []float64{0})
+
+			Expect(huber.CalcLoss(actual, expected)).To(BeNumerically("~", 2.5))
+			Expect(huber.CalcDiff(actual, expected).RawVector().Data).To(Equal([]float64{-1}))
+		})
+	})
+
+	context("Hinge", func() {
+		var hinge losses.Hinge
+
+		it("treats non-positive expected values as negative labels", func() {
+			actual := mat.NewVecDense(3, []float64{0.5, 2, 0.5})
+			expected := mat.NewVecDense(3, []float64{1, 1, 0})
+
+			Expect(hinge.CalcLoss(actual, expected)).To(BeNumerically("~", 0.5+0+1.5))
+			Expect(hinge.CalcDiff(actual, expected).RawVector().Data).To(Equal([]float64{-1, 0, 1}))
+		})
+	})
+}
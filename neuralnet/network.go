@@ -1,11 +1,13 @@
 package neuralnet
 
-// TODO: use sparse matrix implementaion (way faster....)
 import (
 	"fmt"
 	"math/rand"
 
-	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/dwillist/summerschool/v2/neuralnet/sparse"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -14,234 +16,632 @@ type NodeFunc interface {
 	CalcDiff(float64, mat.Vector) float64
 }
 
+// A VectorFunc computes a layer activation from the whole Zval in a single pass,
+// layers whose Func implements it use these methods instead of CalcVal and CalcDiff
+type VectorFunc interface {
+	NodeFunc
+	// dst = f(z)
+	Apply(dst, z *mat.VecDense)
+	// J[i][j] = d f(z)_i / d z_j
+	Jacobian(z *mat.VecDense) *mat.Dense
+	// dst = J(z)ᵀ·grad, a holds f(z)
+	BackpropVec(dst, z, a, grad *mat.VecDense)
+}
+
+type Loss interface {
+	CalcLoss(actual, expected mat.Vector) float64
+	CalcDiff(actual, expected mat.Vector) *mat.VecDense
+}
+
+// A PairedLoss has a simplified derivative when used with a specific output NodeFunc,
+// CalcDelta then returns the gradient with respect to the output layer Zval.
+type PairedLoss interface {
+	Loss
+	Pairs(interface{}) bool
+	CalcDelta(actual, expected mat.Vector) *mat.VecDense
+}
+
+// A ValidatedLoss is checked by NewNetwork and NewSequential, so a loss configured with
+// values it cannot honour fails there instead of training with different ones
+type ValidatedLoss interface {
+	Loss
+	Validate() error
+}
+
+// An Optimizer applies one update step to params in place, params and grads are
+// flattened and passed in the same order on every step, a nil grad skips its param
+type Optimizer interface {
+	Update(params, grads [][]float64)
+}
+
+// Optimizers that also implement DecayingOptimizer apply weight decay, UpdateDecayed is
+// called instead of Update with whether each of params is a weight matrix to decay
+type DecayingOptimizer interface {
+	Optimizer
+	UpdateDecayed(params, grads [][]float64, decayed []bool)
+}
+
+// Optimizers that also implement SparseOptimizer are only passed the params that have a
+// gradient, e.g. the rows of an Embedding a batch used, so a step does not cost every row.
+// positions[i] is the position params[i] has among all params of the network, state is
+// kept by position. decayed is as for DecayingOptimizer.
+type SparseOptimizer interface {
+	Optimizer
+	UpdateSparse(params, grads [][]float64, positions []int, decayed []bool)
+}
+
+// Optimizers that also implement ScheduledOptimizer can have their learning rate
+// changed between updates, e.g. by a learning rate schedule
+type ScheduledOptimizer interface {
+	Optimizer
+	SetLearningRate(float64)
+}
+
+// An Initializer fills values with the row-major data of a weight matrix with fanIn
+// columns, fanOut x fanIn for dense layers, or a bias vector, for the LayerConfigs entry
+// at index layer. Convolution kernels count every kernel position in both fans, so their
+// matrix has fewer than fanOut rows.
+// Any sampling must use rng so networks are reproducible from Config.Source.
+type Initializer interface {
+	Initialize(values []float64, fanIn, fanOut, layer int, rng *rand.Rand)
+}
+
+// adapts a per-value function to an Initializer
+type InitFunc func(*rand.Rand) float64
+
+func (f InitFunc) Initialize(values []float64, _, _, _ int, rng *rand.Rand) {
+	for idx := range values {
+		values[idx] = f(rng)
+	}
+}
+
 type Config struct {
 	LayerConfigs []LayerConfig
-	WeightInit   func() float64
+	// defaults to initializers.XavierUniform
+	WeightInit Initializer
+	// defaults to zeros
+	BiasInit Initializer
+	// defaults to losses.MSE
+	Loss Loss
+	// defaults to plain SGD with a learning rate of .01
+	Optimizer Optimizer
+	// applies to every layer without its own Regularization
+	Regularization Regularization
+	// all randomness of the network is drawn from Source, when nil a source
+	// is seeded from the global math/rand source
+	Source rand.Source
+	// maps every input sample before the dense layers, e.g. a layers.Embedding of ids.
+	// Its output size must match LayerConfigs[0].Size, see Network.InputLayer.
+	InputLayer Layer
+	// sample shape taken by InputLayer, required with it
+	InputShape Shape
 }
 
 type LayerConfig struct {
 	Size int
 	Func NodeFunc
+	// override Config.WeightInit and Config.BiasInit for this layer
+	WeightInit Initializer
+	BiasInit   Initializer
+	// overrides Config.Regularization for the weights feeding this layer
+	Regularization *Regularization
+	// fraction of this layer's activations zeroed in training mode, see SetTraining
+	Dropout float64
+	// normalizes this layer's Zval before Func is applied, see BatchNorm
+	BatchNorm bool
+}
+
+// Gradient holds loss gradients for every trainable parameter of a network, Layers[i]
+// matches Layers[i].Params() of its Sequential. For a Network, LayerIndex gives the
+// Dense layer, and the BatchNorm layer following it, of every LayerConfigs entry.
+// LayerRows[i][j] lists the parameter rows held by Layers[i][j] for SparseLayers, it is nil
+// for full gradients and LayerRows is nil when no layer is a SparseLayer.
+type Gradient struct {
+	Layers    [][]*mat.Dense
+	LayerRows [][][]int
 }
 
+// Network is the Sequential of the layers NewNetwork builds from LayerConfigs: an optional
+// InputLayer, then for every entry after the first a Dense layer, a BatchNorm layer with
+// LayerConfig.BatchNorm and an Activation layer of its Func, and a Dropout layer for
+// every entry with LayerConfig.Dropout. Weights, Bias and Norms share their values with
+// those layers.
 type Network struct {
+	Sequential
+	// size of an input sample, InputShape.Size() with an InputLayer
 	InputSize    int
 	OutputSize   int
 	LayerConfigs []LayerConfig
 	Weights      []*mat.Dense
-	Bias         []*mat.VecDense
-	Activation   []*mat.VecDense
-	Zval         []*mat.VecDense
+	// a non-nil entry stores the matching Weights entry sparsely, that Weights entry is then nil
+	SparseWeights []*sparse.CSR
+	Bias          []*mat.VecDense
+	// a non-nil entry normalizes the matching layer, nil when no layer has LayerConfig.BatchNorm
+	Norms      []*BatchNorm
+	Activation []*mat.VecDense
+	Zval       []*mat.VecDense
+	// per layer results of the most recent CalculateBatch call
+	ActivationBatch []*mat.Dense
+	ZvalBatch       []*mat.Dense
+	// nil without an input layer, see Config.InputLayer, it is then Layers[0]
+	InputLayer Layer
+
+	// index into Layers of the Dense layer of every LayerConfigs entry, -1 for the first
+	denseLayers []int
+	// index into the outputs of a forward pass, which start with its input, of the Zval
+	// and Activation of every LayerConfigs entry
+	zvalOutputs       []int
+	activationOutputs []int
 }
 
-func InitOne() float64 {
-	return 1.0
-}
+var (
+	InitOne InitFunc = func(_ *rand.Rand) float64 {
+		return 1.0
+	}
 
-func InitRandom() float64 {
-	return rand.Float64()
-}
+	InitRandom InitFunc = func(rng *rand.Rand) float64 {
+		return rng.Float64()
+	}
+)
 
 func NewNetwork(config Config) (Network, error) {
 	result := Network{}
 	result.LayerConfigs = config.LayerConfigs
+
+	result.Loss = config.Loss
+	if result.Loss == nil {
+		result.Loss = losses.MSE{}
+	}
+
+	result.Optimizer = config.Optimizer
+	if result.Optimizer == nil {
+		result.Optimizer = optimizers.NewSGD(.01)
+	}
+
+	source := config.Source
+	if source == nil {
+		source = rand.NewSource(rand.Int63())
+	}
+	result.Rand = rand.New(source)
 	// error cases
 	switch {
 	case result.Len() == 0:
-		return result, fmt.Errorf("layerConfig must contain at least 1 element")
+		return Network{}, fmt.Errorf("layerConfig must contain at least 1 element")
 	case result.LayerConfigs[0].Func != nil:
-		panic("balls")
+		return Network{}, fmt.Errorf("the input layer does not support a Func")
+	}
+
+	result.Regularization = config.Regularization
+	if err := result.Regularization.validate(); err != nil {
+		return Network{}, err
+	}
+
+	if err := validateLoss(result.Loss); err != nil {
+		return Network{}, err
+	}
+
+	for _, lconfig := range result.LayerConfigs {
+		if lconfig.Regularization == nil {
+			continue
+		}
+
+		if err := lconfig.Regularization.validate(); err != nil {
+			return Network{}, err
+		}
 	}
 
 	result.InputSize = result.LayerConfigs[0].Size
+	if err := result.setInputLayer(config.InputLayer, config.InputShape); err != nil {
+		return Network{}, err
+	}
+
+	addLayer := func(layer Layer, regularization *Regularization) {
+		result.Layers = append(result.Layers, layer)
+		result.LayerRegularization = append(result.LayerRegularization, regularization)
+	}
+
+	if result.InputLayer != nil {
+		addLayer(result.InputLayer, nil)
+	}
 
 	// set up Bias and Weight values
 	prevSize := 0
 
-	for _, lconfig := range result.LayerConfigs {
+	for layer, lconfig := range result.LayerConfigs {
+		biasVals := make([]float64, lconfig.Size)
+
 		switch {
 		case lconfig.Size <= 0:
 			return Network{}, fmt.Errorf("invalid layer size: %v", lconfig.Size)
+		case lconfig.Dropout < 0 || lconfig.Dropout >= 1:
+			return Network{}, fmt.Errorf("invalid dropout rate: %v", lconfig.Dropout)
+		case lconfig.Dropout != 0 && layer == result.Len()-1:
+			return Network{}, fmt.Errorf("dropout is not supported on the output layer")
+		case lconfig.BatchNorm && layer == 0:
+			return Network{}, fmt.Errorf("batch normalization is not supported on the input layer")
 		case prevSize != 0:
+			weightInit := firstInitializer(lconfig.WeightInit, config.WeightInit, initializers.XavierUniform{})
 			initVals := make([]float64, lconfig.Size*prevSize)
-			for i := 0; i < lconfig.Size*prevSize; i++ {
-				initVals[i] = config.WeightInit()
+			weightInit.Initialize(initVals, prevSize, lconfig.Size, layer, result.Rand)
+
+			weights := mat.NewDense(lconfig.Size, prevSize, initVals)
+			result.Weights = append(result.Weights, weights)
+
+			biasInit := firstInitializer(lconfig.BiasInit, config.BiasInit, initializers.Constant{})
+			biasInit.Initialize(biasVals, prevSize, lconfig.Size, layer, result.Rand)
+
+			result.denseLayers = append(result.denseLayers, len(result.Layers))
+			addLayer(&Dense{Weights: weights, Bias: mat.NewDense(lconfig.Size, 1, biasVals)}, lconfig.Regularization)
+
+			if lconfig.BatchNorm {
+				if result.Norms == nil {
+					result.Norms = make([]*BatchNorm, result.Len())
+				}
+
+				// batch normalization is never regularized
+				result.Norms[layer] = NewBatchNorm(lconfig.Size)
+				addLayer(result.Norms[layer], &Regularization{})
 			}
 
-			result.Weights = append(result.Weights, mat.NewDense(lconfig.Size, prevSize, initVals))
+			result.zvalOutputs = append(result.zvalOutputs, len(result.Layers))
 
-			fallthrough
+			// layers without a Func are linear
+			if lconfig.Func != nil {
+				addLayer(NewActivation(lconfig.Func), nil)
+			}
 		default:
-			result.Bias = append(result.Bias, mat.NewVecDense(lconfig.Size, nil))
-			result.Activation = append(result.Activation, mat.NewVecDense(lconfig.Size, nil))
-			result.Zval = append(result.Zval, mat.NewVecDense(lconfig.Size, nil))
-			prevSize = lconfig.Size
+			result.denseLayers = append(result.denseLayers, -1)
+			result.zvalOutputs = append(result.zvalOutputs, len(result.Layers))
+		}
+
+		if lconfig.Dropout != 0 {
+			addLayer(NewDropout(lconfig.Dropout, result.Rand), nil)
 		}
+
+		result.activationOutputs = append(result.activationOutputs, len(result.Layers))
+		result.Bias = append(result.Bias, mat.NewVecDense(lconfig.Size, biasVals))
+		result.Activation = append(result.Activation, mat.NewVecDense(lconfig.Size, nil))
+		result.Zval = append(result.Zval, mat.NewVecDense(lconfig.Size, nil))
+		prevSize = lconfig.Size
+	}
+
+	if result.InputLayer == nil {
+		result.InputShape = Shape{result.InputSize}
 	}
 
 	result.OutputSize = prevSize
+	result.OutputShape = Shape{prevSize}
 
 	return result, nil
 }
 
+func firstInitializer(initializers ...Initializer) Initializer {
+	for _, initializer := range initializers {
+		if initializer != nil {
+			return initializer
+		}
+	}
+
+	return nil
+}
+
 func (n *Network) Len() int {
 	return len(n.LayerConfigs)
 }
 
+// index into Layers, and Gradient.Layers, of the Dense layer feeding LayerConfigs[layer],
+// its BatchNorm layer directly follows it. The first entry has no Dense layer, it is -1.
+func (n *Network) LayerIndex(layer int) int {
+	return n.denseLayers[layer]
+}
+
 func (n *Network) Reset() {
 	n.Activation = nil
 	n.Zval = nil
+	n.output = nil
+}
+
+// converts the weights at index to sparse storage, dropping every weight whose
+// magnitude is at most threshold. Dropped weights stay zero during training.
+func (n *Network) Sparsify(index int, threshold float64) error {
+	if index < 0 || index >= len(n.Weights) {
+		return fmt.Errorf("invalid weight index: %d", index)
+	}
+
+	dense := n.Layers[n.LayerIndex(index+1)].(*Dense)
+	if err := dense.Sparsify(threshold); err != nil {
+		return err
+	}
+
+	if n.SparseWeights == nil {
+		n.SparseWeights = make([]*sparse.CSR, len(n.Weights))
+	}
+
+	n.SparseWeights[index] = dense.SparseWeights
+	n.Weights[index] = nil
+
+	return nil
 }
 
-// TODO: switch implementation to use sparce matracies datastructures.
+func (n *Network) isSparse(index int) bool {
+	return index < len(n.SparseWeights) && n.SparseWeights[index] != nil
+}
 
 func (n *Network) Calculate(input *mat.VecDense) (*mat.VecDense, error) {
 	n.Reset()
 
-	r := input.Len()
+	outputs, err := n.calculate(columnOf(input))
+	if err != nil {
+		return nil, err
+	}
 
-	if r != n.InputSize {
-		return nil, fmt.Errorf("invalid input size: %v", r)
+	for layer := range n.LayerConfigs {
+		n.Zval = append(n.Zval, mat.VecDenseCopyOf(outputs[n.zvalOutputs[layer]].ColView(0)))
+		n.Activation = append(n.Activation, mat.VecDenseCopyOf(outputs[n.activationOutputs[layer]].ColView(0)))
 	}
-	// set up input Z-value
-	inputZval := mat.VecDenseCopyOf(input)
-	n.Zval = append(n.Zval, inputZval)
 
-	// set up input activation
-	prevActivation := mat.VecDenseCopyOf(input)
-	n.Activation = append(n.Activation, prevActivation)
+	return mat.VecDenseCopyOf(n.Activation[n.Len()-1]), nil
+}
 
-	configIdx := 1
-	weightsIdx := 0
+// Calculate for mostly zero inputs, which are expanded to dense ones
+func (n *Network) CalculateSparse(input *sparse.Vector) (*mat.VecDense, error) {
+	n.Reset()
+
+	if n.InputLayer != nil {
+		return nil, fmt.Errorf("sparse inputs are not supported with an input layer")
+	}
+
+	return n.Calculate(input.ToDense())
+}
 
-	for configIdx < n.Len() {
-		// mult prevOutput by weights
-		newZ := mat.NewVecDense(n.LayerConfigs[configIdx].Size, nil)
+// loss, delta and gradient methods use the most recent CalculateBatch call
+func (n *Network) CalculateBatch(input *mat.Dense) (*mat.Dense, error) {
+	n.ActivationBatch = nil
+	n.ZvalBatch = nil
 
-		newZ.MulVec(n.Weights[weightsIdx], prevActivation)
-		// add bias
-		newZ.AddVec(newZ, n.Bias[configIdx])
+	outputs, err := n.calculate(input)
+	if err != nil {
+		return nil, err
+	}
+
+	for layer := range n.LayerConfigs {
+		n.ZvalBatch = append(n.ZvalBatch, mat.DenseCopyOf(outputs[n.zvalOutputs[layer]]))
+		n.ActivationBatch = append(n.ActivationBatch, mat.DenseCopyOf(outputs[n.activationOutputs[layer]]))
+	}
 
-		n.Zval = append(n.Zval, newZ)
-		// apply function
-		newActivation := mat.VecDenseCopyOf(newZ)
-		nodefuncs.ApplyFunc(newActivation, n.LayerConfigs[configIdx].Func.CalcVal)
+	return mat.DenseCopyOf(n.ActivationBatch[n.Len()-1]), nil
+}
 
-		n.Activation = append(n.Activation, newActivation)
-		prevActivation = newActivation
+// delta with respect to the Zval of every layer after the first, for the most recent
+// Calculate call
+func (n *Network) GenerateDelta(solution *mat.VecDense) ([]*mat.VecDense, error) {
+	deltaBatch, err := n.GenerateDeltaBatch(columnOf(solution))
+	if err != nil {
+		return nil, err
+	}
 
-		// increment indicies
-		configIdx++
-		weightsIdx++
+	var result []*mat.VecDense
+	for _, delta := range deltaBatch {
+		result = append(result, mat.VecDenseCopyOf(delta.ColView(0)))
 	}
 
-	return mat.VecDenseCopyOf(prevActivation), nil
+	return result, nil
 }
 
-func (n *Network) generateInitialDelta(solution *mat.VecDense) (*mat.VecDense, error) {
-	if solution.Len() != n.OutputSize {
-		return nil, fmt.Errorf("invalid solution dimension: %d, expected %d", solution.Len(), n.OutputSize)
+func (n *Network) GenerateDeltaBatch(solutions *mat.Dense) ([]*mat.Dense, error) {
+	layerDelta, err := n.Sequential.GenerateDeltaBatch(solutions)
+	if err != nil {
+		return nil, err
 	}
 
-	layerSub := mat.NewVecDense(n.OutputSize, nil)
-	layerCount := n.Len()
-	layerSub.SubVec(n.Activation[layerCount-1], solution) //(a - y)
+	// layerDelta holds the gradient with respect to the output of every layer
+	var result []*mat.Dense
+	for _, output := range n.zvalOutputs[1:] {
+		result = append(result, layerDelta[output-1])
+	}
 
-	// diffVector := mat.VecDenseCopyOf(n.Zval[layerCount-1])
-	// nodefuncs.ApplyFunc(diffVector, n.LayerConfigs[layerCount-1].Func.CalcDiff)
+	return result, nil
+}
 
-	// result := mat.NewVecDense(n.OutputSize, nil)
-	// result.MulElemVec(diffVector, layerSub)
+// generates parameter gradients for the most recent Calculate call without applying them
+func (n *Network) GenerateGradient(delta []*mat.VecDense) (Gradient, error) {
+	var deltaBatch []*mat.Dense
+	for _, layerDelta := range delta {
+		deltaBatch = append(deltaBatch, columnOf(layerDelta))
+	}
 
-	// return result, nil
-	return layerSub, nil
+	return n.GenerateGradientBatch(deltaBatch)
 }
 
-func (n *Network) GenerateDelta(solution *mat.VecDense) ([]*mat.VecDense, error) {
-	var result []*mat.VecDense
+// gradients summed over every sample of the most recent CalculateBatch call. Every delta
+// is backpropagated through the BatchNorm and Dense layers of its entry, so it does not
+// have to be the result of GenerateDeltaBatch.
+func (n *Network) GenerateGradientBatch(delta []*mat.Dense) (Gradient, error) {
+	if len(delta) != n.Len()-1 {
+		return Gradient{}, fmt.Errorf("invalid delta count: %d, expected %d", len(delta), n.Len()-1)
+	}
 
-	initial, err := n.generateInitialDelta(solution)
+	if n.output == nil {
+		return Gradient{}, fmt.Errorf("no batch has been calculated")
+	}
 
-	if err != nil {
-		panic(err)
+	for idx, grad := range delta {
+		layer := idx + 1
+		for layerIndex := n.zvalOutputs[layer] - 1; layerIndex >= n.denseLayers[layer]; layerIndex-- {
+			var err error
+			if grad, err = n.Layers[layerIndex].Backward(grad); err != nil {
+				return Gradient{}, fmt.Errorf("layer at index %d failed: %s", layerIndex, err)
+			}
+		}
 	}
 
-	result = append(result, initial)
-	prevDiff := initial
-	// iterate backwards through layers
-	for layerIndex := n.Len() - 2; layerIndex > 0; layerIndex-- {
-		weightMat := n.Weights[layerIndex].T()
+	_, batchSize := n.output.Dims()
+
+	return n.layerGradient(n.Layers, batchSize), nil
+}
+
+// Add accumulates other into g, allocating storage on first use.
+func (g *Gradient) Add(other Gradient) {
+	if g.Layers == nil {
+		for _, grads := range other.Layers {
+			var copies []*mat.Dense
+			for _, grad := range grads {
+				copies = append(copies, mat.DenseCopyOf(grad))
+			}
+
+			g.Layers = append(g.Layers, copies)
+		}
 
-		r, c := weightMat.Dims()
+		for _, rows := range other.LayerRows {
+			g.LayerRows = append(g.LayerRows, append([][]int(nil), rows...))
+		}
+
+		return
+	}
 
-		for i := 0; i < r; i++ {
-			for j := 0; j < c; j++ {
+	for idx, grads := range other.Layers {
+		for paramIndex, grad := range grads {
+			if rows := other.rows(idx, paramIndex); rows != nil {
+				g.Layers[idx][paramIndex], g.LayerRows[idx][paramIndex] = addRows(
+					g.Layers[idx][paramIndex], g.LayerRows[idx][paramIndex], grad, rows)
+				continue
 			}
+
+			g.Layers[idx][paramIndex].Add(g.Layers[idx][paramIndex], grad)
 		}
+	}
+}
 
-		mulResult := mat.NewVecDense(n.LayerConfigs[layerIndex].Size, nil)
+func (g *Gradient) Scale(factor float64) {
+	for _, grads := range g.Layers {
+		for _, grad := range grads {
+			grad.Scale(factor, grad)
+		}
+	}
+}
 
-		mulResult.MulVec(weightMat, prevDiff)
+// the params of a single Optimizer step with their gradients and positions among all
+// params of the network
+type optimizerStep struct {
+	params, grads [][]float64
+	positions     []int
+	decayed       []bool
+	// params taken by row, only the rows with a gradient are in params
+	rowParams []rowParam
+	size      int
+}
+
+// a param of a SparseLayer, its rows take the positions from position on
+type rowParam struct {
+	param    *mat.Dense
+	position int
+	decayed  bool
+}
+
+func (s *optimizerStep) add(param, grad []float64, decayed bool) {
+	s.params = append(s.params, param)
+	s.grads = append(s.grads, grad)
+	s.positions = append(s.positions, s.size)
+	s.decayed = append(s.decayed, decayed)
+	s.size++
+}
 
-		// diffVector := mat.VecDenseCopyOf(n.Zval[layerIndex])
-		// nodefuncs.ApplyFunc(diffVector, n.LayerConfigs[layerIndex].Func.CalcDiff)
-		// newResult := mat.NewVecDense(n.LayerConfigs[layerIndex].Size, nil)
-		//
-		// newResult.MulElemVec(mulResult, diffVector)
-		//
-		// result = append(result, newResult)
-		// prevDiff = newResult
-		result = append(result, mulResult)
-		prevDiff = mulResult
+// adds every row of param, grad holds the gradients of rows in order and must be valid
+func (s *optimizerStep) addRows(param, grad *mat.Dense, rows []int, decayed bool) {
+	for k, row := range rows {
+		s.params = append(s.params, param.RawRowView(row))
+		s.grads = append(s.grads, mat.Row(nil, k, grad))
+		s.positions = append(s.positions, s.size+row)
+		s.decayed = append(s.decayed, decayed)
 	}
 
-	i := 0
-	j := len(result) - 1
+	r, _ := param.Dims()
+	s.rowParams = append(s.rowParams, rowParam{param: param, position: s.size, decayed: decayed})
+	s.size += r
+}
 
-	for i < j {
-		result[i], result[j] = result[j], result[i]
-		i++
-		j--
+// appends the params of other, after the params of s
+func (s *optimizerStep) extend(other optimizerStep) {
+	s.params = append(s.params, other.params...)
+	s.grads = append(s.grads, other.grads...)
+	s.decayed = append(s.decayed, other.decayed...)
+	for _, position := range other.positions {
+		s.positions = append(s.positions, s.size+position)
 	}
 
-	return result, nil
+	for _, rp := range other.rowParams {
+		rp.position += s.size
+		s.rowParams = append(s.rowParams, rp)
+	}
+
+	s.size += other.size
 }
 
-func (n *Network) Update(delta []*mat.VecDense) error {
-	if err := n.updateBias(delta); err != nil {
-		return err
+func (s *optimizerStep) apply(optimizer Optimizer) {
+	if sparse, ok := optimizer.(SparseOptimizer); ok {
+		sparse.UpdateSparse(s.params, s.grads, s.positions, s.decayed)
+		return
+	}
+
+	// other optimizers take every param in order, rows without a gradient get a nil grad
+	params := make([][]float64, s.size)
+	grads := make([][]float64, s.size)
+	decayed := make([]bool, s.size)
+	for _, rp := range s.rowParams {
+		r, _ := rp.param.Dims()
+		for row := 0; row < r; row++ {
+			params[rp.position+row] = rp.param.RawRowView(row)
+			decayed[rp.position+row] = rp.decayed
+		}
+	}
+
+	for idx, position := range s.positions {
+		params[position], grads[position], decayed[position] = s.params[idx], s.grads[idx], s.decayed[idx]
 	}
 
-	return n.updateWeights(delta)
+	if decaying, ok := optimizer.(DecayingOptimizer); ok {
+		decaying.UpdateDecayed(params, grads, decayed)
+		return
+	}
+
+	optimizer.Update(params, grads)
 }
 
-func (n *Network) updateBias(delta []*mat.VecDense) error {
-	var deltaIndex int
-	learningRate := float64(.01)
+// fails when loss is a ValidatedLoss with an invalid config
+func validateLoss(loss Loss) error {
+	if validated, ok := loss.(ValidatedLoss); ok {
+		return validated.Validate()
+	}
 
-	for biasIndex := 1; biasIndex < len(n.Bias); biasIndex++ {
-		deltaIndex = biasIndex - 1
-		scaledDelta := mat.VecDenseCopyOf(delta[deltaIndex])
-		nodefuncs.ApplyFunc(scaledDelta, func(x float64, _ mat.Vector) float64 { return x * learningRate })
+	return nil
+}
 
-		n.Bias[biasIndex].SubVec(n.Bias[biasIndex], scaledDelta)
+func setLearningRate(optimizer Optimizer, rate float64) error {
+	scheduled, ok := optimizer.(ScheduledOptimizer)
+	if !ok {
+		return fmt.Errorf("optimizer %T does not support learning rate changes", optimizer)
 	}
 
+	scheduled.SetLearningRate(rate)
+
 	return nil
 }
 
-func (n *Network) updateWeights(delta []*mat.VecDense) error {
-	learningRate := float64(.01)
+// contiguous backing data of m, copying only when m is a strided view
+func denseData(m *mat.Dense) []float64 {
+	raw := m.RawMatrix()
+	if raw.Stride != raw.Cols {
+		raw = mat.DenseCopyOf(m).RawMatrix()
+	}
 
-	for weightIndex := 0; weightIndex < len(n.Weights); weightIndex++ {
-		prevActivation := n.Activation[weightIndex]
-		curDelta := mat.VecDenseCopyOf(delta[weightIndex])
-		nodefuncs.ApplyFunc(curDelta, func(x float64, _ mat.Vector) float64 { return x * learningRate })
+	return raw.Data
+}
 
-		weightDelta := mat.NewDense(curDelta.Len(), prevActivation.Len(), nil)
-		weightDelta.Mul(curDelta, prevActivation.TVec())
-		n.Weights[weightIndex].Sub(n.Weights[weightIndex], weightDelta)
+func vecData(v *mat.VecDense) []float64 {
+	raw := v.RawVector()
+	if raw.Inc != 1 {
+		raw = mat.VecDenseCopyOf(v).RawVector()
 	}
 
-	return nil
+	return raw.Data
 }
@@ -1,13 +1,21 @@
 package neuralnet_test
 
 import (
+	"math"
+	"math/rand"
 	"testing"
 
 	//"fmt"
 
 	"github.com/sclevine/spec"
 	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/initializers"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
 	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/dwillist/summerschool/v2/neuralnet/sparse"
 	"gonum.org/v1/gonum/mat"
 
 	. "github.com/onsi/gomega"
@@ -23,6 +31,47 @@ func (t TestFunc) CalcDiff(_ float64, _ mat.Vector) float64 {
 	return float64(1)
 }
 
+type recordingInitializer struct {
+	calls [][3]int
+}
+
+func (r *recordingInitializer) Initialize(values []float64, fanIn, fanOut, layer int, _ *rand.Rand) {
+	r.calls = append(r.calls, [3]int{fanIn, fanOut, layer})
+	for idx := range values {
+		values[idx] = float64(layer)
+	}
+}
+
+type recordingOptimizer struct {
+	params [][]float64
+	grads  [][]float64
+}
+
+func (r *recordingOptimizer) Update(params, grads [][]float64) {
+	r.params = params
+	r.grads = grads
+}
+
+type decayingOptimizer struct {
+	recordingOptimizer
+	decayed []bool
+}
+
+func (d *decayingOptimizer) UpdateDecayed(params, grads [][]float64, decayed []bool) {
+	d.Update(params, grads)
+	d.decayed = decayed
+}
+
+type sparseOptimizer struct {
+	decayingOptimizer
+	positions []int
+}
+
+func (s *sparseOptimizer) UpdateSparse(params, grads [][]float64, positions []int, decayed []bool) {
+	s.UpdateDecayed(params, grads, decayed)
+	s.positions = positions
+}
+
 func testNetwork(t *testing.T, context spec.G, it spec.S) {
 	var (
 		Expect = NewWithT(t).Expect
@@ -76,6 +125,75 @@ func testNetwork(t *testing.T, context spec.G, it spec.S) {
 
 		})
 
+		context("when initializers are configured", func() {
+			it("passes layer shapes and applies per-layer overrides", func() {
+				weightInit := &recordingInitializer{}
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{
+							Size: 4,
+						},
+						{
+							Size: 3,
+							Func: nodefuncs.Sigmoid{},
+						},
+						{
+							Size:       2,
+							Func:       nodefuncs.Sigmoid{},
+							WeightInit: initializers.Constant{Value: 0.5},
+							BiasInit:   initializers.Constant{Value: -1},
+						},
+					},
+					WeightInit: weightInit,
+					BiasInit:   initializers.Constant{Value: 0.1},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(weightInit.calls).To(Equal([][3]int{{4, 3, 1}}))
+				Expect(network.Weights[0]).To(Equal(mat.NewDense(3, 4, []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1})))
+				Expect(network.Weights[1]).To(Equal(mat.NewDense(2, 3, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5})))
+
+				Expect(network.Bias[0]).To(Equal(mat.NewVecDense(4, nil)))
+				Expect(network.Bias[1]).To(Equal(mat.NewVecDense(3, []float64{0.1, 0.1, 0.1})))
+				Expect(network.Bias[2]).To(Equal(mat.NewVecDense(2, []float64{-1, -1})))
+			})
+
+			it("fills biases with an orthogonal initializer", func() {
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{Size: 3},
+						{Size: 2, Func: nodefuncs.Sigmoid{}},
+					},
+					BiasInit: initializers.NewOrthogonal(),
+					Source:   rand.NewSource(1),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(mat.Norm(network.Bias[1], 2)).To(BeNumerically("~", 1, 1e-10))
+			})
+
+			it("defaults to Xavier uniform weights and zero biases", func() {
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{
+							Size: 6,
+						},
+						{
+							Size: 4,
+							Func: nodefuncs.Sigmoid{},
+						},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, val := range network.Weights[0].RawMatrix().Data {
+					Expect(val).To(BeNumerically("~", 0, 1))
+					Expect(val).NotTo(Equal(float64(0)))
+				}
+				Expect(network.Bias[1]).To(Equal(mat.NewVecDense(4, nil)))
+			})
+		})
+
 		context("failure cases", func() {
 			it("when config has no entries", func() {
 				_, err := neuralnet.NewNetwork(neuralnet.Config{})
@@ -93,6 +211,44 @@ func testNetwork(t *testing.T, context spec.G, it spec.S) {
 				})
 				Expect(err).To(MatchError("invalid layer size: 0"))
 			})
+
+			it("when the input layer has a Func", func() {
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{
+							Size: 1,
+							Func: nodefuncs.Sigmoid{},
+						},
+					},
+				})
+				Expect(err).To(MatchError("the input layer does not support a Func"))
+				Expect(network).To(Equal(neuralnet.Network{}))
+			})
+
+			it("when an input shape has no input layer", func() {
+				_, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{{Size: 2}},
+					InputShape:   neuralnet.Shape{2},
+				})
+				Expect(err).To(MatchError("an input shape requires an input layer"))
+			})
+
+			it("when the input layer output does not match the first layer", func() {
+				_, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{{Size: 5}},
+					InputLayer:   layers.NewEmbedding(4, 2, nil, rand.New(rand.NewSource(1))),
+					InputShape:   neuralnet.Shape{2},
+				})
+				Expect(err).To(MatchError("invalid input layer output size: 4, expected 5"))
+			})
+
+			it("when the loss is invalid", func() {
+				_, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{{Size: 2}},
+					Loss:         losses.Huber{Delta: -1},
+				})
+				Expect(err).To(MatchError("invalid huber delta: -1"))
+			})
 		})
 	})
 
@@ -179,6 +335,20 @@ func testNetwork(t *testing.T, context spec.G, it spec.S) {
 
 			Expect(output.AtVec(0)).To(BeNumerically("~", 14))
 		})
+
+		context("when a layer uses a VectorFunc", func() {
+			it("applies the activation to the whole layer", func() {
+				// the Activation layer follows the Dense layer
+				network.Layers[network.LayerIndex(1)+1].(*neuralnet.Activation).Func = nodefuncs.Softmax{}
+
+				_, err := network.Calculate(mat.NewVecDense(3, []float64{1, 2, 3}))
+				Expect(err).NotTo(HaveOccurred())
+
+				// Zval of (8, 14)
+				Expect(network.Activation[1].AtVec(0)).To(BeNumerically("~", 1/(1+math.Exp(6))))
+				Expect(network.Activation[1].AtVec(1)).To(BeNumerically("~", 1/(1+math.Exp(-6))))
+			})
+		})
 	})
 	context("GenerateDelta", func() {
 		var network neuralnet.Network
@@ -192,7 +362,7 @@ func testNetwork(t *testing.T, context spec.G, it spec.S) {
 						},
 						{
 							Size: 3,
-							Func: TestFunc{},
+							Func: nodefuncs.Identity{},
 						},
 					},
 					WeightInit: neuralnet.InitOne,
@@ -202,8 +372,10 @@ func testNetwork(t *testing.T, context spec.G, it spec.S) {
 				Expect(len(network.Activation)).To(Equal(2))
 				Expect(len(network.Zval)).To(Equal(2))
 
-				network.Activation[1] = mat.NewVecDense(3, []float64{1, 2, 3})
-				network.Zval[1] = mat.NewVecDense(3, []float64{0, 0, 0})
+				network.Weights[0].SetCol(0, []float64{1, 2, 3})
+				_, err = network.Calculate(mat.NewVecDense(4, []float64{1, 0, 0, 0}))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(network.Activation[1].RawVector().Data).To(Equal([]float64{1, 2, 3}))
 			})
 			it("Calculates delta matrix", func() {
 				delta, err := network.GenerateDelta(mat.NewVecDense(3, []float64{0, 0, 0}))
@@ -214,6 +386,119 @@ func testNetwork(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("when the loss pairs with the output layer function", func() {
+			it.Before(func() {
+				var err error
+				network, err = neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{
+							Size: 2,
+						},
+						{
+							Size: 2,
+							Func: nodefuncs.Sigmoid{},
+						},
+					},
+					WeightInit: neuralnet.InitOne,
+					Loss:       losses.BinaryCrossEntropy{},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				// activations of (0.25, 0.5)
+				network.Bias[1].SetVec(0, math.Log(1.0/3))
+				_, err = network.Calculate(mat.NewVecDense(2, nil))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("uses the paired output delta", func() {
+				delta, err := network.GenerateDelta(mat.NewVecDense(2, []float64{1, 0}))
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(delta[0].AtVec(0)).To(BeNumerically("~", -0.75, 1e-12))
+				Expect(delta[0].AtVec(1)).To(Equal(0.5))
+			})
+		})
+
+		context("for networks with non-linear hidden layers", func() {
+			it.Before(func() {
+				var err error
+				network, err = neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{
+							Size: 2,
+						},
+						{
+							Size: 2,
+							Func: nodefuncs.Sigmoid{},
+						},
+						{
+							Size: 2,
+							Func: nodefuncs.Sigmoid{},
+						},
+					},
+					WeightInit: neuralnet.InitOne,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("includes activation derivatives in every layer", func() {
+				_, err := network.Calculate(mat.NewVecDense(2, []float64{1, -1}))
+				Expect(err).NotTo(HaveOccurred())
+
+				delta, err := network.GenerateDelta(mat.NewVecDense(2, []float64{1, 0}))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(delta).To(HaveLen(2))
+
+				var sigmoid nodefuncs.Sigmoid
+				output := sigmoid.CalcVal(1, nil)
+				outputDelta := []float64{
+					(output - 1) * sigmoid.CalcDiff(1, nil),
+					output * sigmoid.CalcDiff(1, nil),
+				}
+				Expect(delta[1].AtVec(0)).To(BeNumerically("~", outputDelta[0]))
+				Expect(delta[1].AtVec(1)).To(BeNumerically("~", outputDelta[1]))
+
+				hiddenDelta := (outputDelta[0] + outputDelta[1]) * sigmoid.CalcDiff(0, nil)
+				Expect(delta[0].AtVec(0)).To(BeNumerically("~", hiddenDelta))
+				Expect(delta[0].AtVec(1)).To(BeNumerically("~", hiddenDelta))
+			})
+
+			it("matches finite differences of the loss", func() {
+				input := mat.NewVecDense(2, []float64{0.3, -0.7})
+				solution := mat.NewVecDense(2, []float64{1, 0})
+				network.Weights[0].Set(0, 1, -0.4)
+				network.Weights[1].Set(1, 0, 0.6)
+
+				report, err := gradcheck.Check(&network, input, solution, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Max()).To(BeNumerically("<", 1e-6))
+			})
+		})
+
+		context("when the solution has the wrong dimension", func() {
+			it.Before(func() {
+				var err error
+				network, err = neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{
+							Size: 2,
+						},
+						{
+							Size: 2,
+							Func: TestFunc{},
+						},
+					},
+					WeightInit: neuralnet.InitOne,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("returns an error", func() {
+				_, err := network.GenerateDelta(mat.NewVecDense(3, nil))
+				Expect(err).To(MatchError("invalid solution dimension: 3, expected 2"))
+			})
+		})
+
 		context("for multi layered networks", func() {
 			it.Before(func() {
 				var err error
@@ -243,22 +528,192 @@ func testNetwork(t *testing.T, context spec.G, it spec.S) {
 				Expect(len(network.Activation)).To(Equal(4))
 				Expect(len(network.Zval)).To(Equal(4))
 
-				network.Activation[1] = mat.NewVecDense(4, []float64{1, 2, 3, 4})
-				network.Zval[1] = mat.NewVecDense(4, []float64{0, 0, 0, 0})
-
-				network.Activation[2] = mat.NewVecDense(3, []float64{1, 2, 3})
-				network.Zval[2] = mat.NewVecDense(3, []float64{0, 0, 0})
-
-				network.Activation[3] = mat.NewVecDense(2, []float64{1, 2})
-				network.Zval[3] = mat.NewVecDense(2, []float64{0, 0})
+				// TestFunc activates every unit with 1 and has a derivative of 1
+				_, err := network.Calculate(mat.NewVecDense(5, nil))
+				Expect(err).NotTo(HaveOccurred())
 
 				delta, err := network.GenerateDelta(mat.NewVecDense(2, []float64{0, 0}))
 
 				Expect(err).NotTo(HaveOccurred())
 				Expect(len(delta)).To(Equal(3))
-				Expect(delta[0].RawVector().Data).To(Equal([]float64{9, 9, 9, 9}))
-				Expect(delta[1].RawVector().Data).To(Equal([]float64{3, 3, 3}))
-				Expect(delta[2].RawVector().Data).To(Equal([]float64{1, 2}))
+				Expect(delta[0].RawVector().Data).To(Equal([]float64{6, 6, 6, 6}))
+				Expect(delta[1].RawVector().Data).To(Equal([]float64{2, 2, 2}))
+				Expect(delta[2].RawVector().Data).To(Equal([]float64{1, 1}))
+			})
+		})
+	})
+
+	context("Training", func() {
+		var (
+			inputs    []*mat.VecDense
+			solutions []*mat.VecDense
+		)
+
+		it.Before(func() {
+			inputs = nil
+			solutions = nil
+
+			for i := 0; i < 10; i++ {
+				for j := 0; j < 10; j++ {
+					x, y := float64(i)/9, float64(j)/9
+					inputs = append(inputs, mat.NewVecDense(2, []float64{x, y}))
+
+					if x+y < 1 {
+						solutions = append(solutions, mat.NewVecDense(2, []float64{1, 0}))
+					} else {
+						solutions = append(solutions, mat.NewVecDense(2, []float64{0, 1}))
+					}
+				}
+			}
+		})
+
+		it("trains a 2-2-2 sigmoid network", func() {
+			network, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{
+						Size: 2,
+					},
+					{
+						Size: 2,
+						Func: nodefuncs.Sigmoid{},
+					},
+					{
+						Size: 2,
+						Func: nodefuncs.Sigmoid{},
+					},
+				},
+				WeightInit: neuralnet.InitRandom,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			totalLoss := func() float64 {
+				result := float64(0)
+				for idx, input := range inputs {
+					_, err := network.Calculate(input)
+					Expect(err).NotTo(HaveOccurred())
+					loss, err := network.CalcLoss(solutions[idx])
+					Expect(err).NotTo(HaveOccurred())
+					result += loss
+				}
+				return result
+			}
+
+			initialLoss := totalLoss()
+
+			for epoch := 0; epoch < 1000; epoch++ {
+				for idx, input := range inputs {
+					_, err := network.Calculate(input)
+					Expect(err).NotTo(HaveOccurred())
+					delta, err := network.GenerateDelta(solutions[idx])
+					Expect(err).NotTo(HaveOccurred())
+					gradient, err := network.GenerateGradient(delta)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(network.Update(gradient)).To(Succeed())
+				}
+			}
+
+			Expect(totalLoss()).To(BeNumerically("<", initialLoss/4))
+
+			correct := 0
+			for idx, input := range inputs {
+				output, err := network.Calculate(input)
+				Expect(err).NotTo(HaveOccurred())
+				if (output.AtVec(0) > output.AtVec(1)) == (solutions[idx].AtVec(0) > solutions[idx].AtVec(1)) {
+					correct++
+				}
+			}
+			Expect(correct).To(BeNumerically(">", 90))
+		})
+
+		it("trains with the configured optimizer", func() {
+			network, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{
+						Size: 2,
+					},
+					{
+						Size: 2,
+						Func: nodefuncs.Sigmoid{},
+					},
+					{
+						Size: 2,
+						Func: nodefuncs.Sigmoid{},
+					},
+				},
+				WeightInit: neuralnet.InitRandom,
+				Optimizer:  optimizers.NewAdam(0.05),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			for epoch := 0; epoch < 50; epoch++ {
+				for idx, input := range inputs {
+					_, err := network.Calculate(input)
+					Expect(err).NotTo(HaveOccurred())
+					delta, err := network.GenerateDelta(solutions[idx])
+					Expect(err).NotTo(HaveOccurred())
+					gradient, err := network.GenerateGradient(delta)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(network.Update(gradient)).To(Succeed())
+				}
+			}
+
+			correct := 0
+			for idx, input := range inputs {
+				output, err := network.Calculate(input)
+				Expect(err).NotTo(HaveOccurred())
+				if (output.AtVec(0) > output.AtVec(1)) == (solutions[idx].AtVec(0) > solutions[idx].AtVec(1)) {
+					correct++
+				}
+			}
+			Expect(correct).To(BeNumerically(">", 90))
+		})
+	})
+
+	context("CalcLoss", func() {
+		var network neuralnet.Network
+		it.Before(func() {
+			var err error
+			network, err = neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{
+						Size: 2,
+					},
+					{
+						Size: 2,
+						Func: nodefuncs.Identity{},
+					},
+				},
+				WeightInit: neuralnet.InitOne,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("defaults to MSE", func() {
+			Expect(network.Loss).To(Equal(losses.MSE{}))
+
+			_, err := network.Calculate(mat.NewVecDense(2, []float64{1, 2}))
+			Expect(err).NotTo(HaveOccurred())
+
+			loss, err := network.CalcLoss(mat.NewVecDense(2, []float64{1, 3}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loss).To(BeNumerically("~", 2))
+		})
+
+		it("uses the configured loss", func() {
+			network.Loss = losses.Hinge{}
+
+			_, err := network.Calculate(mat.NewVecDense(2, []float64{0, 0.25}))
+			Expect(err).NotTo(HaveOccurred())
+
+			loss, err := network.CalcLoss(mat.NewVecDense(2, []float64{1, 0}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loss).To(BeNumerically("~", 0.75+1.25))
+		})
+
+		context("failure cases", func() {
+			it("when solution has the wrong dimension", func() {
+				_, err := network.CalcLoss(mat.NewVecDense(3, nil))
+				Expect(err).To(MatchError("invalid solution dimension: 3, expected 2"))
 			})
 		})
 	})
@@ -292,13 +747,16 @@ func testNetwork(t *testing.T, context spec.G, it spec.S) {
 				delta = append(delta, mat.NewVecDense(3, []float64{1, 2, 3}))
 				delta = append(delta, mat.NewVecDense(2, []float64{1, 2}))
 
-				network.Activation[0] = mat.NewVecDense(4, []float64{1, 1, 1, 1})
-				network.Activation[1] = mat.NewVecDense(3, []float64{1, 1, 1})
-				network.Activation[2] = mat.NewVecDense(2, []float64{1, 1})
+				// TestFunc activates every unit with 1
+				_, err = network.Calculate(mat.NewVecDense(4, []float64{1, 1, 1, 1}))
+				Expect(err).NotTo(HaveOccurred())
 			})
 
 			it("succeeds", func() {
-				Expect(network.Update(delta)).To(Succeed())
+				gradient, err := network.GenerateGradient(delta)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(network.Update(gradient)).To(Succeed())
 				Expect(network.Bias).To(HaveLen(3))
 
 				Expect(network.Bias[1]).To(Equal(mat.NewVecDense(3, []float64{-0.01, -0.02, -0.03})))
@@ -317,6 +775,314 @@ func testNetwork(t *testing.T, context spec.G, it spec.S) {
 				}),
 				))
 			})
+
+			it("uses the learning rate set with SetLearningRate", func() {
+				Expect(network.SetLearningRate(0.5)).To(Succeed())
+
+				gradient, err := network.GenerateGradient(delta)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(network.Update(gradient)).To(Succeed())
+
+				Expect(network.Bias[2]).To(Equal(mat.NewVecDense(2, []float64{-0.5, -1})))
+
+				network.Optimizer = &recordingOptimizer{}
+				Expect(network.SetLearningRate(0.5)).To(MatchError("optimizer *neuralnet_test.recordingOptimizer does not support learning rate changes"))
+			})
+
+			it("passes flattened parameters to the optimizer", func() {
+				optimizer := &recordingOptimizer{}
+				network.Optimizer = optimizer
+
+				gradient, err := network.GenerateGradient(delta)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(network.Update(gradient)).To(Succeed())
+
+				Expect(optimizer.params).To(HaveLen(4))
+				Expect(optimizer.params[0]).To(HaveLen(12))
+				Expect(optimizer.params[1]).To(HaveLen(3))
+				Expect(optimizer.params[2]).To(HaveLen(6))
+				Expect(optimizer.params[3]).To(HaveLen(2))
+
+				Expect(optimizer.grads[1]).To(Equal([]float64{1, 2, 3}))
+				Expect(optimizer.grads[3]).To(Equal([]float64{1, 2}))
+
+				optimizer.params[3][1] = 5
+				Expect(network.Bias[2].AtVec(1)).To(Equal(float64(5)))
+			})
+
+			it("only marks weight matrices as decayed", func() {
+				optimizer := &decayingOptimizer{}
+				network.Optimizer = optimizer
+
+				gradient, err := network.GenerateGradient(delta)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(network.Update(gradient)).To(Succeed())
+
+				Expect(optimizer.params).To(HaveLen(4))
+				Expect(optimizer.decayed).To(Equal([]bool{true, false, true, false}))
+			})
+
+			context("failure cases", func() {
+				it("when the gradient does not match the network", func() {
+					err := network.Update(neuralnet.Gradient{})
+					Expect(err).To(MatchError("invalid gradient dimension: 0 layers, expected 4"))
+				})
+
+				it("when a gradient has the wrong shape", func() {
+					err := network.Update(neuralnet.Gradient{
+						Layers: [][]*mat.Dense{
+							{mat.NewDense(3, 4, nil), mat.NewDense(3, 1, nil)},
+							nil,
+							{mat.NewDense(3, 2, nil), mat.NewDense(2, 1, nil)},
+							nil,
+						},
+					})
+					Expect(err).To(MatchError("invalid gradient dimension at layer 2: 3x2, expected 2x3"))
+				})
+			})
+		})
+	})
+
+	context("GenerateGradient", func() {
+		var network neuralnet.Network
+		it.Before(func() {
+			var err error
+			network, err = neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{
+						Size: 2,
+					},
+					{
+						Size: 3,
+						Func: TestFunc{},
+					},
+				},
+				WeightInit: neuralnet.InitOne,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = network.Calculate(mat.NewVecDense(2, []float64{1, 2}))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("calculates weight and bias gradients without updating the network", func() {
+			gradient, err := network.GenerateGradient([]*mat.VecDense{mat.NewVecDense(3, []float64{1, 2, 3})})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(gradient.Layers).To(HaveLen(2))
+			Expect(gradient.Layers[0][0]).To(Equal(mat.NewDense(3, 2, []float64{
+				1, 2,
+				2, 4,
+				3, 6,
+			})))
+			Expect(gradient.Layers[0][1]).To(Equal(mat.NewDense(3, 1, []float64{1, 2, 3})))
+			Expect(gradient.Layers[1]).To(BeEmpty())
+
+			Expect(network.Weights[0]).To(Equal(mat.NewDense(3, 2, []float64{1, 1, 1, 1, 1, 1})))
+		})
+
+		context("failure cases", func() {
+			it("when delta count does not match the network", func() {
+				_, err := network.GenerateGradient(nil)
+				Expect(err).To(MatchError("invalid delta count: 0, expected 1"))
+			})
+		})
+	})
+
+	context("Gradient", func() {
+		var gradient neuralnet.Gradient
+		it.Before(func() {
+			gradient = neuralnet.Gradient{}
+		})
+
+		it("accumulates and scales gradients", func() {
+			other := neuralnet.Gradient{
+				Layers: [][]*mat.Dense{{mat.NewDense(1, 2, []float64{1, 2}), mat.NewDense(1, 1, []float64{3})}},
+			}
+
+			gradient.Add(other)
+			gradient.Add(other)
+			gradient.Scale(0.5)
+
+			Expect(gradient.Layers[0][0]).To(Equal(mat.NewDense(1, 2, []float64{1, 2})))
+			Expect(gradient.Layers[0][1]).To(Equal(mat.NewDense(1, 1, []float64{3})))
+
+			Expect(other.Layers[0][0]).To(Equal(mat.NewDense(1, 2, []float64{1, 2})))
+		})
+	})
+
+	context("Sparse weights", func() {
+		var (
+			network neuralnet.Network
+			dense   neuralnet.Network
+			input   *mat.VecDense
+		)
+
+		newNetwork := func() neuralnet.Network {
+			result, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{
+						Size: 4,
+					},
+					{
+						Size: 3,
+						Func: nodefuncs.Sigmoid{},
+					},
+					{
+						Size: 2,
+						Func: nodefuncs.Softmax{},
+					},
+				},
+				Loss:   losses.CrossEntropy{},
+				Source: rand.NewSource(3),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			result.Weights[0].Set(0, 1, 0)
+			result.Weights[0].Set(2, 3, 0)
+			result.Weights[1].Set(1, 0, 0)
+
+			return result
+		}
+
+		it.Before(func() {
+			network = newNetwork()
+			dense = newNetwork()
+
+			Expect(network.Sparsify(0, 0)).To(Succeed())
+			Expect(network.Sparsify(1, 0)).To(Succeed())
+
+			input = mat.NewVecDense(4, []float64{0, 0.5, 0, -1})
+		})
+
+		it("drops zero weights", func() {
+			Expect(network.Weights).To(Equal([]*mat.Dense{nil, nil}))
+			Expect(network.SparseWeights[0].NNZ()).To(Equal(10))
+			Expect(network.SparseWeights[1].NNZ()).To(Equal(5))
+			Expect(network.SparseWeights[0].ToDense()).To(Equal(dense.Weights[0]))
+		})
+
+		it("calculates the same outputs and gradients as dense weights", func() {
+			solution := mat.NewVecDense(2, []float64{0, 1})
+
+			expected, err := dense.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+			expectedDelta, err := dense.GenerateDelta(solution)
+			Expect(err).NotTo(HaveOccurred())
+			expectedGradient, err := dense.GenerateGradient(expectedDelta)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, calculate := range []func() (*mat.VecDense, error){
+				func() (*mat.VecDense, error) { return network.Calculate(input) },
+				func() (*mat.VecDense, error) { return network.CalculateSparse(sparse.VectorFromDense(input, 0)) },
+				func() (*mat.VecDense, error) { return dense.CalculateSparse(sparse.VectorFromDense(input, 0)) },
+			} {
+				output, err := calculate()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mat.EqualApprox(output, expected, 1e-12)).To(BeTrue())
+			}
+
+			delta, err := dense.GenerateDelta(solution)
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err := dense.GenerateGradient(delta)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(equalGradients(gradient, expectedGradient, 1e-12)).To(BeTrue())
+
+			delta, err = network.GenerateDelta(solution)
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err = network.GenerateGradient(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			for idx, weights := range network.SparseWeights {
+				expectedWeights, expectedBias := denseGradient(dense, expectedGradient, idx+1)
+				sparseGradient, bias := denseGradient(network, gradient, idx+1)
+
+				// the gradients of the stored weights, in the order of their values
+				var stored []float64
+				r, c := weights.Dims()
+				for i := 0; i < r; i++ {
+					for j := 0; j < c; j++ {
+						if dense.Weights[idx].At(i, j) != 0 {
+							stored = append(stored, expectedWeights.At(i, j))
+						}
+					}
+				}
+
+				Expect(mat.EqualApprox(sparseGradient, mat.NewDense(1, len(stored), stored), 1e-12)).To(BeTrue())
+				Expect(mat.EqualApprox(bias, expectedBias, 1e-12)).To(BeTrue())
+			}
+		})
+
+		it("keeps pruned weights at zero when updating", func() {
+			_, err := network.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := network.GenerateDelta(mat.NewVecDense(2, []float64{1, 0}))
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err := network.GenerateGradient(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			before := network.SparseWeights[0].Clone()
+			Expect(network.Update(gradient)).To(Succeed())
+
+			Expect(network.SparseWeights[0].NNZ()).To(Equal(10))
+			Expect(network.SparseWeights[0].At(0, 1)).To(Equal(0.0))
+			Expect(network.SparseWeights[0].Data).NotTo(Equal(before.Data))
+		})
+
+		it("matches per sample gradients when batched", func() {
+			inputs := mat.NewDense(4, 2, []float64{
+				0, 1,
+				0.5, 0,
+				0, -0.5,
+				-1, 0.25,
+			})
+			solutions := mat.NewDense(2, 2, []float64{
+				0, 1,
+				1, 0,
+			})
+
+			var expected neuralnet.Gradient
+			for j := 0; j < 2; j++ {
+				_, err := network.Calculate(mat.VecDenseCopyOf(inputs.ColView(j)))
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := network.GenerateDelta(mat.VecDenseCopyOf(solutions.ColView(j)))
+				Expect(err).NotTo(HaveOccurred())
+				gradient, err := network.GenerateGradient(delta)
+				Expect(err).NotTo(HaveOccurred())
+				expected.Add(gradient)
+			}
+
+			_, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := network.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err := network.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(equalGradients(gradient, expected, 1e-12)).To(BeTrue())
+		})
+
+		context("failure cases", func() {
+			it("when the weight index is invalid", func() {
+				Expect(network.Sparsify(2, 0)).To(MatchError("invalid weight index: 2"))
+			})
+
+			it("when the gradient is dense", func() {
+				err := network.Update(neuralnet.Gradient{
+					Layers: [][]*mat.Dense{
+						{mat.NewDense(3, 4, nil), mat.NewDense(3, 1, nil)},
+						nil,
+						{mat.NewDense(2, 3, nil), mat.NewDense(2, 1, nil)},
+						nil,
+					},
+				})
+				Expect(err).To(MatchError("invalid gradient dimension at layer 0: 3x4, expected 1x10"))
+			})
+
+			it("when the input has the wrong size", func() {
+				_, err := network.CalculateSparse(sparse.NewVector(3, nil, nil))
+				Expect(err).To(MatchError("invalid input size: 3"))
+			})
 		})
 	})
 }
@@ -9,24 +9,133 @@ import (
 ///
 /// Softmax
 ///
+// Softmax implements both the scalar and vector activation interfaces,
+// the vector methods compute the whole layer in O(n)
 type Softmax struct{}
 
 func (s Softmax) CalcVal(x float64, v mat.Vector) float64 {
-	num := math.Exp(x)
+	max := vecMax(v)
+	num := math.Exp(x - max)
 	denom := float64(0)
 
 	for idx := 0; idx < v.Len(); idx++ {
-		denom += math.Exp(v.AtVec(idx))
+		denom += math.Exp(v.AtVec(idx) - max)
 	}
 
 	return num / denom
 }
 
+// diagonal of the Softmax Jacobian
 func (s Softmax) CalcDiff(x float64, v mat.Vector) float64 {
 	sMax := s.CalcVal(x, v)
 	return sMax * (1 - sMax)
 }
 
+func (s Softmax) Apply(dst, z *mat.VecDense) {
+	max := vecMax(z)
+	sum := float64(0)
+
+	for idx := 0; idx < z.Len(); idx++ {
+		val := math.Exp(z.AtVec(idx) - max)
+		dst.SetVec(idx, val)
+		sum += val
+	}
+
+	dst.ScaleVec(1/sum, dst)
+}
+
+// J[i][j] = s_i * (δ_ij - s_j)
+func (s Softmax) Jacobian(z *mat.VecDense) *mat.Dense {
+	a := mat.NewVecDense(z.Len(), nil)
+	s.Apply(a, z)
+
+	result := mat.NewDense(z.Len(), z.Len(), nil)
+	result.Outer(-1, a, a)
+
+	for idx := 0; idx < z.Len(); idx++ {
+		result.Set(idx, idx, result.At(idx, idx)+a.AtVec(idx))
+	}
+
+	return result
+}
+
+// dst = s ⊙ (grad - (grad · s)) where a holds the Softmax of z
+func (s Softmax) BackpropVec(dst, z, a, grad *mat.VecDense) {
+	dot := mat.Dot(grad, a)
+
+	for idx := 0; idx < z.Len(); idx++ {
+		dst.SetVec(idx, a.AtVec(idx)*(grad.AtVec(idx)-dot))
+	}
+}
+
+///
+/// LogSoftmax
+///
+type LogSoftmax struct{}
+
+func (l LogSoftmax) CalcVal(x float64, v mat.Vector) float64 {
+	return x - logSumExp(v)
+}
+
+// diagonal of the LogSoftmax Jacobian
+func (l LogSoftmax) CalcDiff(x float64, v mat.Vector) float64 {
+	return 1 - math.Exp(x-logSumExp(v))
+}
+
+func (l LogSoftmax) Apply(dst, z *mat.VecDense) {
+	lse := logSumExp(z)
+
+	for idx := 0; idx < z.Len(); idx++ {
+		dst.SetVec(idx, z.AtVec(idx)-lse)
+	}
+}
+
+// J[i][j] = δ_ij - s_j
+func (l LogSoftmax) Jacobian(z *mat.VecDense) *mat.Dense {
+	lse := logSumExp(z)
+	result := mat.NewDense(z.Len(), z.Len(), nil)
+
+	for i := 0; i < z.Len(); i++ {
+		for j := 0; j < z.Len(); j++ {
+			result.Set(i, j, -math.Exp(z.AtVec(j)-lse))
+		}
+
+		result.Set(i, i, result.At(i, i)+1)
+	}
+
+	return result
+}
+
+// dst = grad - s * sum(grad) where s = exp(a)
+func (l LogSoftmax) BackpropVec(dst, z, a, grad *mat.VecDense) {
+	sum := mat.Sum(grad)
+
+	for idx := 0; idx < z.Len(); idx++ {
+		dst.SetVec(idx, grad.AtVec(idx)-math.Exp(a.AtVec(idx))*sum)
+	}
+}
+
+func vecMax(v mat.Vector) float64 {
+	result := math.Inf(-1)
+
+	for idx := 0; idx < v.Len(); idx++ {
+		result = math.Max(result, v.AtVec(idx))
+	}
+
+	return result
+}
+
+func logSumExp(v mat.Vector) float64 {
+	max := vecMax(v)
+	sum := float64(0)
+
+	for idx := 0; idx < v.Len(); idx++ {
+		sum += math.Exp(v.AtVec(idx) - max)
+	}
+
+	return max + math.Log(sum)
+}
+
 ///
 /// Relu Def
 ///
@@ -68,12 +177,15 @@ func (s Identity) CalcVal(x float64, _ mat.Vector) float64 {
 }
 
 func (s Identity) CalcDiff(x float64, _ mat.Vector) float64 {
-	return float64(0)
+	return float64(1)
 }
 
+// nodefunc always receives the original, unmodified vector
 func ApplyFunc(vec *mat.VecDense, nodefunc func(float64, mat.Vector) float64) {
+	original := mat.VecDenseCopyOf(vec)
+
 	r := vec.Len()
 	for rowIdx := 0; rowIdx < r; rowIdx++ {
-		vec.SetVec(rowIdx, nodefunc(vec.AtVec(rowIdx), vec))
+		vec.SetVec(rowIdx, nodefunc(original.AtVec(rowIdx), original))
 	}
 }
@@ -1,6 +1,7 @@
 package nodefuncs_test
 
 import (
+	"math"
 	"testing"
 
 	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
@@ -52,6 +53,87 @@ func testNodeFuncs(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("Softmax vector methods", func() {
+			var softMax nodefuncs.Softmax
+
+			it("applies in a single pass", func() {
+				z := mat.NewVecDense(3, []float64{2, 1, 0.1})
+				dst := mat.NewVecDense(3, nil)
+				softMax.Apply(dst, z)
+
+				Expect(dst.AtVec(0)).To(BeNumerically("~", 0.6590011388859679))
+				Expect(dst.AtVec(1)).To(BeNumerically("~", 0.2424329707047139))
+				Expect(dst.AtVec(2)).To(BeNumerically("~", 0.09856589040931818))
+			})
+
+			it("is stable for large logits", func() {
+				z := mat.NewVecDense(2, []float64{1000, 1000 + math.Log(3)})
+				dst := mat.NewVecDense(2, nil)
+				softMax.Apply(dst, z)
+
+				Expect(dst.AtVec(0)).To(BeNumerically("~", 0.25))
+				Expect(dst.AtVec(1)).To(BeNumerically("~", 0.75))
+				Expect(softMax.CalcVal(1000, z)).To(BeNumerically("~", 0.25))
+			})
+
+			it("computes the Jacobian and its transpose product", func() {
+				z := mat.NewVecDense(3, []float64{0.5, -1, 2})
+				a := mat.NewVecDense(3, nil)
+				softMax.Apply(a, z)
+
+				jacobian := softMax.Jacobian(z)
+				Expect(jacobian.At(0, 0)).To(BeNumerically("~", a.AtVec(0)*(1-a.AtVec(0))))
+				Expect(jacobian.At(0, 2)).To(BeNumerically("~", -a.AtVec(0)*a.AtVec(2)))
+				Expect(jacobian.At(2, 1)).To(BeNumerically("~", -a.AtVec(2)*a.AtVec(1)))
+
+				grad := mat.NewVecDense(3, []float64{1, 2, -3})
+				expected := mat.NewVecDense(3, nil)
+				expected.MulVec(jacobian.T(), grad)
+
+				dst := mat.NewVecDense(3, nil)
+				softMax.BackpropVec(dst, z, a, grad)
+				Expect(mat.EqualApprox(dst, expected, 1e-12)).To(BeTrue())
+			})
+		})
+
+		context("LogSoftmax", func() {
+			var logSoftmax nodefuncs.LogSoftmax
+
+			it("calculates scalar values consistent with Softmax", func() {
+				v := mat.NewVecDense(3, []float64{2, 1, 0.1})
+
+				Expect(logSoftmax.CalcVal(2, v)).To(BeNumerically("~", math.Log(0.6590011388859679)))
+				Expect(logSoftmax.CalcDiff(2, v)).To(BeNumerically("~", 1-0.6590011388859679))
+			})
+
+			it("applies stably for large logits", func() {
+				z := mat.NewVecDense(2, []float64{-1000, 1000})
+				dst := mat.NewVecDense(2, nil)
+				logSoftmax.Apply(dst, z)
+
+				Expect(dst.AtVec(0)).To(BeNumerically("~", -2000))
+				Expect(dst.AtVec(1)).To(BeNumerically("~", 0))
+			})
+
+			it("computes the Jacobian and its transpose product", func() {
+				z := mat.NewVecDense(3, []float64{0.5, -1, 2})
+				a := mat.NewVecDense(3, nil)
+				logSoftmax.Apply(a, z)
+
+				jacobian := logSoftmax.Jacobian(z)
+				Expect(jacobian.At(0, 0)).To(BeNumerically("~", 1-math.Exp(a.AtVec(0))))
+				Expect(jacobian.At(0, 2)).To(BeNumerically("~", -math.Exp(a.AtVec(2))))
+
+				grad := mat.NewVecDense(3, []float64{1, 2, -3})
+				expected := mat.NewVecDense(3, nil)
+				expected.MulVec(jacobian.T(), grad)
+
+				dst := mat.NewVecDense(3, nil)
+				logSoftmax.BackpropVec(dst, z, a, grad)
+				Expect(mat.EqualApprox(dst, expected, 1e-12)).To(BeTrue())
+			})
+		})
+
 		context("Relu", func() {
 			var relu nodefuncs.Relu
 
@@ -119,7 +201,10 @@ func testNodeFuncs(t *testing.T, context spec.G, it spec.S) {
 			context("CalcDiff", func() {
 				it("Calculates Correctly", func() {
 					y := id.CalcDiff(3, nil)
-					Expect(y).To(BeNumerically("~", float64(0)))
+					Expect(y).To(BeNumerically("~", float64(1)))
+
+					y = id.CalcDiff(-2, nil)
+					Expect(y).To(BeNumerically("~", float64(1)))
 				})
 			})
 		})
@@ -138,5 +223,14 @@ func testNodeFuncs(t *testing.T, context spec.G, it spec.S) {
 				Expect(val).To(BeNumerically("~", 5))
 			}
 		})
+
+		it("passes the original vector to the node function", func() {
+			vec = mat.NewVecDense(3, []float64{2, 1, 0.1})
+			nodefuncs.ApplyFunc(vec, nodefuncs.Softmax{}.CalcVal)
+
+			Expect(vec.AtVec(0)).To(BeNumerically("~", 0.6590011388859679))
+			Expect(vec.AtVec(1)).To(BeNumerically("~", 0.2424329707047139))
+			Expect(vec.AtVec(2)).To(BeNumerically("~", 0.09856589040931818))
+		})
 	})
 }
@@ -0,0 +1,14 @@
+package optimizers_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitOptimizers(t *testing.T) {
+	suite := spec.New("Optimizers", spec.Report(report.Terminal{}))
+	suite("Optimizers", testOptimizers)
+	suite.Run(t)
+}
@@ -0,0 +1,285 @@
+package optimizers
+
+import (
+	"math"
+)
+
+// Optimizers update flattened parameters in place. Every Update call is a single step,
+// per-parameter state is kept by position, so params must be passed in the same order on
+// every step. A nil grad skips its param and leaves its state untouched, and a param of a
+// different length, e.g. after sparsifying weights, starts over with fresh state.
+// UpdateSparse is Update with the position of every param given, so params without a
+// gradient can be left out, see neuralnet.SparseOptimizer.
+// Hyperparameters are used as given, so 0 means 0. The New functions fill in the usual
+// defaults for everything but the learning rate.
+// SetLearningRate changes the learning rate of the following steps, e.g. for a schedule.
+
+const (
+	defaultEpsilon = 1e-8
+)
+
+///
+/// SGD Def
+///
+// Momentum of 0 disables velocity tracking
+type SGD struct {
+	LearningRate float64
+	Momentum     float64
+	Nesterov     bool
+
+	velocity state
+}
+
+func NewSGD(learningRate float64) *SGD {
+	return &SGD{LearningRate: learningRate}
+}
+
+func (s *SGD) SetLearningRate(rate float64) {
+	s.LearningRate = rate
+}
+
+func (s *SGD) Update(params, grads [][]float64) {
+	s.UpdateSparse(params, grads, nil, nil)
+}
+
+func (s *SGD) UpdateSparse(params, grads [][]float64, positions []int, _ []bool) {
+	lr := s.LearningRate
+
+	if s.Momentum == 0 {
+		for idx, param := range params {
+			if grads[idx] == nil {
+				continue
+			}
+
+			for i, g := range grads[idx] {
+				param[i] -= lr * g
+			}
+		}
+
+		return
+	}
+
+	for idx, param := range params {
+		if grads[idx] == nil {
+			continue
+		}
+
+		velocity := s.velocity.get(position(positions, idx), param)
+
+		for i, g := range grads[idx] {
+			velocity[i] = s.Momentum*velocity[i] + g
+
+			if s.Nesterov {
+				param[i] -= lr * (g + s.Momentum*velocity[i])
+			} else {
+				param[i] -= lr * velocity[i]
+			}
+		}
+	}
+}
+
+///
+/// RMSProp Def
+///
+// Decay is the weight of the previous mean square
+type RMSProp struct {
+	LearningRate float64
+	Decay        float64
+	Epsilon      float64
+
+	meanSquare state
+}
+
+// Decay of .9 and Epsilon of 1e-8
+func NewRMSProp(learningRate float64) *RMSProp {
+	return &RMSProp{LearningRate: learningRate, Decay: .9, Epsilon: defaultEpsilon}
+}
+
+func (r *RMSProp) SetLearningRate(rate float64) {
+	r.LearningRate = rate
+}
+
+func (r *RMSProp) Update(params, grads [][]float64) {
+	r.UpdateSparse(params, grads, nil, nil)
+}
+
+func (r *RMSProp) UpdateSparse(params, grads [][]float64, positions []int, _ []bool) {
+	lr, decay, epsilon := r.LearningRate, r.Decay, r.Epsilon
+
+	for idx, param := range params {
+		if grads[idx] == nil {
+			continue
+		}
+
+		meanSquare := r.meanSquare.get(position(positions, idx), param)
+
+		for i, g := range grads[idx] {
+			meanSquare[i] = decay*meanSquare[i] + (1-decay)*g*g
+			param[i] -= lr * g / (math.Sqrt(meanSquare[i]) + epsilon)
+		}
+	}
+}
+
+///
+/// Adagrad Def
+///
+type Adagrad struct {
+	LearningRate float64
+	Epsilon      float64
+
+	sumSquare state
+}
+
+// Epsilon of 1e-8
+func NewAdagrad(learningRate float64) *Adagrad {
+	return &Adagrad{LearningRate: learningRate, Epsilon: defaultEpsilon}
+}
+
+func (a *Adagrad) SetLearningRate(rate float64) {
+	a.LearningRate = rate
+}
+
+func (a *Adagrad) Update(params, grads [][]float64) {
+	a.UpdateSparse(params, grads, nil, nil)
+}
+
+func (a *Adagrad) UpdateSparse(params, grads [][]float64, positions []int, _ []bool) {
+	lr, epsilon := a.LearningRate, a.Epsilon
+
+	for idx, param := range params {
+		if grads[idx] == nil {
+			continue
+		}
+
+		sumSquare := a.sumSquare.get(position(positions, idx), param)
+
+		for i, g := range grads[idx] {
+			sumSquare[i] += g * g
+			param[i] -= lr * g / (math.Sqrt(sumSquare[i]) + epsilon)
+		}
+	}
+}
+
+///
+/// Adam Def
+///
+// Beta1 and Beta2 are the decay rates of the first and second moment
+type Adam struct {
+	LearningRate float64
+	Beta1        float64
+	Beta2        float64
+	Epsilon      float64
+
+	step         int
+	firstMoment  state
+	secondMoment state
+}
+
+// Beta1 of .9, Beta2 of .999 and Epsilon of 1e-8
+func NewAdam(learningRate float64) *Adam {
+	return &Adam{LearningRate: learningRate, Beta1: .9, Beta2: .999, Epsilon: defaultEpsilon}
+}
+
+func (a *Adam) SetLearningRate(rate float64) {
+	a.LearningRate = rate
+}
+
+func (a *Adam) Update(params, grads [][]float64) {
+	a.update(params, grads, nil, 0, nil)
+}
+
+func (a *Adam) UpdateSparse(params, grads [][]float64, positions []int, _ []bool) {
+	a.update(params, grads, positions, 0, nil)
+}
+
+// decay is applied directly to the parameters, scaled by the learning rate, for every
+// param whose decayed entry is true or for every param when decayed is nil
+func (a *Adam) update(params, grads [][]float64, positions []int, decay float64, decayed []bool) {
+	lr, beta1, beta2, epsilon := a.LearningRate, a.Beta1, a.Beta2, a.Epsilon
+
+	a.step++
+
+	firstCorrection := 1 - math.Pow(beta1, float64(a.step))
+	secondCorrection := 1 - math.Pow(beta2, float64(a.step))
+
+	for idx, param := range params {
+		if grads[idx] == nil {
+			continue
+		}
+
+		firstMoment := a.firstMoment.get(position(positions, idx), param)
+		secondMoment := a.secondMoment.get(position(positions, idx), param)
+
+		paramDecay := decay
+		if decayed != nil && !decayed[idx] {
+			paramDecay = 0
+		}
+
+		for i, g := range grads[idx] {
+			firstMoment[i] = beta1*firstMoment[i] + (1-beta1)*g
+			secondMoment[i] = beta2*secondMoment[i] + (1-beta2)*g*g
+
+			mHat := firstMoment[i] / firstCorrection
+			vHat := secondMoment[i] / secondCorrection
+
+			param[i] -= lr * (mHat/(math.Sqrt(vHat)+epsilon) + paramDecay*param[i])
+		}
+	}
+}
+
+///
+/// AdamW Def
+///
+// Adam with decoupled weight decay. Networks call UpdateDecayed so that only weight matrices are decayed, not biases or batch
+// normalization parameters.
+type AdamW struct {
+	Adam
+	WeightDecay float64
+}
+
+// the Adam defaults with the given WeightDecay
+func NewAdamW(learningRate, weightDecay float64) *AdamW {
+	return &AdamW{Adam: *NewAdam(learningRate), WeightDecay: weightDecay}
+}
+
+// decays every param
+func (a *AdamW) Update(params, grads [][]float64) {
+	a.update(params, grads, nil, a.WeightDecay, nil)
+}
+
+// decays only the params whose decayed entry is true
+func (a *AdamW) UpdateDecayed(params, grads [][]float64, decayed []bool) {
+	a.update(params, grads, nil, a.WeightDecay, decayed)
+}
+
+// decays only the params whose decayed entry is true
+func (a *AdamW) UpdateSparse(params, grads [][]float64, positions []int, decayed []bool) {
+	a.update(params, grads, positions, a.WeightDecay, decayed)
+}
+
+// position of the param at idx, idx itself when positions is nil
+func position(positions []int, idx int) int {
+	if positions == nil {
+		return idx
+	}
+
+	return positions[idx]
+}
+
+// per-parameter values by position, only positions that were updated hold values
+type state map[int][]float64
+
+// values for the param at position, zeroed on first use or when param changed length
+func (s *state) get(position int, param []float64) []float64 {
+	if *s == nil {
+		*s = state{}
+	}
+
+	values := (*s)[position]
+	if len(values) != len(param) {
+		values = make([]float64, len(param))
+		(*s)[position] = values
+	}
+
+	return values
+}
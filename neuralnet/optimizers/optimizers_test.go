@@ -0,0 +1,222 @@
+package optimizers_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testOptimizers(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		params [][]float64
+		grads  [][]float64
+	)
+
+	it.Before(func() {
+		params = [][]float64{{1, 2}, {3}}
+		grads = [][]float64{{0.5, -1}, {2}}
+	})
+
+	context("SGD", func() {
+		it("steps against the gradient", func() {
+			sgd := &optimizers.SGD{LearningRate: 0.1}
+			sgd.Update(params, grads)
+
+			Expect(params[0][0]).To(BeNumerically("~", 0.95))
+			Expect(params[0][1]).To(BeNumerically("~", 2.1))
+			Expect(params[1][0]).To(BeNumerically("~", 2.8))
+		})
+
+		it("does not move with a learning rate of 0", func() {
+			sgd := optimizers.NewSGD(0.1)
+			sgd.SetLearningRate(0)
+			sgd.Update(params, grads)
+
+			Expect(params).To(Equal([][]float64{{1, 2}, {3}}))
+		})
+
+		context("with momentum", func() {
+			it("accumulates velocity across steps", func() {
+				sgd := &optimizers.SGD{LearningRate: 0.1, Momentum: 0.9}
+				sgd.Update(params, grads)
+				sgd.Update(params, grads)
+
+				// v1 = 2, v2 = 0.9 * 2 + 2 = 3.8
+				Expect(params[1][0]).To(BeNumerically("~", 3-0.2-0.38))
+			})
+
+			it("looks ahead when using Nesterov momentum", func() {
+				sgd := &optimizers.SGD{LearningRate: 0.1, Momentum: 0.9, Nesterov: true}
+				sgd.Update(params, grads)
+				sgd.Update(params, grads)
+
+				// step1 = 2 + 0.9 * 2, step2 = 2 + 0.9 * 3.8
+				Expect(params[1][0]).To(BeNumerically("~", 3-0.38-0.542))
+			})
+		})
+	})
+
+	context("RMSProp", func() {
+		it("scales by the running mean square", func() {
+			rmsProp := optimizers.NewRMSProp(0.1)
+			rmsProp.Decay = 0.5
+			rmsProp.Update(params, grads)
+
+			// mean square = 0.5 * 4 = 2
+			Expect(params[1][0]).To(BeNumerically("~", 3-0.1*2/math.Sqrt(2), 1e-6))
+			Expect(params[0][0]).To(BeNumerically("~", 1-0.1*0.5/math.Sqrt(0.125), 1e-6))
+		})
+
+		it("only uses the latest gradient with a Decay of 0", func() {
+			rmsProp := &optimizers.RMSProp{LearningRate: 0.1}
+			rmsProp.Update(params, grads)
+
+			Expect(params[1][0]).To(BeNumerically("~", 2.9))
+		})
+	})
+
+	context("Adagrad", func() {
+		it("scales by the accumulated square gradients", func() {
+			adagrad := optimizers.NewAdagrad(0.1)
+			adagrad.Update(params, grads)
+			adagrad.Update(params, grads)
+
+			Expect(params[1][0]).To(BeNumerically("~", 3-0.1-0.1*2/math.Sqrt(8), 1e-6))
+		})
+	})
+
+	context("Adam", func() {
+		it("takes bias corrected steps of roughly the learning rate", func() {
+			adam := optimizers.NewAdam(0.1)
+			adam.Update(params, grads)
+
+			Expect(params[0][0]).To(BeNumerically("~", 0.9, 1e-6))
+			Expect(params[0][1]).To(BeNumerically("~", 2.1, 1e-6))
+			Expect(params[1][0]).To(BeNumerically("~", 2.9, 1e-6))
+
+			adam.Update(params, grads)
+			Expect(params[1][0]).To(BeNumerically("~", 2.8, 1e-6))
+		})
+
+		it("has no momentum with a Beta1 of 0", func() {
+			adam := optimizers.NewAdam(0.1)
+			adam.Beta1 = 0
+			adam.Update(params, grads)
+			adam.Update(params, [][]float64{{0, 0}, {0}})
+
+			Expect(params[1][0]).To(BeNumerically("~", 2.9, 1e-6))
+		})
+	})
+
+	context("AdamW", func() {
+		it("decays parameters independently of the gradient", func() {
+			adamW := optimizers.NewAdamW(0.1, 0.5)
+			adamW.Update(params, [][]float64{{0, 0}, {0}})
+
+			Expect(params[0][0]).To(BeNumerically("~", 1-0.1*0.5*1))
+			Expect(params[1][0]).To(BeNumerically("~", 3-0.1*0.5*3))
+		})
+
+		it("only decays the params marked as decayed", func() {
+			adamW := optimizers.NewAdamW(0.1, 0.5)
+			adamW.UpdateDecayed(params, [][]float64{{0, 0}, {0}}, []bool{false, true})
+
+			Expect(params[0]).To(Equal([]float64{1, 2}))
+			Expect(params[1][0]).To(BeNumerically("~", 3-0.1*0.5*3))
+		})
+
+		it("is Adam with a WeightDecay of 0", func() {
+			adamW, adam := optimizers.NewAdamW(0.1, 0), optimizers.NewAdam(0.1)
+			other := [][]float64{{1, 2}, {3}}
+
+			adamW.Update(params, grads)
+			adam.Update(other, grads)
+
+			Expect(params).To(Equal(other))
+		})
+	})
+
+	context("state", func() {
+		it("is kept by position, not by backing array", func() {
+			sgd := &optimizers.SGD{LearningRate: 0.1, Momentum: 0.9}
+			sgd.Update(params, grads)
+
+			// a reallocated param takes over the velocity of its position
+			params[1] = []float64{3}
+			sgd.Update(params, grads)
+
+			Expect(params[1][0]).To(BeNumerically("~", 3-0.38))
+		})
+
+		it("skips params with a nil grad", func() {
+			sgd := &optimizers.SGD{LearningRate: 0.1, Momentum: 0.9}
+			sgd.Update(params, grads)
+			sgd.Update(params, [][]float64{grads[0], nil})
+
+			Expect(params[1][0]).To(BeNumerically("~", 2.8))
+
+			// the velocity is left as it was before the skipped step
+			sgd.Update(params, grads)
+			Expect(params[1][0]).To(BeNumerically("~", 2.8-0.38))
+		})
+
+		it("starts over when a parameter changes length", func() {
+			sgd := &optimizers.SGD{LearningRate: 0.1, Momentum: 0.9}
+			sgd.Update(params, grads)
+
+			shorter := [][]float64{params[0][:1], params[1]}
+			Expect(func() {
+				sgd.Update(shorter, [][]float64{{0.5}, {2}})
+			}).NotTo(Panic())
+
+			// velocity of the shortened parameter is reset to the gradient
+			Expect(params[0][0]).To(BeNumerically("~", 1-0.05-0.05))
+		})
+
+		it("is looked up by the given position in sparse updates", func() {
+			adam := optimizers.NewAdam(0.1)
+			adam.Update(params, grads)
+			adam.UpdateSparse([][]float64{params[1]}, [][]float64{{2}}, []int{1}, nil)
+
+			// matches a full update skipping the param at position 0
+			other := [][]float64{{1, 2}, {3}}
+			full := optimizers.NewAdam(0.1)
+			full.Update(other, grads)
+			full.Update(other, [][]float64{nil, {2}})
+
+			Expect(params).To(Equal(other))
+		})
+	})
+
+	context("SetLearningRate", func() {
+		it("matches setting LearningRate", func() {
+			type optimizer interface {
+				Update(params, grads [][]float64)
+				SetLearningRate(float64)
+			}
+
+			for _, pair := range [][2]optimizer{
+				{optimizers.NewSGD(0.1), optimizers.NewSGD(0.5)},
+				{optimizers.NewRMSProp(0.1), optimizers.NewRMSProp(0.5)},
+				{optimizers.NewAdagrad(0.1), optimizers.NewAdagrad(0.5)},
+				{optimizers.NewAdam(0.1), optimizers.NewAdam(0.5)},
+				{optimizers.NewAdamW(0.1, 0.01), optimizers.NewAdamW(0.5, 0.01)},
+			} {
+				actual := [][]float64{{1, 2}, {3}}
+				pair[0].SetLearningRate(0.5)
+				pair[0].Update(actual, grads)
+
+				pair[1].Update(params, grads)
+				Expect(actual).To(Equal(params))
+
+				params = [][]float64{{1, 2}, {3}}
+			}
+		})
+	})
+}
@@ -0,0 +1,846 @@
+package neuralnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/sparse"
+	"gonum.org/v1/gonum/mat"
+)
+
+type Format int
+
+const (
+	JSONFormat Format = iota
+	BinaryFormat
+)
+
+// version 2 added sparse weights, version 3 regularization, version 4 dropout,
+// version 5 batch normalization, version 6 sequential networks and version 7 input layers,
+// networks of earlier versions still load
+const formatVersion = 7
+
+var binaryMagic = []byte("SSNN")
+
+// upper limits on what Load accepts, so corrupt input fails instead of allocating
+const (
+	maxLayerSize  = 1 << 24
+	maxMatrixSize = 1 << 26
+	maxBlobSize   = 1 << 20
+	// values are read in chunks of at most readChunkSize, so truncated input fails before
+	// a declared count is allocated
+	readChunkSize = 1 << 16
+)
+
+var (
+	nodeFuncRegistry = map[string]reflect.Type{}
+	lossRegistry     = map[string]reflect.Type{}
+)
+
+func init() {
+	RegisterNodeFunc("softmax", nodefuncs.Softmax{})
+	RegisterNodeFunc("log-softmax", nodefuncs.LogSoftmax{})
+	RegisterNodeFunc("relu", nodefuncs.Relu{})
+	RegisterNodeFunc("sigmoid", nodefuncs.Sigmoid{})
+	RegisterNodeFunc("identity", nodefuncs.Identity{})
+
+	RegisterLoss("mse", losses.MSE{})
+	RegisterLoss("cross-entropy", losses.CrossEntropy{})
+	RegisterLoss("binary-cross-entropy", losses.BinaryCrossEntropy{})
+	RegisterLoss("huber", losses.Huber{})
+	RegisterLoss("hinge", losses.Hinge{})
+	RegisterLoss("nll", losses.NLL{})
+
+	RegisterLayer("dense", &Dense{}, loadDense)
+	RegisterLayer("activation", &Activation{}, loadActivation)
+	RegisterLayer("dropout", &Dropout{}, loadDropout)
+	RegisterLayer("batchnorm", &BatchNorm{}, loadBatchNorm)
+}
+
+// makes a NodeFunc type available to Save and Load under name,
+// exported fields are persisted alongside the name
+func RegisterNodeFunc(name string, f NodeFunc) {
+	register(nodeFuncRegistry, name, f)
+}
+
+func RegisterLoss(name string, l Loss) {
+	register(lossRegistry, name, l)
+}
+
+func register(registry map[string]reflect.Type, name string, value interface{}) {
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("duplicate registration for name: %s", name))
+	}
+
+	registry[name] = reflect.TypeOf(value)
+}
+
+type savedComponent struct {
+	Name   string          `json:"name"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type savedLayer struct {
+	Size      int             `json:"size"`
+	Func      *savedComponent `json:"func,omitempty"`
+	BatchNorm *savedNorm      `json:"batchNorm,omitempty"`
+	Dropout   float64         `json:"dropout,omitempty"`
+	// overrides the network regularization, see LayerConfig.Regularization
+	Regularization *savedRegularization `json:"regularization,omitempty"`
+}
+
+type savedRegularization struct {
+	L1          float64 `json:"l1,omitempty"`
+	L2          float64 `json:"l2,omitempty"`
+	MaxNorm     float64 `json:"maxNorm,omitempty"`
+	IncludeBias bool    `json:"includeBias,omitempty"`
+}
+
+type savedNorm struct {
+	Gamma       []float64 `json:"gamma"`
+	Beta        []float64 `json:"beta"`
+	RunningMean []float64 `json:"runningMean"`
+	RunningVar  []float64 `json:"runningVar"`
+	Momentum    float64   `json:"momentum"`
+	Epsilon     float64   `json:"epsilon"`
+}
+
+// sparse matrices set Indptr and Indices, Data then only holds the stored values
+type savedMatrix struct {
+	Rows    int       `json:"rows"`
+	Cols    int       `json:"cols"`
+	Data    []float64 `json:"data"`
+	Indptr  []int     `json:"indptr,omitempty"`
+	Indices []int     `json:"indices,omitempty"`
+}
+
+type savedNetwork struct {
+	Version int `json:"version"`
+	// set for sequential networks, which Load rejects
+	Kind           string               `json:"kind,omitempty"`
+	Loss           *savedComponent      `json:"loss,omitempty"`
+	Regularization *savedRegularization `json:"regularization,omitempty"`
+	Layers         []savedLayer         `json:"layers"`
+	Weights        []savedMatrix        `json:"weights"`
+	Bias           [][]float64          `json:"bias"`
+	// saved like a layer of a sequential network, see Network.InputLayer
+	InputShape []int                 `json:"inputShape,omitempty"`
+	InputLayer *savedSequentialLayer `json:"inputLayer,omitempty"`
+}
+
+func (n *Network) Save(w io.Writer, format Format) error {
+	saved, err := n.toSaved()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case JSONFormat:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(saved)
+	case BinaryFormat:
+		return writeBinary(w, saved)
+	default:
+		return fmt.Errorf("unknown save format: %d", format)
+	}
+}
+
+// reads a network written by Save in either format
+func Load(r io.Reader) (Network, error) {
+	reader := bufio.NewReader(r)
+
+	var (
+		saved savedNetwork
+		err   error
+	)
+
+	header, _ := reader.Peek(len(binaryMagic))
+	switch {
+	case bytes.Equal(header, binaryMagic):
+		saved, err = readBinary(reader)
+	case bytes.Equal(header, sequentialMagic):
+		return Network{}, fmt.Errorf("a sequential network cannot be loaded as a Network, see LoadSequential")
+	default:
+		err = json.NewDecoder(reader).Decode(&saved)
+	}
+
+	if err != nil {
+		return Network{}, fmt.Errorf("error decoding network: %s", err)
+	}
+
+	return fromSaved(saved)
+}
+
+func (n *Network) toSaved() (savedNetwork, error) {
+	result := savedNetwork{Version: formatVersion}
+
+	if n.Loss != nil {
+		loss, err := saveComponent(lossRegistry, n.Loss)
+		if err != nil {
+			return savedNetwork{}, err
+		}
+
+		result.Loss = loss
+	}
+
+	if n.Regularization != (Regularization{}) {
+		result.Regularization = saveRegularization(n.Regularization)
+	}
+
+	for idx, lconfig := range n.LayerConfigs {
+		layer := savedLayer{
+			Size:    lconfig.Size,
+			Dropout: lconfig.Dropout,
+		}
+
+		if lconfig.Regularization != nil {
+			layer.Regularization = saveRegularization(*lconfig.Regularization)
+		}
+
+		if idx < len(n.Norms) && n.Norms[idx] != nil {
+			norm := n.Norms[idx]
+			layer.BatchNorm = &savedNorm{
+				Gamma:       mat.VecDenseCopyOf(norm.Gamma).RawVector().Data,
+				Beta:        mat.VecDenseCopyOf(norm.Beta).RawVector().Data,
+				RunningMean: mat.VecDenseCopyOf(norm.RunningMean).RawVector().Data,
+				RunningVar:  mat.VecDenseCopyOf(norm.RunningVar).RawVector().Data,
+				Momentum:    norm.Momentum,
+				Epsilon:     norm.Epsilon,
+			}
+		}
+
+		if lconfig.Func != nil {
+			f, err := saveComponent(nodeFuncRegistry, lconfig.Func)
+			if err != nil {
+				return savedNetwork{}, err
+			}
+
+			layer.Func = f
+		}
+
+		result.Layers = append(result.Layers, layer)
+	}
+
+	for idx, weights := range n.Weights {
+		if n.isSparse(idx) {
+			sparseWeights := n.SparseWeights[idx]
+			r, c := sparseWeights.Dims()
+			result.Weights = append(result.Weights, savedMatrix{
+				Rows:    r,
+				Cols:    c,
+				Data:    sparseWeights.Clone().Data,
+				Indptr:  sparseWeights.Indptr,
+				Indices: sparseWeights.Indices,
+			})
+
+			continue
+		}
+
+		r, c := weights.Dims()
+		result.Weights = append(result.Weights, savedMatrix{
+			Rows: r,
+			Cols: c,
+			Data: mat.DenseCopyOf(weights).RawMatrix().Data,
+		})
+	}
+
+	for _, bias := range n.Bias {
+		result.Bias = append(result.Bias, mat.VecDenseCopyOf(bias).RawVector().Data)
+	}
+
+	if n.InputLayer != nil {
+		inputLayer, err := saveLayer(n.InputLayer)
+		if err != nil {
+			return savedNetwork{}, fmt.Errorf("input layer cannot be saved: %s", err)
+		}
+
+		result.InputShape = n.InputShape
+		result.InputLayer = &inputLayer
+	}
+
+	return result, nil
+}
+
+func fromSaved(saved savedNetwork) (Network, error) {
+	if saved.Kind != "" {
+		return Network{}, fmt.Errorf("a %s network cannot be loaded as a Network, see LoadSequential", saved.Kind)
+	}
+
+	if saved.Version < 1 || saved.Version > formatVersion {
+		return Network{}, fmt.Errorf("unsupported network version: %d", saved.Version)
+	}
+
+	if err := checkLayerSizes(saved.Layers); err != nil {
+		return Network{}, err
+	}
+
+	config := Config{WeightInit: InitOne}
+
+	if saved.Loss != nil {
+		loss, err := loadComponent(lossRegistry, *saved.Loss)
+		if err != nil {
+			return Network{}, err
+		}
+
+		config.Loss = loss.(Loss)
+	}
+
+	if saved.Regularization != nil {
+		config.Regularization = saved.Regularization.load()
+	}
+
+	for _, layer := range saved.Layers {
+		lconfig := LayerConfig{
+			Size:      layer.Size,
+			BatchNorm: layer.BatchNorm != nil,
+			Dropout:   layer.Dropout,
+		}
+
+		if layer.Regularization != nil {
+			regularization := layer.Regularization.load()
+			lconfig.Regularization = &regularization
+		}
+
+		if layer.Func != nil {
+			f, err := loadComponent(nodeFuncRegistry, *layer.Func)
+			if err != nil {
+				return Network{}, err
+			}
+
+			lconfig.Func = f.(NodeFunc)
+		}
+
+		config.LayerConfigs = append(config.LayerConfigs, lconfig)
+	}
+
+	if saved.InputLayer != nil {
+		rng := rand.New(rand.NewSource(rand.Int63()))
+		config.Source = rand.NewSource(rng.Int63())
+		config.InputShape = saved.InputShape
+
+		var err error
+		if config.InputLayer, err = loadLayer(*saved.InputLayer, rng); err != nil {
+			return Network{}, fmt.Errorf("invalid input layer: %s", err)
+		}
+	}
+
+	result, err := NewNetwork(config)
+	if err != nil {
+		return Network{}, err
+	}
+
+	if saved.InputLayer != nil {
+		if err := loadMatrices(result.InputLayer.Params(), saved.InputLayer.Params); err != nil {
+			return Network{}, fmt.Errorf("invalid input layer params: %s", err)
+		}
+
+		if err := loadMatrices(layerState(result.InputLayer), saved.InputLayer.State); err != nil {
+			return Network{}, fmt.Errorf("invalid input layer state: %s", err)
+		}
+	}
+
+	if len(saved.Weights) != len(result.Weights) || len(saved.Bias) != len(result.Bias) {
+		return Network{}, fmt.Errorf("invalid parameter count: %d weights, %d biases", len(saved.Weights), len(saved.Bias))
+	}
+
+	// values are copied into the layers built by NewNetwork, which share them
+	for idx, weights := range saved.Weights {
+		r, c := result.Weights[idx].Dims()
+		if weights.Rows != r || weights.Cols != c {
+			return Network{}, fmt.Errorf("invalid weight dimension at index %d: %dx%d, expected %dx%d", idx, weights.Rows, weights.Cols, r, c)
+		}
+
+		if weights.Indptr != nil {
+			if err := sparse.CheckCSR(r, c, weights.Indptr, weights.Indices, weights.Data); err != nil {
+				return Network{}, fmt.Errorf("invalid sparse weights at index %d: %s", idx, err)
+			}
+
+			if len(weights.Data) == 0 {
+				return Network{}, fmt.Errorf("invalid sparse weights at index %d: no stored values", idx)
+			}
+
+			if result.SparseWeights == nil {
+				result.SparseWeights = make([]*sparse.CSR, len(result.Weights))
+			}
+
+			dense := result.Layers[result.LayerIndex(idx+1)].(*Dense)
+			dense.SparseWeights = sparse.NewCSR(r, c, weights.Indptr, weights.Indices, weights.Data)
+			dense.Weights = nil
+
+			result.SparseWeights[idx] = dense.SparseWeights
+			result.Weights[idx] = nil
+
+			continue
+		}
+
+		if len(weights.Data) != r*c {
+			return Network{}, fmt.Errorf("invalid weight dimension at index %d: %d values, expected %d", idx, len(weights.Data), r*c)
+		}
+
+		result.Weights[idx].Copy(mat.NewDense(r, c, weights.Data))
+	}
+
+	for idx, bias := range saved.Bias {
+		if len(bias) != result.Bias[idx].Len() {
+			return Network{}, fmt.Errorf("invalid bias dimension at index %d: %d, expected %d", idx, len(bias), result.Bias[idx].Len())
+		}
+
+		result.Bias[idx].CopyVec(mat.NewVecDense(len(bias), bias))
+	}
+
+	for idx, layer := range saved.Layers {
+		if layer.BatchNorm == nil {
+			continue
+		}
+
+		norm := result.Norms[idx]
+		for _, values := range [][]float64{layer.BatchNorm.Gamma, layer.BatchNorm.Beta, layer.BatchNorm.RunningMean, layer.BatchNorm.RunningVar} {
+			if len(values) != layer.Size {
+				return Network{}, fmt.Errorf("invalid batch norm dimension at layer %d: %d, expected %d", idx, len(values), layer.Size)
+			}
+		}
+
+		norm.Gamma.CopyVec(mat.NewVecDense(layer.Size, layer.BatchNorm.Gamma))
+		norm.Beta.CopyVec(mat.NewVecDense(layer.Size, layer.BatchNorm.Beta))
+		norm.RunningMean.CopyVec(mat.NewVecDense(layer.Size, layer.BatchNorm.RunningMean))
+		norm.RunningVar.CopyVec(mat.NewVecDense(layer.Size, layer.BatchNorm.RunningVar))
+		norm.Momentum = layer.BatchNorm.Momentum
+		norm.Epsilon = layer.BatchNorm.Epsilon
+	}
+
+	return result, nil
+}
+
+func saveRegularization(regularization Regularization) *savedRegularization {
+	return &savedRegularization{
+		L1:          regularization.L1,
+		L2:          regularization.L2,
+		MaxNorm:     regularization.MaxNorm,
+		IncludeBias: regularization.IncludeBias,
+	}
+}
+
+func (s savedRegularization) load() Regularization {
+	return Regularization{
+		L1:          s.L1,
+		L2:          s.L2,
+		MaxNorm:     s.MaxNorm,
+		IncludeBias: s.IncludeBias,
+	}
+}
+
+// rejects sizes that NewNetwork would fail on or could not allocate
+func checkLayerSizes(layers []savedLayer) error {
+	for idx, layer := range layers {
+		if layer.Size <= 0 || layer.Size > maxLayerSize {
+			return fmt.Errorf("invalid layer size: %d", layer.Size)
+		}
+
+		if idx > 0 && layers[idx-1].Size*layer.Size > maxMatrixSize {
+			return fmt.Errorf("invalid weight dimension at index %d: %dx%d, at most %d values", idx-1, layer.Size, layers[idx-1].Size, maxMatrixSize)
+		}
+	}
+
+	return nil
+}
+
+func saveComponent(registry map[string]reflect.Type, value interface{}) (*savedComponent, error) {
+	valueType := reflect.TypeOf(value)
+
+	for name, registered := range registry {
+		if registered != valueType {
+			continue
+		}
+
+		result := &savedComponent{Name: name}
+
+		structType := valueType
+		if structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+
+		if structType.Kind() == reflect.Struct && structType.NumField() > 0 {
+			params, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("error encoding %s parameters: %s", name, err)
+			}
+
+			result.Params = params
+		}
+
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("unregistered type: %T", value)
+}
+
+func loadComponent(registry map[string]reflect.Type, saved savedComponent) (interface{}, error) {
+	valueType, ok := registry[saved.Name]
+	if !ok {
+		return nil, fmt.Errorf("unregistered name: %s", saved.Name)
+	}
+
+	isPtr := valueType.Kind() == reflect.Ptr
+	if isPtr {
+		valueType = valueType.Elem()
+	}
+
+	result := reflect.New(valueType)
+
+	if len(saved.Params) > 0 {
+		if err := json.Unmarshal(saved.Params, result.Interface()); err != nil {
+			return nil, fmt.Errorf("error decoding %s parameters: %s", saved.Name, err)
+		}
+	}
+
+	if isPtr {
+		return result.Interface(), nil
+	}
+
+	return result.Elem().Interface(), nil
+}
+
+///
+/// Binary encoding
+///
+// big endian: magic, version, loss, layers, weights then bias. Strings and
+// parameter blobs are length prefixed, a zero length name marks an absent component.
+// Since version 2 every weight matrix is followed by its sparse Indptr and Indices,
+// both empty for dense weights. Since version 3 the loss is followed by a regularization
+// flag and, when set, L1, L2, MaxNorm and IncludeBias, and every layer ends with a
+// regularization of its own in the same encoding. Since version 4 every layer holds its
+// dropout rate before its regularization, and since version 5 a batch norm flag before
+// that and, when set, its parameters and running statistics. Version 6 keeps this layout,
+// it only adds the sequential one. Since version 7 the bias is followed by an input layer
+// flag and, when set, the input shape and the input layer as a sequential network stores
+// its layers: name, config, regularization, params then state.
+
+type binaryWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (b *binaryWriter) write(data interface{}) {
+	if b.err == nil {
+		b.err = binary.Write(b.w, binary.BigEndian, data)
+	}
+}
+
+func (b *binaryWriter) writeBytes(data []byte) {
+	b.write(uint32(len(data)))
+	b.write(data)
+}
+
+func (b *binaryWriter) writeComponent(component *savedComponent) {
+	if component == nil {
+		b.writeBytes(nil)
+		return
+	}
+
+	b.writeBytes([]byte(component.Name))
+	b.writeBytes(component.Params)
+}
+
+func (b *binaryWriter) writeFloats(data []float64) {
+	b.write(uint32(len(data)))
+	b.write(data)
+}
+
+func (b *binaryWriter) writeNorm(norm *savedNorm) {
+	if norm == nil {
+		b.write(uint8(0))
+		return
+	}
+
+	b.write(uint8(1))
+	b.writeFloats(norm.Gamma)
+	b.writeFloats(norm.Beta)
+	b.writeFloats(norm.RunningMean)
+	b.writeFloats(norm.RunningVar)
+	b.write(norm.Momentum)
+	b.write(norm.Epsilon)
+}
+
+func (b *binaryWriter) writeRegularization(regularization *savedRegularization) {
+	if regularization == nil {
+		b.write(uint8(0))
+		return
+	}
+
+	b.write(uint8(1))
+	b.write(regularization.L1)
+	b.write(regularization.L2)
+	b.write(regularization.MaxNorm)
+	b.write(regularization.IncludeBias)
+}
+
+func (b *binaryWriter) writeInts(data []int) {
+	b.write(uint32(len(data)))
+	for _, value := range data {
+		b.write(uint32(value))
+	}
+}
+
+func writeBinary(w io.Writer, saved savedNetwork) error {
+	writer := &binaryWriter{w: w}
+
+	writer.write(binaryMagic)
+	writer.write(uint32(saved.Version))
+	writer.writeComponent(saved.Loss)
+	writer.writeRegularization(saved.Regularization)
+
+	writer.write(uint32(len(saved.Layers)))
+	for _, layer := range saved.Layers {
+		writer.write(uint32(layer.Size))
+		writer.writeComponent(layer.Func)
+		writer.writeNorm(layer.BatchNorm)
+		writer.write(layer.Dropout)
+		writer.writeRegularization(layer.Regularization)
+	}
+
+	writer.write(uint32(len(saved.Weights)))
+	for _, weights := range saved.Weights {
+		writer.write(uint32(weights.Rows))
+		writer.write(uint32(weights.Cols))
+		writer.writeFloats(weights.Data)
+		writer.writeInts(weights.Indptr)
+		writer.writeInts(weights.Indices)
+	}
+
+	writer.write(uint32(len(saved.Bias)))
+	for _, bias := range saved.Bias {
+		writer.writeFloats(bias)
+	}
+
+	if saved.InputLayer == nil {
+		writer.write(uint32(0))
+	} else {
+		writer.write(uint32(1))
+		writer.writeInts(saved.InputShape)
+		writer.writeLayer(*saved.InputLayer)
+	}
+
+	return writer.err
+}
+
+type binaryReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *binaryReader) read(data interface{}) {
+	if b.err == nil {
+		b.err = binary.Read(b.r, binary.BigEndian, data)
+	}
+}
+
+func (b *binaryReader) readCount() int {
+	var count uint32
+	b.read(&count)
+
+	return int(count)
+}
+
+// reads a count and fails when it is above limit
+func (b *binaryReader) readLimit(limit int) int {
+	count := b.readCount()
+	if b.err == nil && count > limit {
+		b.err = fmt.Errorf("invalid value count: %d, at most %d", count, limit)
+	}
+
+	return count
+}
+
+// rows*cols of a matrix, failing when either is too large to allocate
+func (b *binaryReader) matrixSize(rows, cols int) int {
+	if b.err == nil {
+		b.err = CheckLoadSize(rows, cols)
+	}
+
+	return rows * cols
+}
+
+func (b *binaryReader) readBytes() []byte {
+	count := b.readLimit(maxBlobSize)
+	if b.err != nil || count == 0 {
+		return nil
+	}
+
+	result := make([]byte, count)
+	b.read(result)
+
+	return result
+}
+
+func (b *binaryReader) readComponent() *savedComponent {
+	name := b.readBytes()
+	if len(name) == 0 {
+		return nil
+	}
+
+	return &savedComponent{
+		Name:   string(name),
+		Params: b.readBytes(),
+	}
+}
+
+func (b *binaryReader) readFloats(limit int) []float64 {
+	count := b.readLimit(limit)
+	if b.err != nil {
+		return nil
+	}
+
+	result := make([]float64, 0, chunkSize(count))
+	for len(result) < count && b.err == nil {
+		chunk := make([]float64, chunkSize(count-len(result)))
+		b.read(chunk)
+		result = append(result, chunk...)
+	}
+
+	return result
+}
+
+// size is the size of the layer
+func (b *binaryReader) readNorm(size int) *savedNorm {
+	var flag uint8
+	b.read(&flag)
+	if b.err != nil || flag == 0 {
+		return nil
+	}
+
+	result := &savedNorm{
+		Gamma:       b.readFloats(size),
+		Beta:        b.readFloats(size),
+		RunningMean: b.readFloats(size),
+		RunningVar:  b.readFloats(size),
+	}
+	b.read(&result.Momentum)
+	b.read(&result.Epsilon)
+
+	return result
+}
+
+func (b *binaryReader) readRegularization() *savedRegularization {
+	var flag uint8
+	b.read(&flag)
+	if b.err != nil || flag == 0 {
+		return nil
+	}
+
+	result := &savedRegularization{}
+	b.read(&result.L1)
+	b.read(&result.L2)
+	b.read(&result.MaxNorm)
+	b.read(&result.IncludeBias)
+
+	return result
+}
+
+func (b *binaryReader) readInts(limit int) []int {
+	count := b.readLimit(limit)
+	if b.err != nil || count == 0 {
+		return nil
+	}
+
+	result := make([]int, 0, chunkSize(count))
+	for len(result) < count && b.err == nil {
+		values := make([]uint32, chunkSize(count-len(result)))
+		b.read(values)
+
+		for _, value := range values {
+			result = append(result, int(value))
+		}
+	}
+
+	return result
+}
+
+func chunkSize(count int) int {
+	if count > readChunkSize {
+		return readChunkSize
+	}
+
+	return count
+}
+
+func readBinary(r io.Reader) (savedNetwork, error) {
+	reader := &binaryReader{r: r}
+
+	magic := make([]byte, len(binaryMagic))
+	reader.read(magic)
+
+	var result savedNetwork
+	result.Version = reader.readCount()
+	if reader.err != nil || result.Version < 1 || result.Version > formatVersion {
+		// later versions may change the layout, leave the version check to the caller
+		return result, reader.err
+	}
+
+	result.Loss = reader.readComponent()
+	if result.Version >= 3 {
+		result.Regularization = reader.readRegularization()
+	}
+
+	layerCount := reader.readCount()
+	for idx := 0; idx < layerCount && reader.err == nil; idx++ {
+		layer := savedLayer{
+			Size: reader.readLimit(maxLayerSize),
+			Func: reader.readComponent(),
+		}
+
+		if result.Version >= 5 {
+			layer.BatchNorm = reader.readNorm(layer.Size)
+		}
+
+		if result.Version >= 4 {
+			reader.read(&layer.Dropout)
+		}
+
+		if result.Version >= 3 {
+			layer.Regularization = reader.readRegularization()
+		}
+
+		result.Layers = append(result.Layers, layer)
+	}
+
+	weightCount := reader.readCount()
+	for idx := 0; idx < weightCount && reader.err == nil; idx++ {
+		weights := savedMatrix{
+			Rows: reader.readCount(),
+			Cols: reader.readCount(),
+		}
+
+		// dense weights hold exactly rows*cols values, fromSaved checks the exact count
+		size := reader.matrixSize(weights.Rows, weights.Cols)
+		weights.Data = reader.readFloats(size)
+
+		if result.Version >= 2 {
+			weights.Indptr = reader.readInts(weights.Rows + 1)
+			weights.Indices = reader.readInts(size)
+		}
+
+		result.Weights = append(result.Weights, weights)
+	}
+
+	biasCount := reader.readCount()
+	for idx := 0; idx < biasCount && reader.err == nil; idx++ {
+		result.Bias = append(result.Bias, reader.readFloats(maxLayerSize))
+	}
+
+	if result.Version >= 7 && reader.readLimit(1) == 1 {
+		result.InputShape = reader.readInts(maxLayerSize)
+		inputLayer := reader.readLayer()
+		result.InputLayer = &inputLayer
+	}
+
+	return result, reader.err
+}
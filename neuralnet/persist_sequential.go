@@ -0,0 +1,446 @@
+package neuralnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Sequential networks are saved as their loss, regularization and every layer's registered
+// name, LayerConfig, Params and State. The optimizer and training mode are not saved, as
+// for a Network.
+
+// first version holding sequential networks
+const sequentialVersion = 6
+
+// kind of saved sequential networks, saved Networks have none
+const sequentialKind = "sequential"
+
+var sequentialMagic = []byte("SSSQ")
+
+// A SavedLayer can be saved as part of a Sequential, its type must be registered with
+// RegisterLayer
+type SavedLayer interface {
+	Layer
+	// JSON encodable settings the registered LayerLoader builds the layer from, nil for
+	// layers without any. Params and the State of StatefulLayers are saved separately.
+	LayerConfig() interface{}
+}
+
+// builds a layer from the encoded LayerConfig of a saved one, LoadSequential then copies
+// the saved Params and State into it. Random values are drawn from rng. Configs come from
+// untrusted input, so loaders check every matrix with CheckLoadSize before allocating it.
+type LayerLoader func(config []byte, rng *rand.Rand) (Layer, error)
+
+// fails when a rows x cols matrix is larger than Load and LoadSequential accept
+func CheckLoadSize(rows, cols int) error {
+	if rows > maxLayerSize || cols > maxLayerSize || rows*cols > maxMatrixSize {
+		return fmt.Errorf("invalid matrix dimension: %dx%d, at most %d values", rows, cols, maxMatrixSize)
+	}
+
+	return nil
+}
+
+type registeredLayer struct {
+	layerType reflect.Type
+	load      LayerLoader
+}
+
+var layerRegistry = map[string]registeredLayer{}
+
+// makes a SavedLayer type available to Sequential.Save and LoadSequential under name
+func RegisterLayer(name string, layer SavedLayer, load LayerLoader) {
+	if _, ok := layerRegistry[name]; ok {
+		panic(fmt.Sprintf("duplicate registration for name: %s", name))
+	}
+
+	layerRegistry[name] = registeredLayer{layerType: reflect.TypeOf(layer), load: load}
+}
+
+// RegisteredFunc encodes a NodeFunc registered with RegisterNodeFunc as JSON, e.g. in the
+// LayerConfig of a layer applying it
+type RegisteredFunc struct {
+	NodeFunc
+}
+
+func (f RegisteredFunc) MarshalJSON() ([]byte, error) {
+	component, err := saveComponent(nodeFuncRegistry, f.NodeFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(component)
+}
+
+func (f *RegisteredFunc) UnmarshalJSON(data []byte) error {
+	var component savedComponent
+	if err := json.Unmarshal(data, &component); err != nil {
+		return err
+	}
+
+	value, err := loadComponent(nodeFuncRegistry, component)
+	if err != nil {
+		return err
+	}
+
+	f.NodeFunc = value.(NodeFunc)
+
+	return nil
+}
+
+type savedSequential struct {
+	Version        int                    `json:"version"`
+	Kind           string                 `json:"kind"`
+	InputShape     []int                  `json:"inputShape"`
+	Loss           *savedComponent        `json:"loss,omitempty"`
+	Regularization *savedRegularization   `json:"regularization,omitempty"`
+	Layers         []savedSequentialLayer `json:"layers"`
+}
+
+type savedSequentialLayer struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config,omitempty"`
+	// overrides the network regularization, see SequentialConfig.LayerRegularization
+	Regularization *savedRegularization `json:"regularization,omitempty"`
+	Params         []savedMatrix        `json:"params,omitempty"`
+	State          []savedMatrix        `json:"state,omitempty"`
+}
+
+// every layer must be a registered SavedLayer
+func (s *Sequential) Save(w io.Writer, format Format) error {
+	saved, err := s.toSaved()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case JSONFormat:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(saved)
+	case BinaryFormat:
+		return writeSequentialBinary(w, saved)
+	default:
+		return fmt.Errorf("unknown save format: %d", format)
+	}
+}
+
+// reads a network written by Sequential.Save in either format
+func LoadSequential(r io.Reader) (Sequential, error) {
+	reader := bufio.NewReader(r)
+
+	var (
+		saved savedSequential
+		err   error
+	)
+
+	header, _ := reader.Peek(len(sequentialMagic))
+	switch {
+	case bytes.Equal(header, sequentialMagic):
+		saved, err = readSequentialBinary(reader)
+	case bytes.Equal(header, binaryMagic):
+		return Sequential{}, fmt.Errorf("a Network cannot be loaded as a sequential network, see Load")
+	default:
+		err = json.NewDecoder(reader).Decode(&saved)
+	}
+
+	if err != nil {
+		return Sequential{}, fmt.Errorf("error decoding network: %s", err)
+	}
+
+	return sequentialFromSaved(saved)
+}
+
+func (s *Sequential) toSaved() (savedSequential, error) {
+	result := savedSequential{
+		Version:    formatVersion,
+		Kind:       sequentialKind,
+		InputShape: s.InputShape,
+	}
+
+	if s.Loss != nil {
+		loss, err := saveComponent(lossRegistry, s.Loss)
+		if err != nil {
+			return savedSequential{}, err
+		}
+
+		result.Loss = loss
+	}
+
+	if s.Regularization != (Regularization{}) {
+		result.Regularization = saveRegularization(s.Regularization)
+	}
+
+	for idx, layer := range s.Layers {
+		saved, err := saveLayer(layer)
+		if err != nil {
+			return savedSequential{}, fmt.Errorf("layer at index %d cannot be saved: %s", idx, err)
+		}
+
+		if idx < len(s.LayerRegularization) && s.LayerRegularization[idx] != nil {
+			saved.Regularization = saveRegularization(*s.LayerRegularization[idx])
+		}
+
+		result.Layers = append(result.Layers, saved)
+	}
+
+	return result, nil
+}
+
+func saveLayer(layer Layer) (savedSequentialLayer, error) {
+	saveable, ok := layer.(SavedLayer)
+	if !ok {
+		return savedSequentialLayer{}, fmt.Errorf("%T is not a SavedLayer", layer)
+	}
+
+	var result savedSequentialLayer
+	for name, registered := range layerRegistry {
+		if registered.layerType == reflect.TypeOf(layer) {
+			result.Name = name
+		}
+	}
+
+	if result.Name == "" {
+		return savedSequentialLayer{}, fmt.Errorf("unregistered type: %T", layer)
+	}
+
+	if config := saveable.LayerConfig(); config != nil {
+		encoded, err := json.Marshal(config)
+		if err != nil {
+			return savedSequentialLayer{}, fmt.Errorf("error encoding %s config: %s", result.Name, err)
+		}
+
+		result.Config = encoded
+	}
+
+	result.Params = saveMatrices(layer.Params())
+	result.State = saveMatrices(layerState(layer))
+
+	return result, nil
+}
+
+func saveMatrices(matrices []*mat.Dense) []savedMatrix {
+	var result []savedMatrix
+	for _, m := range matrices {
+		r, c := m.Dims()
+		result = append(result, savedMatrix{
+			Rows: r,
+			Cols: c,
+			Data: mat.DenseCopyOf(m).RawMatrix().Data,
+		})
+	}
+
+	return result
+}
+
+func sequentialFromSaved(saved savedSequential) (Sequential, error) {
+	if saved.Kind != sequentialKind {
+		return Sequential{}, fmt.Errorf("a Network cannot be loaded as a sequential network, see Load")
+	}
+
+	if saved.Version < sequentialVersion || saved.Version > formatVersion {
+		return Sequential{}, fmt.Errorf("unsupported network version: %d", saved.Version)
+	}
+
+	rng := rand.New(rand.NewSource(rand.Int63()))
+	config := SequentialConfig{
+		InputShape: saved.InputShape,
+		Source:     rand.NewSource(rng.Int63()),
+	}
+
+	if saved.Loss != nil {
+		loss, err := loadComponent(lossRegistry, *saved.Loss)
+		if err != nil {
+			return Sequential{}, err
+		}
+
+		config.Loss = loss.(Loss)
+	}
+
+	if saved.Regularization != nil {
+		config.Regularization = saved.Regularization.load()
+	}
+
+	for idx, layer := range saved.Layers {
+		loaded, err := loadLayer(layer, rng)
+		if err != nil {
+			return Sequential{}, fmt.Errorf("invalid layer at index %d: %s", idx, err)
+		}
+
+		if layer.Regularization != nil {
+			if config.LayerRegularization == nil {
+				config.LayerRegularization = make([]*Regularization, len(saved.Layers))
+			}
+
+			regularization := layer.Regularization.load()
+			config.LayerRegularization[idx] = &regularization
+		}
+
+		config.Layers = append(config.Layers, loaded)
+	}
+
+	result, err := NewSequential(config)
+	if err != nil {
+		return Sequential{}, err
+	}
+
+	// values are copied after NewSequential, so layers have taken their input shapes
+	for idx, layer := range result.Layers {
+		if err := loadMatrices(layer.Params(), saved.Layers[idx].Params); err != nil {
+			return Sequential{}, fmt.Errorf("invalid params at layer %d: %s", idx, err)
+		}
+
+		if err := loadMatrices(layerState(layer), saved.Layers[idx].State); err != nil {
+			return Sequential{}, fmt.Errorf("invalid state at layer %d: %s", idx, err)
+		}
+	}
+
+	return result, nil
+}
+
+// builds the registered layer of saved, its Params and State are copied separately
+func loadLayer(saved savedSequentialLayer, rng *rand.Rand) (Layer, error) {
+	registered, ok := layerRegistry[saved.Name]
+	if !ok {
+		return nil, fmt.Errorf("unregistered name: %s", saved.Name)
+	}
+
+	return registered.load(saved.Config, rng)
+}
+
+// decodes the LayerConfig of a saved layer into config
+func decodeConfig(name string, data []byte, config interface{}) error {
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("error decoding %s config: %s", name, err)
+	}
+
+	return nil
+}
+
+// State of StatefulLayers, nil for other layers
+func layerState(layer Layer) []*mat.Dense {
+	if stateful, ok := layer.(StatefulLayer); ok {
+		return stateful.State()
+	}
+
+	return nil
+}
+
+// copies saved into matrices, which it must match in count and dimensions
+func loadMatrices(matrices []*mat.Dense, saved []savedMatrix) error {
+	if len(saved) != len(matrices) {
+		return fmt.Errorf("invalid matrix count: %d, expected %d", len(saved), len(matrices))
+	}
+
+	for idx, m := range matrices {
+		r, c := m.Dims()
+		if saved[idx].Rows != r || saved[idx].Cols != c || len(saved[idx].Data) != r*c {
+			return fmt.Errorf("invalid dimension at index %d: %dx%d with %d values, expected %dx%d", idx, saved[idx].Rows, saved[idx].Cols, len(saved[idx].Data), r, c)
+		}
+
+		m.Copy(mat.NewDense(r, c, saved[idx].Data))
+	}
+
+	return nil
+}
+
+///
+/// Binary encoding
+///
+// same encoding as for a Network: magic, version, input shape, loss and regularization,
+// then for every layer its name, config, regularization, Params and State. Matrices are
+// their rows, columns and values.
+
+func writeSequentialBinary(w io.Writer, saved savedSequential) error {
+	writer := &binaryWriter{w: w}
+
+	writer.write(sequentialMagic)
+	writer.write(uint32(saved.Version))
+	writer.writeInts(saved.InputShape)
+	writer.writeComponent(saved.Loss)
+	writer.writeRegularization(saved.Regularization)
+
+	writer.write(uint32(len(saved.Layers)))
+	for _, layer := range saved.Layers {
+		writer.writeLayer(layer)
+	}
+
+	return writer.err
+}
+
+func (b *binaryWriter) writeLayer(layer savedSequentialLayer) {
+	b.writeBytes([]byte(layer.Name))
+	b.writeBytes(layer.Config)
+	b.writeRegularization(layer.Regularization)
+	b.writeMatrices(layer.Params)
+	b.writeMatrices(layer.State)
+}
+
+func (b *binaryWriter) writeMatrices(matrices []savedMatrix) {
+	b.write(uint32(len(matrices)))
+	for _, m := range matrices {
+		b.write(uint32(m.Rows))
+		b.write(uint32(m.Cols))
+		b.writeFloats(m.Data)
+	}
+}
+
+func readSequentialBinary(r io.Reader) (savedSequential, error) {
+	reader := &binaryReader{r: r}
+
+	magic := make([]byte, len(sequentialMagic))
+	reader.read(magic)
+
+	result := savedSequential{Kind: sequentialKind}
+	result.Version = reader.readCount()
+	if reader.err != nil || result.Version < sequentialVersion || result.Version > formatVersion {
+		// later versions may change the layout, leave the version check to the caller
+		return result, reader.err
+	}
+
+	result.InputShape = reader.readInts(maxLayerSize)
+	result.Loss = reader.readComponent()
+	result.Regularization = reader.readRegularization()
+
+	layerCount := reader.readCount()
+	for idx := 0; idx < layerCount && reader.err == nil; idx++ {
+		result.Layers = append(result.Layers, reader.readLayer())
+	}
+
+	return result, reader.err
+}
+
+func (b *binaryReader) readLayer() savedSequentialLayer {
+	result := savedSequentialLayer{
+		Name:   string(b.readBytes()),
+		Config: b.readBytes(),
+	}
+	result.Regularization = b.readRegularization()
+	result.Params = b.readMatrices()
+	result.State = b.readMatrices()
+
+	return result
+}
+
+func (b *binaryReader) readMatrices() []savedMatrix {
+	var result []savedMatrix
+
+	count := b.readCount()
+	for idx := 0; idx < count && b.err == nil; idx++ {
+		m := savedMatrix{
+			Rows: b.readCount(),
+			Cols: b.readCount(),
+		}
+		m.Data = b.readFloats(b.matrixSize(m.Rows, m.Cols))
+
+		result = append(result, m)
+	}
+
+	return result
+}
@@ -0,0 +1,236 @@
+package neuralnet_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testPersistSequential(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		sequential neuralnet.Sequential
+		inputs     *mat.Dense
+	)
+
+	it.Before(func() {
+		rng := rand.New(rand.NewSource(13))
+
+		norm := layers.NewBatchNorm(4)
+		norm.Momentum = 0.5
+
+		var err error
+		sequential, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+			InputShape: neuralnet.Shape{2, 2},
+			Layers: []neuralnet.Layer{
+				layers.NewReshape(4),
+				layers.NewDense(4, 4, neuralnet.InitRandom, neuralnet.InitRandom, rng),
+				norm,
+				layers.NewActivation(nodefuncs.Relu{}),
+				layers.NewDropout(0.25, rng),
+				layers.NewDense(4, 2, nil, nil, rng),
+				layers.NewActivation(nodefuncs.Softmax{}),
+			},
+			Loss:                losses.Huber{Delta: 0.25},
+			Regularization:      neuralnet.Regularization{L2: 0.01},
+			LayerRegularization: []*neuralnet.Regularization{nil, {L1: 0.5, MaxNorm: 2}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		inputs = mat.NewDense(4, 3, []float64{
+			0.1, 0.5, -0.3,
+			0.2, -0.4, 0.8,
+			-0.6, 0.7, 0.9,
+			1, 0, -1,
+		})
+
+		// move the running statistics away from their defaults
+		sequential.SetTraining(true)
+		_, err = sequential.CalculateBatch(inputs)
+		Expect(err).NotTo(HaveOccurred())
+		sequential.SetTraining(false)
+	})
+
+	for _, tc := range []struct {
+		name   string
+		format neuralnet.Format
+	}{
+		{"JSON", neuralnet.JSONFormat},
+		{"binary", neuralnet.BinaryFormat},
+	} {
+		tc := tc
+
+		context("when using the "+tc.name+" format", func() {
+			it("round trips the network", func() {
+				buffer := bytes.NewBuffer(nil)
+				Expect(sequential.Save(buffer, tc.format)).To(Succeed())
+
+				loaded, err := neuralnet.LoadSequential(buffer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(loaded.InputShape).To(Equal(sequential.InputShape))
+				Expect(loaded.OutputShape).To(Equal(sequential.OutputShape))
+				Expect(loaded.Loss).To(Equal(losses.Huber{Delta: 0.25}))
+				Expect(loaded.Regularization).To(Equal(sequential.Regularization))
+				Expect(loaded.LayerRegularization).To(HaveLen(7))
+				Expect(loaded.LayerRegularization[0]).To(BeNil())
+				Expect(loaded.LayerRegularization[1]).To(Equal(sequential.LayerRegularization[1]))
+				Expect(loaded.Snapshot()).To(Equal(sequential.Snapshot()))
+
+				Expect(loaded.Layers[2].(*layers.BatchNorm).Momentum).To(Equal(0.5))
+				Expect(loaded.Layers[4].(*layers.Dropout).Rate).To(Equal(0.25))
+				Expect(loaded.Layers[6].(*layers.Activation).Func).To(Equal(nodefuncs.Softmax{}))
+
+				expected, err := sequential.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				actual, err := loaded.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(actual).To(Equal(expected))
+			})
+
+			it("cannot be loaded as a Network", func() {
+				buffer := bytes.NewBuffer(nil)
+				Expect(sequential.Save(buffer, tc.format)).To(Succeed())
+
+				_, err := neuralnet.Load(buffer)
+				Expect(err).To(MatchError("a sequential network cannot be loaded as a Network, see LoadSequential"))
+			})
+
+			it("does not load a Network", func() {
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{{Size: 1}, {Size: 1, Func: nodefuncs.Sigmoid{}}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				buffer := bytes.NewBuffer(nil)
+				Expect(network.Save(buffer, tc.format)).To(Succeed())
+
+				_, err = neuralnet.LoadSequential(buffer)
+				Expect(err).To(MatchError("a Network cannot be loaded as a sequential network, see Load"))
+			})
+		})
+	}
+
+	it("is human readable and versioned", func() {
+		buffer := bytes.NewBuffer(nil)
+		Expect(sequential.Save(buffer, neuralnet.JSONFormat)).To(Succeed())
+
+		Expect(buffer.String()).To(ContainSubstring(`"version": 7`))
+		Expect(buffer.String()).To(ContainSubstring(`"kind": "sequential"`))
+		Expect(buffer.String()).To(ContainSubstring(`"name": "batchnorm"`))
+	})
+
+	context("failure cases", func() {
+		it("when a layer cannot be saved", func() {
+			opaque, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{2, 2},
+				Layers:     []neuralnet.Layer{sequential.Layers[0], opaqueLayer{sequential.Layers[1]}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = opaque.Save(bytes.NewBuffer(nil), neuralnet.JSONFormat)
+			Expect(err).To(MatchError("layer at index 1 cannot be saved: neuralnet_test.opaqueLayer is not a SavedLayer"))
+		})
+
+		it("when a layer function is not registered", func() {
+			sequential.Layers[3] = layers.NewActivation(TestFunc{})
+
+			err := sequential.Save(bytes.NewBuffer(nil), neuralnet.JSONFormat)
+			Expect(err).To(MatchError(ContainSubstring("unregistered type: neuralnet_test.TestFunc")))
+		})
+
+		it("when the format is unknown", func() {
+			err := sequential.Save(bytes.NewBuffer(nil), neuralnet.Format(7))
+			Expect(err).To(MatchError("unknown save format: 7"))
+		})
+
+		it("when the version is unsupported", func() {
+			_, err := neuralnet.LoadSequential(strings.NewReader(`{"version": 5, "kind": "sequential"}`))
+			Expect(err).To(MatchError("unsupported network version: 5"))
+
+			_, err = neuralnet.LoadSequential(strings.NewReader("SSSQ\x00\x00\x00\x08"))
+			Expect(err).To(MatchError("unsupported network version: 8"))
+		})
+
+		it("when a saved name is not registered", func() {
+			_, err := neuralnet.LoadSequential(strings.NewReader(`{
+				"version": 6,
+				"kind": "sequential",
+				"inputShape": [1],
+				"layers": [{"name": "unknown"}]
+			}`))
+			Expect(err).To(MatchError("invalid layer at index 0: unregistered name: unknown"))
+		})
+
+		it("when a layer config is invalid", func() {
+			_, err := neuralnet.LoadSequential(strings.NewReader(`{
+				"version": 6,
+				"kind": "sequential",
+				"inputShape": [1],
+				"layers": [{"name": "dense", "config": {"inputs": 1, "outputs": 0}}]
+			}`))
+			Expect(err).To(MatchError("invalid layer at index 0: invalid dense size: 1 inputs, 0 outputs"))
+		})
+
+		it("when a layer config is too large to allocate", func() {
+			_, err := neuralnet.LoadSequential(strings.NewReader(`{
+				"version": 7,
+				"kind": "sequential",
+				"inputShape": [1],
+				"layers": [{"name": "dense", "config": {"inputs": 1, "outputs": 4000000000}}]
+			}`))
+			Expect(err).To(MatchError("invalid layer at index 0: invalid matrix dimension: 4000000000x1, at most 67108864 values"))
+
+			_, err = neuralnet.LoadSequential(strings.NewReader(`{
+				"version": 7,
+				"kind": "sequential",
+				"inputShape": [1],
+				"layers": [{"name": "batchnorm", "config": {"size": 4000000000}}]
+			}`))
+			Expect(err).To(MatchError("invalid layer at index 0: invalid matrix dimension: 4000000000x1, at most 67108864 values"))
+		})
+
+		it("when params do not match the layers", func() {
+			_, err := neuralnet.LoadSequential(strings.NewReader(`{
+				"version": 6,
+				"kind": "sequential",
+				"inputShape": [1],
+				"layers": [{
+					"name": "dense",
+					"config": {"inputs": 1, "outputs": 1},
+					"params": [{"rows": 1, "cols": 2, "data": [1, 2]}, {"rows": 1, "cols": 1, "data": [0]}]
+				}]
+			}`))
+			Expect(err).To(MatchError("invalid params at layer 0: invalid dimension at index 0: 1x2 with 2 values, expected 1x1"))
+
+			_, err = neuralnet.LoadSequential(strings.NewReader(`{
+				"version": 6,
+				"kind": "sequential",
+				"inputShape": [1],
+				"layers": [{"name": "batchnorm", "config": {"size": 1}, "params": [{"rows": 1, "cols": 1, "data": [1]}, {"rows": 1, "cols": 1, "data": [0]}]}]
+			}`))
+			Expect(err).To(MatchError("invalid state at layer 0: invalid matrix count: 0, expected 2"))
+		})
+
+		it("when the layers do not fit the input shape", func() {
+			_, err := neuralnet.LoadSequential(strings.NewReader(`{
+				"version": 6,
+				"kind": "sequential",
+				"inputShape": [2],
+				"layers": [{"name": "dense", "config": {"inputs": 1, "outputs": 1}}]
+			}`))
+			Expect(err).To(MatchError("invalid layer at index 0: invalid input size: 2, expected 1"))
+		})
+	})
+}
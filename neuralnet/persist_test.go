@@ -0,0 +1,376 @@
+package neuralnet_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testPersist(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		network neuralnet.Network
+	)
+
+	it.Before(func() {
+		var err error
+		network, err = neuralnet.NewNetwork(neuralnet.Config{
+			LayerConfigs: []neuralnet.LayerConfig{
+				{
+					Size: 3,
+				},
+				{
+					Size: 4,
+					Func: nodefuncs.Sigmoid{},
+				},
+				{
+					Size: 2,
+					Func: nodefuncs.Softmax{},
+				},
+			},
+			WeightInit: neuralnet.InitRandom,
+			Loss:       losses.Huber{Delta: 0.25},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		network.Bias[1].SetVec(2, 0.125)
+		network.Bias[2].SetVec(0, -1.0/3)
+	})
+
+	for _, tc := range []struct {
+		name   string
+		format neuralnet.Format
+	}{
+		{"JSON", neuralnet.JSONFormat},
+		{"binary", neuralnet.BinaryFormat},
+	} {
+		tc := tc
+
+		context("when using the "+tc.name+" format", func() {
+			it("round trips the network", func() {
+				buffer := bytes.NewBuffer(nil)
+				Expect(network.Save(buffer, tc.format)).To(Succeed())
+
+				loaded, err := neuralnet.Load(buffer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(loaded.InputSize).To(Equal(3))
+				Expect(loaded.OutputSize).To(Equal(2))
+				Expect(loaded.LayerConfigs).To(Equal(network.LayerConfigs))
+				Expect(loaded.Loss).To(Equal(losses.Huber{Delta: 0.25}))
+				Expect(loaded.Weights).To(Equal(network.Weights))
+				Expect(loaded.Bias).To(Equal(network.Bias))
+
+				input := mat.NewVecDense(3, []float64{0.1, 0.2, 0.3})
+				expected, err := network.Calculate(input)
+				Expect(err).NotTo(HaveOccurred())
+				actual, err := loaded.Calculate(input)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(actual).To(Equal(expected))
+			})
+		})
+	}
+
+	context("when weights are sparse", func() {
+		it.Before(func() {
+			network.Weights[0].Copy(mat.NewDense(4, 3, []float64{
+				0, 0.5, 0,
+				0, 0, 0,
+				-1, 0, 0.25,
+				0, 0, 2,
+			}))
+			Expect(network.Sparsify(0, 0)).To(Succeed())
+		})
+
+		for _, format := range []neuralnet.Format{neuralnet.JSONFormat, neuralnet.BinaryFormat} {
+			format := format
+
+			it("round trips the sparse structure", func() {
+				buffer := bytes.NewBuffer(nil)
+				Expect(network.Save(buffer, format)).To(Succeed())
+
+				loaded, err := neuralnet.Load(buffer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(loaded.Weights[0]).To(BeNil())
+				Expect(loaded.SparseWeights[0].Indptr).To(Equal([]int{0, 1, 1, 3, 4}))
+				Expect(loaded.SparseWeights[0].Indices).To(Equal([]int{1, 0, 2, 2}))
+				Expect(loaded.SparseWeights[0].Data).To(Equal([]float64{0.5, -1, 0.25, 2}))
+				Expect(loaded.Weights[1]).To(Equal(network.Weights[1]))
+			})
+		}
+	})
+
+	context("when a layer is batch normalized", func() {
+		it.Before(func() {
+			var err error
+			network, err = neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{Size: 3},
+					{Size: 2, Func: nodefuncs.Relu{}, BatchNorm: true},
+					{Size: 1, Func: nodefuncs.Sigmoid{}},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			norm := network.Norms[1]
+			norm.Gamma.SetVec(0, 1.5)
+			norm.Beta.SetVec(1, -0.25)
+			norm.RunningMean.SetVec(0, 0.75)
+			norm.RunningVar.SetVec(1, 2)
+			norm.Momentum = 0.8
+		})
+
+		for _, format := range []neuralnet.Format{neuralnet.JSONFormat, neuralnet.BinaryFormat} {
+			format := format
+
+			it("round trips the parameters and running statistics", func() {
+				buffer := bytes.NewBuffer(nil)
+				Expect(network.Save(buffer, format)).To(Succeed())
+
+				loaded, err := neuralnet.Load(buffer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(loaded.LayerConfigs[1].BatchNorm).To(BeTrue())
+				Expect(loaded.Norms).To(Equal(network.Norms))
+
+				input := mat.NewVecDense(3, []float64{0.1, 0.2, 0.3})
+				expected, err := network.Calculate(input)
+				Expect(err).NotTo(HaveOccurred())
+				actual, err := loaded.Calculate(input)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(actual).To(Equal(expected))
+			})
+		}
+	})
+
+	context("when layers use dropout", func() {
+		it.Before(func() {
+			network.LayerConfigs[1].Dropout = 0.25
+		})
+
+		for _, format := range []neuralnet.Format{neuralnet.JSONFormat, neuralnet.BinaryFormat} {
+			format := format
+
+			it("round trips the dropout rates", func() {
+				buffer := bytes.NewBuffer(nil)
+				Expect(network.Save(buffer, format)).To(Succeed())
+
+				loaded, err := neuralnet.Load(buffer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(loaded.LayerConfigs).To(Equal(network.LayerConfigs))
+			})
+		}
+
+		it("fails on an invalid saved rate", func() {
+			_, err := neuralnet.Load(strings.NewReader(`{"version": 4, "layers": [{"size": 1}, {"size": 1, "dropout": 1.5}, {"size": 1}]}`))
+			Expect(err).To(MatchError("invalid dropout rate: 1.5"))
+		})
+	})
+
+	context("when the network is regularized", func() {
+		it.Before(func() {
+			var err error
+			network, err = neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{Size: 3},
+					{Size: 4, Func: nodefuncs.Sigmoid{}, Regularization: &neuralnet.Regularization{L1: 0.5, IncludeBias: true}},
+					{Size: 2, Func: nodefuncs.Sigmoid{}},
+				},
+				Regularization: neuralnet.Regularization{L2: 0.25, MaxNorm: 3},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		for _, format := range []neuralnet.Format{neuralnet.JSONFormat, neuralnet.BinaryFormat} {
+			format := format
+
+			it("round trips the network and layer regularization", func() {
+				buffer := bytes.NewBuffer(nil)
+				Expect(network.Save(buffer, format)).To(Succeed())
+
+				loaded, err := neuralnet.Load(buffer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(loaded.Regularization).To(Equal(network.Regularization))
+				Expect(loaded.LayerConfigs).To(Equal(network.LayerConfigs))
+			})
+		}
+
+		it("fails on an invalid saved regularization", func() {
+			_, err := neuralnet.Load(strings.NewReader(`{"version": 3, "regularization": {"l2": -1}, "layers": [{"size": 1}, {"size": 1}]}`))
+			Expect(err).To(MatchError("invalid regularization: L1 0, L2 -1, MaxNorm 0"))
+		})
+	})
+
+	context("when loading a version 1 network", func() {
+		it("reads dense weights", func() {
+			loaded, err := neuralnet.Load(strings.NewReader(`{
+				"version": 1,
+				"layers": [{"size": 2}, {"size": 1, "func": {"name": "relu"}}],
+				"weights": [{"rows": 1, "cols": 2, "data": [1, 2]}],
+				"bias": [[0, 0], [0.5]]
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := loaded.Calculate(mat.NewVecDense(2, []float64{1, 1}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.AtVec(0)).To(Equal(3.5))
+
+			binaryV1 := bytes.NewBuffer([]byte("SSNN"))
+			for _, value := range []uint32{1, 0, 2, 2, 0, 1, 4} {
+				Expect(binary.Write(binaryV1, binary.BigEndian, value)).To(Succeed())
+			}
+			binaryV1.WriteString("relu")
+			for _, value := range []interface{}{uint32(0), uint32(1), uint32(1), uint32(2), uint32(2), []float64{1, 2}, uint32(2), uint32(2), []float64{0, 0}, uint32(1), []float64{0.5}} {
+				Expect(binary.Write(binaryV1, binary.BigEndian, value)).To(Succeed())
+			}
+
+			loaded, err = neuralnet.Load(binaryV1)
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err = loaded.Calculate(mat.NewVecDense(2, []float64{1, 1}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.AtVec(0)).To(Equal(3.5))
+		})
+	})
+
+	context("JSON format", func() {
+		it("is human readable and versioned", func() {
+			buffer := bytes.NewBuffer(nil)
+			Expect(network.Save(buffer, neuralnet.JSONFormat)).To(Succeed())
+
+			Expect(buffer.String()).To(ContainSubstring(`"version": 7`))
+			Expect(buffer.String()).To(ContainSubstring(`"name": "sigmoid"`))
+			Expect(buffer.String()).To(ContainSubstring(`"name": "softmax"`))
+			Expect(buffer.String()).To(ContainSubstring(`"name": "huber"`))
+		})
+	})
+
+	context("binary format", func() {
+		it("is more compact than JSON", func() {
+			jsonBuffer := bytes.NewBuffer(nil)
+			Expect(network.Save(jsonBuffer, neuralnet.JSONFormat)).To(Succeed())
+
+			binaryBuffer := bytes.NewBuffer(nil)
+			Expect(network.Save(binaryBuffer, neuralnet.BinaryFormat)).To(Succeed())
+
+			Expect(binaryBuffer.Len()).To(BeNumerically("<", jsonBuffer.Len()))
+			Expect(binaryBuffer.String()).To(HavePrefix("SSNN"))
+		})
+	})
+
+	context("failure cases", func() {
+		it("when a NodeFunc is not registered", func() {
+			network.LayerConfigs[1].Func = TestFunc{}
+
+			err := network.Save(bytes.NewBuffer(nil), neuralnet.JSONFormat)
+			Expect(err).To(MatchError("unregistered type: neuralnet_test.TestFunc"))
+		})
+
+		it("when the format is unknown", func() {
+			err := network.Save(bytes.NewBuffer(nil), neuralnet.Format(7))
+			Expect(err).To(MatchError("unknown save format: 7"))
+		})
+
+		it("when the version is unsupported", func() {
+			_, err := neuralnet.Load(strings.NewReader(`{"version": 8}`))
+			Expect(err).To(MatchError("unsupported network version: 8"))
+
+			_, err = neuralnet.Load(strings.NewReader("SSNN\x00\x00\x00\x08"))
+			Expect(err).To(MatchError("unsupported network version: 8"))
+		})
+
+		it("when a saved name is not registered", func() {
+			_, err := neuralnet.Load(strings.NewReader(`{"version": 1, "layers": [{"size": 1}, {"size": 1, "func": {"name": "tanh"}}]}`))
+			Expect(err).To(MatchError("unregistered name: tanh"))
+		})
+
+		it("when parameters do not match the layers", func() {
+			_, err := neuralnet.Load(strings.NewReader(`{
+				"version": 1,
+				"layers": [{"size": 1}, {"size": 2, "func": {"name": "relu"}}],
+				"weights": [{"rows": 1, "cols": 1, "data": [1]}],
+				"bias": [[0], [0, 0]]
+			}`))
+			Expect(err).To(MatchError("invalid weight dimension at index 0: 1x1, expected 2x1"))
+		})
+
+		it("when sparse weights are malformed", func() {
+			_, err := neuralnet.Load(strings.NewReader(`{
+				"version": 2,
+				"layers": [{"size": 2}, {"size": 1, "func": {"name": "relu"}}],
+				"weights": [{"rows": 1, "cols": 2, "data": [1, 2], "indptr": [0, 2], "indices": [1, 0]}],
+				"bias": [[0, 0], [0]]
+			}`))
+			Expect(err).To(MatchError("invalid sparse weights at index 0: mat: index out of range"))
+		})
+
+		it("when batch norm values do not match the layer", func() {
+			_, err := neuralnet.Load(strings.NewReader(`{
+				"version": 5,
+				"layers": [{"size": 1}, {"size": 2, "func": {"name": "relu"}, "batchNorm": {"gamma": [1], "beta": [0, 0], "runningMean": [0, 0], "runningVar": [1, 1]}}],
+				"weights": [{"rows": 2, "cols": 1, "data": [1, 1]}],
+				"bias": [[0], [0, 0]]
+			}`))
+			Expect(err).To(MatchError("invalid batch norm dimension at layer 1: 1, expected 2"))
+		})
+
+		it("when a binary count does not match the declared dimensions", func() {
+			corrupt := bytes.NewBuffer([]byte("SSNN"))
+			for _, value := range []uint32{2, 0, 0, 1, 2, 2, 0x7FFFFFFF} {
+				Expect(binary.Write(corrupt, binary.BigEndian, value)).To(Succeed())
+			}
+
+			_, err := neuralnet.Load(corrupt)
+			Expect(err).To(MatchError("error decoding network: invalid value count: 2147483647, at most 4"))
+		})
+
+		it("when a binary matrix is too large to allocate", func() {
+			corrupt := bytes.NewBuffer([]byte("SSNN"))
+			for _, value := range []uint32{2, 0, 0, 1, 1 << 20, 1 << 20} {
+				Expect(binary.Write(corrupt, binary.BigEndian, value)).To(Succeed())
+			}
+
+			_, err := neuralnet.Load(corrupt)
+			Expect(err).To(MatchError("error decoding network: invalid matrix dimension: 1048576x1048576, at most 67108864 values"))
+		})
+
+		it("when a binary count is larger than the remaining data", func() {
+			corrupt := bytes.NewBuffer([]byte("SSNN"))
+			for _, value := range []uint32{2, 0, 0, 0, 1, 1 << 24} {
+				Expect(binary.Write(corrupt, binary.BigEndian, value)).To(Succeed())
+			}
+
+			_, err := neuralnet.Load(corrupt)
+			Expect(err).To(MatchError("error decoding network: EOF"))
+		})
+
+		it("when layer sizes are invalid", func() {
+			_, err := neuralnet.Load(strings.NewReader(`{"version": 3, "layers": [{"size": 1}, {"size": -1}]}`))
+			Expect(err).To(MatchError("invalid layer size: -1"))
+
+			_, err = neuralnet.Load(strings.NewReader(`{"version": 3, "layers": [{"size": 16777216}, {"size": 16777216}]}`))
+			Expect(err).To(MatchError("invalid weight dimension at index 0: 16777216x16777216, at most 67108864 values"))
+		})
+
+		it("when the binary data is truncated", func() {
+			buffer := bytes.NewBuffer(nil)
+			Expect(network.Save(buffer, neuralnet.BinaryFormat)).To(Succeed())
+
+			_, err := neuralnet.Load(bytes.NewReader(buffer.Bytes()[:buffer.Len()-8]))
+			Expect(err).To(MatchError("error decoding network: unexpected EOF"))
+		})
+	})
+}
@@ -0,0 +1,126 @@
+package neuralnet
+
+import (
+	"fmt"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Predict, PredictWith and ComputeGradient only read the network, so any number of
+// goroutines may call them at once as long as nothing trains the network at the same
+// time. Calculate, the delta and gradient methods and Update all modify network state
+// and are not safe for concurrent use. Workspaces run replicas of the network's layers.
+
+// Workspace holds the layer replicas of a single goroutine's forward passes, a Workspace
+// must not be shared between goroutines
+type Workspace struct {
+	// replicas of a Sequential's Layers, nil for layers that are not ReplicaLayers
+	layers []Layer
+	// first element of the replicated Layers, copies of a Sequential share it
+	source *Layer
+}
+
+// NewWorkspace replicates every layer of the network, see ReplicaLayer. Replicas draw their
+// random values from a source seeded by the network's Rand, so it must not be called
+// concurrently with other network methods.
+func (s *Sequential) NewWorkspace() *Workspace {
+	return &Workspace{layers: s.replicate(rand.New(rand.NewSource(s.Rand.Int63()))), source: &s.Layers[0]}
+}
+
+// replicas of every layer drawing from rng, nil for layers that are not ReplicaLayers
+func (s *Sequential) replicate(rng *rand.Rand) []Layer {
+	result := make([]Layer, len(s.Layers))
+	for idx, layer := range s.Layers {
+		if replicaLayer, ok := layer.(ReplicaLayer); ok {
+			result[idx] = replicaLayer.Replica(rng)
+		}
+	}
+
+	return result
+}
+
+// output of the network for input without modifying the network
+func (s *Sequential) Predict(input *mat.VecDense) (*mat.VecDense, error) {
+	return s.PredictWith(&Workspace{layers: s.replicate(nil), source: &s.Layers[0]}, input)
+}
+
+// Predict running the layer replicas of workspace, which must come from NewWorkspace on
+// the same network
+func (s *Sequential) PredictWith(workspace *Workspace, input *mat.VecDense) (*mat.VecDense, error) {
+	if err := s.checkWorkspace(workspace); err != nil {
+		return nil, err
+	}
+
+	outputs, err := s.forward(workspace.layers, columnOf(input), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return mat.VecDenseCopyOf(outputs[len(outputs)-1].ColView(0)), nil
+}
+
+// parameter gradients for a single sample, computed by the layer replicas of workspace
+// without modifying the network
+func (s *Sequential) ComputeGradient(workspace *Workspace, input, solution *mat.VecDense) (Gradient, error) {
+	if err := s.checkWorkspace(workspace); err != nil {
+		return Gradient{}, err
+	}
+
+	if s.training {
+		if err := s.CheckParallel(); err != nil {
+			return Gradient{}, err
+		}
+	}
+
+	outputs, err := s.forward(workspace.layers, columnOf(input), s.training)
+	if err != nil {
+		return Gradient{}, err
+	}
+
+	output := outputs[len(outputs)-1]
+	solutions := columnOf(solution)
+	if err := s.checkSolutionBatch(output, solutions); err != nil {
+		return Gradient{}, err
+	}
+
+	if _, err := s.backward(workspace.layers, output, solutions); err != nil {
+		return Gradient{}, err
+	}
+
+	return s.layerGradient(workspace.layers, 1), nil
+}
+
+// fails when ComputeGradient cannot train the network, every layer must be a ReplicaLayer
+// and StatefulLayers would never update their State from single samples
+func (s *Sequential) CheckParallel() error {
+	for idx, layer := range s.Layers {
+		if _, ok := layer.(ReplicaLayer); !ok {
+			return fmt.Errorf("layer at index %d cannot be replicated", idx)
+		}
+
+		if _, ok := layer.(StatefulLayer); ok {
+			return fmt.Errorf("layer at index %d updates its state, which requires CalculateBatch in training mode", idx)
+		}
+	}
+
+	return nil
+}
+
+func (s *Sequential) checkWorkspace(workspace *Workspace) error {
+	if len(workspace.layers) != len(s.Layers) {
+		return fmt.Errorf("invalid workspace layer count: %d, expected %d", len(workspace.layers), len(s.Layers))
+	}
+
+	if workspace.source != &s.Layers[0] {
+		return fmt.Errorf("workspace belongs to a different network")
+	}
+
+	for idx, layer := range workspace.layers {
+		if layer == nil {
+			return fmt.Errorf("layer at index %d cannot be replicated", idx)
+		}
+	}
+
+	return nil
+}
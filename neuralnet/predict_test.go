@@ -0,0 +1,175 @@
+package neuralnet_test
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testPredict(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		network neuralnet.Network
+		inputs  []*mat.VecDense
+	)
+
+	it.Before(func() {
+		var err error
+		network, err = neuralnet.NewNetwork(neuralnet.Config{
+			LayerConfigs: []neuralnet.LayerConfig{
+				{
+					Size: 3,
+				},
+				{
+					Size: 5,
+					Func: nodefuncs.Relu{},
+				},
+				{
+					Size: 2,
+					Func: nodefuncs.Softmax{},
+				},
+			},
+			Source: rand.NewSource(11),
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		rng := rand.New(rand.NewSource(12))
+		inputs = nil
+		for idx := 0; idx < 16; idx++ {
+			inputs = append(inputs, mat.NewVecDense(3, []float64{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()}))
+		}
+	})
+
+	context("Predict", func() {
+		it("matches Calculate without touching network state", func() {
+			expected, err := network.Calculate(inputs[0])
+			Expect(err).NotTo(HaveOccurred())
+			activation := network.Activation
+
+			output, err := network.Predict(inputs[1])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).NotTo(Equal(expected))
+
+			output, err = network.Predict(inputs[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal(expected))
+
+			Expect(network.Activation).To(Equal(activation))
+		})
+
+		it("reuses a workspace across calls", func() {
+			workspace := network.NewWorkspace()
+
+			for _, input := range inputs {
+				expected, err := network.Calculate(input)
+				Expect(err).NotTo(HaveOccurred())
+
+				output, err := network.PredictWith(workspace, input)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(output).To(Equal(expected))
+			}
+		})
+
+		// run with -race to check the guarantee that concurrent inference is safe
+		it("is safe for concurrent use", func() {
+			var expected []*mat.VecDense
+			for _, input := range inputs {
+				output, err := network.Predict(input)
+				Expect(err).NotTo(HaveOccurred())
+				expected = append(expected, output)
+			}
+
+			results := make([][]*mat.VecDense, 8)
+			wg := sync.WaitGroup{}
+			for worker := range results {
+				wg.Add(1)
+				go func(worker int) {
+					defer wg.Done()
+
+					workspace := network.NewWorkspace()
+					for idx := range inputs {
+						var output *mat.VecDense
+						if idx%2 == 0 {
+							output, _ = network.Predict(inputs[idx])
+						} else {
+							output, _ = network.PredictWith(workspace, inputs[idx])
+						}
+						results[worker] = append(results[worker], output)
+					}
+				}(worker)
+			}
+			wg.Wait()
+
+			for _, result := range results {
+				Expect(result).To(Equal(expected))
+			}
+		})
+
+		context("ComputeGradient", func() {
+			it("matches GenerateGradient and is safe for concurrent use", func() {
+				solution := mat.NewVecDense(2, []float64{0, 1})
+
+				var expected []neuralnet.Gradient
+				for _, input := range inputs {
+					_, err := network.Calculate(input)
+					Expect(err).NotTo(HaveOccurred())
+					delta, err := network.GenerateDelta(solution)
+					Expect(err).NotTo(HaveOccurred())
+					gradient, err := network.GenerateGradient(delta)
+					Expect(err).NotTo(HaveOccurred())
+					expected = append(expected, gradient)
+				}
+
+				results := make([][]neuralnet.Gradient, 4)
+				wg := sync.WaitGroup{}
+				for worker := range results {
+					wg.Add(1)
+					go func(worker int) {
+						defer wg.Done()
+
+						workspace := network.NewWorkspace()
+						for _, input := range inputs {
+							gradient, _ := network.ComputeGradient(workspace, input, solution)
+							results[worker] = append(results[worker], gradient)
+						}
+					}(worker)
+				}
+				wg.Wait()
+
+				for _, result := range results {
+					Expect(result).To(Equal(expected))
+				}
+			})
+
+			it("fails when the solution has the wrong size", func() {
+				_, err := network.ComputeGradient(network.NewWorkspace(), inputs[0], mat.NewVecDense(3, nil))
+				Expect(err).To(MatchError("invalid solution dimension: 3, expected 2"))
+			})
+		})
+
+		context("failure cases", func() {
+			it("when input has the wrong size", func() {
+				_, err := network.Predict(mat.NewVecDense(2, nil))
+				Expect(err).To(MatchError("invalid input size: 2"))
+			})
+
+			it("when the workspace belongs to a different network", func() {
+				_, err := network.PredictWith(&neuralnet.Workspace{}, inputs[0])
+				Expect(err).To(MatchError("invalid workspace layer count: 0, expected 4"))
+
+				other, err := neuralnet.NewNetwork(neuralnet.Config{LayerConfigs: network.LayerConfigs})
+				Expect(err).NotTo(HaveOccurred())
+				_, err = network.PredictWith(other.NewWorkspace(), inputs[0])
+				Expect(err).To(MatchError("workspace belongs to a different network"))
+			})
+		})
+	})
+}
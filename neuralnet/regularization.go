@@ -0,0 +1,205 @@
+package neuralnet
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Regularization penalizes large weights, zero values disable each term.
+// The penalty L1·Σ|w| + L2/2·Σw² is added to the loss of every sample and its
+// gradient to the weight gradients.
+// Networks regularize the Params a layer decays, see DecayedLayer, and with IncludeBias
+// every other param of the layer too. Row i of the regularized params with the same row
+// count is constrained by MaxNorm together, e.g. the weights and bias of a Dense unit.
+// Rows a SparseLayer leaves out of its gradient are neither penalized nor constrained by
+// an Update. A Network regularizes its Dense layers by LayerConfig.Regularization, its
+// InputLayer by Regularization and never its BatchNorm layers.
+type Regularization struct {
+	L1 float64
+	L2 float64
+	// after every Update the incoming weights of each neuron are rescaled
+	// to an L2 norm of at most MaxNorm
+	MaxNorm float64
+	// biases are neither penalized nor constrained unless IncludeBias is set
+	IncludeBias bool
+}
+
+func (r Regularization) validate() error {
+	if r.L1 < 0 || r.L2 < 0 || r.MaxNorm < 0 {
+		return fmt.Errorf("invalid regularization: L1 %v, L2 %v, MaxNorm %v", r.L1, r.L2, r.MaxNorm)
+	}
+
+	return nil
+}
+
+func (r Regularization) penalizes() bool {
+	return r.L1 != 0 || r.L2 != 0
+}
+
+// regularization of the layer at layerIndex
+func (s *Sequential) regularization(layerIndex int) Regularization {
+	if layerIndex < len(s.LayerRegularization) && s.LayerRegularization[layerIndex] != nil {
+		return *s.LayerRegularization[layerIndex]
+	}
+
+	return s.Regularization
+}
+
+// indices of the Params regularized by reg of the layer at layerIndex
+func (s *Sequential) regularizedParams(layerIndex int, reg Regularization) []int {
+	var result []int
+	for idx, isDecayed := range decayedParams(s.Layers[layerIndex]) {
+		if isDecayed || reg.IncludeBias {
+			result = append(result, idx)
+		}
+	}
+
+	return result
+}
+
+// regularization penalty of a single sample
+func (s *Sequential) penalty() float64 {
+	result := float64(0)
+
+	for layerIndex, layer := range s.Layers {
+		reg := s.regularization(layerIndex)
+		if !reg.penalizes() {
+			continue
+		}
+
+		params := layer.Params()
+		for _, paramIndex := range s.regularizedParams(layerIndex, reg) {
+			for _, v := range params[paramIndex].RawMatrix().Data {
+				result += reg.L1*math.Abs(v) + reg.L2/2*v*v
+			}
+		}
+	}
+
+	return result
+}
+
+// adds count times the penalty gradient, gradient sums over count samples
+func (s *Sequential) addPenaltyGradient(gradient *Gradient, count float64) {
+	for layerIndex, layer := range s.Layers {
+		reg := s.regularization(layerIndex)
+		if !reg.penalizes() {
+			continue
+		}
+
+		params := layer.Params()
+		for _, paramIndex := range s.regularizedParams(layerIndex, reg) {
+			param, grad := params[paramIndex], gradient.Layers[layerIndex][paramIndex]
+
+			rows := gradient.rows(layerIndex, paramIndex)
+			if rows == nil {
+				r, _ := param.Dims()
+				rows = make([]int, r)
+				for i := range rows {
+					rows[i] = i
+				}
+			}
+
+			for k, row := range rows {
+				dst := grad.RawRowView(k)
+				for col, v := range param.RawRowView(row) {
+					dst[col] += count * (reg.L1*sign(v) + reg.L2*v)
+				}
+			}
+		}
+	}
+}
+
+// constrains the rows updated by gradient
+func (s *Sequential) applyMaxNorm(gradient Gradient) {
+	for layerIndex, layer := range s.Layers {
+		reg := s.regularization(layerIndex)
+		if reg.MaxNorm <= 0 {
+			continue
+		}
+
+		if dense, ok := layer.(*Dense); ok && dense.SparseWeights != nil {
+			dense.applyMaxNorm(reg)
+			continue
+		}
+
+		params := layer.Params()
+
+		// regularized params grouped by row count, row i of every param in a group is one unit
+		groups := map[int][]int{}
+		var rowCounts []int
+		for _, paramIndex := range s.regularizedParams(layerIndex, reg) {
+			r, _ := params[paramIndex].Dims()
+			if _, ok := groups[r]; !ok {
+				rowCounts = append(rowCounts, r)
+			}
+
+			groups[r] = append(groups[r], paramIndex)
+		}
+
+		for _, r := range rowCounts {
+			for _, row := range s.updatedRows(gradient, layerIndex, groups[r], r) {
+				sumSquares := float64(0)
+				for _, paramIndex := range groups[r] {
+					for _, v := range params[paramIndex].RawRowView(row) {
+						sumSquares += v * v
+					}
+				}
+
+				norm := math.Sqrt(sumSquares)
+				if norm <= reg.MaxNorm {
+					continue
+				}
+
+				factor := reg.MaxNorm / norm
+				for _, paramIndex := range groups[r] {
+					values := params[paramIndex].RawRowView(row)
+					for k := range values {
+						values[k] *= factor
+					}
+				}
+			}
+		}
+	}
+}
+
+// sorted rows of the params at paramIndices updated by gradient, every one of the
+// rows unless all of the params have sparse gradients
+func (s *Sequential) updatedRows(gradient Gradient, layerIndex int, paramIndices []int, rowCount int) []int {
+	updated := map[int]bool{}
+	var result []int
+
+	for _, paramIndex := range paramIndices {
+		rows := gradient.rows(layerIndex, paramIndex)
+		if rows == nil {
+			result = make([]int, rowCount)
+			for i := range result {
+				result[i] = i
+			}
+
+			return result
+		}
+
+		for _, row := range rows {
+			if !updated[row] {
+				updated[row] = true
+				result = append(result, row)
+			}
+		}
+	}
+
+	sort.Ints(result)
+
+	return result
+}
+
+func sign(x float64) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,242 @@
+package neuralnet_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/gradcheck"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testRegularization(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		input    *mat.VecDense
+		solution *mat.VecDense
+
+		sigmoid = neuralnet.LayerConfig{Func: nodefuncs.Sigmoid{}}
+	)
+
+	gradientOf := func(network *neuralnet.Network) neuralnet.Gradient {
+		_, err := network.Calculate(input)
+		Expect(err).NotTo(HaveOccurred())
+		delta, err := network.GenerateDelta(solution)
+		Expect(err).NotTo(HaveOccurred())
+		gradient, err := network.GenerateGradient(delta)
+		Expect(err).NotTo(HaveOccurred())
+
+		return gradient
+	}
+
+	it.Before(func() {
+		input = mat.NewVecDense(3, []float64{0.3, -0.7, 0.1})
+		solution = mat.NewVecDense(2, []float64{1, 0})
+	})
+
+	context("L1 and L2 penalties", func() {
+		it("adds the penalty gradient to weights only", func() {
+			plain := newNetwork(t, neuralnet.Config{Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			regularized := newNetwork(t, neuralnet.Config{Regularization: neuralnet.Regularization{L1: 0.1, L2: 0.01}, Source: rand.NewSource(21)}, sigmoid, sigmoid)
+
+			expected := gradientOf(&plain)
+			actual := gradientOf(&regularized)
+
+			for idx, weights := range regularized.Weights {
+				expectedWeights, expectedBias := denseGradient(plain, expected, idx+1)
+				actualWeights, actualBias := denseGradient(regularized, actual, idx+1)
+
+				penalty := mat.DenseCopyOf(weights)
+				penalty.Apply(func(_, _ int, v float64) float64 {
+					return 0.1*math.Copysign(1, v) + 0.01*v
+				}, penalty)
+				penalty.Add(penalty, expectedWeights)
+
+				Expect(mat.EqualApprox(actualWeights, penalty, 1e-12)).To(BeTrue())
+				Expect(actualBias).To(Equal(expectedBias))
+			}
+		})
+
+		it("adds the penalty to the loss", func() {
+			plain := newNetwork(t, neuralnet.Config{Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			regularized := newNetwork(t, neuralnet.Config{Regularization: neuralnet.Regularization{L1: 0.1, L2: 0.01}, Source: rand.NewSource(21)}, sigmoid, sigmoid)
+
+			penalty := float64(0)
+			for _, weights := range regularized.Weights {
+				r, c := weights.Dims()
+				for i := 0; i < r; i++ {
+					for j := 0; j < c; j++ {
+						w := weights.At(i, j)
+						penalty += 0.1*math.Abs(w) + 0.005*w*w
+					}
+				}
+			}
+
+			_, err := plain.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := plain.CalcLoss(solution)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = regularized.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+			actual, err := regularized.CalcLoss(solution)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(actual).To(BeNumerically("~", expected+penalty, 1e-12))
+		})
+
+		it("penalizes biases when included", func() {
+			plain := newNetwork(t, neuralnet.Config{Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			regularized := newNetwork(t, neuralnet.Config{Regularization: neuralnet.Regularization{L2: 0.5, IncludeBias: true}, Source: rand.NewSource(21)}, sigmoid, sigmoid)
+
+			expected := gradientOf(&plain)
+			actual := gradientOf(&regularized)
+
+			_, expectedHidden := denseGradient(plain, expected, 1)
+			_, actualHidden := denseGradient(regularized, actual, 1)
+			Expect(actualHidden.AtVec(0)).To(BeNumerically("~", expectedHidden.AtVec(0)+0.15, 1e-12))
+
+			_, expectedOutput := denseGradient(plain, expected, 2)
+			_, actualOutput := denseGradient(regularized, actual, 2)
+			Expect(actualOutput.AtVec(1)).To(BeNumerically("~", expectedOutput.AtVec(1)-0.1, 1e-12))
+		})
+
+		it("uses layer overrides", func() {
+			plain := newNetwork(t, neuralnet.Config{Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			regularized := newNetwork(t, neuralnet.Config{Regularization: neuralnet.Regularization{L2: 0.5}, Source: rand.NewSource(21)}, sigmoid, neuralnet.LayerConfig{Func: nodefuncs.Sigmoid{}, Regularization: &neuralnet.Regularization{}})
+
+			expected := gradientOf(&plain)
+			actual := gradientOf(&regularized)
+
+			expectedHidden, _ := denseGradient(plain, expected, 1)
+			actualHidden, _ := denseGradient(regularized, actual, 1)
+			Expect(actualHidden).NotTo(Equal(expectedHidden))
+
+			expectedOutput, _ := denseGradient(plain, expected, 2)
+			actualOutput, _ := denseGradient(regularized, actual, 2)
+			Expect(actualOutput).To(Equal(expectedOutput))
+		})
+
+		it("agrees with finite differences", func() {
+			network := newNetwork(t, neuralnet.Config{Regularization: neuralnet.Regularization{L1: 0.05, L2: 0.2, IncludeBias: true}, Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			Expect(network.Sparsify(1, 0.1)).To(Succeed())
+
+			report, err := gradcheck.Check(&network, input, solution, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Max()).To(BeNumerically("<", 1e-5))
+		})
+
+		it("counts the penalty once per sample when batched", func() {
+			network := newNetwork(t, neuralnet.Config{Regularization: neuralnet.Regularization{L1: 0.1, L2: 0.01}, Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			inputs := mat.NewDense(3, 2, []float64{0.3, 1, -0.7, 0, 0.1, -1})
+			solutions := mat.NewDense(2, 2, []float64{1, 0, 0, 1})
+
+			var (
+				expected     neuralnet.Gradient
+				expectedLoss float64
+			)
+			for j := 0; j < 2; j++ {
+				input = mat.VecDenseCopyOf(inputs.ColView(j))
+				solution = mat.VecDenseCopyOf(solutions.ColView(j))
+				expected.Add(gradientOf(&network))
+
+				loss, err := network.CalcLoss(solution)
+				Expect(err).NotTo(HaveOccurred())
+				expectedLoss += loss
+			}
+
+			_, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			loss, err := network.CalcLossBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loss).To(BeNumerically("~", expectedLoss, 1e-12))
+
+			delta, err := network.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err := network.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(equalGradients(gradient, expected, 1e-12)).To(BeTrue())
+		})
+	})
+
+	context("MaxNorm", func() {
+		rowNorms := func(network neuralnet.Network, index int) []float64 {
+			weights := network.Weights[index]
+			if weights == nil {
+				weights = network.SparseWeights[index].ToDense()
+			}
+
+			var result []float64
+			r, _ := weights.Dims()
+			for i := 0; i < r; i++ {
+				result = append(result, mat.Norm(weights.RowView(i), 2))
+			}
+
+			return result
+		}
+
+		it("rescales incoming weights after every update", func() {
+			network := newNetwork(t, neuralnet.Config{Regularization: neuralnet.Regularization{MaxNorm: 0.5}, Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			Expect(network.Sparsify(1, 0)).To(Succeed())
+			network.Weights[0].Set(0, 0, 3)
+
+			before := rowNorms(network, 0)
+			Expect(before[0]).To(BeNumerically(">", 0.5))
+
+			Expect(network.Update(gradientOf(&network))).To(Succeed())
+
+			for idx := range network.Weights {
+				for _, norm := range rowNorms(network, idx) {
+					Expect(norm).To(BeNumerically("<=", 0.5+1e-12))
+				}
+			}
+			Expect(rowNorms(network, 0)[0]).To(BeNumerically("~", 0.5, 1e-12))
+		})
+
+		it("leaves biases unconstrained by default", func() {
+			plain := newNetwork(t, neuralnet.Config{Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			Expect(plain.Update(gradientOf(&plain))).To(Succeed())
+
+			network := newNetwork(t, neuralnet.Config{Regularization: neuralnet.Regularization{MaxNorm: 0.01}, Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			Expect(network.Update(gradientOf(&network))).To(Succeed())
+
+			Expect(network.Bias).To(Equal(plain.Bias))
+			Expect(network.Weights).NotTo(Equal(plain.Weights))
+		})
+
+		it("includes biases when configured", func() {
+			network := newNetwork(t, neuralnet.Config{Regularization: neuralnet.Regularization{MaxNorm: 0.1, IncludeBias: true}, Source: rand.NewSource(21)}, sigmoid, sigmoid)
+			Expect(network.Update(gradientOf(&network))).To(Succeed())
+
+			row := mat.NewVecDense(4, nil)
+			row.CopyVec(network.Weights[0].RowView(0))
+			sumSquares := mat.Dot(row, row) + network.Bias[1].AtVec(0)*network.Bias[1].AtVec(0)
+			Expect(math.Sqrt(sumSquares)).To(BeNumerically("~", 0.1, 1e-12))
+		})
+	})
+
+	context("failure cases", func() {
+		it("when a regularization value is negative", func() {
+			_, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{
+						Size: 1,
+					},
+					{
+						Size:           1,
+						Func:           nodefuncs.Sigmoid{},
+						Regularization: &neuralnet.Regularization{L2: -1},
+					},
+				},
+			})
+			Expect(err).To(MatchError("invalid regularization: L1 0, L2 -1, MaxNorm 0"))
+		})
+	})
+}
@@ -0,0 +1,14 @@
+package schedules_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitSchedules(t *testing.T) {
+	suite := spec.New("Schedules", spec.Report(report.Terminal{}))
+	suite("Schedules", testSchedules)
+	suite.Run(t)
+}
@@ -0,0 +1,318 @@
+package schedules
+
+import (
+	"fmt"
+	"math"
+)
+
+// Schedules return the learning rate of the update at step, counted from 0 over every
+// epoch of a training run, during epoch, also counted from 0. Each schedule is driven by
+// one of the two counters. Every schedule is built by its New function, which validates
+// its config. Hyperparameters are used as given, so 0 means 0: a rate of 0 leaves the
+// network unchanged, and a value that cannot be honoured, e.g. a Factor of 0, is an error
+// rather than replaced by a default.
+
+type Schedule interface {
+	LearningRate(step, epoch int) float64
+}
+
+// Schedules that also implement MetricSchedule are told the validation metric after
+// every epoch, e.g. to reduce the learning rate once it stops improving
+type MetricSchedule interface {
+	Schedule
+	Observe(value float64)
+}
+
+///
+/// Constant Def
+///
+type Constant struct {
+	rate float64
+}
+
+func NewConstant(rate float64) (Constant, error) {
+	if rate < 0 {
+		return Constant{}, fmt.Errorf("invalid constant rate: %v", rate)
+	}
+
+	return Constant{rate: rate}, nil
+}
+
+func (c Constant) LearningRate(_, _ int) float64 {
+	return c.rate
+}
+
+///
+/// StepDecay Def
+///
+// multiplies Initial by Factor every EpochsPerDrop epochs
+type StepDecayConfig struct {
+	Initial       float64
+	Factor        float64
+	EpochsPerDrop int
+}
+
+type StepDecay struct {
+	config StepDecayConfig
+}
+
+func NewStepDecay(config StepDecayConfig) (StepDecay, error) {
+	switch {
+	case config.Initial < 0:
+		return StepDecay{}, fmt.Errorf("invalid step decay initial rate: %v", config.Initial)
+	case config.Factor <= 0 || config.Factor > 1:
+		return StepDecay{}, fmt.Errorf("invalid step decay factor: %v, expected a value in (0, 1]", config.Factor)
+	case config.EpochsPerDrop < 1:
+		return StepDecay{}, fmt.Errorf("invalid step decay epochs per drop: %d", config.EpochsPerDrop)
+	}
+
+	return StepDecay{config: config}, nil
+}
+
+func (s StepDecay) LearningRate(_, epoch int) float64 {
+	return s.config.Initial * math.Pow(s.config.Factor, float64(epoch/s.config.EpochsPerDrop))
+}
+
+///
+/// ExponentialDecay Def
+///
+// multiplies Initial by Rate over every DecaySteps steps, continuously in between
+// unless Staircase is set
+type ExponentialDecayConfig struct {
+	Initial    float64
+	Rate       float64
+	DecaySteps int
+	Staircase  bool
+}
+
+type ExponentialDecay struct {
+	config ExponentialDecayConfig
+}
+
+func NewExponentialDecay(config ExponentialDecayConfig) (ExponentialDecay, error) {
+	switch {
+	case config.Initial < 0:
+		return ExponentialDecay{}, fmt.Errorf("invalid exponential decay initial rate: %v", config.Initial)
+	case config.Rate <= 0 || config.Rate > 1:
+		return ExponentialDecay{}, fmt.Errorf("invalid exponential decay rate: %v, expected a value in (0, 1]", config.Rate)
+	case config.DecaySteps < 1:
+		return ExponentialDecay{}, fmt.Errorf("invalid exponential decay steps: %d", config.DecaySteps)
+	}
+
+	return ExponentialDecay{config: config}, nil
+}
+
+func (e ExponentialDecay) LearningRate(step, _ int) float64 {
+	exponent := float64(step) / float64(e.config.DecaySteps)
+	if e.config.Staircase {
+		exponent = math.Floor(exponent)
+	}
+
+	return e.config.Initial * math.Pow(e.config.Rate, exponent)
+}
+
+///
+/// CosineRestarts Def
+///
+// anneals from Initial to Minimum along half a cosine over Period steps, then restarts
+// at Initial with the period multiplied by PeriodFactor, 1 keeps every period the same
+type CosineRestartsConfig struct {
+	Initial      float64
+	Minimum      float64
+	Period       int
+	PeriodFactor float64
+}
+
+type CosineRestarts struct {
+	config CosineRestartsConfig
+}
+
+// fails on a PeriodFactor below 1, whose periods would shrink until the restarts stop
+func NewCosineRestarts(config CosineRestartsConfig) (CosineRestarts, error) {
+	switch {
+	case config.Minimum < 0 || config.Minimum > config.Initial:
+		return CosineRestarts{}, fmt.Errorf("invalid cosine restarts rates: %v to %v", config.Initial, config.Minimum)
+	case config.Period < 1:
+		return CosineRestarts{}, fmt.Errorf("invalid cosine restarts period: %d", config.Period)
+	case config.PeriodFactor < 1:
+		return CosineRestarts{}, fmt.Errorf("invalid cosine restarts period factor: %v, expected at least 1", config.PeriodFactor)
+	}
+
+	return CosineRestarts{config: config}, nil
+}
+
+func (c CosineRestarts) LearningRate(step, _ int) float64 {
+	position := float64(step)
+	period := float64(c.config.Period)
+
+	if c.config.PeriodFactor == 1 {
+		position = math.Mod(position, period)
+	}
+
+	for position >= period {
+		position -= period
+		period *= c.config.PeriodFactor
+	}
+
+	return cosine(c.config.Initial, c.config.Minimum, position/period)
+}
+
+///
+/// Warmup Def
+///
+// increases the rate linearly over the first Steps steps until it reaches the first
+// rate of Schedule, which then continues from its own step 0. 0 Steps skip the warmup.
+type WarmupConfig struct {
+	Steps    int
+	Schedule Schedule
+}
+
+type Warmup struct {
+	config WarmupConfig
+}
+
+func NewWarmup(config WarmupConfig) (Warmup, error) {
+	switch {
+	case config.Steps < 0:
+		return Warmup{}, fmt.Errorf("invalid warmup steps: %d", config.Steps)
+	case config.Schedule == nil:
+		return Warmup{}, fmt.Errorf("warmup requires a schedule")
+	}
+
+	return Warmup{config: config}, nil
+}
+
+func (w Warmup) LearningRate(step, epoch int) float64 {
+	if step >= w.config.Steps {
+		return w.config.Schedule.LearningRate(step-w.config.Steps, epoch)
+	}
+
+	return w.config.Schedule.LearningRate(0, epoch) * float64(step+1) / float64(w.config.Steps+1)
+}
+
+///
+/// OneCycle Def
+///
+// anneals from Max/DivFactor up to Max over the first WarmupFraction of TotalSteps,
+// then down to Max/(DivFactor*FinalDivFactor) at TotalSteps where it stays. A
+// WarmupFraction of 0 starts at Max, factors of 1 keep the rate at either end.
+type OneCycleConfig struct {
+	Max            float64
+	TotalSteps     int
+	WarmupFraction float64
+	DivFactor      float64
+	FinalDivFactor float64
+}
+
+type OneCycle struct {
+	config OneCycleConfig
+}
+
+func NewOneCycle(config OneCycleConfig) (OneCycle, error) {
+	switch {
+	case config.Max < 0:
+		return OneCycle{}, fmt.Errorf("invalid one cycle max rate: %v", config.Max)
+	case config.TotalSteps < 1:
+		return OneCycle{}, fmt.Errorf("invalid one cycle total steps: %d", config.TotalSteps)
+	case config.WarmupFraction < 0 || config.WarmupFraction >= 1:
+		return OneCycle{}, fmt.Errorf("invalid one cycle warmup fraction: %v, expected a value in [0, 1)", config.WarmupFraction)
+	case config.DivFactor < 1:
+		return OneCycle{}, fmt.Errorf("invalid one cycle div factor: %v, expected at least 1", config.DivFactor)
+	case config.FinalDivFactor < 1:
+		return OneCycle{}, fmt.Errorf("invalid one cycle final div factor: %v, expected at least 1", config.FinalDivFactor)
+	}
+
+	return OneCycle{config: config}, nil
+}
+
+func (o OneCycle) LearningRate(step, _ int) float64 {
+	initial := o.config.Max / o.config.DivFactor
+	final := initial / o.config.FinalDivFactor
+
+	totalSteps := float64(o.config.TotalSteps)
+	warmupSteps := o.config.WarmupFraction * totalSteps
+	position := float64(step)
+
+	switch {
+	case position < warmupSteps:
+		return cosine(initial, o.config.Max, position/warmupSteps)
+	case step < o.config.TotalSteps:
+		return cosine(o.config.Max, final, (position-warmupSteps)/(totalSteps-warmupSteps))
+	default:
+		return final
+	}
+}
+
+///
+/// ReduceOnPlateau Def
+///
+// multiplies the rate, starting at Initial, by Factor once the observed metric has not
+// improved by more than MinDelta for Patience epochs in a row, the rate never drops below
+// Minimum. Lower values are better unless Maximize is set. A Patience of 0 reduces the
+// rate after every epoch without improvement. A NaN value never improves and never
+// becomes the best one, as with early stopping in neuraltools.
+type ReduceOnPlateauConfig struct {
+	Initial  float64
+	Factor   float64
+	Patience int
+	MinDelta float64
+	Minimum  float64
+	Maximize bool
+}
+
+type ReduceOnPlateau struct {
+	config ReduceOnPlateauConfig
+
+	rate     float64
+	best     float64
+	observed bool
+	wait     int
+}
+
+func NewReduceOnPlateau(config ReduceOnPlateauConfig) (*ReduceOnPlateau, error) {
+	switch {
+	case config.Minimum < 0 || config.Minimum > config.Initial:
+		return nil, fmt.Errorf("invalid reduce on plateau rates: %v to %v", config.Initial, config.Minimum)
+	case config.Factor <= 0 || config.Factor >= 1:
+		return nil, fmt.Errorf("invalid reduce on plateau factor: %v, expected a value in (0, 1)", config.Factor)
+	case config.Patience < 0:
+		return nil, fmt.Errorf("invalid reduce on plateau patience: %d", config.Patience)
+	case config.MinDelta < 0:
+		return nil, fmt.Errorf("invalid reduce on plateau min delta: %v", config.MinDelta)
+	}
+
+	return &ReduceOnPlateau{config: config, rate: config.Initial}, nil
+}
+
+func (r *ReduceOnPlateau) LearningRate(_, _ int) float64 {
+	return r.rate
+}
+
+func (r *ReduceOnPlateau) Observe(value float64) {
+	if !math.IsNaN(value) && (!r.observed || r.improves(value)) {
+		r.best = value
+		r.observed = true
+		r.wait = 0
+
+		return
+	}
+
+	r.wait++
+	if r.wait >= r.config.Patience {
+		r.rate = math.Max(r.rate*r.config.Factor, r.config.Minimum)
+		r.wait = 0
+	}
+}
+
+func (r *ReduceOnPlateau) improves(value float64) bool {
+	if r.config.Maximize {
+		return value > r.best+r.config.MinDelta
+	}
+
+	return value < r.best-r.config.MinDelta
+}
+
+// from start at position 0 to end at position 1 along half a cosine
+func cosine(start, end, position float64) float64 {
+	return end + (start-end)*(1+math.Cos(math.Pi*position))/2
+}
@@ -0,0 +1,279 @@
+package schedules_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/schedules"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testSchedules(t *testing.T, context spec.G, it spec.S) {
+	var Expect = NewWithT(t).Expect
+
+	// rates of the first count steps, all within epoch 0
+	rates := func(schedule schedules.Schedule, count int) []float64 {
+		var result []float64
+		for step := 0; step < count; step++ {
+			result = append(result, schedule.LearningRate(step, 0))
+		}
+
+		return result
+	}
+
+	context("Constant", func() {
+		it("returns its rate", func() {
+			schedule, err := schedules.NewConstant(0.1)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(schedule.LearningRate(7, 3)).To(Equal(0.1))
+		})
+
+		it("keeps a rate of 0", func() {
+			schedule, err := schedules.NewConstant(0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(schedule.LearningRate(7, 3)).To(BeZero())
+		})
+
+		it("fails on a negative rate", func() {
+			_, err := schedules.NewConstant(-0.1)
+			Expect(err).To(MatchError("invalid constant rate: -0.1"))
+		})
+	})
+
+	context("StepDecay", func() {
+		it("drops the rate every EpochsPerDrop epochs", func() {
+			schedule, err := schedules.NewStepDecay(schedules.StepDecayConfig{Initial: 0.8, Factor: 0.25, EpochsPerDrop: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(schedule.LearningRate(100, 0)).To(Equal(0.8))
+			Expect(schedule.LearningRate(100, 1)).To(Equal(0.8))
+			Expect(schedule.LearningRate(0, 2)).To(Equal(0.2))
+			Expect(schedule.LearningRate(0, 5)).To(Equal(0.05))
+		})
+
+		it("fails on an invalid config", func() {
+			_, err := schedules.NewStepDecay(schedules.StepDecayConfig{Initial: -1, Factor: 0.5, EpochsPerDrop: 1})
+			Expect(err).To(MatchError("invalid step decay initial rate: -1"))
+
+			_, err = schedules.NewStepDecay(schedules.StepDecayConfig{Initial: 0.8, EpochsPerDrop: 1})
+			Expect(err).To(MatchError("invalid step decay factor: 0, expected a value in (0, 1]"))
+
+			_, err = schedules.NewStepDecay(schedules.StepDecayConfig{Initial: 0.8, Factor: -0.5, EpochsPerDrop: 1})
+			Expect(err).To(MatchError("invalid step decay factor: -0.5, expected a value in (0, 1]"))
+
+			_, err = schedules.NewStepDecay(schedules.StepDecayConfig{Initial: 0.8, Factor: 0.5, EpochsPerDrop: -2})
+			Expect(err).To(MatchError("invalid step decay epochs per drop: -2"))
+		})
+	})
+
+	context("ExponentialDecay", func() {
+		it("decays continuously over DecaySteps", func() {
+			schedule, err := schedules.NewExponentialDecay(schedules.ExponentialDecayConfig{Initial: 1, Rate: 0.25, DecaySteps: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(schedule.LearningRate(0, 0)).To(Equal(float64(1)))
+			Expect(schedule.LearningRate(1, 0)).To(BeNumerically("~", 0.5, 1e-12))
+			Expect(schedule.LearningRate(4, 0)).To(BeNumerically("~", 0.0625, 1e-12))
+		})
+
+		it("decays in steps with Staircase", func() {
+			schedule, err := schedules.NewExponentialDecay(schedules.ExponentialDecayConfig{Initial: 1, Rate: 0.25, DecaySteps: 2, Staircase: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(rates(schedule, 5)).To(Equal([]float64{1, 1, 0.25, 0.25, 0.0625}))
+		})
+
+		it("fails on an invalid config", func() {
+			_, err := schedules.NewExponentialDecay(schedules.ExponentialDecayConfig{Initial: 1, DecaySteps: 2})
+			Expect(err).To(MatchError("invalid exponential decay rate: 0, expected a value in (0, 1]"))
+
+			_, err = schedules.NewExponentialDecay(schedules.ExponentialDecayConfig{Initial: 1, Rate: 0.5})
+			Expect(err).To(MatchError("invalid exponential decay steps: 0"))
+		})
+	})
+
+	context("CosineRestarts", func() {
+		it("anneals to Minimum and restarts", func() {
+			schedule, err := schedules.NewCosineRestarts(schedules.CosineRestartsConfig{Initial: 1, Minimum: 0.2, Period: 4, PeriodFactor: 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			actual := rates(schedule, 6)
+			Expect(actual[0]).To(Equal(float64(1)))
+			Expect(actual[2]).To(BeNumerically("~", 0.6, 1e-12))
+			Expect(actual[3]).To(BeNumerically("~", 0.2+0.8*(1+math.Cos(0.75*math.Pi))/2, 1e-12))
+			Expect(actual[4]).To(Equal(float64(1)))
+			Expect(actual[5]).To(Equal(actual[1]))
+		})
+
+		it("grows every period by PeriodFactor", func() {
+			schedule, err := schedules.NewCosineRestarts(schedules.CosineRestartsConfig{Initial: 1, Period: 2, PeriodFactor: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			// restarts at steps 2, 6 and 14
+			Expect(schedule.LearningRate(4, 0)).To(BeNumerically("~", 0.5, 1e-12))
+			Expect(schedule.LearningRate(6, 0)).To(Equal(float64(1)))
+			Expect(schedule.LearningRate(10, 0)).To(BeNumerically("~", 0.5, 1e-12))
+			Expect(schedule.LearningRate(14, 0)).To(Equal(float64(1)))
+		})
+
+		it("restarts with a PeriodFactor of 1 on late steps", func() {
+			schedule, err := schedules.NewCosineRestarts(schedules.CosineRestartsConfig{Initial: 1, Period: 4, PeriodFactor: 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(schedule.LearningRate(4e9+2, 0)).To(Equal(schedule.LearningRate(2, 0)))
+		})
+
+		it("fails on an invalid config", func() {
+			_, err := schedules.NewCosineRestarts(schedules.CosineRestartsConfig{Initial: 1, PeriodFactor: 1})
+			Expect(err).To(MatchError("invalid cosine restarts period: 0"))
+
+			_, err = schedules.NewCosineRestarts(schedules.CosineRestartsConfig{Initial: 1, Period: 2})
+			Expect(err).To(MatchError("invalid cosine restarts period factor: 0, expected at least 1"))
+
+			_, err = schedules.NewCosineRestarts(schedules.CosineRestartsConfig{Initial: 0.1, Minimum: 0.2, Period: 2, PeriodFactor: 1})
+			Expect(err).To(MatchError("invalid cosine restarts rates: 0.1 to 0.2"))
+
+			_, err = schedules.NewCosineRestarts(schedules.CosineRestartsConfig{Initial: 1, Period: 2, PeriodFactor: 0.5})
+			Expect(err).To(MatchError("invalid cosine restarts period factor: 0.5, expected at least 1"))
+		})
+	})
+
+	context("Warmup", func() {
+		it("ramps up linearly before continuing with Schedule", func() {
+			decay, err := schedules.NewExponentialDecay(schedules.ExponentialDecayConfig{Initial: 0.8, Rate: 0.5, DecaySteps: 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			schedule, err := schedules.NewWarmup(schedules.WarmupConfig{Steps: 3, Schedule: decay})
+			Expect(err).NotTo(HaveOccurred())
+
+			for step, expected := range []float64{0.2, 0.4, 0.6, 0.8, 0.4} {
+				Expect(schedule.LearningRate(step, 0)).To(BeNumerically("~", expected, 1e-12))
+			}
+		})
+
+		it("continues with Schedule right away without Steps", func() {
+			decay, err := schedules.NewExponentialDecay(schedules.ExponentialDecayConfig{Initial: 0.8, Rate: 0.5, DecaySteps: 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			schedule, err := schedules.NewWarmup(schedules.WarmupConfig{Schedule: decay})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(rates(schedule, 3)).To(Equal(rates(decay, 3)))
+		})
+
+		it("fails on an invalid config", func() {
+			constant, err := schedules.NewConstant(0.1)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = schedules.NewWarmup(schedules.WarmupConfig{Steps: -1, Schedule: constant})
+			Expect(err).To(MatchError("invalid warmup steps: -1"))
+
+			_, err = schedules.NewWarmup(schedules.WarmupConfig{Steps: 3})
+			Expect(err).To(MatchError("warmup requires a schedule"))
+		})
+	})
+
+	context("OneCycle", func() {
+		it("rises to Max and anneals to its final rate", func() {
+			schedule, err := schedules.NewOneCycle(schedules.OneCycleConfig{Max: 1, TotalSteps: 10, WarmupFraction: 0.2, DivFactor: 10, FinalDivFactor: 100})
+			Expect(err).NotTo(HaveOccurred())
+
+			actual := rates(schedule, 12)
+			Expect(actual[0]).To(BeNumerically("~", 0.1, 1e-12))
+			Expect(actual[1]).To(BeNumerically("~", 0.55, 1e-12))
+			Expect(actual[2]).To(BeNumerically("~", 1, 1e-12))
+			Expect(actual[6]).To(BeNumerically("~", (1+0.001)/2, 1e-12))
+			Expect(actual[10]).To(BeNumerically("~", 0.001, 1e-12))
+			Expect(actual[11]).To(Equal(actual[10]))
+
+			for step := 3; step < 10; step++ {
+				Expect(actual[step]).To(BeNumerically("<", actual[step-1]))
+			}
+		})
+
+		it("starts at Max without a WarmupFraction", func() {
+			schedule, err := schedules.NewOneCycle(schedules.OneCycleConfig{Max: 2.5, TotalSteps: 10, DivFactor: 25, FinalDivFactor: 1e4})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(schedule.LearningRate(0, 0)).To(Equal(2.5))
+			Expect(schedule.LearningRate(5, 0)).To(BeNumerically("~", (2.5+1e-5)/2, 1e-12))
+			Expect(schedule.LearningRate(10, 0)).To(BeNumerically("~", 1e-5, 1e-12))
+		})
+
+		it("fails on an invalid config", func() {
+			_, err := schedules.NewOneCycle(schedules.OneCycleConfig{Max: 1, DivFactor: 25, FinalDivFactor: 1e4})
+			Expect(err).To(MatchError("invalid one cycle total steps: 0"))
+
+			_, err = schedules.NewOneCycle(schedules.OneCycleConfig{Max: 1, TotalSteps: 10, WarmupFraction: 1, DivFactor: 25, FinalDivFactor: 1e4})
+			Expect(err).To(MatchError("invalid one cycle warmup fraction: 1, expected a value in [0, 1)"))
+
+			_, err = schedules.NewOneCycle(schedules.OneCycleConfig{Max: 1, TotalSteps: 10, FinalDivFactor: 1e4})
+			Expect(err).To(MatchError("invalid one cycle div factor: 0, expected at least 1"))
+
+			_, err = schedules.NewOneCycle(schedules.OneCycleConfig{Max: 1, TotalSteps: 10, DivFactor: 25, FinalDivFactor: -1})
+			Expect(err).To(MatchError("invalid one cycle final div factor: -1, expected at least 1"))
+		})
+	})
+
+	context("ReduceOnPlateau", func() {
+		it("reduces the rate once the metric stops improving", func() {
+			schedule, err := schedules.NewReduceOnPlateau(schedules.ReduceOnPlateauConfig{Initial: 1, Factor: 0.5, Patience: 2, MinDelta: 0.1, Minimum: 0.3})
+			Expect(err).NotTo(HaveOccurred())
+
+			var actual []float64
+			for _, loss := range []float64{1, 0.8, 0.75, 0.85, 0.65, 0.72, 0.5, 0.6, 0.6, 0.6, 0.6} {
+				actual = append(actual, schedule.LearningRate(0, 0))
+				schedule.Observe(loss)
+			}
+			actual = append(actual, schedule.LearningRate(0, 0))
+
+			// improvements at 1, 0.8, 0.65 and 0.5
+			Expect(actual).To(Equal([]float64{1, 1, 1, 1, 0.5, 0.5, 0.5, 0.5, 0.5, 0.3, 0.3, 0.3}))
+		})
+
+		it("watches for increases with Maximize", func() {
+			schedule, err := schedules.NewReduceOnPlateau(schedules.ReduceOnPlateauConfig{Initial: 1, Factor: 0.1, Maximize: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			schedule.Observe(0.5)
+			schedule.Observe(0.6)
+			Expect(schedule.LearningRate(0, 0)).To(Equal(float64(1)))
+
+			schedule.Observe(0.4)
+			Expect(schedule.LearningRate(0, 0)).To(BeNumerically("~", 0.1, 1e-12))
+		})
+
+		it("never takes a NaN value as the best one", func() {
+			schedule, err := schedules.NewReduceOnPlateau(schedules.ReduceOnPlateauConfig{Initial: 1, Factor: 0.5, Patience: 1})
+			Expect(err).NotTo(HaveOccurred())
+
+			// NaN values count as epochs without improvement
+			schedule.Observe(math.NaN())
+			Expect(schedule.LearningRate(0, 0)).To(Equal(0.5))
+
+			// the first measured value is the best, later improvements keep the rate
+			for _, value := range []float64{3, 2, 1} {
+				schedule.Observe(value)
+			}
+			Expect(schedule.LearningRate(0, 0)).To(Equal(0.5))
+		})
+
+		it("fails on an invalid config", func() {
+			_, err := schedules.NewReduceOnPlateau(schedules.ReduceOnPlateauConfig{Initial: 1})
+			Expect(err).To(MatchError("invalid reduce on plateau factor: 0, expected a value in (0, 1)"))
+
+			_, err = schedules.NewReduceOnPlateau(schedules.ReduceOnPlateauConfig{Initial: 1, Factor: 0.5, Patience: -1})
+			Expect(err).To(MatchError("invalid reduce on plateau patience: -1"))
+
+			_, err = schedules.NewReduceOnPlateau(schedules.ReduceOnPlateauConfig{Initial: 1, Factor: 0.5, MinDelta: -0.1})
+			Expect(err).To(MatchError("invalid reduce on plateau min delta: -0.1"))
+
+			_, err = schedules.NewReduceOnPlateau(schedules.ReduceOnPlateauConfig{Initial: 0.1, Factor: 0.5, Minimum: 0.2})
+			Expect(err).To(MatchError("invalid reduce on plateau rates: 0.1 to 0.2"))
+		})
+	})
+}
@@ -0,0 +1,430 @@
+package neuralnet
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Sequential composes arbitrary Layers, each layer's output is the next layer's input.
+// Single sample methods run a batch with one column, so both share the same layers.
+// A Network is the Sequential NewNetwork builds from LayerConfigs. Sequentials are saved
+// with Save and read with LoadSequential, which requires every layer to be registered with
+// RegisterLayer.
+
+type SequentialConfig struct {
+	// shape of a single input sample
+	InputShape Shape
+	Layers     []Layer
+	// defaults to losses.MSE
+	Loss Loss
+	// defaults to plain SGD with a learning rate of .01
+	Optimizer Optimizer
+	// applies to every layer without an entry in LayerRegularization
+	Regularization Regularization
+	// overrides Regularization for the layer at the same index, nil entries and layers
+	// past the end use Regularization
+	LayerRegularization []*Regularization
+	// seeds the random values of workspaces, when nil a source is seeded from the global
+	// math/rand source
+	Source rand.Source
+}
+
+type Sequential struct {
+	InputShape          Shape
+	OutputShape         Shape
+	Layers              []Layer
+	Loss                Loss
+	Optimizer           Optimizer
+	Regularization      Regularization
+	LayerRegularization []*Regularization
+	Rand                *rand.Rand
+
+	// output of the most recent Calculate or CalculateBatch call
+	output   *mat.Dense
+	training bool
+}
+
+func NewSequential(config SequentialConfig) (Sequential, error) {
+	result := Sequential{
+		InputShape:          config.InputShape,
+		Layers:              config.Layers,
+		Loss:                config.Loss,
+		Optimizer:           config.Optimizer,
+		Regularization:      config.Regularization,
+		LayerRegularization: config.LayerRegularization,
+	}
+
+	if result.Loss == nil {
+		result.Loss = losses.MSE{}
+	}
+
+	if result.Optimizer == nil {
+		result.Optimizer = optimizers.NewSGD(.01)
+	}
+
+	source := config.Source
+	if source == nil {
+		source = rand.NewSource(rand.Int63())
+	}
+	result.Rand = rand.New(source)
+
+	if len(result.Layers) == 0 {
+		return Sequential{}, fmt.Errorf("layers must contain at least 1 element")
+	}
+
+	if err := result.InputShape.validate(); err != nil {
+		return Sequential{}, err
+	}
+
+	if len(result.LayerRegularization) > len(result.Layers) {
+		return Sequential{}, fmt.Errorf("invalid layer regularization count: %d, expected at most %d", len(result.LayerRegularization), len(result.Layers))
+	}
+
+	if err := result.Regularization.validate(); err != nil {
+		return Sequential{}, err
+	}
+
+	if err := validateLoss(result.Loss); err != nil {
+		return Sequential{}, err
+	}
+
+	for _, regularization := range result.LayerRegularization {
+		if regularization == nil {
+			continue
+		}
+
+		if err := regularization.validate(); err != nil {
+			return Sequential{}, err
+		}
+	}
+
+	shape := result.InputShape
+	for idx, layer := range result.Layers {
+		var err error
+		if shape, err = layer.OutputShape(shape); err != nil {
+			return Sequential{}, fmt.Errorf("invalid layer at index %d: %s", idx, err)
+		}
+	}
+
+	result.OutputShape = shape
+
+	return result, nil
+}
+
+// Networks start in inference mode. Training mode is passed to every Layer's Forward, so
+// in training mode Calculate, CalculateBatch and ComputeGradient apply dropout and batch
+// normalization uses the statistics of each batch. Predict always runs in inference mode.
+func (s *Sequential) SetTraining(training bool) {
+	s.training = training
+}
+
+func (s *Sequential) IsTraining() bool {
+	return s.training
+}
+
+func (s *Sequential) Calculate(input *mat.VecDense) (*mat.VecDense, error) {
+	output, err := s.CalculateBatch(columnOf(input))
+	if err != nil {
+		return nil, err
+	}
+
+	return mat.VecDenseCopyOf(output.ColView(0)), nil
+}
+
+func (s *Sequential) CalculateBatch(input *mat.Dense) (*mat.Dense, error) {
+	outputs, err := s.calculate(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return mat.DenseCopyOf(outputs[len(outputs)-1]), nil
+}
+
+// outputs of the forward pass of Layers, whose last one the loss and delta methods use
+func (s *Sequential) calculate(input *mat.Dense) ([]*mat.Dense, error) {
+	s.output = nil
+
+	outputs, err := s.forward(s.Layers, input, s.training)
+	if err != nil {
+		return nil, err
+	}
+
+	s.output = outputs[len(outputs)-1]
+
+	return outputs, nil
+}
+
+// runs input through layers, which are the network's Layers or replicas of them, returning
+// input followed by the output of every layer
+func (s *Sequential) forward(layers []Layer, input *mat.Dense, training bool) ([]*mat.Dense, error) {
+	if r, _ := input.Dims(); r != s.InputShape.Size() {
+		return nil, fmt.Errorf("invalid input size: %v", r)
+	}
+
+	result := []*mat.Dense{input}
+	for idx, layer := range layers {
+		output, err := layer.Forward(result[idx], training)
+		if err != nil {
+			return nil, fmt.Errorf("layer at index %d failed: %s", idx, err)
+		}
+
+		result = append(result, output)
+	}
+
+	return result, nil
+}
+
+// loss of the most recent Calculate call
+func (s *Sequential) CalcLoss(solution *mat.VecDense) (float64, error) {
+	return s.CalcLossBatch(columnOf(solution))
+}
+
+// summed loss over every sample of the most recent CalculateBatch call
+func (s *Sequential) CalcLossBatch(solutions *mat.Dense) (float64, error) {
+	if err := s.checkSolutionBatch(s.output, solutions); err != nil {
+		return 0, err
+	}
+
+	_, c := s.output.Dims()
+
+	result := float64(c) * s.penalty()
+	for j := 0; j < c; j++ {
+		result += s.Loss.CalcLoss(s.output.ColView(j), solutions.ColView(j))
+	}
+
+	return result, nil
+}
+
+// backpropagates solution through every layer, returning the gradient with respect to
+// each layer's output. Parameter gradients are kept for GenerateGradient.
+func (s *Sequential) GenerateDelta(solution *mat.VecDense) ([]*mat.VecDense, error) {
+	deltaBatch, err := s.GenerateDeltaBatch(columnOf(solution))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*mat.VecDense
+	for _, delta := range deltaBatch {
+		result = append(result, mat.VecDenseCopyOf(delta.ColView(0)))
+	}
+
+	return result, nil
+}
+
+func (s *Sequential) GenerateDeltaBatch(solutions *mat.Dense) ([]*mat.Dense, error) {
+	if err := s.checkSolutionBatch(s.output, solutions); err != nil {
+		return nil, err
+	}
+
+	return s.backward(s.Layers, s.output, solutions)
+}
+
+// backpropagates solutions through layers, which computed output in their most recent
+// Forward calls
+func (s *Sequential) backward(layers []Layer, output, solutions *mat.Dense) ([]*mat.Dense, error) {
+	r, c := output.Dims()
+	lastIndex := len(layers) - 1
+	if lastIndex < 0 {
+		return nil, nil
+	}
+
+	// with a paired loss the output delta already includes the last layer's derivative
+	paired, isPaired := s.Loss.(PairedLoss)
+	funcLayer, isFuncLayer := layers[lastIndex].(FuncLayer)
+	isPaired = isPaired && isFuncLayer && paired.Pairs(funcLayer.NodeFunc())
+
+	initial := mat.NewDense(r, c, nil)
+	for j := 0; j < c; j++ {
+		if isPaired {
+			initial.SetCol(j, paired.CalcDelta(output.ColView(j), solutions.ColView(j)).RawVector().Data)
+		} else {
+			initial.SetCol(j, s.Loss.CalcDiff(output.ColView(j), solutions.ColView(j)).RawVector().Data)
+		}
+	}
+
+	result := make([]*mat.Dense, len(layers))
+	result[lastIndex] = initial
+	grad := initial
+
+	for layerIndex := lastIndex; layerIndex >= 0; layerIndex-- {
+		if isPaired && layerIndex == lastIndex {
+			// skip the last layer's derivative, FuncLayers have no parameters
+			grad = mat.DenseCopyOf(grad)
+		} else {
+			var err error
+			if grad, err = layers[layerIndex].Backward(grad); err != nil {
+				return nil, fmt.Errorf("layer at index %d failed: %s", layerIndex, err)
+			}
+		}
+
+		if layerIndex > 0 {
+			result[layerIndex-1] = grad
+		}
+	}
+
+	return result, nil
+}
+
+// parameter gradients of the most recent GenerateDelta call, delta must be its result
+func (s *Sequential) GenerateGradient(delta []*mat.VecDense) (Gradient, error) {
+	return s.gradient(len(delta), len(s.Layers))
+}
+
+// gradients summed over every sample of the most recent GenerateDeltaBatch call
+func (s *Sequential) GenerateGradientBatch(delta []*mat.Dense) (Gradient, error) {
+	return s.gradient(len(delta), len(s.Layers))
+}
+
+// gradient of every layer, deltaCount is the length of the delta it was generated for
+func (s *Sequential) gradient(deltaCount, expected int) (Gradient, error) {
+	if deltaCount != expected {
+		return Gradient{}, fmt.Errorf("invalid delta count: %d, expected %d", deltaCount, expected)
+	}
+
+	_, batchSize := s.output.Dims()
+
+	return s.layerGradient(s.Layers, batchSize), nil
+}
+
+// Grads of layers from their most recent Backward calls over batchSize samples,
+// including the penalty gradient
+func (s *Sequential) layerGradient(layers []Layer, batchSize int) Gradient {
+	var (
+		result Gradient
+		rows   [][][]int
+		sparse bool
+	)
+
+	for _, layer := range layers {
+		var grads []*mat.Dense
+		for _, grad := range layer.Grads() {
+			grads = append(grads, mat.DenseCopyOf(grad))
+		}
+
+		result.Layers = append(result.Layers, grads)
+
+		var layerRows [][]int
+		if sparseLayer, ok := layer.(SparseLayer); ok {
+			layerRows = sparseLayer.GradRows()
+			sparse = true
+		}
+
+		rows = append(rows, layerRows)
+	}
+
+	if sparse {
+		result.LayerRows = rows
+	}
+
+	s.addPenaltyGradient(&result, float64(batchSize))
+
+	return result
+}
+
+func (s *Sequential) Update(gradient Gradient) error {
+	step, err := s.optimizerStep(gradient)
+	if err != nil {
+		return err
+	}
+
+	step.apply(s.Optimizer)
+	s.applyMaxNorm(gradient)
+
+	return nil
+}
+
+// the Params of every layer with their gradients, SparseLayers only pass the rows they
+// have a gradient for
+func (s *Sequential) optimizerStep(gradient Gradient) (optimizerStep, error) {
+	var step optimizerStep
+
+	if len(gradient.Layers) != len(s.Layers) {
+		return optimizerStep{}, fmt.Errorf("invalid gradient dimension: %d layers, expected %d", len(gradient.Layers), len(s.Layers))
+	}
+
+	for idx, layer := range s.Layers {
+		layerParams := layer.Params()
+		if len(gradient.Layers[idx]) != len(layerParams) {
+			return optimizerStep{}, fmt.Errorf("invalid gradient count at layer %d: %d, expected %d", idx, len(gradient.Layers[idx]), len(layerParams))
+		}
+
+		layerDecayed := decayedParams(layer)
+
+		for paramIndex, param := range layerParams {
+			grad := gradient.Layers[idx][paramIndex]
+			rows := gradient.rows(idx, paramIndex)
+
+			r, c := param.Dims()
+			gr, gc := grad.Dims()
+			if rows != nil {
+				if gr != len(rows) || c != gc {
+					return optimizerStep{}, fmt.Errorf("invalid gradient dimension at layer %d: %dx%d, expected %dx%d", idx, gr, gc, len(rows), c)
+				}
+
+				for _, row := range rows {
+					if row < 0 || row >= r {
+						return optimizerStep{}, fmt.Errorf("invalid gradient row at layer %d: %d, expected less than %d", idx, row, r)
+					}
+				}
+
+				step.addRows(param, grad, rows, layerDecayed[paramIndex])
+				continue
+			}
+
+			if r != gr || c != gc {
+				return optimizerStep{}, fmt.Errorf("invalid gradient dimension at layer %d: %dx%d, expected %dx%d", idx, gr, gc, r, c)
+			}
+
+			step.add(param.RawMatrix().Data, denseData(grad), layerDecayed[paramIndex])
+		}
+	}
+
+	return step, nil
+}
+
+// whether each of the layer's Params is decayed, see DecayedLayer
+func decayedParams(layer Layer) []bool {
+	result := make([]bool, len(layer.Params()))
+	if decayedLayer, ok := layer.(DecayedLayer); ok {
+		copy(result, decayedLayer.Decayed())
+		return result
+	}
+
+	for idx := range result {
+		result[idx] = true
+	}
+
+	return result
+}
+
+// sets the learning rate of the following updates, Optimizer must be a ScheduledOptimizer
+func (s *Sequential) SetLearningRate(rate float64) error {
+	return setLearningRate(s.Optimizer, rate)
+}
+
+// checks solutions against output, the result of the most recent forward pass
+func (s *Sequential) checkSolutionBatch(output, solutions *mat.Dense) error {
+	r, c := solutions.Dims()
+	if outputSize := s.OutputShape.Size(); r != outputSize {
+		return fmt.Errorf("invalid solution dimension: %d, expected %d", r, outputSize)
+	}
+
+	if output == nil {
+		return fmt.Errorf("no batch has been calculated")
+	}
+
+	if _, batchSize := output.Dims(); c != batchSize {
+		return fmt.Errorf("invalid solution batch size: %d, expected %d", c, batchSize)
+	}
+
+	return nil
+}
+
+// v as a single column matrix
+func columnOf(v *mat.VecDense) *mat.Dense {
+	return mat.NewDense(v.Len(), 1, mat.VecDenseCopyOf(v).RawVector().Data)
+}
@@ -0,0 +1,682 @@
+package neuralnet_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testSequential(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		network    neuralnet.Network
+		sequential neuralnet.Sequential
+		inputs     *mat.Dense
+		solutions  *mat.Dense
+	)
+
+	// a Network and a Sequential sharing the same parameters
+	setup := func(output neuralnet.NodeFunc, loss neuralnet.Loss) {
+		var err error
+		network, err = neuralnet.NewNetwork(neuralnet.Config{
+			LayerConfigs: []neuralnet.LayerConfig{
+				{Size: 3},
+				{Size: 4, Func: nodefuncs.Sigmoid{}},
+				{Size: 2, Func: output},
+			},
+			Loss:      loss,
+			Optimizer: &optimizers.SGD{LearningRate: 0.5},
+			Source:    rand.NewSource(6),
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		network.Bias[1].SetVec(2, 0.3)
+
+		converted, err := layers.FromNetwork(&network)
+		Expect(err).NotTo(HaveOccurred())
+
+		sequential, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+			InputShape: neuralnet.Shape{3},
+			Layers:     converted,
+			Loss:       loss,
+			Optimizer:  &optimizers.SGD{LearningRate: 0.5},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	it.Before(func() {
+		setup(nodefuncs.Sigmoid{}, losses.MSE{})
+
+		inputs = mat.NewDense(3, 3, []float64{
+			0.1, 0.5, -0.3,
+			0.2, -0.4, 0.8,
+			-0.6, 0.7, 0.9,
+		})
+		solutions = mat.NewDense(2, 3, []float64{
+			1, 0, 0,
+			0, 1, 1,
+		})
+	})
+
+	context("NewSequential", func() {
+		it("chains the layer shapes", func() {
+			Expect(sequential.OutputShape).To(Equal(neuralnet.Shape{2}))
+			Expect(sequential.Loss).To(Equal(losses.MSE{}))
+		})
+
+		it("defaults the loss and optimizer", func() {
+			result, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{2},
+				Layers:     []neuralnet.Layer{layers.NewReshape(1, 2)},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Loss).To(Equal(losses.MSE{}))
+			Expect(result.Optimizer).To(Equal(&optimizers.SGD{LearningRate: .01}))
+		})
+
+		it("fails on invalid configurations", func() {
+			_, err := neuralnet.NewSequential(neuralnet.SequentialConfig{InputShape: neuralnet.Shape{2}})
+			Expect(err).To(MatchError("layers must contain at least 1 element"))
+
+			_, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{2, 0},
+				Layers:     []neuralnet.Layer{layers.NewReshape(2)},
+			})
+			Expect(err).To(MatchError("invalid shape: [2 0]"))
+
+			_, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers: []neuralnet.Layer{
+					layers.NewReshape(3, 1),
+					layers.NewDense(2, 1, nil, nil, rand.New(rand.NewSource(1))),
+				},
+			})
+			Expect(err).To(MatchError("invalid layer at index 1: invalid input size: 3, expected 2"))
+
+			_, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape:          neuralnet.Shape{2},
+				Layers:              []neuralnet.Layer{layers.NewReshape(2)},
+				LayerRegularization: []*neuralnet.Regularization{nil, nil},
+			})
+			Expect(err).To(MatchError("invalid layer regularization count: 2, expected at most 1"))
+
+			_, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape:          neuralnet.Shape{2},
+				Layers:              []neuralnet.Layer{layers.NewReshape(2)},
+				LayerRegularization: []*neuralnet.Regularization{{MaxNorm: -1}},
+			})
+			Expect(err).To(MatchError("invalid regularization: L1 0, L2 0, MaxNorm -1"))
+
+			_, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{2},
+				Layers:     []neuralnet.Layer{layers.NewReshape(2)},
+				Loss:       losses.Huber{Delta: -1},
+			})
+			Expect(err).To(MatchError("invalid huber delta: -1"))
+		})
+	})
+
+	context("Calculate", func() {
+		it("matches CalculateBatch for every column", func() {
+			output, err := sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			for j := 0; j < 3; j++ {
+				expected, err := sequential.Calculate(column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mat.EqualApprox(column(output, j), expected, 1e-12)).To(BeTrue())
+			}
+		})
+
+		it("fails on invalid sizes", func() {
+			_, err := sequential.Calculate(mat.NewVecDense(2, nil))
+			Expect(err).To(MatchError("invalid input size: 2"))
+
+			_, err = sequential.CalcLoss(mat.NewVecDense(2, nil))
+			Expect(err).To(MatchError("no batch has been calculated"))
+
+			_, err = sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = sequential.CalcLossBatch(mat.NewDense(3, 3, nil))
+			Expect(err).To(MatchError("invalid solution dimension: 3, expected 2"))
+
+			_, err = sequential.GenerateDeltaBatch(mat.NewDense(2, 1, nil))
+			Expect(err).To(MatchError("invalid solution batch size: 1, expected 3"))
+
+			_, err = sequential.GenerateGradientBatch(nil)
+			Expect(err).To(MatchError("invalid delta count: 0, expected 4"))
+		})
+	})
+
+	for _, tc := range []struct {
+		name   string
+		output neuralnet.NodeFunc
+		loss   neuralnet.Loss
+	}{
+		{"unpaired loss", nodefuncs.Sigmoid{}, losses.MSE{}},
+		{"paired loss", nodefuncs.Softmax{}, losses.CrossEntropy{}},
+	} {
+		tc := tc
+
+		context("with a "+tc.name, func() {
+			it.Before(func() {
+				setup(tc.output, tc.loss)
+			})
+
+			it("matches the Network loss and gradients", func() {
+				_, err := network.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				expectedLoss, err := network.CalcLossBatch(solutions)
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := network.GenerateDeltaBatch(solutions)
+				Expect(err).NotTo(HaveOccurred())
+				expected, err := network.GenerateGradientBatch(delta)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = sequential.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				actualLoss, err := sequential.CalcLossBatch(solutions)
+				Expect(err).NotTo(HaveOccurred())
+				deltaBatch, err := sequential.GenerateDeltaBatch(solutions)
+				Expect(err).NotTo(HaveOccurred())
+				actual, err := sequential.GenerateGradientBatch(deltaBatch)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(actualLoss).To(BeNumerically("~", expectedLoss, 1e-12))
+				Expect(actual.Layers).To(HaveLen(4))
+				for idx := range network.Weights {
+					grads := actual.Layers[2*idx]
+					weights, bias := denseGradient(network, expected, idx+1)
+					Expect(mat.EqualApprox(grads[0], weights, 1e-12)).To(BeTrue())
+					Expect(mat.EqualApprox(grads[1].ColView(0), bias, 1e-12)).To(BeTrue())
+				}
+			})
+
+			it("sums single sample gradients to the batch gradient", func() {
+				var summed neuralnet.Gradient
+				for j := 0; j < 3; j++ {
+					_, err := sequential.Calculate(column(inputs, j))
+					Expect(err).NotTo(HaveOccurred())
+					delta, err := sequential.GenerateDelta(column(solutions, j))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(delta).To(HaveLen(4))
+					gradient, err := sequential.GenerateGradient(delta)
+					Expect(err).NotTo(HaveOccurred())
+
+					summed.Add(gradient)
+				}
+
+				_, err := sequential.CalculateBatch(inputs)
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := sequential.GenerateDeltaBatch(solutions)
+				Expect(err).NotTo(HaveOccurred())
+				expected, err := sequential.GenerateGradientBatch(delta)
+				Expect(err).NotTo(HaveOccurred())
+
+				for idx, grads := range expected.Layers {
+					for paramIndex, grad := range grads {
+						Expect(mat.EqualApprox(summed.Layers[idx][paramIndex], grad, 1e-12)).To(BeTrue())
+					}
+				}
+			})
+		})
+	}
+
+	context("with Regularization", func() {
+		// a Network and a Sequential sharing parameters, the output layer only has an L2 penalty
+		regularized := func(regularization neuralnet.Regularization) (neuralnet.Network, neuralnet.Sequential) {
+			output := neuralnet.Regularization{L2: 0.05, MaxNorm: regularization.MaxNorm, IncludeBias: regularization.IncludeBias}
+
+			network, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{Size: 3},
+					{Size: 4, Func: nodefuncs.Sigmoid{}},
+					{Size: 2, Func: nodefuncs.Sigmoid{}, Regularization: &output},
+				},
+				Optimizer:      &optimizers.SGD{LearningRate: 0.5},
+				Regularization: regularization,
+				Source:         rand.NewSource(6),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			converted, err := layers.FromNetwork(&network)
+			Expect(err).NotTo(HaveOccurred())
+
+			sequential, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape:          neuralnet.Shape{3},
+				Layers:              converted,
+				Optimizer:           &optimizers.SGD{LearningRate: 0.5},
+				Regularization:      regularization,
+				LayerRegularization: []*neuralnet.Regularization{nil, nil, &output},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			return network, sequential
+		}
+
+		it("matches the Network penalties and gradients", func() {
+			network, sequential := regularized(neuralnet.Regularization{L1: 0.1, L2: 0.01, IncludeBias: true})
+
+			_, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			expectedLoss, err := network.CalcLossBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := network.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := network.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			actualLoss, err := sequential.CalcLossBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			deltaBatch, err := sequential.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			actual, err := sequential.GenerateGradientBatch(deltaBatch)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(actualLoss).To(BeNumerically("~", expectedLoss, 1e-12))
+			for idx := range network.Weights {
+				grads := actual.Layers[2*idx]
+				weights, bias := denseGradient(network, expected, idx+1)
+				Expect(mat.EqualApprox(grads[0], weights, 1e-12)).To(BeTrue())
+				Expect(mat.EqualApprox(grads[1].ColView(0), bias, 1e-12)).To(BeTrue())
+			}
+		})
+
+		it("constrains every unit like the Network after Update", func() {
+			_, sequential := regularized(neuralnet.Regularization{MaxNorm: 0.3, IncludeBias: true})
+			network, _ := regularized(neuralnet.Regularization{MaxNorm: 0.3, IncludeBias: true})
+
+			_, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := network.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := network.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(network.Update(expected)).To(Succeed())
+
+			_, err = sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			deltaBatch, err := sequential.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			actual, err := sequential.GenerateGradientBatch(deltaBatch)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequential.Update(actual)).To(Succeed())
+
+			for idx := range network.Weights {
+				dense := sequential.Layers[2*idx].(*layers.Dense)
+				Expect(mat.EqualApprox(dense.Weights, network.Weights[idx], 1e-12)).To(BeTrue())
+				Expect(mat.EqualApprox(dense.Bias.ColView(0), network.Bias[idx+1], 1e-12)).To(BeTrue())
+			}
+
+			row := mat.NewVecDense(4, nil)
+			row.CopyVec(network.Weights[1].RowView(0))
+			Expect(math.Hypot(mat.Norm(row, 2), network.Bias[2].AtVec(0))).To(BeNumerically("~", 0.3, 1e-12))
+		})
+
+		it("only constrains the updated rows of a SparseLayer", func() {
+			embedding := layers.NewEmbedding(6, 2, nil, rand.New(rand.NewSource(8)))
+			sequential, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape:     neuralnet.Shape{2},
+				Layers:         []neuralnet.Layer{embedding},
+				Regularization: neuralnet.Regularization{MaxNorm: 0.01, IncludeBias: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			original := mat.DenseCopyOf(embedding.Vectors)
+
+			gradient := neuralnet.Gradient{
+				Layers:    [][]*mat.Dense{{mat.NewDense(1, 2, nil)}},
+				LayerRows: [][][]int{{{4}}},
+			}
+			Expect(sequential.Update(gradient)).To(Succeed())
+
+			for i := 0; i < 6; i++ {
+				if i == 4 {
+					Expect(mat.Norm(embedding.Vectors.RowView(i), 2)).To(BeNumerically("~", 0.01, 1e-12))
+					continue
+				}
+
+				Expect(mat.Equal(embedding.Vectors.RowView(i), original.RowView(i))).To(BeTrue())
+			}
+		})
+	})
+
+	context("Update", func() {
+		it("applies the optimizer to every layer's parameters", func() {
+			_, err := network.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := network.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := network.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			weightsGrad, _ := denseGradient(network, expected, 1)
+			expectedWeights := mat.DenseCopyOf(network.Weights[0])
+			expectedWeights.Apply(func(i, j int, v float64) float64 {
+				return v - 0.5*0.5*weightsGrad.At(i, j)
+			}, expectedWeights)
+
+			_, err = sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			deltaBatch, err := sequential.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err := sequential.GenerateGradientBatch(deltaBatch)
+			Expect(err).NotTo(HaveOccurred())
+			gradient.Scale(0.5)
+
+			Expect(sequential.Update(gradient)).To(Succeed())
+
+			// the converted Dense layers share the network weights
+			Expect(mat.EqualApprox(network.Weights[0], expectedWeights, 1e-12)).To(BeTrue())
+		})
+
+		it("only marks weight matrices as decayed", func() {
+			optimizer := &decayingOptimizer{}
+			sequential.Optimizer = optimizer
+
+			_, err := sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			deltaBatch, err := sequential.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err := sequential.GenerateGradientBatch(deltaBatch)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(sequential.Update(gradient)).To(Succeed())
+			Expect(optimizer.decayed).To(Equal([]bool{true, false, true, false}))
+		})
+
+		it("sets the learning rate of the optimizer", func() {
+			Expect(sequential.SetLearningRate(0.25)).To(Succeed())
+			Expect(sequential.Optimizer).To(Equal(&optimizers.SGD{LearningRate: 0.25}))
+		})
+
+		it("fails on mismatched gradients", func() {
+			Expect(sequential.Update(neuralnet.Gradient{})).To(MatchError("invalid gradient dimension: 0 layers, expected 4"))
+
+			gradient := neuralnet.Gradient{Layers: make([][]*mat.Dense, 4)}
+			Expect(sequential.Update(gradient)).To(MatchError("invalid gradient count at layer 0: 0, expected 2"))
+
+			gradient.Layers[0] = []*mat.Dense{mat.NewDense(1, 1, nil), mat.NewDense(4, 1, nil)}
+			Expect(sequential.Update(gradient)).To(MatchError("invalid gradient dimension at layer 0: 1x1, expected 4x3"))
+		})
+	})
+
+	context("with a SparseLayer", func() {
+		var embedding *layers.Embedding
+
+		it.Before(func() {
+			var err error
+			embedding = layers.NewEmbedding(6, 2, nil, rand.New(rand.NewSource(8)))
+			sequential, err = neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{2},
+				Layers: []neuralnet.Layer{
+					embedding,
+					layers.NewDense(4, 2, nil, nil, rand.New(rand.NewSource(9))),
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			inputs = mat.NewDense(2, 3, []float64{
+				5, 1, 5,
+				0, 5, 3,
+			})
+		})
+
+		it("merges the rows of summed gradients", func() {
+			var summed neuralnet.Gradient
+			for j := 0; j < 3; j++ {
+				_, err := sequential.Calculate(column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+				delta, err := sequential.GenerateDelta(column(solutions, j))
+				Expect(err).NotTo(HaveOccurred())
+				gradient, err := sequential.GenerateGradient(delta)
+				Expect(err).NotTo(HaveOccurred())
+
+				summed.Add(gradient)
+			}
+
+			_, err := sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			delta, err := sequential.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := sequential.GenerateGradientBatch(delta)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(expected.LayerRows).To(Equal([][][]int{{{0, 1, 3, 5}}, nil}))
+			Expect(summed.LayerRows).To(Equal(expected.LayerRows))
+			for idx, grads := range expected.Layers {
+				for paramIndex, grad := range grads {
+					Expect(mat.EqualApprox(summed.Layers[idx][paramIndex], grad, 1e-12)).To(BeTrue())
+				}
+			}
+		})
+
+		it("only updates the listed rows", func() {
+			original := mat.DenseCopyOf(embedding.Vectors)
+
+			gradient := neuralnet.Gradient{
+				Layers:    [][]*mat.Dense{{mat.NewDense(1, 2, []float64{1, -1})}, {mat.NewDense(2, 4, nil), mat.NewDense(2, 1, nil)}},
+				LayerRows: [][][]int{{{4}}, nil},
+			}
+			Expect(sequential.Update(gradient)).To(Succeed())
+
+			for i := 0; i < 6; i++ {
+				if i == 4 {
+					Expect(embedding.Vectors.At(i, 0)).To(BeNumerically("~", original.At(i, 0)-.01, 1e-12))
+					Expect(embedding.Vectors.At(i, 1)).To(BeNumerically("~", original.At(i, 1)+.01, 1e-12))
+					continue
+				}
+
+				Expect(mat.Equal(embedding.Vectors.RowView(i), original.RowView(i))).To(BeTrue())
+			}
+		})
+
+		it("passes nil grads for the rows without a gradient", func() {
+			optimizer := &recordingOptimizer{}
+			sequential.Optimizer = optimizer
+
+			_, err := sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			deltaBatch, err := sequential.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err := sequential.GenerateGradientBatch(deltaBatch)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequential.Update(gradient)).To(Succeed())
+
+			// every vector in order, then the Dense weights and bias, only ids 0, 1, 3 and 5
+			// have a gradient
+			Expect(optimizer.params).To(HaveLen(8))
+			for i := 0; i < 6; i++ {
+				Expect(optimizer.grads[i] == nil).To(Equal(i == 2 || i == 4))
+			}
+
+			optimizer.params[3][0] = 7
+			Expect(embedding.Vectors.At(3, 0)).To(Equal(float64(7)))
+		})
+
+		it("only passes the rows with a gradient to a SparseOptimizer", func() {
+			optimizer := &sparseOptimizer{}
+			sequential.Optimizer = optimizer
+
+			_, err := sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			deltaBatch, err := sequential.GenerateDeltaBatch(solutions)
+			Expect(err).NotTo(HaveOccurred())
+			gradient, err := sequential.GenerateGradientBatch(deltaBatch)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sequential.Update(gradient)).To(Succeed())
+
+			// the rows keep the positions of a full update, the Dense weights and bias follow
+			// the 6 vectors
+			Expect(optimizer.positions).To(Equal([]int{0, 1, 3, 5, 6, 7}))
+			Expect(optimizer.decayed).To(Equal([]bool{false, false, false, false, true, false}))
+			for _, grad := range optimizer.grads {
+				Expect(grad).NotTo(BeNil())
+			}
+
+			optimizer.params[2][0] = 7
+			Expect(embedding.Vectors.At(3, 0)).To(Equal(float64(7)))
+		})
+
+		it("fails on input a layer cannot take", func() {
+			_, err := sequential.CalculateBatch(mat.NewDense(2, 1, []float64{1, 6}))
+			Expect(err).To(MatchError("layer at index 0 failed: embedding: invalid id 6, expected an integer in [0, 6)"))
+		})
+
+		it("fails on mismatched rows", func() {
+			gradient := neuralnet.Gradient{
+				Layers:    [][]*mat.Dense{{mat.NewDense(1, 2, nil)}, {mat.NewDense(2, 4, nil), mat.NewDense(2, 1, nil)}},
+				LayerRows: [][][]int{{{1, 2}}, nil},
+			}
+			Expect(sequential.Update(gradient)).To(MatchError("invalid gradient dimension at layer 0: 1x2, expected 2x2"))
+
+			gradient.LayerRows[0][0] = []int{6}
+			Expect(sequential.Update(gradient)).To(MatchError("invalid gradient row at layer 0: 6, expected less than 6"))
+		})
+	})
+
+	context("ComputeGradient", func() {
+		it("matches the single sample gradient without modifying the network", func() {
+			workspace := sequential.NewWorkspace()
+
+			for j := 0; j < 3; j++ {
+				_, err := sequential.Calculate(column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+
+				// the replicas leave the cached sample of the network alone
+				next := (j + 1) % 3
+				_, err = sequential.ComputeGradient(workspace, column(inputs, next), column(solutions, next))
+				Expect(err).NotTo(HaveOccurred())
+
+				delta, err := sequential.GenerateDelta(column(solutions, j))
+				Expect(err).NotTo(HaveOccurred())
+				expected, err := sequential.GenerateGradient(delta)
+				Expect(err).NotTo(HaveOccurred())
+
+				actual, err := sequential.ComputeGradient(workspace, column(inputs, j), column(solutions, j))
+				Expect(err).NotTo(HaveOccurred())
+
+				for idx, grads := range expected.Layers {
+					for paramIndex, grad := range grads {
+						Expect(mat.EqualApprox(actual.Layers[idx][paramIndex], grad, 1e-12)).To(BeTrue())
+					}
+				}
+			}
+		})
+
+		it("predicts like Calculate", func() {
+			workspace := sequential.NewWorkspace()
+
+			for j := 0; j < 3; j++ {
+				expected, err := sequential.Calculate(column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+
+				actual, err := sequential.Predict(column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(actual).To(Equal(expected))
+
+				actual, err = sequential.PredictWith(workspace, column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(actual).To(Equal(expected))
+			}
+		})
+
+		it("draws dropout masks from the workspace", func() {
+			dropout := func() neuralnet.Sequential {
+				result, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+					InputShape: neuralnet.Shape{3},
+					Layers:     []neuralnet.Layer{layers.NewDropout(0.5, rand.New(rand.NewSource(7)))},
+					Source:     rand.NewSource(8),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				result.SetTraining(true)
+
+				return result
+			}
+
+			first, second := dropout(), dropout()
+			firstWorkspace, secondWorkspace := first.NewWorkspace(), second.NewWorkspace()
+			for j := 0; j < 3; j++ {
+				expected, err := first.ComputeGradient(firstWorkspace, column(inputs, j), column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+				actual, err := second.ComputeGradient(secondWorkspace, column(inputs, j), column(inputs, j))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(actual).To(Equal(expected))
+			}
+		})
+
+		it("fails on layers that cannot run in parallel", func() {
+			opaque, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers:     []neuralnet.Layer{sequential.Layers[0], opaqueLayer{sequential.Layers[1]}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(opaque.CheckParallel()).To(MatchError("layer at index 1 cannot be replicated"))
+			_, err = opaque.Predict(column(inputs, 0))
+			Expect(err).To(MatchError("layer at index 1 cannot be replicated"))
+			_, err = opaque.ComputeGradient(opaque.NewWorkspace(), column(inputs, 0), column(solutions, 0))
+			Expect(err).To(MatchError("layer at index 1 cannot be replicated"))
+
+			normalized, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers:     []neuralnet.Layer{layers.NewBatchNorm(3)},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			normalized.SetTraining(true)
+
+			expected := "layer at index 0 updates its state, which requires CalculateBatch in training mode"
+			Expect(normalized.CheckParallel()).To(MatchError(expected))
+			_, err = normalized.ComputeGradient(normalized.NewWorkspace(), column(inputs, 0), column(inputs, 0))
+			Expect(err).To(MatchError(expected))
+		})
+
+		it("fails on a workspace of another network", func() {
+			_, err := sequential.PredictWith(network.NewWorkspace(), column(inputs, 0))
+			Expect(err).To(MatchError("workspace belongs to a different network"))
+
+			_, err = sequential.PredictWith(&neuralnet.Workspace{}, column(inputs, 0))
+			Expect(err).To(MatchError("invalid workspace layer count: 0, expected 4"))
+		})
+	})
+
+	context("SetTraining", func() {
+		it("passes the mode to every layer", func() {
+			sequential, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers:     []neuralnet.Layer{layers.NewDropout(0.5, rand.New(rand.NewSource(7)))},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal(inputs))
+
+			sequential.SetTraining(true)
+			Expect(sequential.IsTraining()).To(BeTrue())
+
+			output, err = sequential.CalculateBatch(inputs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).NotTo(Equal(inputs))
+		})
+	})
+}
+
+// hides the optional interfaces of the embedded layer, e.g. ReplicaLayer
+type opaqueLayer struct {
+	neuralnet.Layer
+}
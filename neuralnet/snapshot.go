@@ -0,0 +1,63 @@
+package neuralnet
+
+import "fmt"
+
+// A snapshot holds a copy of every learned value of a network, e.g. the best weights seen
+// during training. Snapshots are only valid for the network they were taken from.
+
+// copies the Params of every layer, followed by its State for StatefulLayers. For a
+// Network these are the input layer values, then the weights, biases and batch
+// normalization parameters and running statistics of every layer.
+func (s *Sequential) Snapshot() [][]float64 {
+	return copyValues(s.values())
+}
+
+func (s *Sequential) Restore(snapshot [][]float64) error {
+	return restoreValues(s.values(), snapshot)
+}
+
+func (s *Sequential) values() [][]float64 {
+	var result [][]float64
+
+	for _, layer := range s.Layers {
+		for _, param := range layer.Params() {
+			result = append(result, param.RawMatrix().Data)
+		}
+
+		if stateful, ok := layer.(StatefulLayer); ok {
+			for _, state := range stateful.State() {
+				result = append(result, state.RawMatrix().Data)
+			}
+		}
+	}
+
+	return result
+}
+
+func copyValues(values [][]float64) [][]float64 {
+	result := make([][]float64, len(values))
+	for idx, value := range values {
+		result[idx] = append([]float64(nil), value...)
+	}
+
+	return result
+}
+
+// checks every length before copying so a failed restore leaves values untouched
+func restoreValues(values, snapshot [][]float64) error {
+	if len(snapshot) != len(values) {
+		return fmt.Errorf("invalid snapshot: %d values, expected %d", len(snapshot), len(values))
+	}
+
+	for idx, value := range values {
+		if len(snapshot[idx]) != len(value) {
+			return fmt.Errorf("invalid snapshot length at index %d: %d, expected %d", idx, len(snapshot[idx]), len(value))
+		}
+	}
+
+	for idx, value := range values {
+		copy(value, snapshot[idx])
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package neuralnet_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testSnapshot(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		network neuralnet.Network
+		input   *mat.VecDense
+	)
+
+	it.Before(func() {
+		var err error
+		network, err = neuralnet.NewNetwork(neuralnet.Config{
+			LayerConfigs: []neuralnet.LayerConfig{
+				{Size: 3},
+				{Size: 4, Func: nodefuncs.Sigmoid{}, BatchNorm: true},
+				{Size: 4, Func: nodefuncs.Sigmoid{}},
+				{Size: 2, Func: nodefuncs.Sigmoid{}},
+			},
+			Optimizer: &optimizers.SGD{LearningRate: 0.5},
+			Source:    rand.NewSource(11),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(network.Sparsify(1, 0.1)).To(Succeed())
+
+		input = mat.NewVecDense(3, []float64{0.5, -0.25, 1})
+	})
+
+	// one batch training step that changes every value including the running statistics
+	step := func(network batchNetwork) {
+		inputs := mat.NewDense(3, 2, []float64{0.5, 1, -0.25, 0.75, 1, -1})
+		_, err := network.CalculateBatch(inputs)
+		Expect(err).NotTo(HaveOccurred())
+
+		delta, err := network.GenerateDeltaBatch(mat.NewDense(2, 2, []float64{1, 0, 0, 1}))
+		Expect(err).NotTo(HaveOccurred())
+		gradient, err := network.GenerateGradientBatch(delta)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(network.Update(gradient)).To(Succeed())
+	}
+
+	context("Network", func() {
+		it("restores every learned value", func() {
+			expected, err := network.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+
+			network.SetTraining(true)
+			snapshot := network.Snapshot()
+			step(&network)
+			network.SetTraining(false)
+
+			changed, err := network.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mat.EqualApprox(changed, expected, 1e-6)).To(BeFalse())
+
+			Expect(network.Restore(snapshot)).To(Succeed())
+			actual, err := network.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mat.Equal(actual, expected)).To(BeTrue())
+			Expect(network.Norms[1].RunningVar.AtVec(0)).To(Equal(float64(1)))
+		})
+
+		it("copies values so later updates do not change the snapshot", func() {
+			snapshot := network.Snapshot()
+			first := snapshot[0][0]
+
+			network.SetTraining(true)
+			step(&network)
+
+			Expect(snapshot[0][0]).To(Equal(first))
+		})
+
+		it("fails on snapshots of other networks", func() {
+			snapshot := network.Snapshot()
+			weight := network.Weights[0].At(0, 0)
+
+			Expect(network.Restore(snapshot[1:])).To(MatchError("invalid snapshot: 9 values, expected 10"))
+
+			snapshot[0] = append(snapshot[0], 1)
+			Expect(network.Restore(snapshot)).To(MatchError("invalid snapshot length at index 0: 13, expected 12"))
+			Expect(network.Weights[0].At(0, 0)).To(Equal(weight))
+		})
+	})
+
+	context("Sequential", func() {
+		it("restores the params of every layer", func() {
+			rng := rand.New(rand.NewSource(2))
+			sequential, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+				InputShape: neuralnet.Shape{3},
+				Layers: []neuralnet.Layer{
+					layers.NewDense(3, 4, neuralnet.InitRandom, neuralnet.InitRandom, rng),
+					layers.NewActivation(nodefuncs.Sigmoid{}),
+					layers.NewDense(4, 2, neuralnet.InitRandom, neuralnet.InitRandom, rng),
+				},
+				Optimizer: &optimizers.SGD{LearningRate: 0.5},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			expected, err := sequential.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+
+			snapshot := sequential.Snapshot()
+			step(&sequential)
+
+			Expect(sequential.Restore(snapshot)).To(Succeed())
+			actual, err := sequential.Calculate(input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mat.Equal(actual, expected)).To(BeTrue())
+
+			Expect(sequential.Restore(nil)).To(MatchError("invalid snapshot: 0 values, expected 4"))
+		})
+	})
+}
+
+// the batch training methods shared by Network and Sequential
+type batchNetwork interface {
+	CalculateBatch(*mat.Dense) (*mat.Dense, error)
+	GenerateDeltaBatch(*mat.Dense) ([]*mat.Dense, error)
+	GenerateGradientBatch([]*mat.Dense) (neuralnet.Gradient, error)
+	Update(neuralnet.Gradient) error
+}
@@ -0,0 +1,14 @@
+package sparse_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitSparse(t *testing.T) {
+	suite := spec.New("Sparse", spec.Report(report.Terminal{}))
+	suite("Sparse", testSparse)
+	suite.Run(t)
+}
@@ -0,0 +1,425 @@
+package sparse
+
+import (
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Sparse matrices and vectors only store their non-zero entries, products skip
+// every zero so their cost scales with the number of stored values instead of
+// the full dimensions.
+
+///
+/// CSR Def
+///
+// compressed sparse row matrix: the values of row i are Data[Indptr[i]:Indptr[i+1]],
+// found in the columns Indices[Indptr[i]:Indptr[i+1]] which are strictly increasing.
+// Matrices created by Pattern share Indptr and Indices, so they must not be modified.
+type CSR struct {
+	rows, cols int
+
+	Indptr  []int
+	Indices []int
+	Data    []float64
+}
+
+// panics when the compressed structure is inconsistent with r x c, see CheckCSR
+func NewCSR(r, c int, indptr, indices []int, data []float64) *CSR {
+	if err := CheckCSR(r, c, indptr, indices, data); err != nil {
+		panic(err)
+	}
+
+	return &CSR{
+		rows:    r,
+		cols:    c,
+		Indptr:  indptr,
+		Indices: indices,
+		Data:    data,
+	}
+}
+
+// reports whether indptr, indices and data describe a valid r x c CSR matrix
+func CheckCSR(r, c int, indptr, indices []int, data []float64) error {
+	if r <= 0 || c <= 0 {
+		return mat.ErrZeroLength
+	}
+
+	if len(indptr) != r+1 || indptr[0] != 0 || indptr[r] != len(indices) || len(indices) != len(data) {
+		return mat.ErrShape
+	}
+
+	for i := 0; i < r; i++ {
+		if indptr[i] > indptr[i+1] {
+			return mat.ErrShape
+		}
+
+		for k := indptr[i]; k < indptr[i+1]; k++ {
+			if indices[k] < 0 || indices[k] >= c || (k > indptr[i] && indices[k] <= indices[k-1]) {
+				return mat.ErrIndexOutOfRange
+			}
+		}
+	}
+
+	return nil
+}
+
+// keeps only the entries of m whose magnitude is greater than threshold
+func CSRFromDense(m mat.Matrix, threshold float64) *CSR {
+	r, c := m.Dims()
+	result := &CSR{
+		rows:   r,
+		cols:   c,
+		Indptr: make([]int, r+1),
+	}
+
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			if v := m.At(i, j); math.Abs(v) > threshold {
+				result.Indices = append(result.Indices, j)
+				result.Data = append(result.Data, v)
+			}
+		}
+
+		result.Indptr[i+1] = len(result.Indices)
+	}
+
+	return result
+}
+
+func (c *CSR) Dims() (int, int) {
+	return c.rows, c.cols
+}
+
+func (c *CSR) At(i, j int) float64 {
+	if i < 0 || i >= c.rows || j < 0 || j >= c.cols {
+		panic(mat.ErrIndexOutOfRange)
+	}
+
+	if k, ok := c.find(i, j); ok {
+		return c.Data[k]
+	}
+
+	return 0
+}
+
+func (c *CSR) T() mat.Matrix {
+	return mat.Transpose{Matrix: c}
+}
+
+// number of stored values
+func (c *CSR) NNZ() int {
+	return len(c.Data)
+}
+
+func (c *CSR) ToDense() *mat.Dense {
+	result := mat.NewDense(c.rows, c.cols, nil)
+
+	for i := 0; i < c.rows; i++ {
+		for k := c.Indptr[i]; k < c.Indptr[i+1]; k++ {
+			result.Set(i, c.Indices[k], c.Data[k])
+		}
+	}
+
+	return result
+}
+
+// zero valued matrix with the same sparsity structure as c
+func (c *CSR) Pattern() *CSR {
+	return &CSR{
+		rows:    c.rows,
+		cols:    c.cols,
+		Indptr:  c.Indptr,
+		Indices: c.Indices,
+		Data:    make([]float64, len(c.Data)),
+	}
+}
+
+func (c *CSR) Clone() *CSR {
+	result := c.Pattern()
+	copy(result.Data, c.Data)
+
+	return result
+}
+
+// c += other, both matrices must share the same sparsity structure
+func (c *CSR) Add(other *CSR) {
+	c.checkPattern(other)
+
+	for k, v := range other.Data {
+		c.Data[k] += v
+	}
+}
+
+func (c *CSR) Scale(factor float64) {
+	for k := range c.Data {
+		c.Data[k] *= factor
+	}
+}
+
+// dst = c·x, or cᵀ·x when trans is set
+func (c *CSR) MulVecTo(dst *mat.VecDense, trans bool, x mat.Vector) {
+	outLen, inLen := c.rows, c.cols
+	if trans {
+		outLen, inLen = inLen, outLen
+	}
+
+	if x.Len() != inLen || dst.Len() != outLen {
+		panic(mat.ErrShape)
+	}
+
+	xData := denseValues(x)
+
+	if trans {
+		dst.Zero()
+
+		for i := 0; i < c.rows; i++ {
+			xi := xData[i]
+			if xi == 0 {
+				continue
+			}
+
+			for k := c.Indptr[i]; k < c.Indptr[i+1]; k++ {
+				j := c.Indices[k]
+				dst.SetVec(j, dst.AtVec(j)+c.Data[k]*xi)
+			}
+		}
+
+		return
+	}
+
+	for i := 0; i < c.rows; i++ {
+		sum := float64(0)
+		for k := c.Indptr[i]; k < c.Indptr[i+1]; k++ {
+			sum += c.Data[k] * xData[c.Indices[k]]
+		}
+
+		dst.SetVec(i, sum)
+	}
+}
+
+// dst = c·b, or cᵀ·b when trans is set
+func (c *CSR) MulTo(dst *mat.Dense, trans bool, b mat.Matrix) {
+	outRows, inRows := c.rows, c.cols
+	if trans {
+		outRows, inRows = inRows, outRows
+	}
+
+	br, bc := b.Dims()
+	dr, dc := dst.Dims()
+	if br != inRows || dr != outRows || dc != bc {
+		panic(mat.ErrShape)
+	}
+
+	bDense, ok := b.(*mat.Dense)
+	if !ok {
+		bDense = mat.DenseCopyOf(b)
+	}
+
+	dst.Zero()
+	bRaw := bDense.RawMatrix()
+	dstRaw := dst.RawMatrix()
+
+	for i := 0; i < c.rows; i++ {
+		for k := c.Indptr[i]; k < c.Indptr[i+1]; k++ {
+			v := c.Data[k]
+
+			src, out := c.Indices[k], i
+			if trans {
+				src, out = i, c.Indices[k]
+			}
+
+			bRow := bRaw.Data[src*bRaw.Stride : src*bRaw.Stride+bc]
+			dstRow := dstRaw.Data[out*dstRaw.Stride : out*dstRaw.Stride+bc]
+			for col, bv := range bRow {
+				dstRow[col] += v * bv
+			}
+		}
+	}
+}
+
+// c += alpha·x·yᵀ restricted to the stored entries of c
+func (c *CSR) AddOuter(alpha float64, x, y mat.Vector) {
+	if x.Len() != c.rows || y.Len() != c.cols {
+		panic(mat.ErrShape)
+	}
+
+	yData := denseValues(y)
+
+	for i := 0; i < c.rows; i++ {
+		xi := alpha * x.AtVec(i)
+		if xi == 0 {
+			continue
+		}
+
+		for k := c.Indptr[i]; k < c.Indptr[i+1]; k++ {
+			c.Data[k] += xi * yData[c.Indices[k]]
+		}
+	}
+}
+
+// c += a·bᵀ restricted to the stored entries of c
+func (c *CSR) AddMulT(a, b mat.Matrix) {
+	ar, ac := a.Dims()
+	br, bc := b.Dims()
+	if ar != c.rows || br != c.cols || ac != bc {
+		panic(mat.ErrShape)
+	}
+
+	for i := 0; i < c.rows; i++ {
+		for k := c.Indptr[i]; k < c.Indptr[i+1]; k++ {
+			j := c.Indices[k]
+
+			sum := float64(0)
+			for col := 0; col < ac; col++ {
+				sum += a.At(i, col) * b.At(j, col)
+			}
+
+			c.Data[k] += sum
+		}
+	}
+}
+
+func (c *CSR) find(i, j int) (int, bool) {
+	start, end := c.Indptr[i], c.Indptr[i+1]
+	k := start + sort.SearchInts(c.Indices[start:end], j)
+
+	return k, k < end && c.Indices[k] == j
+}
+
+func (c *CSR) checkPattern(other *CSR) {
+	if c.rows != other.rows || c.cols != other.cols || len(c.Data) != len(other.Data) {
+		panic(mat.ErrShape)
+	}
+}
+
+///
+/// Vector Def
+///
+// sparse column vector holding Data at the strictly increasing Indices
+type Vector struct {
+	n int
+
+	Indices []int
+	Data    []float64
+}
+
+// panics when indices are out of range or not strictly increasing
+func NewVector(n int, indices []int, data []float64) *Vector {
+	if n <= 0 {
+		panic(mat.ErrZeroLength)
+	}
+
+	if len(indices) != len(data) {
+		panic(mat.ErrShape)
+	}
+
+	for k, idx := range indices {
+		if idx < 0 || idx >= n || (k > 0 && idx <= indices[k-1]) {
+			panic(mat.ErrIndexOutOfRange)
+		}
+	}
+
+	return &Vector{
+		n:       n,
+		Indices: indices,
+		Data:    data,
+	}
+}
+
+// keeps only the entries of v whose magnitude is greater than threshold
+func VectorFromDense(v mat.Vector, threshold float64) *Vector {
+	result := &Vector{n: v.Len()}
+
+	for i := 0; i < v.Len(); i++ {
+		if value := v.AtVec(i); math.Abs(value) > threshold {
+			result.Indices = append(result.Indices, i)
+			result.Data = append(result.Data, value)
+		}
+	}
+
+	return result
+}
+
+func (v *Vector) Dims() (int, int) {
+	return v.n, 1
+}
+
+func (v *Vector) At(i, j int) float64 {
+	if j != 0 {
+		panic(mat.ErrColAccess)
+	}
+
+	return v.AtVec(i)
+}
+
+func (v *Vector) AtVec(i int) float64 {
+	if i < 0 || i >= v.n {
+		panic(mat.ErrRowAccess)
+	}
+
+	k := sort.SearchInts(v.Indices, i)
+	if k < len(v.Indices) && v.Indices[k] == i {
+		return v.Data[k]
+	}
+
+	return 0
+}
+
+func (v *Vector) T() mat.Matrix {
+	return mat.Transpose{Matrix: v}
+}
+
+func (v *Vector) Len() int {
+	return v.n
+}
+
+func (v *Vector) NNZ() int {
+	return len(v.Data)
+}
+
+func (v *Vector) ToDense() *mat.VecDense {
+	result := mat.NewVecDense(v.n, nil)
+	for k, idx := range v.Indices {
+		result.SetVec(idx, v.Data[k])
+	}
+
+	return result
+}
+
+// dst = a·x where only the columns of a matching stored entries of x are read
+func MulVec(dst *mat.VecDense, a mat.Matrix, x *Vector) {
+	r, c := a.Dims()
+	if c != x.n || dst.Len() != r {
+		panic(mat.ErrShape)
+	}
+
+	dst.Zero()
+
+	for k, j := range x.Indices {
+		xj := x.Data[k]
+		for i := 0; i < r; i++ {
+			dst.SetVec(i, dst.AtVec(i)+a.At(i, j)*xj)
+		}
+	}
+}
+
+// dense values of x, sparse vectors are scattered into a new slice
+func denseValues(x mat.Vector) []float64 {
+	switch v := x.(type) {
+	case *Vector:
+		return v.ToDense().RawVector().Data
+	case *mat.VecDense:
+		if raw := v.RawVector(); raw.Inc == 1 {
+			return raw.Data
+		}
+	}
+
+	result := make([]float64, x.Len())
+	for i := range result {
+		result[i] = x.AtVec(i)
+	}
+
+	return result
+}
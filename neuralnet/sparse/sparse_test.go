@@ -0,0 +1,144 @@
+package sparse_test
+
+import (
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/sparse"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testSparse(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		dense *mat.Dense
+		csr   *sparse.CSR
+	)
+
+	it.Before(func() {
+		dense = mat.NewDense(3, 4, []float64{
+			0, 2, 0, 0,
+			0, 0, 0, 0,
+			1, 0, -3, 0.01,
+		})
+		csr = sparse.CSRFromDense(dense, 0.1)
+	})
+
+	context("CSR", func() {
+		it("stores entries above the threshold", func() {
+			Expect(csr.Indptr).To(Equal([]int{0, 1, 1, 3}))
+			Expect(csr.Indices).To(Equal([]int{1, 0, 2}))
+			Expect(csr.Data).To(Equal([]float64{2, 1, -3}))
+			Expect(csr.NNZ()).To(Equal(3))
+
+			r, c := csr.Dims()
+			Expect(r).To(Equal(3))
+			Expect(c).To(Equal(4))
+
+			Expect(csr.At(2, 2)).To(Equal(-3.0))
+			Expect(csr.At(2, 3)).To(Equal(0.0))
+			Expect(csr.T().At(1, 0)).To(Equal(2.0))
+
+			dense.Set(2, 3, 0)
+			Expect(csr.ToDense()).To(Equal(dense))
+		})
+
+		it("multiplies vectors", func() {
+			x := mat.NewVecDense(4, []float64{1, 2, 3, 4})
+			dst := mat.NewVecDense(3, nil)
+			csr.MulVecTo(dst, false, x)
+			Expect(dst.RawVector().Data).To(Equal([]float64{4, 0, -8}))
+
+			y := mat.NewVecDense(3, []float64{1, 5, 2})
+			dstT := mat.NewVecDense(4, nil)
+			csr.MulVecTo(dstT, true, y)
+			Expect(dstT.RawVector().Data).To(Equal([]float64{2, 2, -6, 0}))
+
+			sparseX := sparse.NewVector(4, []int{1, 2}, []float64{2, 3})
+			csr.MulVecTo(dst, false, sparseX)
+			Expect(dst.RawVector().Data).To(Equal([]float64{4, 0, -9}))
+		})
+
+		it("multiplies matrices", func() {
+			b := mat.NewDense(4, 2, []float64{
+				1, 0,
+				2, 1,
+				3, 0,
+				4, 1,
+			})
+			dst := mat.NewDense(3, 2, nil)
+			csr.MulTo(dst, false, b)
+
+			expected := &mat.Dense{}
+			expected.Mul(csr.ToDense(), b)
+			Expect(dst).To(Equal(expected))
+
+			bT := mat.NewDense(3, 2, []float64{1, 2, 3, 4, 5, 6})
+			dstT := mat.NewDense(4, 2, nil)
+			csr.MulTo(dstT, true, bT)
+
+			expectedT := &mat.Dense{}
+			expectedT.Mul(csr.ToDense().T(), bT)
+			Expect(dstT).To(Equal(expectedT))
+		})
+
+		it("accumulates products on its stored entries only", func() {
+			grad := csr.Pattern()
+			Expect(grad.Data).To(Equal([]float64{0, 0, 0}))
+
+			grad.AddOuter(2, mat.NewVecDense(3, []float64{1, 1, 3}), mat.NewVecDense(4, []float64{1, 2, 3, 4}))
+			Expect(grad.Data).To(Equal([]float64{4, 6, 18}))
+
+			a := mat.NewDense(3, 2, []float64{1, 2, 0, 0, 3, 1})
+			b := mat.NewDense(4, 2, []float64{1, 1, 2, 0, 0, 1, 5, 5})
+			grad.AddMulT(a, b)
+			Expect(grad.Data).To(Equal([]float64{6, 10, 19}))
+
+			clone := grad.Clone()
+			clone.Add(grad)
+			clone.Scale(0.5)
+			Expect(clone.Data).To(Equal(grad.Data))
+		})
+
+		context("failure cases", func() {
+			it("when the structure is invalid", func() {
+				Expect(sparse.CheckCSR(1, 2, []int{0, 2}, []int{1, 0}, []float64{1, 2})).To(MatchError(mat.ErrIndexOutOfRange))
+				Expect(sparse.CheckCSR(2, 2, []int{0, 1}, []int{0}, []float64{1})).To(MatchError(mat.ErrShape))
+				Expect(func() { sparse.NewCSR(0, 2, []int{0}, nil, nil) }).To(Panic())
+			})
+
+			it("when dimensions do not match", func() {
+				Expect(func() { csr.MulVecTo(mat.NewVecDense(3, nil), false, mat.NewVecDense(3, nil)) }).To(Panic())
+				Expect(func() { csr.Add(sparse.CSRFromDense(dense, 0)) }).To(Panic())
+			})
+		})
+	})
+
+	context("Vector", func() {
+		it("stores entries above the threshold", func() {
+			v := sparse.VectorFromDense(mat.NewVecDense(5, []float64{0, 1, 0, 0, -2}), 0)
+			Expect(v.Indices).To(Equal([]int{1, 4}))
+			Expect(v.Data).To(Equal([]float64{1, -2}))
+			Expect(v.Len()).To(Equal(5))
+			Expect(v.NNZ()).To(Equal(2))
+			Expect(v.AtVec(4)).To(Equal(-2.0))
+			Expect(v.AtVec(2)).To(Equal(0.0))
+			Expect(v.ToDense().RawVector().Data).To(Equal([]float64{0, 1, 0, 0, -2}))
+		})
+
+		it("multiplies dense matrices by reading stored columns", func() {
+			dst := mat.NewVecDense(3, nil)
+			sparse.MulVec(dst, dense, sparse.NewVector(4, []int{0, 2}, []float64{2, 1}))
+			Expect(dst.RawVector().Data).To(Equal([]float64{0, 0, -1}))
+		})
+
+		context("failure cases", func() {
+			it("when indices are not increasing", func() {
+				Expect(func() { sparse.NewVector(3, []int{2, 1}, []float64{1, 1}) }).To(Panic())
+			})
+		})
+	})
+}
@@ -0,0 +1,175 @@
+package neuraltools
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A Callback is notified as a Trainer run progresses, e.g. to log, checkpoint or stop
+// training. Every hook receives the Progress of the run, an error ends the run with it.
+//go:generate faux --interface Callback --output fakes/callback.go
+type Callback interface {
+	OnTrainBegin(*Progress) error
+	OnEpochBegin(*Progress) error
+	OnBatchBegin(*Progress) error
+	OnBatchEnd(*Progress) error
+	OnEpochEnd(*Progress) error
+	OnTrainEnd(*Progress) error
+}
+
+// Progress is shared by every hook of a run, hooks may change Metrics and Stop
+type Progress struct {
+	Network Network
+	// counted from 0
+	Epoch int
+	// index of the first DataPair of the current batch within the shuffled epoch data
+	Batch int
+	// updates applied so far over every epoch
+	Step int
+	// mean training loss of the last batch in OnBatchEnd and of the epoch in OnEpochEnd,
+	// NaN when the network does not compute it or is trained by parallel workers
+	Loss float64
+	// named metrics of the run. TestAndTrain sets "correct", the count of correct training
+	// samples before the epoch, and Fit the measured validation metrics named by Monitor.
+	Metrics map[string]float64
+	// ends training after the current batch, the remaining hooks of the epoch and
+	// OnTrainEnd are still called
+	Stop bool
+}
+
+///
+/// CallbackFuncs Def
+///
+// adapts functions to a Callback, nil functions are skipped
+type CallbackFuncs struct {
+	TrainBegin func(*Progress) error
+	EpochBegin func(*Progress) error
+	BatchBegin func(*Progress) error
+	BatchEnd   func(*Progress) error
+	EpochEnd   func(*Progress) error
+	TrainEnd   func(*Progress) error
+}
+
+func (c CallbackFuncs) OnTrainBegin(progress *Progress) error {
+	return call(c.TrainBegin, progress)
+}
+
+func (c CallbackFuncs) OnEpochBegin(progress *Progress) error {
+	return call(c.EpochBegin, progress)
+}
+
+func (c CallbackFuncs) OnBatchBegin(progress *Progress) error {
+	return call(c.BatchBegin, progress)
+}
+
+func (c CallbackFuncs) OnBatchEnd(progress *Progress) error {
+	return call(c.BatchEnd, progress)
+}
+
+func (c CallbackFuncs) OnEpochEnd(progress *Progress) error {
+	return call(c.EpochEnd, progress)
+}
+
+func (c CallbackFuncs) OnTrainEnd(progress *Progress) error {
+	return call(c.TrainEnd, progress)
+}
+
+func call(hook func(*Progress) error, progress *Progress) error {
+	if hook == nil {
+		return nil
+	}
+
+	return hook(progress)
+}
+
+///
+/// Logger Def
+///
+// writes the loss and metrics of every epoch to Writer, and the loss of every
+// BatchInterval-th update when BatchInterval is positive. Epochs are counted from 1.
+type Logger struct {
+	Writer        io.Writer
+	BatchInterval int
+}
+
+func (l Logger) OnTrainBegin(*Progress) error {
+	return nil
+}
+
+func (l Logger) OnEpochBegin(*Progress) error {
+	return nil
+}
+
+func (l Logger) OnBatchBegin(*Progress) error {
+	return nil
+}
+
+func (l Logger) OnBatchEnd(progress *Progress) error {
+	if l.BatchInterval < 1 || progress.Step%l.BatchInterval != 0 {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(l.Writer, "epoch %d step %d: loss %.4f\n", progress.Epoch+1, progress.Step, progress.Loss)
+	return err
+}
+
+// metrics are written in name order
+func (l Logger) OnEpochEnd(progress *Progress) error {
+	line := fmt.Sprintf("epoch %d: loss %.4f", progress.Epoch+1, progress.Loss)
+
+	var names []string
+	for name := range progress.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		line += fmt.Sprintf(", %s %.4f", name, progress.Metrics[name])
+	}
+
+	_, err := fmt.Fprintln(l.Writer, line)
+	return err
+}
+
+func (l Logger) OnTrainEnd(*Progress) error {
+	return nil
+}
+
+// calls hook of every Callback in order, hooks are method expressions such as
+// Callback.OnEpochEnd
+func (r *trainingRun) notify(hook func(Callback, *Progress) error) error {
+	for _, callback := range r.Callbacks {
+		if err := hook(callback, &r.progress); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *trainingRun) batchBegin(start int) error {
+	r.progress.Batch = start
+
+	return r.notify(Callback.OnBatchBegin)
+}
+
+// loss is summed over the count DataPairs of the batch
+func (r *trainingRun) batchEnd(loss float64, count int) error {
+	r.step++
+	r.epochLoss += loss
+	r.epochCount += count
+
+	r.progress.Step = r.step
+	r.progress.Loss = loss / float64(count)
+
+	return r.notify(Callback.OnBatchEnd)
+}
+
+func (r *trainingRun) epochEnd() error {
+	return r.notify(Callback.OnEpochEnd)
+}
+
+func (r *trainingRun) end() error {
+	return r.notify(Callback.OnTrainEnd)
+}
@@ -0,0 +1,252 @@
+package neuraltools_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuraltools"
+	"github.com/dwillist/summerschool/v2/neuraltools/fakes"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+// a fake network whose loss is the first value of the solution
+type solutionLossNetwork struct {
+	*fakes.Network
+}
+
+func (s solutionLossNetwork) CalcLoss(solution *mat.VecDense) (float64, error) {
+	return solution.AtVec(0), nil
+}
+
+func testCallbacks(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		data     []neuraltools.DataPair
+		network  *fakes.Network
+		callback *fakes.Callback
+		trainer  neuraltools.Trainer
+	)
+
+	it.Before(func() {
+		data = nil
+		for idx := 0; idx < 4; idx++ {
+			data = append(data, neuraltools.DataPair{
+				Input:    mat.NewVecDense(1, []float64{float64(idx)}),
+				Solution: mat.NewVecDense(1, []float64{float64(idx)}),
+			})
+		}
+
+		network = &fakes.Network{}
+		callback = &fakes.Callback{}
+		trainer = neuraltools.Trainer{EpochCount: 2, BatchSize: 2, Callbacks: []neuraltools.Callback{callback}}
+	})
+
+	it("notifies every hook in order", func() {
+		var events []string
+		record := func(name string) func(*neuraltools.Progress) error {
+			return func(progress *neuraltools.Progress) error {
+				events = append(events, fmt.Sprintf("%s %d %d %d", name, progress.Epoch, progress.Batch, progress.Step))
+				return nil
+			}
+		}
+
+		trainer.Callbacks = []neuraltools.Callback{neuraltools.CallbackFuncs{
+			TrainBegin: record("train begin"),
+			EpochBegin: record("epoch begin"),
+			BatchBegin: record("batch begin"),
+			BatchEnd:   record("batch end"),
+			EpochEnd:   record("epoch end"),
+			TrainEnd:   record("train end"),
+		}}
+		Expect(trainer.Train(network, data...)).To(Succeed())
+
+		Expect(events).To(Equal([]string{
+			"train begin 0 0 0",
+			"epoch begin 0 0 0",
+			"batch begin 0 0 0",
+			"batch end 0 0 1",
+			"batch begin 0 2 1",
+			"batch end 0 2 2",
+			"epoch end 0 2 2",
+			"epoch begin 1 2 2",
+			"batch begin 1 0 2",
+			"batch end 1 0 3",
+			"batch begin 1 2 3",
+			"batch end 1 2 4",
+			"epoch end 1 2 4",
+			"train end 1 2 4",
+		}))
+
+		Expect(neuraltools.CallbackFuncs{}.OnTrainBegin(nil)).To(Succeed())
+	})
+
+	context("when the network computes its loss", func() {
+		it("reports the mean loss of every batch and epoch", func() {
+			var batchLosses []float64
+			callback.OnBatchEndCall.Stub = func(progress *neuraltools.Progress) error {
+				batchLosses = append(batchLosses, progress.Loss)
+				return nil
+			}
+
+			trainer.EpochCount = 1
+			Expect(trainer.Train(solutionLossNetwork{network}, data...)).To(Succeed())
+
+			// batches are shuffled, the epoch loss is their mean
+			Expect(batchLosses).To(HaveLen(2))
+			Expect(batchLosses[0] + batchLosses[1]).To(Equal(float64(3)))
+			Expect(callback.OnEpochEndCall.Receives.Progress.Loss).To(Equal(1.5))
+		})
+
+		it("uses the batch loss of a BatchNetwork", func() {
+			batchNetwork := struct {
+				*fakes.BatchNetwork
+				*fakes.BatchLossCalculator
+			}{&fakes.BatchNetwork{}, &fakes.BatchLossCalculator{}}
+			var expected, batchLosses []float64
+			batchNetwork.CalcLossBatchCall.Stub = func(solutions *mat.Dense) (float64, error) {
+				expected = append(expected, mat.Sum(solutions)/2)
+				return mat.Sum(solutions), nil
+			}
+			callback.OnBatchEndCall.Stub = func(progress *neuraltools.Progress) error {
+				batchLosses = append(batchLosses, progress.Loss)
+				return nil
+			}
+
+			trainer.EpochCount = 1
+			Expect(trainer.Train(batchNetwork, data...)).To(Succeed())
+
+			Expect(batchNetwork.CalcLossBatchCall.CallCount).To(Equal(2))
+			Expect(batchLosses).To(Equal(expected))
+			Expect(callback.OnEpochEndCall.Receives.Progress.Loss).To(Equal(1.5))
+		})
+
+		it("does not compute the loss without callbacks", func() {
+			batchNetwork := struct {
+				*fakes.BatchNetwork
+				*fakes.BatchLossCalculator
+			}{&fakes.BatchNetwork{}, &fakes.BatchLossCalculator{}}
+
+			trainer.Callbacks = nil
+			Expect(trainer.Train(batchNetwork, data...)).To(Succeed())
+
+			Expect(batchNetwork.CalcLossBatchCall.CallCount).To(BeZero())
+		})
+
+		it("fails when computing the loss fails", func() {
+			batchNetwork := struct {
+				*fakes.BatchNetwork
+				*fakes.BatchLossCalculator
+			}{&fakes.BatchNetwork{}, &fakes.BatchLossCalculator{}}
+			batchNetwork.CalcLossBatchCall.Returns.Error = errors.New("error occurred")
+
+			Expect(trainer.Train(batchNetwork, data...)).To(MatchError("network loss calculation failed on batch at index: 0"))
+		})
+	})
+
+	it("reports a NaN loss when the network does not compute it", func() {
+		Expect(trainer.Train(network, data...)).To(Succeed())
+
+		Expect(math.IsNaN(callback.OnBatchEndCall.Receives.Progress.Loss)).To(BeTrue())
+		Expect(math.IsNaN(callback.OnEpochEndCall.Receives.Progress.Loss)).To(BeTrue())
+	})
+
+	it("passes the correct count of TestAndTrain as a metric", func() {
+		network.CalculateCall.Stub = func(input *mat.VecDense) (*mat.VecDense, error) {
+			return input, nil
+		}
+
+		_, err := trainer.TestAndTrain(network, func(actual, expected *mat.VecDense) bool {
+			return actual.AtVec(0) < 1
+		}, data...)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(callback.OnEpochBeginCall.Receives.Progress.Metrics).To(Equal(map[string]float64{"correct": 1}))
+	})
+
+	it("stops training after the batch that requested it", func() {
+		callback.OnBatchEndCall.Stub = func(progress *neuraltools.Progress) error {
+			progress.Stop = true
+			return nil
+		}
+
+		Expect(trainer.Train(network, data...)).To(Succeed())
+
+		Expect(network.UpdateCall.CallCount).To(Equal(1))
+		Expect(callback.OnEpochBeginCall.CallCount).To(Equal(1))
+		Expect(callback.OnEpochEndCall.CallCount).To(Equal(1))
+		Expect(callback.OnTrainEndCall.CallCount).To(Equal(1))
+	})
+
+	it("ends the run on the first hook error", func() {
+		other := &fakes.Callback{}
+		trainer.Callbacks = append(trainer.Callbacks, other)
+		callback.OnEpochBeginCall.Returns.Error = errors.New("error occurred")
+
+		Expect(trainer.Train(network, data...)).To(MatchError("error occurred"))
+
+		Expect(other.OnEpochBeginCall.CallCount).To(BeZero())
+		Expect(network.CalculateCall.CallCount).To(BeZero())
+		Expect(callback.OnTrainEndCall.CallCount).To(BeZero())
+	})
+
+	context("when used with Fit", func() {
+		it("passes the validation metrics and can stop early", func() {
+			network, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{{Size: 1}, {Size: 1, Func: nodefuncs.Sigmoid{}}},
+				Source:       rand.NewSource(4),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var metrics []map[string]float64
+			trainer.EpochCount = 5
+			trainer.Callbacks = []neuraltools.Callback{neuraltools.CallbackFuncs{
+				EpochEnd: func(progress *neuraltools.Progress) error {
+					metrics = append(metrics, progress.Metrics)
+					progress.Stop = progress.Epoch == 1
+					return nil
+				},
+			}}
+
+			history, err := trainer.Fit(&network, neuraltools.EarlyStopping{}, neuraltools.MaxJudge, data, data[:2])
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(history.StopReason).To(Equal(neuraltools.StoppedByCallback))
+			Expect(history.String()).To(Equal("stopped by a callback after epoch 2, best epoch 2"))
+			Expect(metrics).To(HaveLen(2))
+			Expect(metrics[1]).To(Equal(map[string]float64{
+				"validation loss":     history.Epochs[1].ValidationLoss,
+				"validation accuracy": 1,
+			}))
+		})
+	})
+
+	context("Logger", func() {
+		it("writes the loss and metrics", func() {
+			buffer := bytes.NewBuffer(nil)
+			logger := neuraltools.Logger{Writer: buffer, BatchInterval: 2}
+
+			progress := &neuraltools.Progress{Epoch: 2, Step: 3, Loss: 0.5}
+			Expect(logger.OnBatchEnd(progress)).To(Succeed())
+			Expect(buffer.String()).To(BeEmpty())
+
+			progress.Step = 4
+			Expect(logger.OnBatchEnd(progress)).To(Succeed())
+
+			progress.Loss = 0.25
+			progress.Metrics = map[string]float64{"validation loss": 0.3, "validation accuracy": 0.9}
+			Expect(logger.OnEpochEnd(progress)).To(Succeed())
+
+			Expect(buffer.String()).To(Equal("epoch 3 step 4: loss 0.5000\nepoch 3: loss 0.2500, validation accuracy 0.9000, validation loss 0.3000\n"))
+		})
+	})
+}
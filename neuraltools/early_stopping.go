@@ -0,0 +1,227 @@
+package neuraltools
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Monitor selects the validation metric EarlyStopping watches
+type Monitor int
+
+const (
+	// mean Loss of the validation data, lower is better
+	ValidationLoss Monitor = iota
+	// fraction of the validation data accepted by the judge, higher is better
+	ValidationAccuracy
+)
+
+func (m Monitor) String() string {
+	switch m {
+	case ValidationLoss:
+		return "validation loss"
+	case ValidationAccuracy:
+		return "validation accuracy"
+	default:
+		return fmt.Sprintf("Monitor(%d)", int(m))
+	}
+}
+
+// EarlyStopping ends Fit once Monitor has not improved by more than MinDelta for
+// Patience epochs in a row, a Patience of 0 always trains every epoch
+type EarlyStopping struct {
+	Monitor  Monitor
+	Patience int
+	MinDelta float64
+	// returns the network to its values after the best epoch once training ends,
+	// requires the network to be a Snapshotter
+	RestoreBest bool
+}
+
+// whether value improves on best by more than MinDelta
+func (e EarlyStopping) improves(value, best float64) bool {
+	if e.Monitor == ValidationAccuracy {
+		return value > best+e.MinDelta
+	}
+
+	return value < best-e.MinDelta
+}
+
+type StopReason int
+
+const (
+	// every epoch of Trainer.EpochCount was trained
+	EpochsCompleted StopReason = iota
+	// Monitor did not improve for EarlyStopping.Patience epochs
+	NoImprovement
+	// a Callback set Progress.Stop
+	StoppedByCallback
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case EpochsCompleted:
+		return "epochs completed"
+	case NoImprovement:
+		return "no improvement"
+	case StoppedByCallback:
+		return "stopped by callback"
+	default:
+		return fmt.Sprintf("StopReason(%d)", int(r))
+	}
+}
+
+// validation results after an epoch, metrics that were not measured are NaN
+type EpochResult struct {
+	// counted from 1
+	Epoch              int
+	ValidationLoss     float64
+	ValidationAccuracy float64
+	// whether the monitored metric improved on every earlier epoch
+	Improved bool
+}
+
+type History struct {
+	Epochs []EpochResult
+	// epoch with the best monitored metric, 0 while every monitored value is NaN
+	BestEpoch  int
+	StopReason StopReason
+	// last trained epoch
+	StoppedEpoch int
+	// whether the network was returned to its values after BestEpoch
+	Restored bool
+}
+
+func (h History) String() string {
+	switch h.StopReason {
+	case NoImprovement:
+		return fmt.Sprintf("stopped after epoch %d with no improvement since epoch %d", h.StoppedEpoch, h.BestEpoch)
+	case StoppedByCallback:
+		return fmt.Sprintf("stopped by a callback after epoch %d, best epoch %d", h.StoppedEpoch, h.BestEpoch)
+	}
+
+	return fmt.Sprintf("completed %d epochs, best epoch %d", h.StoppedEpoch, h.BestEpoch)
+}
+
+// trains on train for up to EpochCount epochs, measuring validation after every epoch.
+// The validation loss is measured when the network is a LossCalculator and the accuracy
+// when judge is not nil, stopping decides which of them is monitored. The monitored
+// metric is also passed on when the Trainer Schedule is a MetricSchedule, Callbacks get
+// every measured metric in OnEpochEnd.
+func (t Trainer) Fit(network Network, stopping EarlyStopping, judge func(*mat.VecDense, *mat.VecDense) bool, train, validation []DataPair) (History, error) {
+	var history History
+
+	lossCalculator, isLossCalculator := network.(LossCalculator)
+	snapshotter, isSnapshotter := network.(Snapshotter)
+
+	switch {
+	case len(validation) == 0:
+		return history, fmt.Errorf("missing validation data")
+	case stopping.Monitor != ValidationLoss && stopping.Monitor != ValidationAccuracy:
+		return history, fmt.Errorf("invalid monitor: %s", stopping.Monitor)
+	case stopping.Monitor == ValidationLoss && !isLossCalculator:
+		return history, fmt.Errorf("monitoring %s requires a LossCalculator network", stopping.Monitor)
+	case stopping.Monitor == ValidationAccuracy && judge == nil:
+		return history, fmt.Errorf("monitoring %s requires a judge", stopping.Monitor)
+	case stopping.RestoreBest && !isSnapshotter:
+		return history, fmt.Errorf("restoring the best epoch requires a Snapshotter network")
+	}
+
+	run, err := t.start(network, train)
+	if err != nil {
+		return history, err
+	}
+
+	var (
+		best     float64
+		snapshot [][]float64
+		wait     int
+	)
+
+	for epoch := 1; epoch <= t.EpochCount; epoch++ {
+		if err := run.trainEpoch(); err != nil {
+			return history, err
+		}
+
+		result := EpochResult{
+			Epoch:              epoch,
+			ValidationLoss:     math.NaN(),
+			ValidationAccuracy: math.NaN(),
+		}
+		metrics := map[string]float64{}
+
+		if isLossCalculator {
+			loss, err := Loss(lossCalculator, validation...)
+			if err != nil {
+				return history, err
+			}
+
+			result.ValidationLoss = loss
+			metrics[ValidationLoss.String()] = loss
+		}
+
+		if judge != nil {
+			correct, err := Test(network, judge, validation...)
+			if err != nil {
+				return history, err
+			}
+
+			result.ValidationAccuracy = float64(correct) / float64(len(validation))
+			metrics[ValidationAccuracy.String()] = result.ValidationAccuracy
+		}
+
+		value := result.ValidationLoss
+		if stopping.Monitor == ValidationAccuracy {
+			value = result.ValidationAccuracy
+		}
+
+		run.observe(value)
+
+		// a NaN metric never improves, the first measured one is always the best so far
+		result.Improved = !math.IsNaN(value) && (history.BestEpoch == 0 || stopping.improves(value, best))
+		history.Epochs = append(history.Epochs, result)
+		history.StoppedEpoch = epoch
+
+		if result.Improved {
+			best = value
+			history.BestEpoch = epoch
+			wait = 0
+
+			if stopping.RestoreBest {
+				snapshot = snapshotter.Snapshot()
+			}
+		} else {
+			wait++
+		}
+
+		run.progress.Metrics = metrics
+		if err := run.epochEnd(); err != nil {
+			return history, err
+		}
+
+		if run.progress.Stop {
+			history.StopReason = StoppedByCallback
+			break
+		}
+
+		if stopping.Patience > 0 && wait >= stopping.Patience {
+			history.StopReason = NoImprovement
+			break
+		}
+	}
+
+	if stopping.RestoreBest && history.BestEpoch != 0 && history.BestEpoch != history.StoppedEpoch {
+		if err := snapshotter.Restore(snapshot); err != nil {
+			return history, fmt.Errorf("failed to restore epoch %d: %s", history.BestEpoch, err)
+		}
+
+		history.Restored = true
+	}
+
+	if err := run.end(); err != nil {
+		return history, err
+	}
+
+	return history, nil
+}
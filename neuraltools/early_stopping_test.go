@@ -0,0 +1,277 @@
+package neuraltools_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/dwillist/summerschool/v2/neuralnet/schedules"
+	"github.com/dwillist/summerschool/v2/neuraltools"
+	"github.com/dwillist/summerschool/v2/neuraltools/fakes"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+// a fake network whose validation loss after every epoch is taken from Losses
+type lossNetwork struct {
+	*fakes.Network
+	Losses []float64
+}
+
+func (l lossNetwork) CalcLoss(*mat.VecDense) (float64, error) {
+	return l.Losses[l.UpdateCall.CallCount-1], nil
+}
+
+type snapshotNetwork struct {
+	lossNetwork
+	*fakes.Snapshotter
+}
+
+func testEarlyStopping(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		train      []neuraltools.DataPair
+		validation []neuraltools.DataPair
+		network    snapshotNetwork
+		trainer    neuraltools.Trainer
+	)
+
+	it.Before(func() {
+		train = []neuraltools.DataPair{
+			{Input: mat.NewVecDense(1, []float64{0}), Solution: mat.NewVecDense(1, []float64{0})},
+			{Input: mat.NewVecDense(1, []float64{1}), Solution: mat.NewVecDense(1, []float64{1})},
+		}
+		validation = train[:1]
+
+		network = snapshotNetwork{
+			lossNetwork: lossNetwork{
+				Network: &fakes.Network{},
+				Losses:  []float64{1, 0.5, 0.45, 0.6, 0.49, 0.3},
+			},
+			Snapshotter: &fakes.Snapshotter{},
+		}
+		network.SnapshotCall.Stub = func() [][]float64 {
+			return [][]float64{{float64(network.UpdateCall.CallCount)}}
+		}
+
+		trainer = neuraltools.Trainer{EpochCount: 6, BatchSize: 2}
+	})
+
+	it("stops once the monitored metric has not improved by MinDelta for Patience epochs", func() {
+		history, err := trainer.Fit(network, neuraltools.EarlyStopping{Patience: 2, MinDelta: 0.1}, nil, train, validation)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(history.Epochs).To(HaveLen(4))
+		for idx, improved := range []bool{true, true, false, false} {
+			Expect(history.Epochs[idx].Epoch).To(Equal(idx + 1))
+			Expect(history.Epochs[idx].ValidationLoss).To(Equal(network.Losses[idx]))
+			Expect(math.IsNaN(history.Epochs[idx].ValidationAccuracy)).To(BeTrue())
+			Expect(history.Epochs[idx].Improved).To(Equal(improved))
+		}
+
+		Expect(history.StopReason).To(Equal(neuraltools.NoImprovement))
+		Expect(history.StoppedEpoch).To(Equal(4))
+		Expect(history.BestEpoch).To(Equal(2))
+		Expect(history.Restored).To(BeFalse())
+		Expect(history.String()).To(Equal("stopped after epoch 4 with no improvement since epoch 2"))
+		Expect(network.UpdateCall.CallCount).To(Equal(4))
+	})
+
+	it("restores the snapshot of the best epoch", func() {
+		history, err := trainer.Fit(network, neuraltools.EarlyStopping{Patience: 2, MinDelta: 0.1, RestoreBest: true}, nil, train, validation)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(history.Restored).To(BeTrue())
+		Expect(network.SnapshotCall.CallCount).To(Equal(2))
+		Expect(network.RestoreCall.CallCount).To(Equal(1))
+		Expect(network.RestoreCall.Receives.Float64SliceSlice).To(Equal([][]float64{{2}}))
+	})
+
+	it("trains every epoch without Patience", func() {
+		history, err := trainer.Fit(network, neuraltools.EarlyStopping{MinDelta: 0.1, RestoreBest: true}, nil, train, validation)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(history.Epochs).To(HaveLen(6))
+		Expect(history.StopReason).To(Equal(neuraltools.EpochsCompleted))
+		Expect(history.BestEpoch).To(Equal(6))
+		Expect(history.Restored).To(BeFalse())
+		Expect(history.String()).To(Equal("completed 6 epochs, best epoch 6"))
+		Expect(network.RestoreCall.CallCount).To(BeZero())
+	})
+
+	it("never treats a NaN metric as the best", func() {
+		network.Losses = []float64{math.NaN(), 0.8, math.NaN(), 0.9, 0.85, 0.85}
+
+		history, err := trainer.Fit(network, neuraltools.EarlyStopping{Patience: 3, RestoreBest: true}, nil, train, validation)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(history.StoppedEpoch).To(Equal(5))
+		Expect(history.BestEpoch).To(Equal(2))
+		for idx, improved := range []bool{false, true, false, false, false} {
+			Expect(history.Epochs[idx].Improved).To(Equal(improved))
+		}
+		Expect(network.RestoreCall.Receives.Float64SliceSlice).To(Equal([][]float64{{2}}))
+	})
+
+	it("does not restore when every metric is NaN", func() {
+		network.Losses = []float64{math.NaN(), math.NaN(), math.NaN()}
+
+		history, err := trainer.Fit(network, neuraltools.EarlyStopping{Patience: 2, RestoreBest: true}, nil, train, validation)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(history.StoppedEpoch).To(Equal(2))
+		Expect(history.BestEpoch).To(BeZero())
+		Expect(history.Restored).To(BeFalse())
+		Expect(network.RestoreCall.CallCount).To(BeZero())
+	})
+
+	it("monitors the validation accuracy", func() {
+		// indexed by the number of trained epochs, the first entry is only used during training
+		correct := []bool{false, false, true, true, false}
+		network.CalculateCall.Stub = func(input *mat.VecDense) (*mat.VecDense, error) {
+			if correct[network.UpdateCall.CallCount] {
+				return input, nil
+			}
+
+			return mat.NewVecDense(1, []float64{-1}), nil
+		}
+
+		judge := func(actual, expected *mat.VecDense) bool {
+			return mat.Equal(actual, expected)
+		}
+
+		history, err := trainer.Fit(network, neuraltools.EarlyStopping{Monitor: neuraltools.ValidationAccuracy, Patience: 2}, judge, train, validation)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(history.StoppedEpoch).To(Equal(4))
+		Expect(history.BestEpoch).To(Equal(2))
+		Expect(history.Epochs[1].ValidationAccuracy).To(Equal(float64(1)))
+		Expect(history.Epochs[1].ValidationLoss).To(Equal(0.5))
+		Expect(history.Epochs[2].Improved).To(BeFalse())
+	})
+
+	it("passes the monitored metric to a MetricSchedule", func() {
+		scheduled := struct {
+			lossNetwork
+			*fakes.LearningRateSetter
+		}{network.lossNetwork, &fakes.LearningRateSetter{}}
+
+		var rates []float64
+		scheduled.SetLearningRateCall.Stub = func(rate float64) error {
+			rates = append(rates, rate)
+			return nil
+		}
+
+		schedule, err := schedules.NewReduceOnPlateau(schedules.ReduceOnPlateauConfig{Initial: 1, Factor: 0.5, MinDelta: 0.1})
+		Expect(err).NotTo(HaveOccurred())
+
+		trainer.Schedule = schedule
+		_, err = trainer.Fit(scheduled, neuraltools.EarlyStopping{}, nil, train, validation)
+		Expect(err).NotTo(HaveOccurred())
+
+		// halved after each of the losses 0.45, 0.6 and 0.49
+		Expect(rates).To(Equal([]float64{1, 1, 1, 0.5, 0.25, 0.125}))
+	})
+
+	context("when training a network that overfits", func() {
+		it("returns the network to its best validation loss", func() {
+			rng := rand.New(rand.NewSource(5))
+
+			// random labels can only be memorized, so validation loss rises as training continues
+			sample := func() neuraltools.DataPair {
+				input := mat.NewVecDense(4, nil)
+				for idx := 0; idx < 4; idx++ {
+					input.SetVec(idx, rng.NormFloat64())
+				}
+
+				return neuraltools.DataPair{Input: input, Solution: mat.NewVecDense(1, []float64{float64(rng.Intn(2))})}
+			}
+
+			var train, validation []neuraltools.DataPair
+			for idx := 0; idx < 20; idx++ {
+				train = append(train, sample())
+				validation = append(validation, sample())
+			}
+
+			network, err := neuralnet.NewNetwork(neuralnet.Config{
+				LayerConfigs: []neuralnet.LayerConfig{
+					{Size: 4},
+					{Size: 32, Func: nodefuncs.Relu{}},
+					{Size: 1, Func: nodefuncs.Sigmoid{}},
+				},
+				Optimizer: optimizers.NewAdam(0.01),
+				Source:    rand.NewSource(6),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			trainer := neuraltools.Trainer{EpochCount: 500, BatchSize: 4, Source: rand.NewSource(7)}
+			history, err := trainer.Fit(&network, neuraltools.EarlyStopping{Patience: 5, RestoreBest: true}, nil, train, validation)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(history.StopReason).To(Equal(neuraltools.NoImprovement))
+			Expect(history.StoppedEpoch).To(BeNumerically("<", 500))
+			Expect(history.Restored).To(BeTrue())
+
+			loss, err := neuraltools.Loss(&network, validation...)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loss).To(Equal(history.Epochs[history.BestEpoch-1].ValidationLoss))
+			Expect(loss).To(BeNumerically("<", history.Epochs[history.StoppedEpoch-1].ValidationLoss))
+		})
+	})
+
+	context("failure cases", func() {
+		it("fails without validation data", func() {
+			_, err := trainer.Fit(network, neuraltools.EarlyStopping{}, nil, train, nil)
+			Expect(err).To(MatchError("missing validation data"))
+		})
+
+		it("fails on an invalid monitor", func() {
+			_, err := trainer.Fit(network, neuraltools.EarlyStopping{Monitor: 7}, nil, train, validation)
+			Expect(err).To(MatchError("invalid monitor: Monitor(7)"))
+		})
+
+		it("fails monitoring the loss of a network that does not compute it", func() {
+			_, err := trainer.Fit(&fakes.Network{}, neuraltools.EarlyStopping{}, nil, train, validation)
+			Expect(err).To(MatchError("monitoring validation loss requires a LossCalculator network"))
+		})
+
+		it("fails monitoring the accuracy without a judge", func() {
+			_, err := trainer.Fit(network, neuraltools.EarlyStopping{Monitor: neuraltools.ValidationAccuracy}, nil, train, validation)
+			Expect(err).To(MatchError("monitoring validation accuracy requires a judge"))
+		})
+
+		it("fails restoring a network that cannot be restored", func() {
+			_, err := trainer.Fit(network.lossNetwork, neuraltools.EarlyStopping{RestoreBest: true}, nil, train, validation)
+			Expect(err).To(MatchError("restoring the best epoch requires a Snapshotter network"))
+		})
+
+		it("returns the history so far when training fails", func() {
+			network.UpdateCall.Stub = func(neuralnet.Gradient) error {
+				if network.UpdateCall.CallCount == 2 {
+					return fmt.Errorf("error occurred")
+				}
+
+				return nil
+			}
+
+			history, err := trainer.Fit(network, neuraltools.EarlyStopping{}, nil, train, validation)
+			Expect(err).To(MatchError("network update failed on batch at index: 0"))
+			Expect(history.Epochs).To(HaveLen(1))
+		})
+
+		it("fails when restoring fails", func() {
+			network.RestoreCall.Returns.Error = fmt.Errorf("error occurred")
+
+			history, err := trainer.Fit(network, neuraltools.EarlyStopping{Patience: 2, MinDelta: 0.1, RestoreBest: true}, nil, train, validation)
+			Expect(err).To(MatchError("failed to restore epoch 2: error occurred"))
+			Expect(history.Restored).To(BeFalse())
+		})
+	})
+}
@@ -0,0 +1,55 @@
+package fakes
+
+import (
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type BatchCalculator struct {
+	CalculateCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			VecDense *mat.VecDense
+		}
+		Returns struct {
+			VecDense *mat.VecDense
+			Error    error
+		}
+		Stub func(*mat.VecDense) (*mat.VecDense, error)
+	}
+	CalculateBatchCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Dense *mat.Dense
+		}
+		Returns struct {
+			Dense *mat.Dense
+			Error error
+		}
+		Stub func(*mat.Dense) (*mat.Dense, error)
+	}
+}
+
+func (f *BatchCalculator) Calculate(param1 *mat.VecDense) (*mat.VecDense, error) {
+	f.CalculateCall.Lock()
+	defer f.CalculateCall.Unlock()
+	f.CalculateCall.CallCount++
+	f.CalculateCall.Receives.VecDense = param1
+	if f.CalculateCall.Stub != nil {
+		return f.CalculateCall.Stub(param1)
+	}
+	return f.CalculateCall.Returns.VecDense, f.CalculateCall.Returns.Error
+}
+func (f *BatchCalculator) CalculateBatch(param1 *mat.Dense) (*mat.Dense, error) {
+	f.CalculateBatchCall.Lock()
+	defer f.CalculateBatchCall.Unlock()
+	f.CalculateBatchCall.CallCount++
+	f.CalculateBatchCall.Receives.Dense = param1
+	if f.CalculateBatchCall.Stub != nil {
+		return f.CalculateBatchCall.Stub(param1)
+	}
+	return f.CalculateBatchCall.Returns.Dense, f.CalculateBatchCall.Returns.Error
+}
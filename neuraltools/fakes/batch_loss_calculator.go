@@ -0,0 +1,33 @@
+package fakes
+
+import (
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type BatchLossCalculator struct {
+	CalcLossBatchCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Dense *mat.Dense
+		}
+		Returns struct {
+			Float64 float64
+			Error   error
+		}
+		Stub func(*mat.Dense) (float64, error)
+	}
+}
+
+func (f *BatchLossCalculator) CalcLossBatch(param1 *mat.Dense) (float64, error) {
+	f.CalcLossBatchCall.Lock()
+	defer f.CalcLossBatchCall.Unlock()
+	f.CalcLossBatchCall.CallCount++
+	f.CalcLossBatchCall.Receives.Dense = param1
+	if f.CalcLossBatchCall.Stub != nil {
+		return f.CalcLossBatchCall.Stub(param1)
+	}
+	return f.CalcLossBatchCall.Returns.Float64, f.CalcLossBatchCall.Returns.Error
+}
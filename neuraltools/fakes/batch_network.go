@@ -0,0 +1,165 @@
+package fakes
+
+import (
+	"sync"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"gonum.org/v1/gonum/mat"
+)
+
+type BatchNetwork struct {
+	CalculateCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			VecDense *mat.VecDense
+		}
+		Returns struct {
+			VecDense *mat.VecDense
+			Error    error
+		}
+		Stub func(*mat.VecDense) (*mat.VecDense, error)
+	}
+	CalculateBatchCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Dense *mat.Dense
+		}
+		Returns struct {
+			Dense *mat.Dense
+			Error error
+		}
+		Stub func(*mat.Dense) (*mat.Dense, error)
+	}
+	GenerateDeltaCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			VecDense *mat.VecDense
+		}
+		Returns struct {
+			VecDenseSlice []*mat.VecDense
+			Error         error
+		}
+		Stub func(*mat.VecDense) ([]*mat.VecDense, error)
+	}
+	GenerateDeltaBatchCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Dense *mat.Dense
+		}
+		Returns struct {
+			DenseSlice []*mat.Dense
+			Error      error
+		}
+		Stub func(*mat.Dense) ([]*mat.Dense, error)
+	}
+	GenerateGradientCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			VecDenseSlice []*mat.VecDense
+		}
+		Returns struct {
+			Gradient neuralnet.Gradient
+			Error    error
+		}
+		Stub func([]*mat.VecDense) (neuralnet.Gradient, error)
+	}
+	GenerateGradientBatchCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			DenseSlice []*mat.Dense
+		}
+		Returns struct {
+			Gradient neuralnet.Gradient
+			Error    error
+		}
+		Stub func([]*mat.Dense) (neuralnet.Gradient, error)
+	}
+	UpdateCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Gradient neuralnet.Gradient
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(neuralnet.Gradient) error
+	}
+}
+
+func (f *BatchNetwork) Calculate(param1 *mat.VecDense) (*mat.VecDense, error) {
+	f.CalculateCall.Lock()
+	defer f.CalculateCall.Unlock()
+	f.CalculateCall.CallCount++
+	f.CalculateCall.Receives.VecDense = param1
+	if f.CalculateCall.Stub != nil {
+		return f.CalculateCall.Stub(param1)
+	}
+	return f.CalculateCall.Returns.VecDense, f.CalculateCall.Returns.Error
+}
+func (f *BatchNetwork) CalculateBatch(param1 *mat.Dense) (*mat.Dense, error) {
+	f.CalculateBatchCall.Lock()
+	defer f.CalculateBatchCall.Unlock()
+	f.CalculateBatchCall.CallCount++
+	f.CalculateBatchCall.Receives.Dense = param1
+	if f.CalculateBatchCall.Stub != nil {
+		return f.CalculateBatchCall.Stub(param1)
+	}
+	return f.CalculateBatchCall.Returns.Dense, f.CalculateBatchCall.Returns.Error
+}
+func (f *BatchNetwork) GenerateDelta(param1 *mat.VecDense) ([]*mat.VecDense, error) {
+	f.GenerateDeltaCall.Lock()
+	defer f.GenerateDeltaCall.Unlock()
+	f.GenerateDeltaCall.CallCount++
+	f.GenerateDeltaCall.Receives.VecDense = param1
+	if f.GenerateDeltaCall.Stub != nil {
+		return f.GenerateDeltaCall.Stub(param1)
+	}
+	return f.GenerateDeltaCall.Returns.VecDenseSlice, f.GenerateDeltaCall.Returns.Error
+}
+func (f *BatchNetwork) GenerateDeltaBatch(param1 *mat.Dense) ([]*mat.Dense, error) {
+	f.GenerateDeltaBatchCall.Lock()
+	defer f.GenerateDeltaBatchCall.Unlock()
+	f.GenerateDeltaBatchCall.CallCount++
+	f.GenerateDeltaBatchCall.Receives.Dense = param1
+	if f.GenerateDeltaBatchCall.Stub != nil {
+		return f.GenerateDeltaBatchCall.Stub(param1)
+	}
+	return f.GenerateDeltaBatchCall.Returns.DenseSlice, f.GenerateDeltaBatchCall.Returns.Error
+}
+func (f *BatchNetwork) GenerateGradient(param1 []*mat.VecDense) (neuralnet.Gradient, error) {
+	f.GenerateGradientCall.Lock()
+	defer f.GenerateGradientCall.Unlock()
+	f.GenerateGradientCall.CallCount++
+	f.GenerateGradientCall.Receives.VecDenseSlice = param1
+	if f.GenerateGradientCall.Stub != nil {
+		return f.GenerateGradientCall.Stub(param1)
+	}
+	return f.GenerateGradientCall.Returns.Gradient, f.GenerateGradientCall.Returns.Error
+}
+func (f *BatchNetwork) GenerateGradientBatch(param1 []*mat.Dense) (neuralnet.Gradient, error) {
+	f.GenerateGradientBatchCall.Lock()
+	defer f.GenerateGradientBatchCall.Unlock()
+	f.GenerateGradientBatchCall.CallCount++
+	f.GenerateGradientBatchCall.Receives.DenseSlice = param1
+	if f.GenerateGradientBatchCall.Stub != nil {
+		return f.GenerateGradientBatchCall.Stub(param1)
+	}
+	return f.GenerateGradientBatchCall.Returns.Gradient, f.GenerateGradientBatchCall.Returns.Error
+}
+func (f *BatchNetwork) Update(param1 neuralnet.Gradient) error {
+	f.UpdateCall.Lock()
+	defer f.UpdateCall.Unlock()
+	f.UpdateCall.CallCount++
+	f.UpdateCall.Receives.Gradient = param1
+	if f.UpdateCall.Stub != nil {
+		return f.UpdateCall.Stub(param1)
+	}
+	return f.UpdateCall.Returns.Error
+}
@@ -0,0 +1,137 @@
+package fakes
+
+import (
+	"sync"
+
+	"github.com/dwillist/summerschool/v2/neuraltools"
+)
+
+type Callback struct {
+	OnBatchBeginCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Progress *neuraltools.Progress
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(*neuraltools.Progress) error
+	}
+	OnBatchEndCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Progress *neuraltools.Progress
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(*neuraltools.Progress) error
+	}
+	OnEpochBeginCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Progress *neuraltools.Progress
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(*neuraltools.Progress) error
+	}
+	OnEpochEndCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Progress *neuraltools.Progress
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(*neuraltools.Progress) error
+	}
+	OnTrainBeginCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Progress *neuraltools.Progress
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(*neuraltools.Progress) error
+	}
+	OnTrainEndCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Progress *neuraltools.Progress
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(*neuraltools.Progress) error
+	}
+}
+
+func (f *Callback) OnBatchBegin(param1 *neuraltools.Progress) error {
+	f.OnBatchBeginCall.Lock()
+	defer f.OnBatchBeginCall.Unlock()
+	f.OnBatchBeginCall.CallCount++
+	f.OnBatchBeginCall.Receives.Progress = param1
+	if f.OnBatchBeginCall.Stub != nil {
+		return f.OnBatchBeginCall.Stub(param1)
+	}
+	return f.OnBatchBeginCall.Returns.Error
+}
+func (f *Callback) OnBatchEnd(param1 *neuraltools.Progress) error {
+	f.OnBatchEndCall.Lock()
+	defer f.OnBatchEndCall.Unlock()
+	f.OnBatchEndCall.CallCount++
+	f.OnBatchEndCall.Receives.Progress = param1
+	if f.OnBatchEndCall.Stub != nil {
+		return f.OnBatchEndCall.Stub(param1)
+	}
+	return f.OnBatchEndCall.Returns.Error
+}
+func (f *Callback) OnEpochBegin(param1 *neuraltools.Progress) error {
+	f.OnEpochBeginCall.Lock()
+	defer f.OnEpochBeginCall.Unlock()
+	f.OnEpochBeginCall.CallCount++
+	f.OnEpochBeginCall.Receives.Progress = param1
+	if f.OnEpochBeginCall.Stub != nil {
+		return f.OnEpochBeginCall.Stub(param1)
+	}
+	return f.OnEpochBeginCall.Returns.Error
+}
+func (f *Callback) OnEpochEnd(param1 *neuraltools.Progress) error {
+	f.OnEpochEndCall.Lock()
+	defer f.OnEpochEndCall.Unlock()
+	f.OnEpochEndCall.CallCount++
+	f.OnEpochEndCall.Receives.Progress = param1
+	if f.OnEpochEndCall.Stub != nil {
+		return f.OnEpochEndCall.Stub(param1)
+	}
+	return f.OnEpochEndCall.Returns.Error
+}
+func (f *Callback) OnTrainBegin(param1 *neuraltools.Progress) error {
+	f.OnTrainBeginCall.Lock()
+	defer f.OnTrainBeginCall.Unlock()
+	f.OnTrainBeginCall.CallCount++
+	f.OnTrainBeginCall.Receives.Progress = param1
+	if f.OnTrainBeginCall.Stub != nil {
+		return f.OnTrainBeginCall.Stub(param1)
+	}
+	return f.OnTrainBeginCall.Returns.Error
+}
+func (f *Callback) OnTrainEnd(param1 *neuraltools.Progress) error {
+	f.OnTrainEndCall.Lock()
+	defer f.OnTrainEndCall.Unlock()
+	f.OnTrainEndCall.CallCount++
+	f.OnTrainEndCall.Receives.Progress = param1
+	if f.OnTrainEndCall.Stub != nil {
+		return f.OnTrainEndCall.Stub(param1)
+	}
+	return f.OnTrainEndCall.Returns.Error
+}
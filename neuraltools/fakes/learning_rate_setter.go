@@ -0,0 +1,28 @@
+package fakes
+
+import "sync"
+
+type LearningRateSetter struct {
+	SetLearningRateCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Float64 float64
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(float64) error
+	}
+}
+
+func (f *LearningRateSetter) SetLearningRate(param1 float64) error {
+	f.SetLearningRateCall.Lock()
+	defer f.SetLearningRateCall.Unlock()
+	f.SetLearningRateCall.CallCount++
+	f.SetLearningRateCall.Receives.Float64 = param1
+	if f.SetLearningRateCall.Stub != nil {
+		return f.SetLearningRateCall.Stub(param1)
+	}
+	return f.SetLearningRateCall.Returns.Error
+}
@@ -0,0 +1,55 @@
+package fakes
+
+import (
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type LossCalculator struct {
+	CalcLossCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			VecDense *mat.VecDense
+		}
+		Returns struct {
+			Float64 float64
+			Error   error
+		}
+		Stub func(*mat.VecDense) (float64, error)
+	}
+	CalculateCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			VecDense *mat.VecDense
+		}
+		Returns struct {
+			VecDense *mat.VecDense
+			Error    error
+		}
+		Stub func(*mat.VecDense) (*mat.VecDense, error)
+	}
+}
+
+func (f *LossCalculator) CalcLoss(param1 *mat.VecDense) (float64, error) {
+	f.CalcLossCall.Lock()
+	defer f.CalcLossCall.Unlock()
+	f.CalcLossCall.CallCount++
+	f.CalcLossCall.Receives.VecDense = param1
+	if f.CalcLossCall.Stub != nil {
+		return f.CalcLossCall.Stub(param1)
+	}
+	return f.CalcLossCall.Returns.Float64, f.CalcLossCall.Returns.Error
+}
+func (f *LossCalculator) Calculate(param1 *mat.VecDense) (*mat.VecDense, error) {
+	f.CalculateCall.Lock()
+	defer f.CalculateCall.Unlock()
+	f.CalculateCall.CallCount++
+	f.CalculateCall.Receives.VecDense = param1
+	if f.CalculateCall.Stub != nil {
+		return f.CalculateCall.Stub(param1)
+	}
+	return f.CalculateCall.Returns.VecDense, f.CalculateCall.Returns.Error
+}
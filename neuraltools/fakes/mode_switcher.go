@@ -0,0 +1,24 @@
+package fakes
+
+import "sync"
+
+type ModeSwitcher struct {
+	SetTrainingCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Bool bool
+		}
+		Stub func(bool)
+	}
+}
+
+func (f *ModeSwitcher) SetTraining(param1 bool) {
+	f.SetTrainingCall.Lock()
+	defer f.SetTrainingCall.Unlock()
+	f.SetTrainingCall.CallCount++
+	f.SetTrainingCall.Receives.Bool = param1
+	if f.SetTrainingCall.Stub != nil {
+		f.SetTrainingCall.Stub(param1)
+	}
+}
@@ -3,6 +3,7 @@ package fakes
 import (
 	"sync"
 
+	"github.com/dwillist/summerschool/v2/neuralnet"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -31,16 +32,28 @@ type Network struct {
 		}
 		Stub func(*mat.VecDense) ([]*mat.VecDense, error)
 	}
-	UpdateCall struct {
+	GenerateGradientCall struct {
 		sync.Mutex
 		CallCount int
 		Receives  struct {
 			VecDenseSlice []*mat.VecDense
 		}
+		Returns struct {
+			Gradient neuralnet.Gradient
+			Error    error
+		}
+		Stub func([]*mat.VecDense) (neuralnet.Gradient, error)
+	}
+	UpdateCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Gradient neuralnet.Gradient
+		}
 		Returns struct {
 			Error error
 		}
-		Stub func([]*mat.VecDense) error
+		Stub func(neuralnet.Gradient) error
 	}
 }
 
@@ -64,11 +77,21 @@ func (f *Network) GenerateDelta(param1 *mat.VecDense) ([]*mat.VecDense, error) {
 	}
 	return f.GenerateDeltaCall.Returns.VecDenseSlice, f.GenerateDeltaCall.Returns.Error
 }
-func (f *Network) Update(param1 []*mat.VecDense) error {
+func (f *Network) GenerateGradient(param1 []*mat.VecDense) (neuralnet.Gradient, error) {
+	f.GenerateGradientCall.Lock()
+	defer f.GenerateGradientCall.Unlock()
+	f.GenerateGradientCall.CallCount++
+	f.GenerateGradientCall.Receives.VecDenseSlice = param1
+	if f.GenerateGradientCall.Stub != nil {
+		return f.GenerateGradientCall.Stub(param1)
+	}
+	return f.GenerateGradientCall.Returns.Gradient, f.GenerateGradientCall.Returns.Error
+}
+func (f *Network) Update(param1 neuralnet.Gradient) error {
 	f.UpdateCall.Lock()
 	defer f.UpdateCall.Unlock()
 	f.UpdateCall.CallCount++
-	f.UpdateCall.Receives.VecDenseSlice = param1
+	f.UpdateCall.Receives.Gradient = param1
 	if f.UpdateCall.Stub != nil {
 		return f.UpdateCall.Stub(param1)
 	}
@@ -0,0 +1,24 @@
+package fakes
+
+import "sync"
+
+type ParallelChecker struct {
+	CheckParallelCall struct {
+		sync.Mutex
+		CallCount int
+		Returns   struct {
+			Error error
+		}
+		Stub func() error
+	}
+}
+
+func (f *ParallelChecker) CheckParallel() error {
+	f.CheckParallelCall.Lock()
+	defer f.CheckParallelCall.Unlock()
+	f.CheckParallelCall.CallCount++
+	if f.CheckParallelCall.Stub != nil {
+		return f.CheckParallelCall.Stub()
+	}
+	return f.CheckParallelCall.Returns.Error
+}
@@ -0,0 +1,142 @@
+package fakes
+
+import (
+	"sync"
+
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"gonum.org/v1/gonum/mat"
+)
+
+type ParallelNetwork struct {
+	CalculateCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			VecDense *mat.VecDense
+		}
+		Returns struct {
+			VecDense *mat.VecDense
+			Error    error
+		}
+		Stub func(*mat.VecDense) (*mat.VecDense, error)
+	}
+	ComputeGradientCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Workspace *neuralnet.Workspace
+			Input     *mat.VecDense
+			Solution  *mat.VecDense
+		}
+		Returns struct {
+			Gradient neuralnet.Gradient
+			Error    error
+		}
+		Stub func(*neuralnet.Workspace, *mat.VecDense, *mat.VecDense) (neuralnet.Gradient, error)
+	}
+	GenerateDeltaCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			VecDense *mat.VecDense
+		}
+		Returns struct {
+			VecDenseSlice []*mat.VecDense
+			Error         error
+		}
+		Stub func(*mat.VecDense) ([]*mat.VecDense, error)
+	}
+	GenerateGradientCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			VecDenseSlice []*mat.VecDense
+		}
+		Returns struct {
+			Gradient neuralnet.Gradient
+			Error    error
+		}
+		Stub func([]*mat.VecDense) (neuralnet.Gradient, error)
+	}
+	NewWorkspaceCall struct {
+		sync.Mutex
+		CallCount int
+		Returns   struct {
+			Workspace *neuralnet.Workspace
+		}
+		Stub func() *neuralnet.Workspace
+	}
+	UpdateCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Gradient neuralnet.Gradient
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func(neuralnet.Gradient) error
+	}
+}
+
+func (f *ParallelNetwork) Calculate(param1 *mat.VecDense) (*mat.VecDense, error) {
+	f.CalculateCall.Lock()
+	defer f.CalculateCall.Unlock()
+	f.CalculateCall.CallCount++
+	f.CalculateCall.Receives.VecDense = param1
+	if f.CalculateCall.Stub != nil {
+		return f.CalculateCall.Stub(param1)
+	}
+	return f.CalculateCall.Returns.VecDense, f.CalculateCall.Returns.Error
+}
+func (f *ParallelNetwork) ComputeGradient(param1 *neuralnet.Workspace, param2 *mat.VecDense, param3 *mat.VecDense) (neuralnet.Gradient, error) {
+	f.ComputeGradientCall.Lock()
+	defer f.ComputeGradientCall.Unlock()
+	f.ComputeGradientCall.CallCount++
+	f.ComputeGradientCall.Receives.Workspace = param1
+	f.ComputeGradientCall.Receives.Input = param2
+	f.ComputeGradientCall.Receives.Solution = param3
+	if f.ComputeGradientCall.Stub != nil {
+		return f.ComputeGradientCall.Stub(param1, param2, param3)
+	}
+	return f.ComputeGradientCall.Returns.Gradient, f.ComputeGradientCall.Returns.Error
+}
+func (f *ParallelNetwork) GenerateDelta(param1 *mat.VecDense) ([]*mat.VecDense, error) {
+	f.GenerateDeltaCall.Lock()
+	defer f.GenerateDeltaCall.Unlock()
+	f.GenerateDeltaCall.CallCount++
+	f.GenerateDeltaCall.Receives.VecDense = param1
+	if f.GenerateDeltaCall.Stub != nil {
+		return f.GenerateDeltaCall.Stub(param1)
+	}
+	return f.GenerateDeltaCall.Returns.VecDenseSlice, f.GenerateDeltaCall.Returns.Error
+}
+func (f *ParallelNetwork) GenerateGradient(param1 []*mat.VecDense) (neuralnet.Gradient, error) {
+	f.GenerateGradientCall.Lock()
+	defer f.GenerateGradientCall.Unlock()
+	f.GenerateGradientCall.CallCount++
+	f.GenerateGradientCall.Receives.VecDenseSlice = param1
+	if f.GenerateGradientCall.Stub != nil {
+		return f.GenerateGradientCall.Stub(param1)
+	}
+	return f.GenerateGradientCall.Returns.Gradient, f.GenerateGradientCall.Returns.Error
+}
+func (f *ParallelNetwork) NewWorkspace() *neuralnet.Workspace {
+	f.NewWorkspaceCall.Lock()
+	defer f.NewWorkspaceCall.Unlock()
+	f.NewWorkspaceCall.CallCount++
+	if f.NewWorkspaceCall.Stub != nil {
+		return f.NewWorkspaceCall.Stub()
+	}
+	return f.NewWorkspaceCall.Returns.Workspace
+}
+func (f *ParallelNetwork) Update(param1 neuralnet.Gradient) error {
+	f.UpdateCall.Lock()
+	defer f.UpdateCall.Unlock()
+	f.UpdateCall.CallCount++
+	f.UpdateCall.Receives.Gradient = param1
+	if f.UpdateCall.Stub != nil {
+		return f.UpdateCall.Stub(param1)
+	}
+	return f.UpdateCall.Returns.Error
+}
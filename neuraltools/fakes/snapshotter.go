@@ -0,0 +1,45 @@
+package fakes
+
+import "sync"
+
+type Snapshotter struct {
+	RestoreCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Float64SliceSlice [][]float64
+		}
+		Returns struct {
+			Error error
+		}
+		Stub func([][]float64) error
+	}
+	SnapshotCall struct {
+		sync.Mutex
+		CallCount int
+		Returns   struct {
+			Float64SliceSlice [][]float64
+		}
+		Stub func() [][]float64
+	}
+}
+
+func (f *Snapshotter) Restore(param1 [][]float64) error {
+	f.RestoreCall.Lock()
+	defer f.RestoreCall.Unlock()
+	f.RestoreCall.CallCount++
+	f.RestoreCall.Receives.Float64SliceSlice = param1
+	if f.RestoreCall.Stub != nil {
+		return f.RestoreCall.Stub(param1)
+	}
+	return f.RestoreCall.Returns.Error
+}
+func (f *Snapshotter) Snapshot() [][]float64 {
+	f.SnapshotCall.Lock()
+	defer f.SnapshotCall.Unlock()
+	f.SnapshotCall.CallCount++
+	if f.SnapshotCall.Stub != nil {
+		return f.SnapshotCall.Stub()
+	}
+	return f.SnapshotCall.Returns.Float64SliceSlice
+}
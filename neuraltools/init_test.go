@@ -10,5 +10,8 @@ import (
 func TestUnitSummerSchool(t *testing.T) {
 	suite := spec.New("neuraltools", spec.Report(report.Terminal{}))
 	suite("Tools", testTools)
+	suite("Sequence", testSequence)
+	suite("EarlyStopping", testEarlyStopping)
+	suite("Callbacks", testCallbacks)
 	suite.Run(t)
 }
@@ -0,0 +1,14 @@
+package metrics_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitMetrics(t *testing.T) {
+	suite := spec.New("Metrics", spec.Report(report.Terminal{}))
+	suite("Metrics", testMetrics)
+	suite.Run(t)
+}
@@ -0,0 +1,546 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/dwillist/summerschool/v2/neuralnet/losses"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Metrics accumulate over the samples of a dataset, see neuraltools.Evaluate. Value is
+// NaN until a sample has been added. Classification metrics take the class of a vector
+// to be the index of its largest value, or for a single output whether it is at least .5
+type Metric interface {
+	// actual is the network output and expected the solution of one sample
+	Add(actual, expected mat.Vector)
+	Value() float64
+	Reset()
+	Name() string
+}
+
+///
+/// ConfusionMatrix Def
+///
+// counts samples by expected and predicted class, its Value is the accuracy. There are
+// at least Classes classes, more are added as they are seen.
+type ConfusionMatrix struct {
+	Classes int
+
+	// rows are expected classes, columns predicted classes
+	counts [][]float64
+	total  float64
+}
+
+func (c *ConfusionMatrix) Add(actual, expected mat.Vector) {
+	predicted, class := classOf(actual), classOf(expected)
+
+	if predicted > class {
+		c.grow(predicted + 1)
+	} else {
+		c.grow(class + 1)
+	}
+	c.counts[class][predicted]++
+	c.total++
+}
+
+func (c *ConfusionMatrix) Value() float64 {
+	return c.Accuracy()
+}
+
+func (c *ConfusionMatrix) Reset() {
+	c.counts = nil
+	c.total = 0
+}
+
+func (c *ConfusionMatrix) Name() string {
+	return "accuracy"
+}
+
+// rows are expected classes and columns predicted classes, nil without classes
+func (c *ConfusionMatrix) Counts() *mat.Dense {
+	size := len(c.counts)
+	if size < c.Classes {
+		size = c.Classes
+	}
+
+	if size == 0 {
+		return nil
+	}
+
+	result := mat.NewDense(size, size, nil)
+	for row := range c.counts {
+		for col, count := range c.counts[row] {
+			result.Set(row, col, count)
+		}
+	}
+
+	return result
+}
+
+func (c *ConfusionMatrix) Accuracy() float64 {
+	if c.total == 0 {
+		return math.NaN()
+	}
+
+	correct := float64(0)
+	for class := range c.counts {
+		correct += c.counts[class][class]
+	}
+
+	return correct / c.total
+}
+
+// share of the samples predicted as class that are of class, 0 when none are predicted
+func (c *ConfusionMatrix) Precision(class int) float64 {
+	return ratio(c.truePositives(class), c.predicted(class))
+}
+
+// share of the samples of class that are predicted as class, 0 when there are none
+func (c *ConfusionMatrix) Recall(class int) float64 {
+	return ratio(c.truePositives(class), c.expected(class))
+}
+
+// harmonic mean of Precision and Recall
+func (c *ConfusionMatrix) F1(class int) float64 {
+	precision, recall := c.Precision(class), c.Recall(class)
+
+	return ratio(2*precision*recall, precision+recall)
+}
+
+// unweighted mean over classes, as every sample belongs to exactly one class the micro
+// averages of precision, recall and F1 all equal Accuracy
+func (c *ConfusionMatrix) MacroPrecision() float64 {
+	return c.macro(c.Precision)
+}
+
+func (c *ConfusionMatrix) MacroRecall() float64 {
+	return c.macro(c.Recall)
+}
+
+func (c *ConfusionMatrix) MacroF1() float64 {
+	return c.macro(c.F1)
+}
+
+// Cohen's kappa, the agreement of expected and predicted classes beyond chance
+func (c *ConfusionMatrix) Kappa() float64 {
+	if c.total == 0 {
+		return math.NaN()
+	}
+
+	chance := float64(0)
+	for class := range c.counts {
+		chance += c.expected(class) * c.predicted(class) / (c.total * c.total)
+	}
+
+	if chance == 1 {
+		return 1
+	}
+
+	return (c.Accuracy() - chance) / (1 - chance)
+}
+
+func (c *ConfusionMatrix) grow(size int) {
+	if size < c.Classes {
+		size = c.Classes
+	}
+
+	for len(c.counts) < size {
+		c.counts = append(c.counts, nil)
+	}
+
+	for row := range c.counts {
+		for len(c.counts[row]) < len(c.counts) {
+			c.counts[row] = append(c.counts[row], 0)
+		}
+	}
+}
+
+func (c *ConfusionMatrix) macro(perClass func(int) float64) float64 {
+	if c.total == 0 {
+		return math.NaN()
+	}
+
+	result := float64(0)
+	for class := range c.counts {
+		result += perClass(class)
+	}
+
+	return result / float64(len(c.counts))
+}
+
+func (c *ConfusionMatrix) truePositives(class int) float64 {
+	if class < 0 || class >= len(c.counts) {
+		return 0
+	}
+
+	return c.counts[class][class]
+}
+
+func (c *ConfusionMatrix) expected(class int) float64 {
+	if class < 0 || class >= len(c.counts) {
+		return 0
+	}
+
+	return floats.Sum(c.counts[class])
+}
+
+func (c *ConfusionMatrix) predicted(class int) float64 {
+	result := float64(0)
+	for row := range c.counts {
+		if class >= 0 && class < len(c.counts[row]) {
+			result += c.counts[row][class]
+		}
+	}
+
+	return result
+}
+
+///
+/// Average Def
+///
+// how Precision, Recall and F1 combine the classes, their Value is NaN for any other
+// Average
+type Average int
+
+const (
+	// unweighted mean of the per class values
+	Macro Average = iota
+	// counted over every sample, which equals the accuracy as every sample has exactly
+	// one expected and one predicted class. ConfusionMatrix.Precision, Recall and F1 give
+	// the values of a single class, e.g. the positive class 1 of a binary problem.
+	Micro
+)
+
+func (a Average) String() string {
+	switch a {
+	case Macro:
+		return "macro"
+	case Micro:
+		return "micro"
+	default:
+		return fmt.Sprintf("Average(%d)", int(a))
+	}
+}
+
+// accumulates a ConfusionMatrix for the metrics derived from it
+type confusion struct {
+	matrix ConfusionMatrix
+}
+
+func (c *confusion) Add(actual, expected mat.Vector) {
+	c.matrix.Add(actual, expected)
+}
+
+func (c *confusion) Reset() {
+	c.matrix.Reset()
+}
+
+func (c *confusion) average(average Average, macro func() float64) float64 {
+	switch average {
+	case Macro:
+		return macro()
+	case Micro:
+		return c.matrix.Accuracy()
+	default:
+		return math.NaN()
+	}
+}
+
+///
+/// Precision Def
+///
+// Precision over every class combined by Average, Micro gives the accuracy
+type Precision struct {
+	Average Average
+	confusion
+}
+
+func (p *Precision) Value() float64 {
+	return p.average(p.Average, p.matrix.MacroPrecision)
+}
+
+func (p *Precision) Name() string {
+	return fmt.Sprintf("%s precision", p.Average)
+}
+
+///
+/// Recall Def
+///
+// Recall over every class combined by Average, Micro gives the accuracy
+type Recall struct {
+	Average Average
+	confusion
+}
+
+func (r *Recall) Value() float64 {
+	return r.average(r.Average, r.matrix.MacroRecall)
+}
+
+func (r *Recall) Name() string {
+	return fmt.Sprintf("%s recall", r.Average)
+}
+
+///
+/// F1 Def
+///
+// F1 over every class combined by Average, Micro gives the accuracy
+type F1 struct {
+	Average Average
+	confusion
+}
+
+func (f *F1) Value() float64 {
+	return f.average(f.Average, f.matrix.MacroF1)
+}
+
+func (f *F1) Name() string {
+	return fmt.Sprintf("%s f1", f.Average)
+}
+
+///
+/// Kappa Def
+///
+// Cohen's kappa, see ConfusionMatrix.Kappa
+type Kappa struct {
+	confusion
+}
+
+func (k *Kappa) Value() float64 {
+	return k.matrix.Kappa()
+}
+
+func (k *Kappa) Name() string {
+	return "kappa"
+}
+
+///
+/// TopK Def
+///
+// share of samples whose expected class is among the K largest outputs, K defaults to 1.
+// Requires one output per class.
+type TopK struct {
+	K int
+
+	hits  float64
+	count float64
+}
+
+func (t *TopK) Add(actual, expected mat.Vector) {
+	target := actual.AtVec(classOf(expected))
+
+	larger := 0
+	for idx := 0; idx < actual.Len(); idx++ {
+		if actual.AtVec(idx) > target {
+			larger++
+		}
+	}
+
+	if larger < t.k() {
+		t.hits++
+	}
+	t.count++
+}
+
+func (t *TopK) Value() float64 {
+	return t.hits / nanIfZero(t.count)
+}
+
+func (t *TopK) Reset() {
+	t.hits, t.count = 0, 0
+}
+
+func (t *TopK) Name() string {
+	return fmt.Sprintf("top %d accuracy", t.k())
+}
+
+func (t *TopK) k() int {
+	if t.K < 1 {
+		return 1
+	}
+
+	return t.K
+}
+
+///
+/// LogLoss Def
+///
+// mean cross entropy of the outputs as probabilities, binary for a single output
+type LogLoss struct {
+	sum   float64
+	count float64
+}
+
+func (l *LogLoss) Add(actual, expected mat.Vector) {
+	if actual.Len() == 1 {
+		l.sum += losses.BinaryCrossEntropy{}.CalcLoss(actual, expected)
+	} else {
+		l.sum += losses.CrossEntropy{}.CalcLoss(actual, expected)
+	}
+	l.count++
+}
+
+func (l *LogLoss) Value() float64 {
+	return l.sum / nanIfZero(l.count)
+}
+
+func (l *LogLoss) Reset() {
+	l.sum, l.count = 0, 0
+}
+
+func (l *LogLoss) Name() string {
+	return "log loss"
+}
+
+///
+/// MSE Def
+///
+// mean squared error over every output of every sample
+type MSE struct {
+	differences
+}
+
+func (m *MSE) Add(actual, expected mat.Vector) {
+	m.add(actual, expected, func(diff float64) float64 {
+		return diff * diff
+	})
+}
+
+func (m *MSE) Name() string {
+	return "mse"
+}
+
+///
+/// MAE Def
+///
+// mean absolute error over every output of every sample
+type MAE struct {
+	differences
+}
+
+func (m *MAE) Add(actual, expected mat.Vector) {
+	m.add(actual, expected, math.Abs)
+}
+
+func (m *MAE) Name() string {
+	return "mae"
+}
+
+// sums a function of the difference of every output
+type differences struct {
+	sum   float64
+	count float64
+}
+
+func (d *differences) add(actual, expected mat.Vector, measure func(float64) float64) {
+	for idx := 0; idx < actual.Len(); idx++ {
+		d.sum += measure(actual.AtVec(idx) - expected.AtVec(idx))
+	}
+	d.count += float64(actual.Len())
+}
+
+func (d *differences) Value() float64 {
+	return d.sum / nanIfZero(d.count)
+}
+
+func (d *differences) Reset() {
+	d.sum, d.count = 0, 0
+}
+
+///
+/// R2 Def
+///
+// coefficient of determination, 1 - residual / total sum of squares, averaged over
+// outputs. An output whose expected values are constant scores 1 when predicted exactly
+// and 0 otherwise.
+type R2 struct {
+	count float64
+	// per output running means of expected values, the squared deviations from them
+	// as in Welford's algorithm and the squared residuals
+	means      []float64
+	deviations []float64
+	residuals  []float64
+}
+
+func (r *R2) Add(actual, expected mat.Vector) {
+	for len(r.means) < expected.Len() {
+		r.means = append(r.means, 0)
+		r.deviations = append(r.deviations, 0)
+		r.residuals = append(r.residuals, 0)
+	}
+
+	r.count++
+	for idx := 0; idx < expected.Len(); idx++ {
+		y := expected.AtVec(idx)
+		diff := actual.AtVec(idx) - y
+
+		delta := y - r.means[idx]
+		r.means[idx] += delta / r.count
+		r.deviations[idx] += delta * (y - r.means[idx])
+		r.residuals[idx] += diff * diff
+	}
+}
+
+func (r *R2) Value() float64 {
+	if r.count == 0 {
+		return math.NaN()
+	}
+
+	result := float64(0)
+	for idx := range r.means {
+		switch {
+		case r.deviations[idx] > 0:
+			result += 1 - r.residuals[idx]/r.deviations[idx]
+		case r.residuals[idx] == 0:
+			result++
+		}
+	}
+
+	return result / float64(len(r.means))
+}
+
+func (r *R2) Reset() {
+	*r = R2{}
+}
+
+func (r *R2) Name() string {
+	return "r2"
+}
+
+// index of the largest value, or for a single value whether it is at least .5
+func classOf(vec mat.Vector) int {
+	if vec.Len() == 1 {
+		if vec.AtVec(0) >= .5 {
+			return 1
+		}
+
+		return 0
+	}
+
+	result := 0
+	for idx := 1; idx < vec.Len(); idx++ {
+		if vec.AtVec(idx) > vec.AtVec(result) {
+			result = idx
+		}
+	}
+
+	return result
+}
+
+func ratio(numerator, denominator float64) float64 {
+	if denominator == 0 {
+		return 0
+	}
+
+	return numerator / denominator
+}
+
+// a NaN divisor makes the Value of a metric without samples NaN
+func nanIfZero(count float64) float64 {
+	if count == 0 {
+		return math.NaN()
+	}
+
+	return count
+}
@@ -0,0 +1,231 @@
+package metrics_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuraltools/metrics"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testMetrics(t *testing.T, context spec.G, it spec.S) {
+	var Expect = NewWithT(t).Expect
+
+	vec := func(values ...float64) *mat.VecDense {
+		return mat.NewVecDense(len(values), values)
+	}
+
+	oneHot := func(class int) *mat.VecDense {
+		result := mat.NewVecDense(3, nil)
+		result.SetVec(class, 1)
+
+		return result
+	}
+
+	// expected and predicted classes, counted by row {2, 1, 0}, {0, 1, 1}, {1, 0, 2}
+	classify := func(metric metrics.Metric) {
+		for _, pair := range [][2]int{{0, 0}, {0, 0}, {0, 1}, {1, 1}, {1, 2}, {2, 2}, {2, 2}, {2, 0}} {
+			metric.Add(oneHot(pair[1]), oneHot(pair[0]))
+		}
+	}
+
+	context("ConfusionMatrix", func() {
+		var matrix *metrics.ConfusionMatrix
+
+		it.Before(func() {
+			matrix = &metrics.ConfusionMatrix{}
+		})
+
+		it("counts samples by expected and predicted class", func() {
+			classify(matrix)
+
+			Expect(mat.Equal(matrix.Counts(), mat.NewDense(3, 3, []float64{2, 1, 0, 0, 1, 1, 1, 0, 2}))).To(BeTrue())
+			Expect(matrix.Name()).To(Equal("accuracy"))
+			Expect(matrix.Value()).To(Equal(5.0 / 8))
+		})
+
+		it("computes per class and averaged scores", func() {
+			classify(matrix)
+
+			Expect(matrix.Precision(0)).To(Equal(2.0 / 3))
+			Expect(matrix.Recall(1)).To(Equal(0.5))
+			Expect(matrix.F1(2)).To(BeNumerically("~", 2.0/3, 1e-12))
+			Expect(matrix.MacroPrecision()).To(BeNumerically("~", 11.0/18, 1e-12))
+			Expect(matrix.MacroRecall()).To(BeNumerically("~", 11.0/18, 1e-12))
+			Expect(matrix.MacroF1()).To(BeNumerically("~", 11.0/18, 1e-12))
+			Expect(matrix.Kappa()).To(BeNumerically("~", 3.0/7, 1e-12))
+		})
+
+		it("scores classes that are never predicted or seen as 0", func() {
+			matrix.Classes = 4
+			classify(matrix)
+
+			rows, _ := matrix.Counts().Dims()
+			Expect(rows).To(Equal(4))
+			Expect(matrix.Precision(3)).To(BeZero())
+			Expect(matrix.Recall(7)).To(BeZero())
+			Expect(matrix.MacroRecall()).To(BeNumerically("~", 11.0/24, 1e-12))
+		})
+
+		it("does not keep the classes Counts pads with", func() {
+			classify(matrix)
+			matrix.Classes = 4
+			rows, _ := matrix.Counts().Dims()
+			Expect(rows).To(Equal(4))
+
+			matrix.Classes = 0
+			rows, _ = matrix.Counts().Dims()
+			Expect(rows).To(Equal(3))
+		})
+
+		it("thresholds a single output at .5", func() {
+			matrix.Add(vec(0.7), vec(1))
+			matrix.Add(vec(0.3), vec(1))
+			matrix.Add(vec(0.5), vec(0))
+
+			Expect(mat.Equal(matrix.Counts(), mat.NewDense(2, 2, []float64{0, 1, 1, 1}))).To(BeTrue())
+		})
+
+		it("is NaN without samples", func() {
+			classify(matrix)
+			matrix.Reset()
+
+			Expect(matrix.Counts()).To(BeNil())
+			Expect(math.IsNaN(matrix.Value())).To(BeTrue())
+			Expect(math.IsNaN(matrix.MacroF1())).To(BeTrue())
+			Expect(math.IsNaN(matrix.Kappa())).To(BeTrue())
+		})
+
+		it("has a kappa of 1 when a single class is always predicted", func() {
+			matrix.Add(oneHot(1), oneHot(1))
+
+			Expect(matrix.Kappa()).To(Equal(float64(1)))
+		})
+	})
+
+	context("Precision, Recall and F1", func() {
+		it("average over classes", func() {
+			for _, metric := range []metrics.Metric{&metrics.Precision{}, &metrics.Recall{}, &metrics.F1{}} {
+				classify(metric)
+				Expect(metric.Value()).To(BeNumerically("~", 11.0/18, 1e-12))
+			}
+		})
+
+		it("equal the accuracy when micro averaged", func() {
+			f1 := &metrics.F1{Average: metrics.Micro}
+			classify(f1)
+
+			Expect(f1.Name()).To(Equal("micro f1"))
+			Expect(f1.Value()).To(Equal(5.0 / 8))
+
+			f1.Reset()
+			Expect(math.IsNaN(f1.Value())).To(BeTrue())
+		})
+
+		it("are named by their average", func() {
+			Expect((&metrics.Precision{}).Name()).To(Equal("macro precision"))
+			Expect((&metrics.Recall{Average: metrics.Micro}).Name()).To(Equal("micro recall"))
+			Expect(metrics.Average(5).String()).To(Equal("Average(5)"))
+		})
+
+		it("are NaN with an invalid average", func() {
+			precision := &metrics.Precision{Average: 5}
+			classify(precision)
+
+			Expect(math.IsNaN(precision.Value())).To(BeTrue())
+		})
+	})
+
+	context("Kappa", func() {
+		it("is Cohen's kappa of the ConfusionMatrix", func() {
+			kappa := &metrics.Kappa{}
+			classify(kappa)
+
+			Expect(kappa.Name()).To(Equal("kappa"))
+			Expect(kappa.Value()).To(BeNumerically("~", 3.0/7, 1e-12))
+		})
+	})
+
+	context("TopK", func() {
+		it("counts expected classes among the K largest outputs", func() {
+			top1, top2 := &metrics.TopK{}, &metrics.TopK{K: 2}
+			for _, metric := range []metrics.Metric{top1, top2} {
+				metric.Add(vec(0.1, 0.5, 0.4), vec(0, 0, 1))
+				metric.Add(vec(0.1, 0.5, 0.4), vec(0, 1, 0))
+			}
+
+			Expect(top1.Name()).To(Equal("top 1 accuracy"))
+			Expect(top1.Value()).To(Equal(0.5))
+			Expect(top2.Name()).To(Equal("top 2 accuracy"))
+			Expect(top2.Value()).To(Equal(float64(1)))
+
+			top2.Reset()
+			Expect(math.IsNaN(top2.Value())).To(BeTrue())
+		})
+	})
+
+	context("LogLoss", func() {
+		it("averages the cross entropy, binary for a single output", func() {
+			logLoss := &metrics.LogLoss{}
+			logLoss.Add(vec(0.25, 0.75), vec(0, 1))
+			logLoss.Add(vec(0.8), vec(1))
+
+			Expect(logLoss.Name()).To(Equal("log loss"))
+			Expect(logLoss.Value()).To(BeNumerically("~", -(math.Log(0.75)+math.Log(0.8))/2, 1e-9))
+		})
+	})
+
+	context("MSE and MAE", func() {
+		it("average over every output", func() {
+			mse, mae := &metrics.MSE{}, &metrics.MAE{}
+			for _, metric := range []metrics.Metric{mse, mae} {
+				metric.Add(vec(1, 2), vec(0, 4))
+				metric.Add(vec(3, 3), vec(3, 3))
+			}
+
+			Expect(mse.Name()).To(Equal("mse"))
+			Expect(mse.Value()).To(Equal(1.25))
+			Expect(mae.Name()).To(Equal("mae"))
+			Expect(mae.Value()).To(Equal(0.75))
+
+			mse.Reset()
+			Expect(math.IsNaN(mse.Value())).To(BeTrue())
+		})
+	})
+
+	context("R2", func() {
+		it("averages the coefficient of determination over outputs", func() {
+			r2 := &metrics.R2{}
+			r2.Add(vec(1, 5), vec(1, 5))
+			r2.Add(vec(2, 5), vec(2, 5))
+			r2.Add(vec(4, 5), vec(3, 5))
+
+			// 1 - 1/2 for the first output, the second is constant and predicted exactly
+			Expect(r2.Name()).To(Equal("r2"))
+			Expect(r2.Value()).To(BeNumerically("~", 0.75, 1e-12))
+
+			r2.Reset()
+			Expect(math.IsNaN(r2.Value())).To(BeTrue())
+		})
+
+		it("scores a constant output predicted inexactly as 0", func() {
+			r2 := &metrics.R2{}
+			r2.Add(vec(1), vec(2))
+			r2.Add(vec(2), vec(2))
+
+			Expect(r2.Value()).To(BeZero())
+		})
+
+		it("is accurate for values far from 0", func() {
+			r2 := &metrics.R2{}
+			r2.Add(vec(1e9+1), vec(1e9+1))
+			r2.Add(vec(1e9+2), vec(1e9+2))
+			r2.Add(vec(1e9+4), vec(1e9+3))
+
+			Expect(r2.Value()).To(BeNumerically("~", 0.5, 1e-12))
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package neuraltools
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// a sequence of step vectors with its solution, see FlattenSequences
+type SequencePair struct {
+	Inputs   []*mat.VecDense
+	Solution *mat.VecDense
+}
+
+func NewSequencePair(inputs [][]*mat.VecDense, solutions []*mat.VecDense) ([]SequencePair, error) {
+	var result []SequencePair
+
+	if len(inputs) != len(solutions) {
+		return result, fmt.Errorf("input and solution of unequal cardenality %v, %v", len(inputs), len(solutions))
+	}
+
+	for idx := range inputs {
+		result = append(result, SequencePair{
+			Inputs:   inputs[idx],
+			Solution: solutions[idx],
+		})
+	}
+
+	return result, nil
+}
+
+// DataPairs with the steps of every sequence concatenated, which is the layout of a
+// {steps, features} input to recurrent layers. Every sequence must have the same number
+// of steps and every step the same size, so that the DataPairs can be batched.
+func FlattenSequences(data ...SequencePair) ([]DataPair, error) {
+	var result []DataPair
+
+	if len(data) == 0 {
+		return result, nil
+	}
+
+	steps := len(data[0].Inputs)
+	if steps == 0 {
+		return nil, fmt.Errorf("empty sequence at index 0")
+	}
+
+	features := data[0].Inputs[0].Len()
+
+	for idx, datum := range data {
+		if len(datum.Inputs) != steps {
+			return nil, fmt.Errorf("sequence at index %d has %d steps, expected %d", idx, len(datum.Inputs), steps)
+		}
+
+		input := mat.NewVecDense(steps*features, nil)
+		for step, vec := range datum.Inputs {
+			if vec.Len() != features {
+				return nil, fmt.Errorf("step %d of sequence at index %d has size %d, expected %d", step, idx, vec.Len(), features)
+			}
+
+			input.SliceVec(step*features, (step+1)*features).(*mat.VecDense).CopyVec(vec)
+		}
+
+		result = append(result, DataPair{
+			Input:    input,
+			Solution: datum.Solution,
+		})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,81 @@
+package neuraltools_test
+
+import (
+	"testing"
+
+	"github.com/dwillist/summerschool/v2/neuraltools"
+	"github.com/sclevine/spec"
+	"gonum.org/v1/gonum/mat"
+
+	. "github.com/onsi/gomega"
+)
+
+func testSequence(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		inputs    [][]*mat.VecDense
+		solutions []*mat.VecDense
+	)
+
+	it.Before(func() {
+		inputs = [][]*mat.VecDense{
+			{mat.NewVecDense(2, []float64{1, 2}), mat.NewVecDense(2, []float64{3, 4}), mat.NewVecDense(2, []float64{5, 6})},
+			{mat.NewVecDense(2, []float64{-1, -2}), mat.NewVecDense(2, []float64{-3, -4}), mat.NewVecDense(2, []float64{-5, -6})},
+		}
+
+		solutions = []*mat.VecDense{
+			mat.NewVecDense(1, []float64{1}),
+			mat.NewVecDense(1, []float64{0}),
+		}
+	})
+
+	context("NewSequencePair", func() {
+		it("pairs every sequence with its solution", func() {
+			out, err := neuraltools.NewSequencePair(inputs, solutions)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal([]neuraltools.SequencePair{
+				{Inputs: inputs[0], Solution: solutions[0]},
+				{Inputs: inputs[1], Solution: solutions[1]},
+			}))
+		})
+
+		it("fails when the counts differ", func() {
+			_, err := neuraltools.NewSequencePair(inputs[:1], solutions)
+			Expect(err).To(MatchError("input and solution of unequal cardenality 1, 2"))
+		})
+	})
+
+	context("FlattenSequences", func() {
+		it("concatenates the steps of every sequence", func() {
+			sequences, err := neuraltools.NewSequencePair(inputs, solutions)
+			Expect(err).NotTo(HaveOccurred())
+
+			out, err := neuraltools.FlattenSequences(sequences...)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(HaveLen(2))
+
+			Expect(out[0].Input.RawVector().Data).To(Equal([]float64{1, 2, 3, 4, 5, 6}))
+			Expect(out[1].Input.RawVector().Data).To(Equal([]float64{-1, -2, -3, -4, -5, -6}))
+			Expect(out[1].Solution).To(Equal(solutions[1]))
+		})
+
+		it("fails on sequences that cannot be batched", func() {
+			_, err := neuraltools.FlattenSequences(neuraltools.SequencePair{})
+			Expect(err).To(MatchError("empty sequence at index 0"))
+
+			_, err = neuraltools.FlattenSequences(
+				neuraltools.SequencePair{Inputs: inputs[0]},
+				neuraltools.SequencePair{Inputs: inputs[1][:2]},
+			)
+			Expect(err).To(MatchError("sequence at index 1 has 2 steps, expected 3"))
+
+			inputs[1][2] = mat.NewVecDense(3, nil)
+			_, err = neuraltools.FlattenSequences(
+				neuraltools.SequencePair{Inputs: inputs[0]},
+				neuraltools.SequencePair{Inputs: inputs[1]},
+			)
+			Expect(err).To(MatchError("step 2 of sequence at index 1 has size 3, expected 2"))
+		})
+	})
+}
@@ -2,7 +2,13 @@ package neuraltools
 
 import (
 	"fmt"
+	"math"
+	"math/rand"
+	"sync"
 
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/schedules"
+	"github.com/dwillist/summerschool/v2/neuraltools/metrics"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -35,7 +41,8 @@ func NewDataPair(inputs, solutions []*mat.VecDense) ([]DataPair, error) {
 type Network interface {
 	Calculator
 	GenerateDelta(*mat.VecDense) ([]*mat.VecDense, error)
-	Update([]*mat.VecDense) error
+	GenerateGradient([]*mat.VecDense) (neuralnet.Gradient, error)
+	Update(neuralnet.Gradient) error
 }
 
 //go:generate faux --interface Calculator --output fakes/calculator.go
@@ -43,46 +50,444 @@ type Calculator interface {
 	Calculate(*mat.VecDense) (*mat.VecDense, error)
 }
 
-// mutates the network
+// Calculators that also implement BatchCalculator are evaluated a batch at a time,
+// every column of the input matrix is one sample
+//go:generate faux --interface BatchCalculator --output fakes/batch_calculator.go
+type BatchCalculator interface {
+	Calculator
+	CalculateBatch(*mat.Dense) (*mat.Dense, error)
+}
+
+// Networks that also implement BatchNetwork are trained a batch at a time,
+// GenerateGradientBatch returns gradients summed over the batch
+//go:generate faux --interface BatchNetwork --output fakes/batch_network.go
+type BatchNetwork interface {
+	Network
+	CalculateBatch(*mat.Dense) (*mat.Dense, error)
+	GenerateDeltaBatch(*mat.Dense) ([]*mat.Dense, error)
+	GenerateGradientBatch([]*mat.Dense) (neuralnet.Gradient, error)
+}
+
+// Networks that also implement ParallelNetwork can be trained by several goroutines at once,
+// ComputeGradient must only read the network so that workers can share it
+//go:generate faux --interface ParallelNetwork --output fakes/parallel_network.go
+type ParallelNetwork interface {
+	Network
+	NewWorkspace() *neuralnet.Workspace
+	ComputeGradient(workspace *neuralnet.Workspace, input, solution *mat.VecDense) (neuralnet.Gradient, error)
+}
+
+// ParallelNetworks that also implement ParallelChecker are checked before parallel training
+// starts, CheckParallel fails when ComputeGradient cannot train the network
+//go:generate faux --interface ParallelChecker --output fakes/parallel_checker.go
+type ParallelChecker interface {
+	CheckParallel() error
+}
+
+// Networks that also implement ModeSwitcher are switched to training mode for the
+// duration of Train and to inference mode by Test, e.g. to toggle dropout
+//go:generate faux --interface ModeSwitcher --output fakes/mode_switcher.go
+type ModeSwitcher interface {
+	SetTraining(bool)
+}
+
+// Networks that also implement LossCalculator can be monitored on their validation loss,
+// CalcLoss returns the loss of the most recent Calculate call
+//go:generate faux --interface LossCalculator --output fakes/loss_calculator.go
+type LossCalculator interface {
+	Calculator
+	CalcLoss(*mat.VecDense) (float64, error)
+}
+
+// Networks that also implement Snapshotter can be returned to earlier values, e.g. the
+// best weights seen by Fit
+//go:generate faux --interface Snapshotter --output fakes/snapshotter.go
+type Snapshotter interface {
+	Snapshot() [][]float64
+	Restore([][]float64) error
+}
+
+// BatchNetworks that also implement BatchLossCalculator report their training loss to
+// Callbacks, CalcLossBatch returns the summed loss of the most recent CalculateBatch call
+//go:generate faux --interface BatchLossCalculator --output fakes/batch_loss_calculator.go
+type BatchLossCalculator interface {
+	CalcLossBatch(*mat.Dense) (float64, error)
+}
+
+// Networks that also implement LearningRateSetter can be trained with a Trainer Schedule
+//go:generate faux --interface LearningRateSetter --output fakes/learning_rate_setter.go
+type LearningRateSetter interface {
+	SetLearningRate(float64) error
+}
+
+// batch size used by Test and Evaluate when the network is a BatchCalculator
+const testBatchSize = 256
+
+// mutates the network, gradients are averaged over each batch of batchSize DataPairs
 func Train(network Network, batchSize int, data ...DataPair) error {
-	if batchSize != 1 {
-		return fmt.Errorf("unimplemented batch size != 1, %v received", batchSize)
+	return train(network, batchSize, data, &trainingRun{}, sumBatch(network, false))
+}
+
+// Train with every batch split into contiguous shards, one per worker goroutine. Worker
+// gradients are reduced in shard order so a fixed worker count gives reproducible results.
+func TrainParallel(network ParallelNetwork, workers, batchSize int, data ...DataPair) error {
+	sumGradient, err := sumParallel(network, workers)
+	if err != nil {
+		return err
 	}
 
-	for idx, datum := range data {
+	return train(network, batchSize, data, &trainingRun{}, sumGradient)
+}
+
+// returns the summed gradient and loss of the batch starting at index start, the loss
+// is NaN when it is not measured
+type sumFunc func(start int, batch []DataPair) (neuralnet.Gradient, float64, error)
+
+// sums with the batch methods of BatchNetworks, otherwise one DataPair at a time. The loss
+// is only measured when withLoss is set.
+func sumBatch(network Network, withLoss bool) sumFunc {
+	return func(start int, batch []DataPair) (neuralnet.Gradient, float64, error) {
+		if batchNetwork, ok := network.(BatchNetwork); ok {
+			return trainBatch(batchNetwork, start, batch, withLoss)
+		}
+
+		return trainSamples(network, start, batch, withLoss)
+	}
+}
+
+// the loss is never measured, ComputeGradient does not keep the network output
+func sumParallel(network ParallelNetwork, workers int) (sumFunc, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid worker count: %v", workers)
+	}
+
+	if err := checkParallel(network); err != nil {
+		return nil, err
+	}
+
+	workspaces := make([]*neuralnet.Workspace, workers)
+	for idx := range workspaces {
+		workspaces[idx] = network.NewWorkspace()
+	}
+
+	return func(start int, batch []DataPair) (neuralnet.Gradient, float64, error) {
+		gradient, err := trainParallel(network, workspaces, start, batch)
+		return gradient, math.NaN(), err
+	}, nil
+}
+
+func checkParallel(network ParallelNetwork) error {
+	if checker, ok := network.(ParallelChecker); ok {
+		if err := checker.CheckParallel(); err != nil {
+			return fmt.Errorf("network cannot be trained in parallel: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// applies the averaged gradient of every batch, run is notified around every batch
+// and stops training once a Callback requests it
+func train(network Network, batchSize int, data []DataPair, run *trainingRun, sumGradient sumFunc) error {
+	if batchSize < 1 {
+		return fmt.Errorf("invalid batch size: %v", batchSize)
+	}
+
+	if switcher, ok := network.(ModeSwitcher); ok {
+		switcher.SetTraining(true)
+		defer switcher.SetTraining(false)
+	}
+
+	for start := 0; start < len(data) && !run.progress.Stop; start += batchSize {
+		end := start + batchSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if err := run.batchBegin(start); err != nil {
+			return err
+		}
+
+		batchGradient, loss, err := sumGradient(start, data[start:end])
+		if err != nil {
+			return err
+		}
+
+		batchGradient.Scale(1 / float64(end-start))
+
+		if err := run.schedule(start); err != nil {
+			return err
+		}
+
+		err = network.Update(batchGradient)
+		if err != nil {
+			return fmt.Errorf("network update failed on batch at index: %v", start)
+		}
+
+		if err := run.batchEnd(loss, end-start); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sums gradients one DataPair at a time, start is the index of the first DataPair in batch
+func trainSamples(network Network, start int, batch []DataPair, withLoss bool) (neuralnet.Gradient, float64, error) {
+	var result neuralnet.Gradient
+
+	lossCalculator, measureLoss := network.(LossCalculator)
+	measureLoss = measureLoss && withLoss
+
+	loss := float64(0)
+	if !measureLoss {
+		loss = math.NaN()
+	}
+
+	for offset, datum := range batch {
+		idx := start + offset
+
 		_, err := network.Calculate(datum.Input)
 		if err != nil {
-			return fmt.Errorf("network calculation failed on input at index: %v", idx)
+			return neuralnet.Gradient{}, 0, fmt.Errorf("network calculation failed on input at index: %v", idx)
+		}
+
+		if measureLoss {
+			sampleLoss, err := lossCalculator.CalcLoss(datum.Solution)
+			if err != nil {
+				return neuralnet.Gradient{}, 0, fmt.Errorf("network loss calculation failed on solution at index: %v", idx)
+			}
+
+			loss += sampleLoss
 		}
 
 		delta, err := network.GenerateDelta(datum.Solution)
 		if err != nil {
-			return fmt.Errorf("network delta generation failed on solution at index: %v", idx)
+			return neuralnet.Gradient{}, 0, fmt.Errorf("network delta generation failed on solution at index: %v", idx)
 		}
 
-		err = network.Update(delta)
+		gradient, err := network.GenerateGradient(delta)
 		if err != nil {
-			return fmt.Errorf("network update failed on delta at index: %v", idx)
+			return neuralnet.Gradient{}, 0, fmt.Errorf("network gradient generation failed on delta at index: %v", idx)
 		}
+
+		result.Add(gradient)
 	}
 
-	return nil
+	return result, loss, nil
+}
+
+func trainBatch(network BatchNetwork, start int, batch []DataPair, withLoss bool) (neuralnet.Gradient, float64, error) {
+	inputs, solutions := stackBatch(batch)
+
+	_, err := network.CalculateBatch(inputs)
+	if err != nil {
+		return neuralnet.Gradient{}, 0, fmt.Errorf("network calculation failed on batch at index: %v", start)
+	}
+
+	loss := math.NaN()
+	if lossCalculator, ok := network.(BatchLossCalculator); ok && withLoss {
+		loss, err = lossCalculator.CalcLossBatch(solutions)
+		if err != nil {
+			return neuralnet.Gradient{}, 0, fmt.Errorf("network loss calculation failed on batch at index: %v", start)
+		}
+	}
+
+	delta, err := network.GenerateDeltaBatch(solutions)
+	if err != nil {
+		return neuralnet.Gradient{}, 0, fmt.Errorf("network delta generation failed on batch at index: %v", start)
+	}
+
+	gradient, err := network.GenerateGradientBatch(delta)
+	if err != nil {
+		return neuralnet.Gradient{}, 0, fmt.Errorf("network gradient generation failed on batch at index: %v", start)
+	}
+
+	return gradient, loss, nil
+}
+
+func trainParallel(network ParallelNetwork, workspaces []*neuralnet.Workspace, start int, batch []DataPair) (neuralnet.Gradient, error) {
+	shardSize := (len(batch) + len(workspaces) - 1) / len(workspaces)
+
+	gradients := make([]neuralnet.Gradient, len(workspaces))
+	errs := make([]error, len(workspaces))
+	wg := sync.WaitGroup{}
+
+	for worker, workspace := range workspaces {
+		shardStart := worker * shardSize
+		if shardStart >= len(batch) {
+			break
+		}
+
+		shardEnd := shardStart + shardSize
+		if shardEnd > len(batch) {
+			shardEnd = len(batch)
+		}
+
+		wg.Add(1)
+		go func(worker int, workspace *neuralnet.Workspace, shard []DataPair, shardStart int) {
+			defer wg.Done()
+
+			for offset, datum := range shard {
+				gradient, err := network.ComputeGradient(workspace, datum.Input, datum.Solution)
+				if err != nil {
+					errs[worker] = fmt.Errorf("network gradient computation failed on input at index: %v", start+shardStart+offset)
+					return
+				}
+
+				gradients[worker].Add(gradient)
+			}
+		}(worker, workspace, batch[shardStart:shardEnd], shardStart)
+	}
+
+	wg.Wait()
+
+	var result neuralnet.Gradient
+	for worker, gradient := range gradients {
+		if errs[worker] != nil {
+			return neuralnet.Gradient{}, errs[worker]
+		}
+
+		result.Add(gradient)
+	}
+
+	return result, nil
+}
+
+// inputs and solutions of the batch as matrix columns
+func stackBatch(batch []DataPair) (inputs, solutions *mat.Dense) {
+	inputs = mat.NewDense(batch[0].Input.Len(), len(batch), nil)
+	solutions = mat.NewDense(batch[0].Solution.Len(), len(batch), nil)
+
+	for idx, datum := range batch {
+		inputs.SetCol(idx, mat.VecDenseCopyOf(datum.Input).RawVector().Data)
+		solutions.SetCol(idx, mat.VecDenseCopyOf(datum.Solution).RawVector().Data)
+	}
+
+	return inputs, solutions
 }
 
 func Test(network Calculator, judge func(*mat.VecDense, *mat.VecDense) bool, data ...DataPair) (correct int, err error) {
 	result := 0
 
+	err = calculateAll(network, data, func(idx int, actual *mat.VecDense) {
+		if judge(actual, data[idx].Solution) {
+			result++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return result, nil
+}
+
+// runs every metric over data in a single pass, like Test the network is switched to
+// inference mode. Metrics are Reset first, their values are returned by Name.
+func Evaluate(network Calculator, tracked []metrics.Metric, data ...DataPair) (map[string]float64, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no data to evaluate")
+	}
+
+	result := map[string]float64{}
+
+	for _, metric := range tracked {
+		if _, ok := result[metric.Name()]; ok {
+			return nil, fmt.Errorf("duplicate metric name: %s", metric.Name())
+		}
+
+		result[metric.Name()] = 0
+		metric.Reset()
+	}
+
+	err := calculateAll(network, data, func(idx int, actual *mat.VecDense) {
+		for _, metric := range tracked {
+			metric.Add(actual, data[idx].Solution)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, metric := range tracked {
+		result[metric.Name()] = metric.Value()
+	}
+
+	return result, nil
+}
+
+// calls visit with the output for every DataPair in order, in inference mode and with
+// the batch methods of BatchCalculators
+func calculateAll(network Calculator, data []DataPair, visit func(idx int, actual *mat.VecDense)) error {
+	if switcher, ok := network.(ModeSwitcher); ok {
+		switcher.SetTraining(false)
+	}
+
+	if batchCalculator, ok := network.(BatchCalculator); ok {
+		return calculateBatch(batchCalculator, data, visit)
+	}
+
 	for idx, datum := range data {
 		actual, err := network.Calculate(datum.Input)
+		if err != nil {
+			return fmt.Errorf("error on input %d calculation: %s", idx, err)
+		}
+
+		visit(idx, actual)
+	}
+
+	return nil
+}
 
+func calculateBatch(network BatchCalculator, data []DataPair, visit func(idx int, actual *mat.VecDense)) error {
+	for start := 0; start < len(data); start += testBatchSize {
+		end := start + testBatchSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		inputs, _ := stackBatch(data[start:end])
+
+		actual, err := network.CalculateBatch(inputs)
+		if err != nil {
+			return fmt.Errorf("error on batch %d calculation: %s", start, err)
+		}
+
+		for idx := start; idx < end; idx++ {
+			visit(idx, mat.VecDenseCopyOf(actual.ColView(idx-start)))
+		}
+	}
+
+	return nil
+}
+
+// mean loss over data, like Test the network is switched to inference mode
+func Loss(network LossCalculator, data ...DataPair) (float64, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("no data to compute the loss of")
+	}
+
+	if switcher, ok := network.(ModeSwitcher); ok {
+		switcher.SetTraining(false)
+	}
+
+	result := float64(0)
+
+	for idx, datum := range data {
+		_, err := network.Calculate(datum.Input)
 		if err != nil {
 			return 0, fmt.Errorf("error on input %d calculation: %s", idx, err)
-		} else if judge(actual, datum.Solution) {
-			result++
 		}
+
+		loss, err := network.CalcLoss(datum.Solution)
+		if err != nil {
+			return 0, fmt.Errorf("error on solution %d loss: %s", idx, err)
+		}
+
+		result += loss
 	}
 
-	return result, nil
+	return result / float64(len(data)), nil
 }
 
 // assumes len(actual) == len(expected)
@@ -117,22 +522,173 @@ func MaxJudge(actual, expected *mat.VecDense) bool {
 	return actualMaxIndex == expectedMaxIndex
 }
 
-func TestAndTrain(network Network, epochCount, batchSize int, judge func(*mat.VecDense, *mat.VecDense) bool, data ...DataPair) (correctList []int, err error) {
+// Trainer runs EpochCount epochs of Train. When Source is set the training data is
+// shuffled before every epoch using only Source, so a fixed seed reproduces a run.
+// Workers above 1 train with TrainParallel, the network must then be a ParallelNetwork.
+// When Schedule is set the learning rate of every update is taken from it, the network
+// must then be a LearningRateSetter. Callbacks are notified in order as the run progresses.
+type Trainer struct {
+	EpochCount int
+	BatchSize  int
+	Workers    int
+	Source     rand.Source
+	Schedule   schedules.Schedule
+	Callbacks  []Callback
+}
+
+func (t Trainer) Train(network Network, data ...DataPair) error {
+	_, err := t.TestAndTrain(network, nil, data...)
+	return err
+}
+
+// tests before every epoch, a nil judge skips testing
+func (t Trainer) TestAndTrain(network Network, judge func(*mat.VecDense, *mat.VecDense) bool, data ...DataPair) (correctList []int, err error) {
 	var result []int
 
-	for epoch := 0; epoch < epochCount; epoch++ {
-		correct, err := Test(network, judge, data...)
-		if err != nil {
-			return result, err
+	run, err := t.start(network, data)
+	if err != nil {
+		return result, err
+	}
+
+	for epoch := 0; epoch < t.EpochCount && !run.progress.Stop; epoch++ {
+		if judge != nil {
+			correct, err := Test(network, judge, data...)
+			if err != nil {
+				return result, err
+			}
+
+			result = append(result, correct)
+			run.progress.Metrics = map[string]float64{"correct": float64(correct)}
 		}
 
-		result = append(result, correct)
+		if err := run.trainEpoch(); err != nil {
+			return result, err
+		}
 
-		err = Train(network, batchSize, data...)
-		if err != nil {
+		if err := run.epochEnd(); err != nil {
 			return result, err
 		}
 	}
 
+	return result, run.end()
+}
+
+// state of a Trainer over the epochs of a single run
+type trainingRun struct {
+	Trainer
+	network Network
+	rng     *rand.Rand
+	// shuffled in place, a copy of the training data
+	data   []DataPair
+	setter LearningRateSetter
+	// sums with the worker workspaces shared by every epoch, nil for a single worker
+	parallel sumFunc
+	// counted from 0, step counts the updates of every epoch
+	epoch int
+	step  int
+	// summed loss and sample count of the current epoch
+	epochLoss  float64
+	epochCount int
+	progress   Progress
+}
+
+// calls OnTrainBegin once the run is set up
+func (t Trainer) start(network Network, data []DataPair) (*trainingRun, error) {
+	result := &trainingRun{
+		Trainer: t,
+		network: network,
+		data:    make([]DataPair, len(data)),
+		progress: Progress{
+			Network: network,
+			Loss:    math.NaN(),
+		},
+	}
+
+	copy(result.data, data)
+
+	if t.Source != nil {
+		result.rng = rand.New(t.Source)
+	}
+
+	if t.Workers > 1 {
+		parallelNetwork, ok := network.(ParallelNetwork)
+		if !ok {
+			return nil, fmt.Errorf("training with %d workers requires a ParallelNetwork", t.Workers)
+		}
+
+		var err error
+		if result.parallel, err = sumParallel(parallelNetwork, t.Workers); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.Schedule != nil {
+		setter, ok := network.(LearningRateSetter)
+		if !ok {
+			return nil, fmt.Errorf("learning rate schedules require a LearningRateSetter network")
+		}
+
+		result.setter = setter
+	}
+
+	if err := result.notify(Callback.OnTrainBegin); err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
+
+// shuffles the data when Source is set, then trains on it once. OnEpochEnd is left to
+// the caller so that it can add metrics first.
+func (r *trainingRun) trainEpoch() error {
+	r.progress.Epoch = r.epoch
+	r.epochLoss, r.epochCount = 0, 0
+
+	if err := r.notify(Callback.OnEpochBegin); err != nil {
+		return err
+	}
+
+	if r.rng != nil {
+		r.rng.Shuffle(len(r.data), func(i, j int) {
+			r.data[i], r.data[j] = r.data[j], r.data[i]
+		})
+	}
+
+	sumGradient := sumBatch(r.network, len(r.Callbacks) > 0)
+	if r.parallel != nil {
+		sumGradient = r.parallel
+	}
+
+	err := train(r.network, r.BatchSize, r.data, r, sumGradient)
+	r.progress.Loss = r.epochLoss / float64(r.epochCount)
+	r.epoch++
+
+	return err
+}
+
+// sets the learning rate of the next update from Schedule
+func (r *trainingRun) schedule(start int) error {
+	if r.setter == nil {
+		return nil
+	}
+
+	if err := r.setter.SetLearningRate(r.Schedule.LearningRate(r.step, r.epoch)); err != nil {
+		return fmt.Errorf("failed to set learning rate on batch at index: %v: %s", start, err)
+	}
+
+	return nil
+}
+
+// passes the validation metric of the last epoch on to a MetricSchedule
+func (r *trainingRun) observe(value float64) {
+	if metricSchedule, ok := r.Schedule.(schedules.MetricSchedule); ok {
+		metricSchedule.Observe(value)
+	}
+}
+
+func TestAndTrain(network Network, epochCount, batchSize int, judge func(*mat.VecDense, *mat.VecDense) bool, data ...DataPair) (correctList []int, err error) {
+	return Trainer{
+		EpochCount: epochCount,
+		BatchSize:  batchSize,
+	}.TestAndTrain(network, judge, data...)
+}
@@ -3,11 +3,18 @@ package neuraltools_test
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/sclevine/spec"
+	"github.com/dwillist/summerschool/v2/neuralnet"
+	"github.com/dwillist/summerschool/v2/neuralnet/layers"
+	"github.com/dwillist/summerschool/v2/neuralnet/nodefuncs"
+	"github.com/dwillist/summerschool/v2/neuralnet/optimizers"
+	"github.com/dwillist/summerschool/v2/neuralnet/schedules"
 	"github.com/dwillist/summerschool/v2/neuraltools"
 	"github.com/dwillist/summerschool/v2/neuraltools/fakes"
+	"github.com/dwillist/summerschool/v2/neuraltools/metrics"
 	"gonum.org/v1/gonum/mat"
 
 	. "github.com/onsi/gomega"
@@ -92,7 +99,65 @@ func testTools(t *testing.T, context spec.G, it spec.S) {
 			Expect(neuraltools.Train(network, 1, trainingData...)).To(Succeed())
 		})
 
+		context("when batch size is greater than 1", func() {
+			it.Before(func() {
+				trainingData = []neuraltools.DataPair{
+					{
+						Input:    mat.NewVecDense(1, []float64{1}),
+						Solution: mat.NewVecDense(1, nil),
+					},
+					{
+						Input:    mat.NewVecDense(1, []float64{3}),
+						Solution: mat.NewVecDense(1, nil),
+					},
+					{
+						Input:    mat.NewVecDense(1, []float64{5}),
+						Solution: mat.NewVecDense(1, nil),
+					},
+				}
+
+				var lastInput *mat.VecDense
+				network.CalculateCall.Stub = func(input *mat.VecDense) (*mat.VecDense, error) {
+					lastInput = input
+					return input, nil
+				}
+
+				network.GenerateGradientCall.Stub = func([]*mat.VecDense) (neuralnet.Gradient, error) {
+					return neuralnet.Gradient{
+						Layers: [][]*mat.Dense{{mat.NewDense(1, 1, []float64{lastInput.AtVec(0)}), mat.NewDense(1, 1, []float64{1})}},
+					}, nil
+				}
+			})
+
+			it("applies one averaged update per batch", func() {
+				var updates []neuralnet.Gradient
+				network.UpdateCall.Stub = func(gradient neuralnet.Gradient) error {
+					updates = append(updates, gradient)
+					return nil
+				}
+
+				Expect(neuraltools.Train(network, 2, trainingData...)).To(Succeed())
+
+				Expect(network.CalculateCall.CallCount).To(Equal(3))
+				Expect(network.GenerateDeltaCall.CallCount).To(Equal(3))
+				Expect(network.UpdateCall.CallCount).To(Equal(2))
+
+				Expect(updates[0].Layers[0][0].At(0, 0)).To(Equal(float64(2)))
+				Expect(updates[0].Layers[0][1].At(0, 0)).To(Equal(float64(1)))
+
+				Expect(updates[1].Layers[0][0].At(0, 0)).To(Equal(float64(5)))
+				Expect(updates[1].Layers[0][1].At(0, 0)).To(Equal(float64(1)))
+			})
+		})
+
 		context("falure cases", func() {
+			context("batch size is less than 1", func() {
+				it("returns an error", func() {
+					err := neuraltools.Train(network, 0, trainingData...)
+					Expect(err).To(MatchError("invalid batch size: 0"))
+				})
+			})
+
 			context("network Calculate fails", func() {
 				it("returns an error", func() {
 					network.CalculateCall.Returns.Error = errors.New("error")
@@ -112,17 +177,287 @@ func testTools(t *testing.T, context spec.G, it spec.S) {
 				})
 			})
 
+			context("network GenerateGradient fails", func() {
+				it("returns an error", func() {
+					network.GenerateGradientCall.Returns.Error = errors.New("error")
+
+					err := neuraltools.Train(network, 1, trainingData...)
+					Expect(err).To(MatchError("network gradient generation failed on delta at index: 0"))
+				})
+			})
+
 			context("network Update fails", func() {
 				it("returns an error", func() {
 					network.UpdateCall.Returns.Error = errors.New("error")
 
 					err := neuraltools.Train(network, 1, trainingData...)
-					Expect(err).To(MatchError("network update failed on delta at index: 0"))
+					Expect(err).To(MatchError("network update failed on batch at index: 0"))
 				})
 			})
 		})
 	})
 
+	context("Train with a BatchNetwork", func() {
+		var (
+			trainingData []neuraltools.DataPair
+			network      *fakes.BatchNetwork
+		)
+
+		it.Before(func() {
+			trainingData = []neuraltools.DataPair{
+				{
+					Input:    mat.NewVecDense(2, []float64{1, 2}),
+					Solution: mat.NewVecDense(1, []float64{3}),
+				},
+				{
+					Input:    mat.NewVecDense(2, []float64{4, 5}),
+					Solution: mat.NewVecDense(1, []float64{6}),
+				},
+				{
+					Input:    mat.NewVecDense(2, []float64{7, 8}),
+					Solution: mat.NewVecDense(1, []float64{9}),
+				},
+			}
+
+			network = &fakes.BatchNetwork{}
+			network.GenerateGradientBatchCall.Stub = func([]*mat.Dense) (neuralnet.Gradient, error) {
+				return neuralnet.Gradient{
+					Layers: [][]*mat.Dense{{mat.NewDense(1, 1, []float64{4}), mat.NewDense(1, 1, []float64{2})}},
+				}, nil
+			}
+		})
+
+		it("passes each batch through the network as matrix columns", func() {
+			var (
+				inputs    []*mat.Dense
+				solutions []*mat.Dense
+				updates   []neuralnet.Gradient
+			)
+			network.CalculateBatchCall.Stub = func(input *mat.Dense) (*mat.Dense, error) {
+				inputs = append(inputs, input)
+				return input, nil
+			}
+			network.GenerateDeltaBatchCall.Stub = func(solution *mat.Dense) ([]*mat.Dense, error) {
+				solutions = append(solutions, solution)
+				return nil, nil
+			}
+			network.UpdateCall.Stub = func(gradient neuralnet.Gradient) error {
+				updates = append(updates, gradient)
+				return nil
+			}
+
+			Expect(neuraltools.Train(network, 2, trainingData...)).To(Succeed())
+
+			Expect(network.CalculateCall.CallCount).To(Equal(0))
+			Expect(network.GenerateDeltaCall.CallCount).To(Equal(0))
+			Expect(network.GenerateGradientCall.CallCount).To(Equal(0))
+
+			Expect(inputs).To(Equal([]*mat.Dense{
+				mat.NewDense(2, 2, []float64{1, 4, 2, 5}),
+				mat.NewDense(2, 1, []float64{7, 8}),
+			}))
+			Expect(solutions).To(Equal([]*mat.Dense{
+				mat.NewDense(1, 2, []float64{3, 6}),
+				mat.NewDense(1, 1, []float64{9}),
+			}))
+
+			Expect(updates).To(HaveLen(2))
+			Expect(updates[0].Layers[0][0].At(0, 0)).To(Equal(float64(2)))
+			Expect(updates[0].Layers[0][1].At(0, 0)).To(Equal(float64(1)))
+			Expect(updates[1].Layers[0][0].At(0, 0)).To(Equal(float64(4)))
+		})
+
+		context("failure cases", func() {
+			it("when CalculateBatch fails", func() {
+				network.CalculateBatchCall.Returns.Error = errors.New("error")
+
+				err := neuraltools.Train(network, 2, trainingData...)
+				Expect(err).To(MatchError("network calculation failed on batch at index: 0"))
+			})
+
+			it("when GenerateDeltaBatch fails", func() {
+				network.GenerateDeltaBatchCall.Returns.Error = errors.New("error")
+
+				err := neuraltools.Train(network, 2, trainingData...)
+				Expect(err).To(MatchError("network delta generation failed on batch at index: 0"))
+			})
+
+			it("when GenerateGradientBatch fails", func() {
+				network.GenerateGradientBatchCall.Stub = nil
+				network.GenerateGradientBatchCall.Returns.Error = errors.New("error")
+
+				err := neuraltools.Train(network, 2, trainingData...)
+				Expect(err).To(MatchError("network gradient generation failed on batch at index: 0"))
+			})
+		})
+	})
+
+	context("TrainParallel", func() {
+		var (
+			trainingData []neuraltools.DataPair
+			network      *fakes.ParallelNetwork
+			workspaces   []*neuralnet.Workspace
+		)
+
+		it.Before(func() {
+			trainingData = nil
+			for idx := 0; idx < 5; idx++ {
+				trainingData = append(trainingData, neuraltools.DataPair{
+					Input:    mat.NewVecDense(1, []float64{float64(idx)}),
+					Solution: mat.NewVecDense(1, nil),
+				})
+			}
+
+			workspaces = nil
+			network = &fakes.ParallelNetwork{}
+			network.NewWorkspaceCall.Stub = func() *neuralnet.Workspace {
+				workspace := &neuralnet.Workspace{}
+				workspaces = append(workspaces, workspace)
+				return workspace
+			}
+			network.ComputeGradientCall.Stub = func(_ *neuralnet.Workspace, input, _ *mat.VecDense) (neuralnet.Gradient, error) {
+				return neuralnet.Gradient{
+					Layers: [][]*mat.Dense{{mat.NewDense(1, 1, []float64{input.AtVec(0)}), mat.NewDense(1, 1, []float64{1})}},
+				}, nil
+			}
+		})
+
+		it("shards each batch across workers and applies one averaged update", func() {
+			seen := map[*neuralnet.Workspace][]float64{}
+			computeGradient := network.ComputeGradientCall.Stub
+			network.ComputeGradientCall.Stub = func(workspace *neuralnet.Workspace, input, solution *mat.VecDense) (neuralnet.Gradient, error) {
+				seen[workspace] = append(seen[workspace], input.AtVec(0))
+				return computeGradient(workspace, input, solution)
+			}
+
+			var updates []neuralnet.Gradient
+			network.UpdateCall.Stub = func(gradient neuralnet.Gradient) error {
+				updates = append(updates, gradient)
+				return nil
+			}
+
+			Expect(neuraltools.TrainParallel(network, 2, 4, trainingData...)).To(Succeed())
+
+			Expect(network.NewWorkspaceCall.CallCount).To(Equal(2))
+			Expect(network.CalculateCall.CallCount).To(Equal(0))
+			Expect(network.GenerateDeltaCall.CallCount).To(Equal(0))
+
+			Expect(seen[workspaces[0]]).To(Equal([]float64{0, 1, 4}))
+			Expect(seen[workspaces[1]]).To(Equal([]float64{2, 3}))
+
+			Expect(updates).To(HaveLen(2))
+			Expect(updates[0].Layers[0][0].At(0, 0)).To(Equal(1.5))
+			Expect(updates[0].Layers[0][1].At(0, 0)).To(Equal(float64(1)))
+			Expect(updates[1].Layers[0][0].At(0, 0)).To(Equal(float64(4)))
+		})
+
+		context("when training a real network", func() {
+			var data []neuraltools.DataPair
+
+			it.Before(func() {
+				rng := rand.New(rand.NewSource(4))
+				data = nil
+				for idx := 0; idx < 20; idx++ {
+					x := rng.Float64()
+					data = append(data, neuraltools.DataPair{
+						Input:    mat.NewVecDense(2, []float64{x, 1 - x}),
+						Solution: mat.NewVecDense(1, []float64{x * x}),
+					})
+				}
+			})
+
+			train := func(workers int) neuralnet.Network {
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{
+							Size: 2,
+						},
+						{
+							Size: 4,
+							Func: nodefuncs.Sigmoid{},
+						},
+						{
+							Size: 1,
+							Func: nodefuncs.Sigmoid{},
+						},
+					},
+					Source: rand.NewSource(9),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				if workers == 0 {
+					Expect(neuraltools.Train(&network, 8, data...)).To(Succeed())
+				} else {
+					Expect(neuraltools.TrainParallel(&network, workers, 8, data...)).To(Succeed())
+				}
+
+				return network
+			}
+
+			it("matches sequential training", func() {
+				expected := train(0)
+				actual := train(3)
+
+				for idx := range expected.Weights {
+					Expect(mat.EqualApprox(actual.Weights[idx], expected.Weights[idx], 1e-12)).To(BeTrue())
+					Expect(mat.EqualApprox(actual.Bias[idx+1], expected.Bias[idx+1], 1e-12)).To(BeTrue())
+				}
+			})
+
+			it("is deterministic for a fixed worker count", func() {
+				first := train(3)
+				second := train(3)
+
+				Expect(first.Weights).To(Equal(second.Weights))
+				Expect(first.Bias).To(Equal(second.Bias))
+			})
+		})
+
+		context("failure cases", func() {
+			it("when the worker count is less than 1", func() {
+				err := neuraltools.TrainParallel(network, 0, 2, trainingData...)
+				Expect(err).To(MatchError("invalid worker count: 0"))
+			})
+
+			it("when the batch size is less than 1", func() {
+				err := neuraltools.TrainParallel(network, 2, 0, trainingData...)
+				Expect(err).To(MatchError("invalid batch size: 0"))
+			})
+
+			it("when ComputeGradient fails", func() {
+				network.ComputeGradientCall.Stub = func(_ *neuralnet.Workspace, input, _ *mat.VecDense) (neuralnet.Gradient, error) {
+					if input.AtVec(0) == 3 {
+						return neuralnet.Gradient{}, errors.New("error")
+					}
+					return neuralnet.Gradient{}, nil
+				}
+
+				err := neuraltools.TrainParallel(network, 2, 4, trainingData...)
+				Expect(err).To(MatchError("network gradient computation failed on input at index: 3"))
+				Expect(network.UpdateCall.CallCount).To(Equal(0))
+			})
+
+			it("when Update fails", func() {
+				network.UpdateCall.Returns.Error = errors.New("error")
+
+				err := neuraltools.TrainParallel(network, 2, 4, trainingData...)
+				Expect(err).To(MatchError("network update failed on batch at index: 0"))
+			})
+
+			it("when the network cannot be trained in parallel", func() {
+				checked := struct {
+					*fakes.ParallelNetwork
+					*fakes.ParallelChecker
+				}{network, &fakes.ParallelChecker{}}
+				checked.CheckParallelCall.Returns.Error = errors.New("error")
+
+				err := neuraltools.TrainParallel(checked, 2, 4, trainingData...)
+				Expect(err).To(MatchError("network cannot be trained in parallel: error"))
+				Expect(network.ComputeGradientCall.CallCount).To(Equal(0))
+			})
+		})
+	})
+
 	context("Test", func() {
 		var (
 			trainingData []neuraltools.DataPair
@@ -166,6 +501,491 @@ func testTools(t *testing.T, context spec.G, it spec.S) {
 		})
 	})
 
+	context("Loss", func() {
+		var (
+			data    []neuraltools.DataPair
+			network *fakes.LossCalculator
+		)
+
+		it.Before(func() {
+			data = []neuraltools.DataPair{
+				{Input: mat.NewVecDense(1, []float64{1}), Solution: mat.NewVecDense(1, []float64{2})},
+				{Input: mat.NewVecDense(1, []float64{3}), Solution: mat.NewVecDense(1, []float64{4})},
+			}
+
+			network = &fakes.LossCalculator{}
+			network.CalcLossCall.Stub = func(solution *mat.VecDense) (float64, error) {
+				return solution.AtVec(0), nil
+			}
+		})
+
+		it("averages the loss of every sample after its calculation", func() {
+			loss, err := neuraltools.Loss(network, data...)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(loss).To(Equal(float64(3)))
+			Expect(network.CalculateCall.CallCount).To(Equal(2))
+			Expect(network.CalculateCall.Receives.VecDense).To(Equal(data[1].Input))
+		})
+
+		context("failure cases", func() {
+			it("fails without data", func() {
+				_, err := neuraltools.Loss(network)
+				Expect(err).To(MatchError("no data to compute the loss of"))
+			})
+
+			it("fails during calculation", func() {
+				network.CalculateCall.Returns.Error = fmt.Errorf("error occurred")
+				_, err := neuraltools.Loss(network, data...)
+				Expect(err).To(MatchError("error on input 0 calculation: error occurred"))
+			})
+
+			it("fails computing the loss", func() {
+				network.CalcLossCall.Stub = nil
+				network.CalcLossCall.Returns.Error = fmt.Errorf("error occurred")
+				_, err := neuraltools.Loss(network, data...)
+				Expect(err).To(MatchError("error on solution 0 loss: error occurred"))
+			})
+		})
+	})
+
+	context("Test with a BatchCalculator", func() {
+		var (
+			testData []neuraltools.DataPair
+			network  *fakes.BatchCalculator
+		)
+
+		it.Before(func() {
+			testData = nil
+			for idx := 0; idx < 300; idx++ {
+				testData = append(testData, neuraltools.DataPair{
+					Input:    mat.NewVecDense(1, []float64{float64(idx)}),
+					Solution: mat.NewVecDense(1, []float64{float64(idx % 3)}),
+				})
+			}
+
+			network = &fakes.BatchCalculator{}
+			network.CalculateBatchCall.Stub = func(input *mat.Dense) (*mat.Dense, error) {
+				result := mat.DenseCopyOf(input)
+				result.Apply(func(_, _ int, v float64) float64 { return float64(int(v) % 2) }, result)
+				return result, nil
+			}
+		})
+
+		it("calculates the correct count a batch at a time", func() {
+			correct, err := neuraltools.Test(network, fakeJudge, testData...)
+			Expect(err).NotTo(HaveOccurred())
+
+			// idx % 2 == idx % 3 when idx % 6 is 0 or 1
+			Expect(correct).To(Equal(100))
+			Expect(network.CalculateCall.CallCount).To(Equal(0))
+			Expect(network.CalculateBatchCall.CallCount).To(Equal(2))
+		})
+
+		context("failure cases", func() {
+			it("fails during calculation", func() {
+				network.CalculateBatchCall.Stub = nil
+				network.CalculateBatchCall.Returns.Error = fmt.Errorf("error occurred")
+
+				_, err := neuraltools.Test(network, fakeJudge, testData...)
+				Expect(err).To(MatchError("error on batch 0 calculation: error occurred"))
+			})
+		})
+	})
+
+	context("Evaluate", func() {
+		var (
+			data    []neuraltools.DataPair
+			network *fakes.Calculator
+		)
+
+		it.Before(func() {
+			data = []neuraltools.DataPair{
+				{Input: mat.NewVecDense(2, []float64{0.9, 0.1}), Solution: mat.NewVecDense(2, []float64{1, 0})},
+				{Input: mat.NewVecDense(2, []float64{0.6, 0.4}), Solution: mat.NewVecDense(2, []float64{0, 1})},
+				{Input: mat.NewVecDense(2, []float64{0.2, 0.8}), Solution: mat.NewVecDense(2, []float64{0, 1})},
+			}
+
+			network = &fakes.Calculator{}
+			network.CalculateCall.Stub = func(input *mat.VecDense) (*mat.VecDense, error) {
+				return input, nil
+			}
+		})
+
+		it("runs every metric over the data in a single pass", func() {
+			tracked := []metrics.Metric{&metrics.ConfusionMatrix{}, &metrics.MAE{}}
+
+			for run := 0; run < 2; run++ {
+				values, err := neuraltools.Evaluate(network, tracked, data...)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(values).To(HaveLen(2))
+				Expect(values["accuracy"]).To(BeNumerically("~", 2.0/3, 1e-12))
+				Expect(values["mae"]).To(BeNumerically("~", 0.3, 1e-12))
+			}
+
+			Expect(network.CalculateCall.CallCount).To(Equal(6))
+		})
+
+		it("evaluates a BatchCalculator a batch at a time", func() {
+			batchNetwork := &fakes.BatchCalculator{}
+			batchNetwork.CalculateBatchCall.Stub = func(input *mat.Dense) (*mat.Dense, error) {
+				return input, nil
+			}
+
+			values, err := neuraltools.Evaluate(batchNetwork, []metrics.Metric{&metrics.F1{}}, data...)
+			Expect(err).NotTo(HaveOccurred())
+
+			// F1 of 2/3 for class 0 and 2/3 for class 1
+			Expect(values).To(Equal(map[string]float64{"macro f1": 2.0 / 3}))
+			Expect(batchNetwork.CalculateBatchCall.CallCount).To(Equal(1))
+		})
+
+		context("failure cases", func() {
+			it("fails without data", func() {
+				_, err := neuraltools.Evaluate(network, []metrics.Metric{&metrics.MSE{}})
+				Expect(err).To(MatchError("no data to evaluate"))
+			})
+
+			it("fails on metrics with the same name", func() {
+				_, err := neuraltools.Evaluate(network, []metrics.Metric{&metrics.MSE{}, &metrics.MSE{}}, data...)
+				Expect(err).To(MatchError("duplicate metric name: mse"))
+			})
+
+			it("fails during calculation", func() {
+				network.CalculateCall.Stub = nil
+				network.CalculateCall.Returns.Error = fmt.Errorf("error occurred")
+
+				_, err := neuraltools.Evaluate(network, []metrics.Metric{&metrics.MSE{}}, data...)
+				Expect(err).To(MatchError("error on input 0 calculation: error occurred"))
+			})
+		})
+	})
+
+	context("when the network implements ModeSwitcher", func() {
+		type switchingNetwork struct {
+			*fakes.Network
+			*fakes.ModeSwitcher
+		}
+
+		var (
+			network     switchingNetwork
+			modes       []bool
+			training    bool
+			calculating []bool
+		)
+
+		it.Before(func() {
+			modes = nil
+			calculating = nil
+			network = switchingNetwork{
+				Network:      &fakes.Network{},
+				ModeSwitcher: &fakes.ModeSwitcher{},
+			}
+			network.SetTrainingCall.Stub = func(mode bool) {
+				modes = append(modes, mode)
+				training = mode
+			}
+			network.CalculateCall.Stub = func(input *mat.VecDense) (*mat.VecDense, error) {
+				calculating = append(calculating, training)
+				return input, nil
+			}
+		})
+
+		it("trains in training mode and tests in inference mode", func() {
+			data := []neuraltools.DataPair{
+				{
+					Input:    mat.NewVecDense(1, []float64{1}),
+					Solution: mat.NewVecDense(1, []float64{1}),
+				},
+			}
+
+			correctList, err := neuraltools.Trainer{EpochCount: 2, BatchSize: 1}.TestAndTrain(network, fakeJudge, data...)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(correctList).To(Equal([]int{1, 1}))
+
+			Expect(modes).To(Equal([]bool{false, true, false, false, true, false}))
+			Expect(calculating).To(Equal([]bool{false, true, false, true}))
+		})
+
+		it("switches back to inference mode when training fails", func() {
+			network.UpdateCall.Returns.Error = errors.New("error")
+
+			err := neuraltools.Train(network, 1, neuraltools.DataPair{
+				Input:    mat.NewVecDense(1, []float64{1}),
+				Solution: mat.NewVecDense(1, []float64{1}),
+			})
+			Expect(err).To(HaveOccurred())
+
+			Expect(modes).To(Equal([]bool{true, false}))
+		})
+	})
+
+	context("Trainer", func() {
+		var (
+			trainingData []neuraltools.DataPair
+			network      *fakes.Network
+			seen         []float64
+		)
+
+		it.Before(func() {
+			trainingData = nil
+			for idx := 0; idx < 6; idx++ {
+				trainingData = append(trainingData, neuraltools.DataPair{
+					Input:    mat.NewVecDense(1, []float64{float64(idx)}),
+					Solution: mat.NewVecDense(1, nil),
+				})
+			}
+
+			seen = nil
+			network = &fakes.Network{}
+			network.CalculateCall.Stub = func(input *mat.VecDense) (*mat.VecDense, error) {
+				seen = append(seen, input.AtVec(0))
+				return input, nil
+			}
+		})
+
+		it("trains every epoch in data order without a Source", func() {
+			trainer := neuraltools.Trainer{EpochCount: 2, BatchSize: 3}
+			Expect(trainer.Train(network, trainingData...)).To(Succeed())
+
+			Expect(seen).To(Equal([]float64{0, 1, 2, 3, 4, 5, 0, 1, 2, 3, 4, 5}))
+			Expect(network.UpdateCall.CallCount).To(Equal(4))
+		})
+
+		it("shuffles reproducibly with a Source", func() {
+			trainer := neuraltools.Trainer{EpochCount: 2, BatchSize: 1, Source: rand.NewSource(3)}
+			Expect(trainer.Train(network, trainingData...)).To(Succeed())
+			first := seen
+
+			seen = nil
+			trainer.Source = rand.NewSource(3)
+			Expect(trainer.Train(network, trainingData...)).To(Succeed())
+
+			Expect(seen).To(Equal(first))
+			Expect(first[:6]).NotTo(Equal([]float64{0, 1, 2, 3, 4, 5}))
+			Expect(first[:6]).To(ConsistOf(float64(0), float64(1), float64(2), float64(3), float64(4), float64(5)))
+			Expect(trainingData[0].Input.AtVec(0)).To(Equal(float64(0)))
+		})
+
+		it("tests before every epoch", func() {
+			trainer := neuraltools.Trainer{EpochCount: 3, BatchSize: 2}
+			correctList, err := trainer.TestAndTrain(network, fakeJudge, trainingData...)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(correctList).To(Equal([]int{1, 1, 1}))
+		})
+
+		it("creates the worker workspaces once per run", func() {
+			parallel := &fakes.ParallelNetwork{}
+			parallel.NewWorkspaceCall.Stub = func() *neuralnet.Workspace {
+				return &neuralnet.Workspace{}
+			}
+			parallel.ComputeGradientCall.Stub = func(*neuralnet.Workspace, *mat.VecDense, *mat.VecDense) (neuralnet.Gradient, error) {
+				return neuralnet.Gradient{}, nil
+			}
+
+			trainer := neuraltools.Trainer{EpochCount: 3, BatchSize: 4, Workers: 2}
+			Expect(trainer.Train(parallel, trainingData...)).To(Succeed())
+
+			Expect(parallel.NewWorkspaceCall.CallCount).To(Equal(2))
+			Expect(parallel.ComputeGradientCall.CallCount).To(Equal(18))
+		})
+
+		context("with a Schedule", func() {
+			var (
+				scheduled struct {
+					*fakes.Network
+					*fakes.LearningRateSetter
+				}
+				counters [][2]int
+				schedule schedules.Schedule
+			)
+
+			it.Before(func() {
+				scheduled.Network = network
+				scheduled.LearningRateSetter = &fakes.LearningRateSetter{}
+
+				counters = nil
+				schedule = scheduleFunc(func(step, epoch int) float64 {
+					counters = append(counters, [2]int{step, epoch})
+					return float64(step) / 10
+				})
+			})
+
+			it("sets the learning rate before every update", func() {
+				var rates []float64
+				scheduled.SetLearningRateCall.Stub = func(rate float64) error {
+					Expect(network.UpdateCall.CallCount).To(Equal(len(rates)))
+					rates = append(rates, rate)
+					return nil
+				}
+
+				trainer := neuraltools.Trainer{EpochCount: 2, BatchSize: 4, Schedule: schedule}
+				Expect(trainer.Train(scheduled, trainingData...)).To(Succeed())
+
+				Expect(counters).To(Equal([][2]int{{0, 0}, {1, 0}, {2, 1}, {3, 1}}))
+				Expect(rates).To(Equal([]float64{0, 0.1, 0.2, 0.3}))
+			})
+
+			it("sets the learning rate of a real optimizer", func() {
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{{Size: 1}, {Size: 1, Func: nodefuncs.Sigmoid{}}},
+					Source:       rand.NewSource(11),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				oneCycle, err := schedules.NewOneCycle(schedules.OneCycleConfig{Max: 0.5, TotalSteps: 6, WarmupFraction: 0.3, DivFactor: 25, FinalDivFactor: 1e4})
+				Expect(err).NotTo(HaveOccurred())
+
+				trainer := neuraltools.Trainer{EpochCount: 2, BatchSize: 2, Schedule: oneCycle}
+				Expect(trainer.Train(&network, trainingData...)).To(Succeed())
+
+				Expect(network.Optimizer.(*optimizers.SGD).LearningRate).To(Equal(oneCycle.LearningRate(5, 1)))
+			})
+
+			context("failure cases", func() {
+				it("when the network cannot change its learning rate", func() {
+					trainer := neuraltools.Trainer{EpochCount: 2, BatchSize: 4, Schedule: schedule}
+					Expect(trainer.Train(network, trainingData...)).To(MatchError("learning rate schedules require a LearningRateSetter network"))
+				})
+
+				it("when setting the learning rate fails", func() {
+					scheduled.SetLearningRateCall.Returns.Error = errors.New("error occurred")
+
+					trainer := neuraltools.Trainer{EpochCount: 2, BatchSize: 4, Schedule: schedule}
+					err := trainer.Train(scheduled, trainingData...)
+					Expect(err).To(MatchError("failed to set learning rate on batch at index: 0: error occurred"))
+					Expect(network.UpdateCall.CallCount).To(BeZero())
+				})
+			})
+		})
+
+		context("when training a network with a fixed seed", func() {
+			var workers int
+
+			it.Before(func() {
+				workers = 0
+			})
+
+			train := func() neuralnet.Network {
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{
+							Size: 1,
+						},
+						{
+							Size: 3,
+							Func: nodefuncs.Sigmoid{},
+						},
+						{
+							Size: 1,
+							Func: nodefuncs.Sigmoid{},
+						},
+					},
+					Source: rand.NewSource(11),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				trainer := neuraltools.Trainer{EpochCount: 3, BatchSize: 2, Workers: workers, Source: rand.NewSource(12)}
+				Expect(trainer.Train(&network, trainingData...)).To(Succeed())
+
+				return network
+			}
+
+			it("produces bit-identical weights", func() {
+				first := train()
+				second := train()
+
+				Expect(first.Weights).To(Equal(second.Weights))
+				Expect(first.Bias).To(Equal(second.Bias))
+			})
+
+			it("produces bit-identical weights with parallel workers", func() {
+				workers = 4
+				first := train()
+				second := train()
+
+				Expect(first.Weights).To(Equal(second.Weights))
+				Expect(first.Bias).To(Equal(second.Bias))
+			})
+		})
+
+		context("when training a Sequential with workers", func() {
+			it("matches the Network it was converted from", func() {
+				build := func() neuralnet.Network {
+					network, err := neuralnet.NewNetwork(neuralnet.Config{
+						LayerConfigs: []neuralnet.LayerConfig{
+							{Size: 1},
+							{Size: 3, Func: nodefuncs.Sigmoid{}},
+							{Size: 1, Func: nodefuncs.Sigmoid{}},
+						},
+						Source: rand.NewSource(11),
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					return network
+				}
+
+				expected := build()
+				trainer := neuraltools.Trainer{EpochCount: 3, BatchSize: 4, Workers: 2, Source: rand.NewSource(12)}
+				Expect(trainer.Train(&expected, trainingData...)).To(Succeed())
+
+				actual := build()
+				converted, err := layers.FromNetwork(&actual)
+				Expect(err).NotTo(HaveOccurred())
+				sequential, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+					InputShape: neuralnet.Shape{1},
+					Layers:     converted,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				trainer.Source = rand.NewSource(12)
+				Expect(trainer.Train(&sequential, trainingData...)).To(Succeed())
+
+				for idx := range expected.Weights {
+					Expect(mat.EqualApprox(actual.Weights[idx], expected.Weights[idx], 1e-12)).To(BeTrue())
+					Expect(mat.EqualApprox(actual.Bias[idx], expected.Bias[idx], 1e-12)).To(BeTrue())
+				}
+			})
+		})
+
+		context("failure cases", func() {
+			it("when training with workers on a network that is not a ParallelNetwork", func() {
+				trainer := neuraltools.Trainer{EpochCount: 2, BatchSize: 4, Workers: 2}
+				Expect(trainer.Train(network, trainingData...)).To(MatchError("training with 2 workers requires a ParallelNetwork"))
+				Expect(network.UpdateCall.CallCount).To(BeZero())
+			})
+
+			it("when training with workers on a batch normalized network", func() {
+				network, err := neuralnet.NewNetwork(neuralnet.Config{
+					LayerConfigs: []neuralnet.LayerConfig{
+						{Size: 1},
+						{Size: 2, Func: nodefuncs.Relu{}, BatchNorm: true},
+						{Size: 1, Func: nodefuncs.Sigmoid{}},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				trainer := neuraltools.Trainer{EpochCount: 2, BatchSize: 4, Workers: 2}
+				err = trainer.Train(&network, trainingData...)
+				Expect(err).To(MatchError("network cannot be trained in parallel: layer at index 1 updates its state, which requires CalculateBatch in training mode"))
+
+				sequential, err := neuralnet.NewSequential(neuralnet.SequentialConfig{
+					InputShape: neuralnet.Shape{1},
+					Layers: []neuralnet.Layer{
+						layers.NewDense(1, 2, nil, nil, rand.New(rand.NewSource(2))),
+						layers.NewBatchNorm(2),
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				err = trainer.Train(&sequential, trainingData...)
+				Expect(err).To(MatchError("network cannot be trained in parallel: layer at index 1 updates its state, which requires CalculateBatch in training mode"))
+			})
+		})
+	})
+
 	context("MaxJudge", func() {
 		context("when indicies of max elements are equal", func() {
 			it("return true", func() {
@@ -186,3 +1006,9 @@ func testTools(t *testing.T, context spec.G, it spec.S) {
 		})
 	})
 }
+
+type scheduleFunc func(step, epoch int) float64
+
+func (f scheduleFunc) LearningRate(step, epoch int) float64 {
+	return f(step, epoch)
+}